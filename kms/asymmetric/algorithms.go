@@ -0,0 +1,143 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// AlgorithmInfo describes the parameters of a KMS asymmetric algorithm.
+type AlgorithmInfo struct {
+	// Name is the KMS algorithm name, such as "RSA_SIGN_PSS_2048_SHA256".
+	Name string
+	// Purpose is the purpose of keys using the algorithm: "ASYMMETRIC_SIGN"
+	// or "ASYMMETRIC_DECRYPT".
+	Purpose string
+	// KeyType is "RSA", "EC" or "Ed25519".
+	KeyType string
+	// KeySize is the RSA modulus size or the curve size, in bits.
+	KeySize int
+	// Hash is the digest the algorithm signs, or the OAEP hash. It is zero
+	// for algorithms that take the message itself.
+	Hash crypto.Hash
+	// Padding is "PSS", "PKCS1" or "OAEP" for RSA algorithms and empty
+	// otherwise.
+	Padding string
+	// MaxMessageLen is the largest plaintext, in bytes, that an OAEP key can
+	// encrypt. It is zero for signing algorithms, which accept messages of any
+	// length because they sign a digest.
+	MaxMessageLen int
+}
+
+// algorithms is the registry of known KMS asymmetric algorithms, which every
+// function taking an algorithm name consults. It holds the KMS algorithms
+// below, and any added with RegisterAlgorithm.
+var (
+	algorithmsMu sync.RWMutex
+	algorithms   = []AlgorithmInfo{
+		{Name: "RSA_SIGN_PSS_2048_SHA256", KeyType: "RSA", KeySize: 2048, Hash: crypto.SHA256, Padding: "PSS"},
+		{Name: "RSA_SIGN_PSS_3072_SHA256", KeyType: "RSA", KeySize: 3072, Hash: crypto.SHA256, Padding: "PSS"},
+		{Name: "RSA_SIGN_PSS_4096_SHA256", KeyType: "RSA", KeySize: 4096, Hash: crypto.SHA256, Padding: "PSS"},
+		{Name: "RSA_SIGN_PSS_4096_SHA512", KeyType: "RSA", KeySize: 4096, Hash: crypto.SHA512, Padding: "PSS"},
+		{Name: "RSA_SIGN_PKCS1_2048_SHA256", KeyType: "RSA", KeySize: 2048, Hash: crypto.SHA256, Padding: "PKCS1"},
+		{Name: "RSA_SIGN_PKCS1_3072_SHA256", KeyType: "RSA", KeySize: 3072, Hash: crypto.SHA256, Padding: "PKCS1"},
+		{Name: "RSA_SIGN_PKCS1_4096_SHA256", KeyType: "RSA", KeySize: 4096, Hash: crypto.SHA256, Padding: "PKCS1"},
+		{Name: "RSA_SIGN_PKCS1_4096_SHA512", KeyType: "RSA", KeySize: 4096, Hash: crypto.SHA512, Padding: "PKCS1"},
+		{Name: "RSA_SIGN_RAW_PKCS1_2048", KeyType: "RSA", KeySize: 2048, Padding: "PKCS1"},
+		{Name: "RSA_SIGN_RAW_PKCS1_3072", KeyType: "RSA", KeySize: 3072, Padding: "PKCS1"},
+		{Name: "RSA_SIGN_RAW_PKCS1_4096", KeyType: "RSA", KeySize: 4096, Padding: "PKCS1"},
+		{Name: "RSA_DECRYPT_OAEP_2048_SHA256", KeyType: "RSA", KeySize: 2048, Hash: crypto.SHA256, Padding: "OAEP"},
+		{Name: "RSA_DECRYPT_OAEP_3072_SHA256", KeyType: "RSA", KeySize: 3072, Hash: crypto.SHA256, Padding: "OAEP"},
+		{Name: "RSA_DECRYPT_OAEP_4096_SHA256", KeyType: "RSA", KeySize: 4096, Hash: crypto.SHA256, Padding: "OAEP"},
+		{Name: "RSA_DECRYPT_OAEP_4096_SHA512", KeyType: "RSA", KeySize: 4096, Hash: crypto.SHA512, Padding: "OAEP"},
+		{Name: "RSA_DECRYPT_OAEP_2048_SHA1", KeyType: "RSA", KeySize: 2048, Hash: crypto.SHA1, Padding: "OAEP"},
+		{Name: "RSA_DECRYPT_OAEP_3072_SHA1", KeyType: "RSA", KeySize: 3072, Hash: crypto.SHA1, Padding: "OAEP"},
+		{Name: "RSA_DECRYPT_OAEP_4096_SHA1", KeyType: "RSA", KeySize: 4096, Hash: crypto.SHA1, Padding: "OAEP"},
+		{Name: "EC_SIGN_P256_SHA256", KeyType: "EC", KeySize: 256, Hash: crypto.SHA256},
+		{Name: "EC_SIGN_P384_SHA384", KeyType: "EC", KeySize: 384, Hash: crypto.SHA384},
+		{Name: "EC_SIGN_SECP256K1_SHA256", KeyType: "EC", KeySize: 256, Hash: crypto.SHA256},
+		{Name: "EC_SIGN_ED25519", KeyType: "Ed25519", KeySize: 256},
+	}
+)
+
+func init() {
+	for i := range algorithms {
+		deriveAlgorithm(&algorithms[i])
+	}
+}
+
+// deriveAlgorithm sets the Purpose and MaxMessageLen of a from its padding.
+func deriveAlgorithm(a *AlgorithmInfo) {
+	if a.Padding == "OAEP" {
+		a.Purpose = "ASYMMETRIC_DECRYPT"
+		// RFC 8017, section 7.1.1: mLen <= k - 2hLen - 2.
+		a.MaxMessageLen = a.KeySize/8 - 2*a.Hash.Size() - 2
+	} else {
+		a.Purpose = "ASYMMETRIC_SIGN"
+		a.MaxMessageLen = 0
+	}
+}
+
+// RegisterAlgorithm adds a KMS asymmetric algorithm to those known to this
+// package, so that signing, verification and encryption accept keys using
+// it, as long as it is an RSA or EC algorithm of a kind they already handle,
+// such as a new key size or hash. The Purpose and MaxMessageLen of info are
+// derived from its padding, as for the built-in algorithms. It returns an
+// error if info.Name is already known, or if the hash or padding is not
+// supported. Call it during initialization, before any key uses the
+// algorithm.
+func RegisterAlgorithm(info AlgorithmInfo) error {
+	if info.Name == "" {
+		return errors.New("algorithm has no name")
+	}
+	if info.Hash != 0 && !info.Hash.Available() {
+		return fmt.Errorf("algorithm %s: hash %v is not linked into the binary", info.Name, info.Hash)
+	}
+	switch {
+	case info.KeyType == "RSA" && (info.Padding == "PSS" || info.Padding == "PKCS1" || info.Padding == "OAEP"):
+	case info.KeyType == "EC" && info.Padding == "":
+	case info.KeyType == "Ed25519" && info.Padding == "":
+	default:
+		return fmt.Errorf("algorithm %s: unsupported key type %q with padding %q", info.Name, info.KeyType, info.Padding)
+	}
+	if info.Padding == "OAEP" && info.Hash == 0 {
+		return fmt.Errorf("algorithm %s: OAEP needs a hash", info.Name)
+	}
+	deriveAlgorithm(&info)
+
+	algorithmsMu.Lock()
+	defer algorithmsMu.Unlock()
+	for _, a := range algorithms {
+		if a.Name == info.Name {
+			return fmt.Errorf("algorithm %s is already registered", info.Name)
+		}
+	}
+	algorithms = append(algorithms, info)
+	return nil
+}
+
+// supportedAlgorithms returns the parameters of every KMS asymmetric
+// algorithm known to this package, including those added with
+// RegisterAlgorithm.
+func supportedAlgorithms() []AlgorithmInfo {
+	algorithmsMu.RLock()
+	defer algorithmsMu.RUnlock()
+	return append([]AlgorithmInfo(nil), algorithms...)
+}
+
+// lookupAlgorithm returns the parameters of the KMS algorithm called name.
+func lookupAlgorithm(name string) (AlgorithmInfo, bool) {
+	algorithmsMu.RLock()
+	defer algorithmsMu.RUnlock()
+	for _, a := range algorithms {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return AlgorithmInfo{}, false
+}
@@ -0,0 +1,107 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha512"
+	"encoding/base64"
+	"testing"
+)
+
+func TestAlgorithmMaxMessageLen(t *testing.T) {
+	a, ok := lookupAlgorithm("RSA_DECRYPT_OAEP_2048_SHA256")
+	if !ok {
+		t.Fatal("RSA_DECRYPT_OAEP_2048_SHA256 missing from table")
+	}
+	if a.MaxMessageLen != 190 {
+		t.Errorf("MaxMessageLen = %d; want: %d", a.MaxMessageLen, 190)
+	}
+	key, err := rsa.GenerateKey(rand.Reader, a.KeySize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rsa.EncryptOAEP(a.Hash.New(), rand.Reader, &key.PublicKey, make([]byte, a.MaxMessageLen), nil); err != nil {
+		t.Errorf("encrypting MaxMessageLen bytes: %v", err)
+	}
+	if _, err := rsa.EncryptOAEP(a.Hash.New(), rand.Reader, &key.PublicKey, make([]byte, a.MaxMessageLen+1), nil); err == nil {
+		t.Errorf("encrypting MaxMessageLen+1 bytes should fail")
+	}
+}
+
+// kmsAsymmetricAlgorithms lists every RSA, EC and Ed25519 algorithm of
+// CryptoKeyVersion.algorithm in the KMS API.
+var kmsAsymmetricAlgorithms = []string{
+	"RSA_SIGN_PSS_2048_SHA256", "RSA_SIGN_PSS_3072_SHA256", "RSA_SIGN_PSS_4096_SHA256", "RSA_SIGN_PSS_4096_SHA512",
+	"RSA_SIGN_PKCS1_2048_SHA256", "RSA_SIGN_PKCS1_3072_SHA256", "RSA_SIGN_PKCS1_4096_SHA256", "RSA_SIGN_PKCS1_4096_SHA512",
+	"RSA_SIGN_RAW_PKCS1_2048", "RSA_SIGN_RAW_PKCS1_3072", "RSA_SIGN_RAW_PKCS1_4096",
+	"RSA_DECRYPT_OAEP_2048_SHA256", "RSA_DECRYPT_OAEP_3072_SHA256", "RSA_DECRYPT_OAEP_4096_SHA256", "RSA_DECRYPT_OAEP_4096_SHA512",
+	"RSA_DECRYPT_OAEP_2048_SHA1", "RSA_DECRYPT_OAEP_3072_SHA1", "RSA_DECRYPT_OAEP_4096_SHA1",
+	"EC_SIGN_P256_SHA256", "EC_SIGN_P384_SHA384", "EC_SIGN_SECP256K1_SHA256", "EC_SIGN_ED25519",
+}
+
+func TestAlgorithmsComplete(t *testing.T) {
+	known := make(map[string]bool)
+	for _, a := range supportedAlgorithms() {
+		if known[a.Name] {
+			t.Errorf("%s is in the table twice", a.Name)
+		}
+		known[a.Name] = true
+	}
+	for _, name := range kmsAsymmetricAlgorithms {
+		if !known[name] {
+			t.Errorf("%s is missing from the table", name)
+		}
+		delete(known, name)
+	}
+	for name := range known {
+		t.Errorf("%s is in the table but not a KMS algorithm", name)
+	}
+}
+
+func TestRegisterAlgorithm(t *testing.T) {
+	const name = "RSA_SIGN_PSS_2048_SHA512"
+	n := len(supportedAlgorithms())
+	t.Cleanup(func() {
+		algorithmsMu.Lock()
+		defer algorithmsMu.Unlock()
+		algorithms = algorithms[:n]
+	})
+	if err := RegisterAlgorithm(AlgorithmInfo{Name: name, KeyType: "RSA", KeySize: 2048, Hash: crypto.SHA512, Padding: "PSS"}); err != nil {
+		t.Fatalf("RegisterAlgorithm: %v", err)
+	}
+	alg, ok := lookupAlgorithm(name)
+	if !ok || alg.Purpose != "ASYMMETRIC_SIGN" {
+		t.Fatalf("lookupAlgorithm(%s) = %+v, %v; want a signing algorithm", name, alg, ok)
+	}
+
+	// The registered algorithm works with the existing verify code.
+	key := testPrivateKey(t, "RSA_SIGN_PSS_2048_SHA256").(*rsa.PrivateKey)
+	message := []byte("message")
+	digest := sha512.Sum512(message)
+	signature, err := rsa.SignPSS(rand.Reader, key, crypto.SHA512, digest[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := newOptions(nil)
+	if err := o.verifyWithAlgorithm(key.Public(), alg, base64.StdEncoding.EncodeToString(signature), message, "test key"); err != nil {
+		t.Errorf("verifying with %s: %v", name, err)
+	}
+
+	for _, info := range []AlgorithmInfo{
+		{Name: name, KeyType: "RSA", KeySize: 2048, Hash: crypto.SHA512, Padding: "PSS"},
+		{Name: "EC_SIGN_P256_SHA256", KeyType: "EC", KeySize: 256, Hash: crypto.SHA256},
+		{Name: "", KeyType: "EC", KeySize: 256, Hash: crypto.SHA256},
+		{Name: "EC_SIGN_P256_OAEP", KeyType: "EC", KeySize: 256, Hash: crypto.SHA256, Padding: "OAEP"},
+		{Name: "RSA_DECRYPT_OAEP_2048", KeyType: "RSA", KeySize: 2048, Padding: "OAEP"},
+		{Name: "RSA_SIGN_PSS_2048_MD4", KeyType: "RSA", KeySize: 2048, Hash: crypto.MD4, Padding: "PSS"},
+	} {
+		if err := RegisterAlgorithm(info); err == nil {
+			t.Errorf("RegisterAlgorithm(%+v): got nil error", info)
+		}
+	}
+}
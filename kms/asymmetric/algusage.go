@@ -0,0 +1,81 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"fmt"
+	"sync"
+)
+
+// WithVerifiedAlgorithm makes verifySignature, verifySignatureRSA,
+// verifySignatureEC, verifyDigestSignature, verifyWithHint, verifyStrict,
+// verifyBundle and the functions returned by newVerifier call report with the KMS algorithm,
+// such as "EC_SIGN_P256_SHA256", of each signature they find valid, to
+// measure the mix of algorithms in use; joseAlgorithm gives the JOSE name,
+// such as "ES256". Nothing is reported for invalid signatures. Without it,
+// the algorithm is not even worked out. Functions that verify concurrently
+// may call report concurrently.
+func WithVerifiedAlgorithm(report func(alg string)) Option {
+	return func(o *options) { o.onVerifiedAlgorithm = report }
+}
+
+// reportAlgorithm calls the function given to WithVerifiedAlgorithm, if any,
+// with the algorithm that name returns.
+func (o *options) reportAlgorithm(name func() string) {
+	if o.onVerifiedAlgorithm != nil {
+		o.onVerifiedAlgorithm(name())
+	}
+}
+
+// rsaPSSAlgorithm returns the name of the RSA_SIGN_PSS_*_SHA256 algorithm
+// of key, which verifySignatureRSA checks signatures with.
+func rsaPSSAlgorithm(key *rsa.PublicKey) string {
+	return fmt.Sprintf("RSA_SIGN_PSS_%d_SHA256", key.N.BitLen())
+}
+
+// ecAlgorithm returns the name of the algorithm of key, whose curve, as
+// ecHash says, fixes the hash. ecHash rejects other curves before any
+// signature is verified.
+func ecAlgorithm(key *ecdsa.PublicKey) string {
+	if key.Curve == elliptic.P384() {
+		return "EC_SIGN_P384_SHA384"
+	}
+	return "EC_SIGN_P256_SHA256"
+}
+
+// AlgorithmUsage counts the signatures verified with each algorithm, as
+// reported to its Record method by WithVerifiedAlgorithm. It is safe for
+// concurrent use.
+type AlgorithmUsage struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+// newAlgorithmUsage returns an empty AlgorithmUsage.
+func newAlgorithmUsage() *AlgorithmUsage {
+	return &AlgorithmUsage{counts: make(map[string]int64)}
+}
+
+// Record counts one signature verified with alg.
+func (u *AlgorithmUsage) Record(alg string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.counts[alg]++
+}
+
+// Counts returns the number of signatures verified with each algorithm so
+// far.
+func (u *AlgorithmUsage) Counts() map[string]int64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	counts := make(map[string]int64, len(u.counts))
+	for alg, n := range u.counts {
+		counts[alg] = n
+	}
+	return counts
+}
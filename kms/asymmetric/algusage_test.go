@@ -0,0 +1,56 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestWithVerifiedAlgorithm(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keys := map[string]string{
+		testKeyPath("usage-pss"):   "RSA_SIGN_PSS_2048_SHA256",
+		testKeyPath("usage-pkcs1"): "RSA_SIGN_PKCS1_2048_SHA256",
+		testKeyPath("usage-p256"):  "EC_SIGN_P256_SHA256",
+		testKeyPath("usage-p384"):  "EC_SIGN_P384_SHA384",
+	}
+	usage := newAlgorithmUsage()
+	report := WithVerifiedAlgorithm(usage.Record)
+	for keyPath, alg := range keys {
+		f.addKey(t, keyPath, alg)
+		signature, err := signAsymmetric(ctx, client, "message", keyPath)
+		if err != nil {
+			t.Fatalf("signAsymmetric(%s): %v", alg, err)
+		}
+		verify, err := newVerifier(ctx, client, keyPath, report)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := verify(signature, "message"); err != nil {
+			t.Errorf("verifier for %s: %v", alg, err)
+		}
+		if err := verify(signature, "other"); err == nil {
+			t.Errorf("verifier for %s accepted another message", alg)
+		}
+		if alg != "RSA_SIGN_PKCS1_2048_SHA256" {
+			if err := verifySignature(ctx, client, signature, []byte("message"), keyPath, report); err != nil {
+				t.Errorf("verifySignature for %s: %v", alg, err)
+			}
+		}
+	}
+	want := map[string]int64{
+		"RSA_SIGN_PSS_2048_SHA256":   2,
+		"RSA_SIGN_PKCS1_2048_SHA256": 1,
+		"EC_SIGN_P256_SHA256":        2,
+		"EC_SIGN_P384_SHA384":        2,
+	}
+	if got := usage.Counts(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Counts() = %v, want %v", got, want)
+	}
+}
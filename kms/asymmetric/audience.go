@@ -0,0 +1,78 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// WithRequiredAudience makes verifyJWT, verifyJWTWithJWKS and verifyDSSE
+// fail with ErrAudienceMismatch unless the signed token or payload names
+// audience, so that a token issued for one service is not accepted by
+// another. The audience is the "aud" member of the JWT claims or of the DSSE
+// payload, which must then be a JSON object; as in a JWT, it is a string or
+// an array of strings, one of which must be audience. It is checked only
+// after the signature has been verified, and a missing "aud" never matches.
+func WithRequiredAudience(audience string) Option {
+	return func(o *options) {
+		o.requireAudience = true
+		o.requiredAudience = audience
+	}
+}
+
+// checkAudience enforces WithRequiredAudience for a token or payload whose
+// audience claim is aud.
+func (o *options) checkAudience(aud []string) error {
+	if !o.requireAudience || containsString(aud, o.requiredAudience) {
+		return nil
+	}
+	if len(aud) == 0 {
+		return fmt.Errorf("%w: no audience, want %q", ErrAudienceMismatch, o.requiredAudience)
+	}
+	return fmt.Errorf("%w: got %q, want %q", ErrAudienceMismatch, aud, o.requiredAudience)
+}
+
+// checkPayloadAudience enforces WithRequiredAudience for the JSON payload.
+func (o *options) checkPayloadAudience(payload []byte) error {
+	if !o.requireAudience {
+		return nil
+	}
+	var claims struct {
+		Audience interface{} `json:"aud"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return fmt.Errorf("%w: payload is not a JSON object: %w", ErrAudienceMismatch, err)
+	}
+	aud, err := parseAudience(claims.Audience)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrAudienceMismatch, err)
+	}
+	return o.checkAudience(aud)
+}
+
+// parseAudience returns the audiences of an "aud" claim decoded from JSON,
+// which is absent, a string or an array of strings.
+func parseAudience(v interface{}) ([]string, error) {
+	switch aud := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{aud}, nil
+	case []interface{}:
+		var audiences []string
+		for _, a := range aud {
+			s, ok := a.(string)
+			if !ok {
+				return nil, errors.New("aud contains a non-string")
+			}
+			audiences = append(audiences, s)
+		}
+		return audiences, nil
+	default:
+		return nil, errors.New("aud is not a string or array")
+	}
+}
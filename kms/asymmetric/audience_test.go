@@ -0,0 +1,95 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestWithRequiredAudienceJWT(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwksJSON, err := json.Marshal(jwkSet{Keys: []jwk{{
+		Kty: "EC", Kid: "k1", Crv: "P-256",
+		X: encodeSegment(key.X.Bytes()),
+		Y: encodeSegment(key.Y.Bytes()),
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := time.Now().Unix() + 300
+	tests := []struct {
+		name string
+		aud  interface{}
+		want error
+	}{
+		{"string", "billing", nil},
+		{"in array", []string{"reports", "billing"}, nil},
+		{"other service", "reports", ErrAudienceMismatch},
+		{"missing", nil, ErrAudienceMismatch},
+	}
+	for _, test := range tests {
+		claims := map[string]interface{}{"exp": exp}
+		if test.aud != nil {
+			claims["aud"] = test.aud
+		}
+		token := signTestJWT(t, key, "k1", claims)
+		if _, err := verifyJWTWithJWKS(token, jwksJSON, "", "", WithRequiredAudience("billing")); !errors.Is(err, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.want)
+		}
+	}
+
+	// The signature is checked first.
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := signTestJWT(t, other, "k1", map[string]interface{}{"exp": exp, "aud": "reports"})
+	if _, err := verifyJWTWithJWKS(forged, jwksJSON, "", "", WithRequiredAudience("billing")); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("forged token: got %v, want ErrSignatureInvalid", err)
+	}
+}
+
+func TestWithRequiredAudienceDSSE(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("audience-dsse")
+	f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+	tests := []struct {
+		name    string
+		payload string
+		want    error
+	}{
+		{"matching", `{"aud":"billing","amount":10}`, nil},
+		{"other service", `{"aud":["reports"]}`, ErrAudienceMismatch},
+		{"no aud", `{"amount":10}`, ErrAudienceMismatch},
+		{"not JSON", "amount=10", ErrAudienceMismatch},
+	}
+	for _, test := range tests {
+		envelope, err := signDSSE(ctx, client, "application/json", []byte(test.payload), keyPath)
+		if err != nil {
+			t.Fatalf("signDSSE: %v", err)
+		}
+		if _, err := verifyDSSE(ctx, client, envelope, keyPath, WithRequiredAudience("billing")); !errors.Is(err, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.want)
+		}
+		if _, err := verifyDSSE(ctx, client, envelope, keyPath); err != nil {
+			t.Errorf("%s without WithRequiredAudience: %v", test.name, err)
+		}
+	}
+	if got := failureReason(ErrAudienceMismatch); got != ReasonClaims {
+		t.Errorf("failureReason(ErrAudienceMismatch) = %v, want ReasonClaims", got)
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// SignRecord describes one signing operation exactly, for audit evidence:
+// together with the public key of KeyVersion, it lets anyone check the
+// signature again.
+type SignRecord struct {
+	// Signature is the base64 signature returned by KMS.
+	Signature string
+	// Digest is the digest sent to KMS, which is what KMS signed.
+	Digest []byte
+	// Hash is the algorithm Digest was computed with.
+	Hash crypto.Hash
+	// Algorithm is the KMS algorithm of the key, such as
+	// "EC_SIGN_P256_SHA256". signDigest leaves it empty.
+	Algorithm string
+	// KeyVersion is the resource name of the key version that signed, as
+	// reported by KMS, and LogicalKeyID its logicalKeyID.
+	KeyVersion   string
+	LogicalKeyID string
+	// DigestCrc32c is the CRC32C of Digest sent with the request, and
+	// VerifiedDigestCrc32c and SignatureCrc32c are what KMS answered: that it
+	// checked the request's CRC32C, and the CRC32C of Signature. Signing
+	// fails unless KMS checked the request and SignatureCrc32c matches, so a
+	// recorded SignRecord shows that both checks were made when it was
+	// signed.
+	DigestCrc32c         int64
+	VerifiedDigestCrc32c bool
+	SignatureCrc32c      int64
+}
+
+// WithSignRecord makes signAsymmetric and signDigest fill in r after a
+// successful signature. r is left alone if signing fails.
+func WithSignRecord(r *SignRecord) Option {
+	return func(o *options) { o.signRecord = r }
+}
+
+// signAsymmetricAudited is like signAsymmetric, but returns the SignRecord
+// of the signature, with the integrity values KMS reported, for storage in
+// an audit log. The record's Signature is the base64 signature from KMS,
+// whatever WithSignatureEncoding says.
+func signAsymmetricAudited(ctx context.Context, client *cloudkms.Service, message, keyPath string, opts ...Option) (SignRecord, error) {
+	var record SignRecord
+	opts = append(opts[:len(opts):len(opts)], WithSignRecord(&record))
+	if _, err := signAsymmetric(ctx, client, message, keyPath, opts...); err != nil {
+		return SignRecord{}, err
+	}
+	return record, nil
+}
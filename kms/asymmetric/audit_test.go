@@ -0,0 +1,80 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestWithSignRecord(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("ec-sign")
+	f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+
+	var record SignRecord
+	signature, err := signAsymmetric(ctx, client, "message", keyPath, WithSignRecord(&record), WithSignatureEncoding(SignatureHex))
+	if err != nil {
+		t.Fatalf("signAsymmetric: %v", err)
+	}
+	digest := sha256.Sum256([]byte("message"))
+	if !bytes.Equal(record.Digest, digest[:]) || record.Hash != crypto.SHA256 {
+		t.Errorf("record has digest %x with %v, want %x with SHA-256", record.Digest, record.Hash, digest)
+	}
+	if record.Algorithm != "EC_SIGN_P256_SHA256" || record.KeyVersion != keyPath || record.LogicalKeyID != parentKeyPath(keyPath) {
+		t.Errorf("record has algorithm %q, key version %q and logical key ID %q", record.Algorithm, record.KeyVersion, record.LogicalKeyID)
+	}
+	// The record holds the base64 signature from KMS, whatever the output
+	// encoding, and it verifies against the recorded digest.
+	if err := verifySignature(ctx, client, signature, []byte("message"), keyPath, WithSignatureEncoding(SignatureHex)); err != nil {
+		t.Errorf("verifySignature of returned signature: %v", err)
+	}
+	decoded, err := decodeSignature(record.Signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, publicKey, err := fetchPublicKey(ctx, client, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alg, _ := lookupAlgorithm(record.Algorithm)
+	if err := verifyDigest(publicKey, alg, record.Digest, decoded); err != nil {
+		t.Errorf("recorded signature does not verify over recorded digest: %v", err)
+	}
+}
+
+func TestSignAsymmetricAudited(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("ec-sign")
+	f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+
+	record, err := signAsymmetricAudited(ctx, client, "message", keyPath, WithSignatureEncoding(SignatureHex))
+	if err != nil {
+		t.Fatalf("signAsymmetricAudited: %v", err)
+	}
+	digest := sha256.Sum256([]byte("message"))
+	if want := int64(crc32c(digest[:])); record.DigestCrc32c != want {
+		t.Errorf("DigestCrc32c = %d, want %d", record.DigestCrc32c, want)
+	}
+	if !record.VerifiedDigestCrc32c {
+		t.Error("VerifiedDigestCrc32c = false, want true")
+	}
+	decoded, err := decodeSignature(record.Signature)
+	if err != nil {
+		t.Fatalf("record signature is not base64: %v", err)
+	}
+	if want := int64(crc32c(decoded)); record.SignatureCrc32c != want {
+		t.Errorf("SignatureCrc32c = %d, want %d", record.SignatureCrc32c, want)
+	}
+	if err := verifySignature(ctx, client, record.Signature, []byte("message"), keyPath); err != nil {
+		t.Errorf("verifySignature of the recorded signature: %v", err)
+	}
+}
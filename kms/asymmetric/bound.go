@@ -0,0 +1,67 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// encryptRSAWithContext encrypts message with the RSA key at keyPath, bound
+// to contextStr, such as "invoice-service/v1" or a record ID. The plaintext
+// encrypted is contextStr and message framed by framedMessage, so
+// decryptRSAWithContext can tell which context a ciphertext was made for and
+// refuse one replayed in another. (An OAEP label would serve the same
+// purpose, but KMS does not support labels.) The context takes 4 bytes plus
+// its length out of the key's MaxMessageLen.
+//
+// The context does not authenticate the sender, since anyone with the public
+// key can encrypt for any context; it only stops a ciphertext meant for one
+// context from being accepted in another.
+func encryptRSAWithContext(ctx context.Context, client *cloudkms.Service, message, contextStr, keyPath string, opts ...Option) (string, error) {
+	plaintext, err := framedMessage([]byte(contextStr), []byte(message))
+	if err != nil {
+		return "", err
+	}
+	defer zeroize(plaintext)
+	alg, err := getKeyAlgorithm(ctx, client, keyPath, opts...)
+	if err != nil {
+		return "", err
+	}
+	if alg.Purpose != "ASYMMETRIC_DECRYPT" {
+		return "", fmt.Errorf("%w: %s is a %s key, not an encryption key", ErrKeyTypeMismatch, keyPath, alg.Name)
+	}
+	if len(plaintext) > alg.MaxMessageLen {
+		return "", fmt.Errorf("%w: message and context are %d bytes, but %s encrypts at most %d",
+			ErrPlaintextTooLarge, len(plaintext), alg.Name, alg.MaxMessageLen)
+	}
+	return encryptRSABytes(ctx, client, plaintext, keyPath, opts...)
+}
+
+// decryptRSAWithContext decrypts a ciphertext made by encryptRSAWithContext
+// and returns the message, if it was encrypted for contextStr. A ciphertext
+// for another context fails with ErrContextMismatch, and one not made by
+// encryptRSAWithContext with ErrContextMismatch or a decryption error.
+func decryptRSAWithContext(ctx context.Context, client *cloudkms.Service, ciphertext, contextStr, keyPath string, opts ...Option) (string, error) {
+	plaintext, err := decryptRSABytes(ctx, client, ciphertext, keyPath, opts...)
+	if err != nil {
+		return "", err
+	}
+	defer zeroize(plaintext)
+	if len(plaintext) < 4 {
+		return "", fmt.Errorf("%w: plaintext has no context", ErrContextMismatch)
+	}
+	n := binary.BigEndian.Uint32(plaintext)
+	if uint64(n) > uint64(len(plaintext)-4) {
+		return "", fmt.Errorf("%w: plaintext has no context", ErrContextMismatch)
+	}
+	if got := string(plaintext[4 : 4+n]); got != contextStr {
+		return "", fmt.Errorf("%w: encrypted for context %q, not %q", ErrContextMismatch, got, contextStr)
+	}
+	return string(plaintext[4+n:]), nil
+}
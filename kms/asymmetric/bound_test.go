@@ -0,0 +1,52 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestEncryptRSAWithContext(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("rsa-decrypt")
+	f.addKey(t, keyPath, "RSA_DECRYPT_OAEP_2048_SHA256")
+
+	ciphertext, err := encryptRSAWithContext(ctx, client, "secret", "invoices", keyPath)
+	if err != nil {
+		t.Fatalf("encryptRSAWithContext: %v", err)
+	}
+	if got, err := decryptRSAWithContext(ctx, client, ciphertext, "invoices", keyPath); err != nil || got != "secret" {
+		t.Errorf("decryptRSAWithContext = (%q, %v), want (%q, nil)", got, err, "secret")
+	}
+	for _, other := range []string{"payroll", "invoice", ""} {
+		if _, err := decryptRSAWithContext(ctx, client, ciphertext, other, keyPath); !errors.Is(err, ErrContextMismatch) {
+			t.Errorf("decryptRSAWithContext in context %q: got %v, want ErrContextMismatch", other, err)
+		}
+	}
+
+	// A ciphertext without a context frame is rejected.
+	plain, err := encryptRSA(ctx, client, "ab", keyPath)
+	if err != nil {
+		t.Fatalf("encryptRSA: %v", err)
+	}
+	if _, err := decryptRSAWithContext(ctx, client, plain, "", keyPath); !errors.Is(err, ErrContextMismatch) {
+		t.Errorf("decryptRSAWithContext of a plain ciphertext: got %v, want ErrContextMismatch", err)
+	}
+
+	// The context counts against the key's size limit.
+	alg, _ := lookupAlgorithm("RSA_DECRYPT_OAEP_2048_SHA256")
+	message := strings.Repeat("x", alg.MaxMessageLen-4-len("invoices"))
+	if _, err := encryptRSAWithContext(ctx, client, message, "invoices", keyPath); err != nil {
+		t.Errorf("encryptRSAWithContext at the limit: %v", err)
+	}
+	if _, err := encryptRSAWithContext(ctx, client, message+"x", "invoices", keyPath); !errors.Is(err, ErrPlaintextTooLarge) {
+		t.Errorf("encryptRSAWithContext over the limit: got %v, want ErrPlaintextTooLarge", err)
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// BreakerState is the state of a PublicKeyBreaker.
+type BreakerState int
+
+const (
+	// BreakerClosed fetches public keys from KMS as usual.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen answers from the cache, or fails with ErrCircuitOpen,
+	// without calling KMS.
+	BreakerOpen
+	// BreakerHalfOpen lets one probe through to KMS, which closes the
+	// breaker if it succeeds and opens it again if it fails.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("BreakerState(%d)", int(s))
+	}
+}
+
+// A PublicKeyBreaker fetches public keys with getAsymmetricPublicKey behind
+// a circuit breaker, so that a verification hot path stops calling KMS while
+// KMS is failing. Each fetch is given at most a fixed time. After threshold
+// consecutive fetches fail because KMS is unavailable, throttling or too
+// slow, the breaker opens: for the cooldown that follows, PublicKey returns
+// the last key fetched for the key version, or fails at once with
+// ErrCircuitOpen if there is none. After the cooldown, one fetch is let
+// through as a probe; if it succeeds the breaker closes, and otherwise it
+// opens for another cooldown. Other errors, such as a permission error or
+// the caller's context ending, are returned without counting as failures.
+//
+// The public key of a key version never changes, so serving a cached key is
+// safe, but a cached key is still served after its key version is disabled.
+// A PublicKeyBreaker is safe for concurrent use.
+type PublicKeyBreaker struct {
+	client    *cloudkms.Service
+	threshold int
+	timeout   time.Duration
+	cooldown  time.Duration
+	opts      []Option
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+	keys     map[string]crypto.PublicKey
+}
+
+// newPublicKeyBreaker returns a closed PublicKeyBreaker that opens after
+// threshold consecutive failures, gives each fetch at most timeout, or no
+// limit beyond the caller's context if timeout is 0, and stays open for
+// cooldown before probing. opts are used for every fetch; WithClock sets the
+// clock the cooldown is measured with.
+func newPublicKeyBreaker(client *cloudkms.Service, threshold int, timeout, cooldown time.Duration, opts ...Option) (*PublicKeyBreaker, error) {
+	if threshold <= 0 {
+		return nil, fmt.Errorf("failure threshold must be positive, got %d", threshold)
+	}
+	if timeout < 0 || cooldown <= 0 {
+		return nil, fmt.Errorf("invalid timeout %v or cooldown %v", timeout, cooldown)
+	}
+	return &PublicKeyBreaker{
+		client:    client,
+		threshold: threshold,
+		timeout:   timeout,
+		cooldown:  cooldown,
+		opts:      opts,
+		now:       newOptions(opts).now,
+		keys:      make(map[string]crypto.PublicKey),
+	}, nil
+}
+
+// PublicKey returns the public key of the key version at keyPath, as
+// described for PublicKeyBreaker. Pass it to the verify functions with
+// WithPublicKey.
+func (b *PublicKeyBreaker) PublicKey(ctx context.Context, keyPath string) (crypto.PublicKey, error) {
+	fetch, probe, err := b.allow(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	if !fetch {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		return b.keys[keyPath], nil
+	}
+
+	fetchCtx := ctx
+	if b.timeout > 0 {
+		var cancel context.CancelFunc
+		fetchCtx, cancel = context.WithTimeout(ctx, b.timeout)
+		defer cancel()
+	}
+	publicKey, err := getAsymmetricPublicKey(fetchCtx, b.client, keyPath, b.opts...)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if probe {
+		b.probing = false
+	}
+	switch {
+	case err == nil:
+		b.keys[keyPath] = publicKey
+		b.state = BreakerClosed
+		b.failures = 0
+		return publicKey, nil
+	case ctx.Err() != nil || !breakerFailure(err):
+		return nil, err
+	}
+	b.failures++
+	if probe || b.failures >= b.threshold {
+		b.state = BreakerOpen
+		b.openedAt = b.now()
+	}
+	return nil, err
+}
+
+// State returns the state of the breaker.
+func (b *PublicKeyBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen && !b.now().Before(b.openedAt.Add(b.cooldown)) {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+// allow decides whether PublicKey may call KMS for keyPath, and whether the
+// call is the probe of a half-open breaker. If it may not, the cached key is
+// to be served, or err is ErrCircuitOpen if there is none.
+func (b *PublicKeyBreaker) allow(keyPath string) (fetch, probe bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != BreakerOpen {
+		return true, false, nil
+	}
+	retryAt := b.openedAt.Add(b.cooldown)
+	if b.now().Before(retryAt) || b.probing {
+		if _, ok := b.keys[keyPath]; ok {
+			return false, false, nil
+		}
+		return false, false, fmt.Errorf("%w: %d consecutive public key fetches failed; next attempt after %v", ErrCircuitOpen, b.failures, retryAt)
+	}
+	b.probing = true
+	return true, true, nil
+}
+
+// breakerFailure reports whether err, from a public key fetch, shows that KMS
+// is failing: it is unavailable, rate limiting or did not answer in time.
+func breakerFailure(err error) bool {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusTooManyRequests {
+		return true
+	}
+	return isUnavailable(err) || errors.Is(err, context.DeadlineExceeded)
+}
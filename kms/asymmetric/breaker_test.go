@@ -0,0 +1,97 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestPublicKeyBreaker(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	cached := testKeyPath("breaker-cached")
+	uncached := testKeyPath("breaker-uncached")
+	f.addKey(t, cached, "EC_SIGN_P256_SHA256")
+	f.addKey(t, uncached, "RSA_SIGN_PSS_2048_SHA256")
+	now := time.Unix(1500000000, 0)
+	b, err := newPublicKeyBreaker(client, 2, time.Second, time.Minute, WithClock(func() time.Time { return now }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fail := func(n int) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		for i := 0; i < n; i++ {
+			f.failures = append(f.failures, http.StatusServiceUnavailable)
+		}
+	}
+	requests := func() int {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return f.requests
+	}
+
+	if _, err := b.PublicKey(ctx, cached); err != nil {
+		t.Fatalf("PublicKey: %v", err)
+	}
+	fail(2)
+	for i := 0; i < 2; i++ {
+		if _, err := b.PublicKey(ctx, uncached); err == nil {
+			t.Fatalf("PublicKey during an outage, attempt %d: got nil error", i+1)
+		}
+	}
+	if got := b.State(); got != BreakerOpen {
+		t.Fatalf("state after 2 failures = %v, want open", got)
+	}
+
+	// While open, KMS is not called: the cached key is served and others fail
+	// fast.
+	before := requests()
+	if key, err := b.PublicKey(ctx, cached); err != nil || key == nil {
+		t.Errorf("PublicKey of a cached key while open = %v, %v; want the cached key", key, err)
+	}
+	if _, err := b.PublicKey(ctx, uncached); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("PublicKey of an uncached key while open: got %v, want ErrCircuitOpen", err)
+	}
+	if got := requests(); got != before {
+		t.Errorf("%d requests sent while open, want 0", got-before)
+	}
+
+	// A failed probe opens the breaker again; a successful one closes it.
+	now = now.Add(time.Minute)
+	if got := b.State(); got != BreakerHalfOpen {
+		t.Errorf("state after the cooldown = %v, want half-open", got)
+	}
+	fail(1)
+	if _, err := b.PublicKey(ctx, uncached); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("failing probe: got %v, want the KMS error", err)
+	}
+	if got := b.State(); got != BreakerOpen {
+		t.Errorf("state after a failed probe = %v, want open", got)
+	}
+	now = now.Add(time.Minute)
+	if _, err := b.PublicKey(ctx, uncached); err != nil {
+		t.Errorf("successful probe: %v", err)
+	}
+	if got := b.State(); got != BreakerClosed {
+		t.Errorf("state after a successful probe = %v, want closed", got)
+	}
+
+	// Errors that do not show an outage are not counted.
+	f.mu.Lock()
+	f.failures = []int{http.StatusForbidden, http.StatusForbidden, http.StatusForbidden}
+	f.mu.Unlock()
+	for i := 0; i < 3; i++ {
+		b.PublicKey(ctx, uncached)
+	}
+	if got := b.State(); got != BreakerClosed {
+		t.Errorf("state after permission errors = %v, want closed", got)
+	}
+}
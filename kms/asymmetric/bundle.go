@@ -0,0 +1,63 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// loadTrustBundle reads every file ending in ".pem" in dir, in name order,
+// for use with verifyAgainstBundle.
+func loadTrustBundle(dir string) ([][]byte, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", dir, err)
+	}
+	sort.Strings(paths)
+	var bundle [][]byte
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read trust bundle: %w", err)
+		}
+		bundle = append(bundle, data)
+	}
+	return bundle, nil
+}
+
+// verifyAgainstBundle verifies signature, base64-encoded as KMS returns it,
+// over message with each PEM-encoded public key in bundle in turn, and
+// returns the keyFingerprint of the first key that verifies it. alg is the
+// KMS signing algorithm the signer uses, such as "EC_SIGN_P256_SHA256"; keys
+// of another type are skipped. No KMS requests are made, so the keys may
+// belong to parties outside KMS. If no key verifies the signature, the
+// error wraps ErrSignatureInvalid.
+func verifyAgainstBundle(bundle [][]byte, signature, message string, alg string) (string, error) {
+	info, ok := lookupAlgorithm(alg)
+	if !ok || info.Purpose != "ASYMMETRIC_SIGN" || info.Hash == 0 {
+		return "", fmt.Errorf("unsupported signing algorithm %s", alg)
+	}
+	decodedSignature, err := decodeSignature(signature)
+	if err != nil {
+		return "", err
+	}
+	digest := info.Hash.New()
+	digest.Write([]byte(message))
+	sum := digest.Sum(nil)
+	for i, pemBytes := range bundle {
+		publicKey, err := parsePublicKeyPEM(string(pemBytes))
+		if err != nil {
+			return "", fmt.Errorf("trust bundle entry %d: %w", i, err)
+		}
+		if verifyDigest(publicKey, info, sum, decodedSignature) != nil {
+			continue
+		}
+		return keyFingerprint(publicKey)
+	}
+	return "", fmt.Errorf("%w: no key in the trust bundle matches", ErrSignatureInvalid)
+}
@@ -0,0 +1,67 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestVerifyAgainstBundle(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	dir := t.TempDir()
+	var signerPath, signerFingerprint string
+	for _, id := range []string{"a", "b", "c"} {
+		keyPath := testKeyPath("party-" + id)
+		f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+		response, publicKey, err := fetchPublicKey(ctx, client, keyPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, id+".pem"), []byte(response.Pem), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if id == "b" {
+			signerPath = keyPath
+			if signerFingerprint, err = keyFingerprint(publicKey); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	rsaPath := testKeyPath("rsa")
+	f.addKey(t, rsaPath, "RSA_SIGN_PSS_2048_SHA256")
+	rsaResponse, _, err := fetchPublicKey(ctx, client, rsaPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle, err := loadTrustBundle(dir)
+	if err != nil {
+		t.Fatalf("loadTrustBundle: %v", err)
+	}
+	if len(bundle) != 3 {
+		t.Fatalf("loaded %d keys, want 3", len(bundle))
+	}
+	bundle = append([][]byte{[]byte(rsaResponse.Pem)}, bundle...)
+
+	signature, err := signAsymmetric(ctx, client, "message", signerPath)
+	if err != nil {
+		t.Fatalf("signAsymmetric: %v", err)
+	}
+	got, err := verifyAgainstBundle(bundle, signature, "message", "EC_SIGN_P256_SHA256")
+	if err != nil {
+		t.Fatalf("verifyAgainstBundle: %v", err)
+	}
+	if got != signerFingerprint {
+		t.Errorf("matched fingerprint %s, want %s", got, signerFingerprint)
+	}
+	if _, err := verifyAgainstBundle(bundle, signature, "other", "EC_SIGN_P256_SHA256"); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("wrong message: got %v, want ErrSignatureInvalid", err)
+	}
+}
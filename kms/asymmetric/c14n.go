@@ -0,0 +1,279 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// xmlNamespace is the namespace bound to the "xml" prefix, which is never
+// declared.
+const xmlNamespace = "http://www.w3.org/XML/1998/namespace"
+
+// canonicalXML canonicalizes the UTF-8 XML document xmlBytes with Exclusive
+// XML Canonicalization 1.0 without comments
+// (http://www.w3.org/2001/10/xml-exc-c14n#), applied to the whole document
+// with an empty InclusiveNamespaces PrefixList:
+//   - the XML declaration, comments and whitespace outside the document
+//     element are removed, and CDATA sections are replaced by their text;
+//   - line breaks are normalized to "\n", and text and attribute values are
+//     escaped as the specification requires;
+//   - empty elements are written as a start and end tag pair;
+//   - each element declares only the namespaces it or its attributes use
+//     and its output ancestors have not already declared, followed by its
+//     attributes sorted by namespace URI and local name.
+//
+// Documents with a document type declaration, which can change content
+// through entities and attribute defaults, or with literal tab, newline or
+// carriage return characters in attribute values, which a validating parser
+// would normalize to spaces, are rejected with ErrUnsupportedXML rather than
+// canonicalized differently from other implementations. Write such
+// characters as character references instead.
+func canonicalXML(xmlBytes []byte) ([]byte, error) {
+	d := xml.NewDecoder(bytes.NewReader(xmlBytes))
+	var (
+		buf bytes.Buffer
+		// scopes[i] holds the namespaces in scope in the i-th open element,
+		// and rendered[i] those declared in the output up to it.
+		scopes   = []map[string]string{{"": ""}}
+		rendered = []map[string]string{{"": ""}}
+		open     []xml.Name
+		seenRoot bool
+	)
+	for {
+		start := d.InputOffset()
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse XML: %w", err)
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			if len(open) == 0 && seenRoot {
+				return nil, fmt.Errorf("failed to parse XML: more than one document element")
+			}
+			if err := checkAttributeWhitespace(xmlBytes[start:d.InputOffset()]); err != nil {
+				return nil, err
+			}
+			scope, out, err := writeC14NStart(&buf, tok, scopes[len(scopes)-1], rendered[len(rendered)-1])
+			if err != nil {
+				return nil, err
+			}
+			scopes, rendered = append(scopes, scope), append(rendered, out)
+			open = append(open, tok.Name)
+			seenRoot = true
+		case xml.EndElement:
+			if len(open) == 0 || open[len(open)-1] != tok.Name {
+				return nil, fmt.Errorf("failed to parse XML: unexpected end tag </%s>", qualifiedName(tok.Name))
+			}
+			buf.WriteString("</" + qualifiedName(tok.Name) + ">")
+			open = open[:len(open)-1]
+			scopes, rendered = scopes[:len(scopes)-1], rendered[:len(rendered)-1]
+		case xml.CharData:
+			if len(open) == 0 {
+				if len(bytes.TrimLeft(tok, " \t\r\n")) > 0 {
+					return nil, fmt.Errorf("failed to parse XML: text outside the document element")
+				}
+				continue
+			}
+			writeC14NText(&buf, string(tok))
+		case xml.ProcInst:
+			if tok.Target == "xml" {
+				continue
+			}
+			if len(open) == 0 && seenRoot {
+				buf.WriteByte('\n')
+			}
+			buf.WriteString("<?" + tok.Target)
+			if len(tok.Inst) > 0 {
+				buf.WriteString(" " + string(tok.Inst))
+			}
+			buf.WriteString("?>")
+			if len(open) == 0 && !seenRoot {
+				buf.WriteByte('\n')
+			}
+		case xml.Directive:
+			return nil, fmt.Errorf("%w: document type declarations and other directives", ErrUnsupportedXML)
+		case xml.Comment:
+			// Removed by canonicalization without comments.
+		}
+	}
+	if !seenRoot || len(open) > 0 {
+		return nil, fmt.Errorf("failed to parse XML: %w", io.ErrUnexpectedEOF)
+	}
+	return buf.Bytes(), nil
+}
+
+// writeC14NStart writes the canonical start tag of e, whose parent has the
+// namespaces parentScope in scope and parentRendered declared in the output.
+// It returns the same for e.
+func writeC14NStart(buf *bytes.Buffer, e xml.StartElement, parentScope, parentRendered map[string]string) (scope, rendered map[string]string, err error) {
+	scope = parentScope
+	declared := false
+	var attrs []xml.Attr
+	for _, a := range e.Attr {
+		prefix, ok := namespaceDeclaration(a.Name)
+		if !ok {
+			attrs = append(attrs, a)
+			continue
+		}
+		if !declared {
+			scope, declared = copyNamespaces(parentScope), true
+		}
+		scope[prefix] = a.Value
+	}
+
+	// Declare the namespaces that e visibly uses, where they differ from
+	// what the output already declares.
+	used := map[string]bool{e.Name.Space: true}
+	for _, a := range attrs {
+		if a.Name.Space != "" {
+			used[a.Name.Space] = true
+		}
+	}
+	rendered = parentRendered
+	var prefixes []string
+	for prefix := range used {
+		if prefix == "xml" {
+			continue
+		}
+		uri, ok := scope[prefix]
+		if !ok {
+			return nil, nil, fmt.Errorf("failed to parse XML: undeclared namespace prefix %q", prefix)
+		}
+		if parentRendered[prefix] == uri {
+			continue
+		}
+		if prefixes == nil {
+			rendered = copyNamespaces(parentRendered)
+		}
+		rendered[prefix] = uri
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+
+	type sortedAttr struct {
+		uri string
+		xml.Attr
+	}
+	sorted := make([]sortedAttr, len(attrs))
+	for i, a := range attrs {
+		sorted[i].Attr = a
+		switch a.Name.Space {
+		case "":
+		case "xml":
+			sorted[i].uri = xmlNamespace
+		default:
+			sorted[i].uri = scope[a.Name.Space]
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].uri != sorted[j].uri {
+			return sorted[i].uri < sorted[j].uri
+		}
+		return sorted[i].Name.Local < sorted[j].Name.Local
+	})
+
+	buf.WriteString("<" + qualifiedName(e.Name))
+	for _, prefix := range prefixes {
+		name := "xmlns"
+		if prefix != "" {
+			name += ":" + prefix
+		}
+		writeC14NAttr(buf, name, rendered[prefix])
+	}
+	for _, a := range sorted {
+		writeC14NAttr(buf, qualifiedName(a.Name), a.Value)
+	}
+	buf.WriteByte('>')
+	return scope, rendered, nil
+}
+
+// namespaceDeclaration reports whether the raw attribute name declares a
+// namespace, and if so, for which prefix; the default namespace has prefix "".
+func namespaceDeclaration(name xml.Name) (prefix string, ok bool) {
+	switch {
+	case name.Space == "" && name.Local == "xmlns":
+		return "", true
+	case name.Space == "xmlns":
+		return name.Local, true
+	default:
+		return "", false
+	}
+}
+
+func copyNamespaces(m map[string]string) map[string]string {
+	c := make(map[string]string, len(m)+1)
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// qualifiedName returns the raw name as written, with its prefix.
+func qualifiedName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
+
+var (
+	c14nTextEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+	c14nAttrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;",
+		"\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+)
+
+func writeC14NText(buf *bytes.Buffer, text string) {
+	c14nTextEscaper.WriteString(buf, text)
+}
+
+func writeC14NAttr(buf *bytes.Buffer, name, value string) {
+	buf.WriteString(" " + name + `="`)
+	c14nAttrEscaper.WriteString(buf, value)
+	buf.WriteByte('"')
+}
+
+// checkAttributeWhitespace returns an error wrapping ErrUnsupportedXML if the
+// raw start tag has a literal tab, newline or carriage return inside an
+// attribute value.
+func checkAttributeWhitespace(tag []byte) error {
+	var quote byte
+	for _, c := range tag {
+		switch {
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case c == quote:
+			quote = 0
+		case quote != 0 && (c == '\t' || c == '\n' || c == '\r'):
+			return fmt.Errorf("%w: literal whitespace %q in an attribute value", ErrUnsupportedXML, c)
+		}
+	}
+	return nil
+}
+
+// verifyXMLC14N verifies a signature over the canonical form of the XML
+// document xmlBytes, as produced by canonicalXML, with the key at keyPath.
+// The signer must have signed the same canonical form, so differences in
+// attribute order, namespace declarations, empty-element syntax and other
+// insignificant formatting do not matter. This checks a signature over the
+// whole document; it does not process XML-DSig Signature elements.
+func verifyXMLC14N(ctx context.Context, client *cloudkms.Service, signature string, xmlBytes []byte, keyPath string, opts ...Option) error {
+	message, err := canonicalXML(xmlBytes)
+	if err != nil {
+		return err
+	}
+	return verifySignature(ctx, client, signature, message, keyPath, opts...)
+}
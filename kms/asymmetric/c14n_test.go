@@ -0,0 +1,128 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestCanonicalXML(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{
+			// Section 2.2 of the Exclusive XML Canonicalization
+			// recommendation: n3 is declared where it is used.
+			"namespace pushdown",
+			`<n0:local xmlns:n0="foo:bar" xmlns:n3="ftp://example.org"><n1:elem2 xmlns:n1="http://example.net" xml:lang="en"><n3:stuff xmlns:n3="ftp://example.org"/></n1:elem2></n0:local>`,
+			`<n0:local xmlns:n0="foo:bar"><n1:elem2 xmlns:n1="http://example.net" xml:lang="en"><n3:stuff xmlns:n3="ftp://example.org"></n3:stuff></n1:elem2></n0:local>`,
+		},
+		{
+			// After section 3.3 of the Canonical XML recommendation.
+			"start and end tags",
+			`<?xml version="1.0"?>
+<!-- comment -->
+<doc>
+   <e1   />
+   <e2   ></e2>
+   <e3   name = "elem3"   id="elem3"   />
+   <e5 a:attr="out" b:attr="sorted" attr2="all" attr="I'm"
+      xmlns:b="http://www.ietf.org"
+      xmlns:a="http://www.w3.org"
+      xmlns="http://example.org"/>
+   <e6 xmlns="" xmlns:a="http://www.w3.org">
+       <e7 xmlns="http://www.ietf.org">
+           <e8 xmlns="" xmlns:a="http://www.w3.org">
+               <e9 xmlns="" xmlns:a="http://www.ietf.org"/>
+           </e8>
+       </e7>
+   </e6>
+</doc>
+`,
+			`<doc>
+   <e1></e1>
+   <e2></e2>
+   <e3 id="elem3" name="elem3"></e3>
+   <e5 xmlns="http://example.org" xmlns:a="http://www.w3.org" xmlns:b="http://www.ietf.org" attr="I'm" attr2="all" b:attr="sorted" a:attr="out"></e5>
+   <e6>
+       <e7 xmlns="http://www.ietf.org">
+           <e8 xmlns="">
+               <e9></e9>
+           </e8>
+       </e7>
+   </e6>
+</doc>`,
+		},
+		{
+			"escaping",
+			"<a x='&lt;&#9;&quot;&#xD;&gt;'>&amp;&gt;&#xD;\r\n<![CDATA[<b>]]></a>",
+			`<a x="&lt;&#x9;&quot;&#xD;>">&amp;&gt;&#xD;` + "\n" + `&lt;b&gt;</a>`,
+		},
+		{
+			"processing instructions",
+			"<?xml version=\"1.0\"?>\n<?before data?>\n<r><?in?></r>\n<?after?>\n",
+			"<?before data?>\n<r><?in?></r>\n<?after?>",
+		},
+	}
+	for _, test := range tests {
+		got, err := canonicalXML([]byte(test.in))
+		if err != nil {
+			t.Errorf("%s: canonicalXML: %v", test.name, err)
+			continue
+		}
+		if string(got) != test.want {
+			t.Errorf("%s: canonicalXML =\n%s\nwant\n%s", test.name, got, test.want)
+		}
+	}
+
+	for _, in := range []string{
+		`<!DOCTYPE r [<!ENTITY e "x">]><r>&e;</r>`,
+		"<r a=\"one\ntwo\"/>",
+	} {
+		if _, err := canonicalXML([]byte(in)); !errors.Is(err, ErrUnsupportedXML) {
+			t.Errorf("canonicalXML(%q): got %v, want ErrUnsupportedXML", in, err)
+		}
+	}
+	for _, in := range []string{
+		"",
+		"<r>",
+		"<r></s>",
+		"<r/><r/>",
+		"<r/>text",
+		"<p:r/>",
+	} {
+		if _, err := canonicalXML([]byte(in)); err == nil || errors.Is(err, ErrUnsupportedXML) {
+			t.Errorf("canonicalXML(%q): got %v, want a parse error", in, err)
+		}
+	}
+}
+
+func TestVerifyXMLC14N(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("ec-sign")
+	f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+
+	signed := `<r:doc xmlns:r="urn:r" id="1" lang="en"><item/></r:doc>`
+	canonical, err := canonicalXML([]byte(signed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := signAsymmetric(ctx, client, string(canonical), keyPath)
+	if err != nil {
+		t.Fatalf("signAsymmetric: %v", err)
+	}
+	reformatted := "<?xml version=\"1.0\"?>\n<r:doc lang='en' id=\"1\" xmlns:unused=\"urn:u\" xmlns:r=\"urn:r\" ><item></item><!-- note --></r:doc>\n"
+	if err := verifyXMLC14N(ctx, client, signature, []byte(reformatted), keyPath); err != nil {
+		t.Errorf("verifyXMLC14N of an equivalent document: %v", err)
+	}
+	tampered := `<r:doc xmlns:r="urn:r" id="2" lang="en"><item/></r:doc>`
+	if err := verifyXMLC14N(ctx, client, signature, []byte(tampered), keyPath); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("verifyXMLC14N of a changed document: got %v, want ErrSignatureInvalid", err)
+	}
+}
@@ -0,0 +1,33 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+
+	"golang.org/x/net/context"
+)
+
+// kmsCall sends one KMS request, to the method op, such as "GetPublicKey",
+// of the resource name. Every request goes through it, so that each is
+// treated alike: an AsymmetricSign or AsymmetricDecrypt request first waits
+// for WithRateLimit, header, the header of the request, gets the headers of
+// WithHeader, and the response header, or that of an error response, goes
+// to WithResponseHeaders and the quota observers. do sends the request and
+// returns the header of the response; its latency is reported to WithStats.
+func (o *options) kmsCall(ctx context.Context, op, name string, header http.Header, do func() (http.Header, error)) error {
+	switch op {
+	case "AsymmetricSign", "AsymmetricDecrypt":
+		if err := o.waitRateLimit(ctx, name); err != nil {
+			return err
+		}
+	}
+	o.setHeaders(header)
+	start := o.startCall()
+	responseHeader, err := do()
+	o.endCall(op, start)
+	o.captureHeader(responseHeader, err)
+	return err
+}
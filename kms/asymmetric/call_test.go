@@ -0,0 +1,41 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestKMSCallPages(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("ec-sign")
+	key := strings.TrimSuffix(keyPath, "1")
+	for _, version := range []string{"1", "2", "3", "4"} {
+		f.addKey(t, key+version, "EC_SIGN_P256_SHA256")
+	}
+	stats := newLatencyStats(0)
+
+	// The versions span two pages, sent with the same request headers.
+	older, err := previousVersions(ctx, client, key+"4", 3, newOptions([]Option{WithHeader("X-Test", "1"), WithStats(stats)}))
+	if err != nil {
+		t.Fatalf("previousVersions: %v", err)
+	}
+	if want := []string{key + "3", key + "2", key + "1"}; !reflect.DeepEqual(older, want) {
+		t.Errorf("previousVersions = %q, want %q", older, want)
+	}
+	if n := stats.Count("ListCryptoKeyVersions"); n != 2 {
+		t.Errorf("recorded %d ListCryptoKeyVersions latencies, want 2", n)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if got := f.lastHeader.Values("X-Test"); !reflect.DeepEqual(got, []string{"1"}) {
+		t.Errorf("X-Test of the second page = %q, want [\"1\"]", got)
+	}
+}
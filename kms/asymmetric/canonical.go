@@ -0,0 +1,57 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// canonicalJSON encodes v as JSON in a deterministic form, so that signer and
+// verifier produce identical bytes for equal values:
+//   - object keys are sorted, including the fields of structs;
+//   - there is no insignificant whitespace;
+//   - '<', '>' and '&' are not escaped;
+//   - numbers keep the text produced by encoding/json for v.
+//
+// This is not a full implementation of RFC 8785: numbers are not normalized,
+// so a signer in another language must format them as Go does.
+func canonicalJSON(v interface{}) ([]byte, error) {
+	// Round-trip through a generic value so that struct fields are sorted
+	// like map keys. UseNumber keeps numbers exactly as first encoded.
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	var generic interface{}
+	if err := decoder.Decode(&generic); err != nil {
+		return nil, fmt.Errorf("failed to decode JSON: %w", err)
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(generic); err != nil {
+		return nil, fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+	// Encode appends a newline.
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// verifyCanonicalJSON verifies a signature over the canonical JSON encoding of
+// obj, as produced by canonicalJSON. The signer must have signed the same
+// encoding, so key order in its JSON text does not matter.
+func verifyCanonicalJSON(ctx context.Context, client *cloudkms.Service, signature string, obj interface{}, keyPath string, opts ...Option) error {
+	message, err := canonicalJSON(obj)
+	if err != nil {
+		return err
+	}
+	return verifySignature(ctx, client, signature, message, keyPath, opts...)
+}
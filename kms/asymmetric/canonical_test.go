@@ -0,0 +1,41 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestVerifyCanonicalJSON(t *testing.T) {
+	f, client := newFakeKMS(t)
+	ctx := context.Background()
+	// verifyCanonicalJSON must check the signature with the padding and hash
+	// of the key's algorithm, not just those of the common case.
+	for _, alg := range []string{"EC_SIGN_P256_SHA256", "RSA_SIGN_PKCS1_2048_SHA256", "RSA_SIGN_PSS_4096_SHA512"} {
+		keyPath := testKeyPath(alg)
+		f.addKey(t, keyPath, alg)
+
+		// The signer saw the keys in a different order than the verifier's struct.
+		signed := `{"amount":10,"currency":"EUR","note":"a<b"}`
+		sig, err := signAsymmetric(ctx, client, signed, keyPath)
+		if err != nil {
+			t.Fatalf("%s: signAsymmetric: %v", alg, err)
+		}
+		obj := struct {
+			Note     string `json:"note"`
+			Currency string `json:"currency"`
+			Amount   int    `json:"amount"`
+		}{"a<b", "EUR", 10}
+		if err := verifyCanonicalJSON(ctx, client, sig, obj, keyPath); err != nil {
+			t.Errorf("%s: verifyCanonicalJSON: %v", alg, err)
+		}
+		obj.Amount = 11
+		if err := verifyCanonicalJSON(ctx, client, sig, obj, keyPath); err == nil {
+			t.Errorf("%s: verifyCanonicalJSON of modified object should fail", alg)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"unicode/utf8"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// canonicalCBOR encodes v as CBOR in the core deterministic encoding of
+// RFC 8949, section 4.2.1, so that signer and verifier produce identical
+// bytes for equal values:
+//   - integers, lengths and tags use the shortest form of their head, and
+//     lengths are never indefinite;
+//   - floats use the shortest of half, single and double precision that
+//     represents the value exactly, and every NaN is encoded as 0xf97e00;
+//   - map keys are sorted by the bytewise order of their own encodings, and
+//     duplicate keys are an error;
+//   - strings must be valid UTF-8 and are text strings; []byte and [N]byte
+//     are byte strings.
+//
+// Structs are encoded as maps from field name to value. A `cbor:"name"` tag
+// renames a field and `cbor:"-"` omits it; unexported fields are omitted. nil
+// pointers, interfaces, slices and maps are encoded as null. Other types,
+// such as channels and functions, are rejected.
+func canonicalCBOR(v interface{}) ([]byte, error) {
+	return appendCBOR(nil, reflect.ValueOf(v))
+}
+
+func appendCBOR(dst []byte, v reflect.Value) ([]byte, error) {
+	if !v.IsValid() {
+		return append(dst, 0xf6), nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return append(dst, 0xf6), nil
+		}
+		return appendCBOR(dst, v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			return append(dst, 0xf5), nil
+		}
+		return append(dst, 0xf4), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return append(dst, cborInt(v.Int())...), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return append(dst, cborHead(0, v.Uint())...), nil
+	case reflect.Float32, reflect.Float64:
+		return append(dst, cborFloat(v.Float())...), nil
+	case reflect.String:
+		if !utf8.ValidString(v.String()) {
+			return nil, fmt.Errorf("CBOR text string %q is not valid UTF-8", v.String())
+		}
+		dst = append(dst, cborHead(3, uint64(v.Len()))...)
+		return append(dst, v.String()...), nil
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return append(dst, 0xf6), nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			dst = append(dst, cborHead(2, uint64(v.Len()))...)
+			for i := 0; i < v.Len(); i++ {
+				dst = append(dst, byte(v.Index(i).Uint()))
+			}
+			return dst, nil
+		}
+		dst = append(dst, cborHead(4, uint64(v.Len()))...)
+		for i := 0; i < v.Len(); i++ {
+			var err error
+			if dst, err = appendCBOR(dst, v.Index(i)); err != nil {
+				return nil, err
+			}
+		}
+		return dst, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return append(dst, 0xf6), nil
+		}
+		var entries []cborEntry
+		for it := v.MapRange(); it.Next(); {
+			key, err := appendCBOR(nil, it.Key())
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, cborEntry{key, it.Value()})
+		}
+		return appendCBORMap(dst, entries)
+	case reflect.Struct:
+		var entries []cborEntry
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := field.Name
+			if tag := field.Tag.Get("cbor"); tag == "-" || field.PkgPath != "" {
+				continue
+			} else if tag != "" {
+				name = tag
+			}
+			key, err := appendCBOR(nil, reflect.ValueOf(name))
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, cborEntry{key, v.Field(i)})
+		}
+		return appendCBORMap(dst, entries)
+	default:
+		return nil, fmt.Errorf("cannot encode %v as CBOR", v.Type())
+	}
+}
+
+// cborEntry is a map entry whose key has already been encoded.
+type cborEntry struct {
+	key   []byte
+	value reflect.Value
+}
+
+// appendCBORMap appends a map of entries, sorted by encoded key.
+func appendCBORMap(dst []byte, entries []cborEntry) ([]byte, error) {
+	sort.Slice(entries, func(i, j int) bool { return bytes.Compare(entries[i].key, entries[j].key) < 0 })
+	dst = append(dst, cborHead(5, uint64(len(entries)))...)
+	for i, e := range entries {
+		if i > 0 && bytes.Equal(e.key, entries[i-1].key) {
+			return nil, fmt.Errorf("duplicate CBOR map key %x", e.key)
+		}
+		dst = append(dst, e.key...)
+		var err error
+		if dst, err = appendCBOR(dst, e.value); err != nil {
+			return nil, err
+		}
+	}
+	return dst, nil
+}
+
+// cborFloat encodes f as a CBOR float in the shortest exact form.
+func cborFloat(f float64) []byte {
+	if math.IsNaN(f) {
+		return []byte{0xf9, 0x7e, 0x00}
+	}
+	f32 := float32(f)
+	if float64(f32) != f {
+		return binary.BigEndian.AppendUint64([]byte{0xfb}, math.Float64bits(f))
+	}
+	bits := math.Float32bits(f32)
+	if half, ok := float16Bits(bits); ok {
+		return binary.BigEndian.AppendUint16([]byte{0xf9}, half)
+	}
+	return binary.BigEndian.AppendUint32([]byte{0xfa}, bits)
+}
+
+// float16Bits converts the single-precision float with the given bits to
+// half precision, if it can be represented exactly.
+func float16Bits(bits uint32) (uint16, bool) {
+	sign := uint16(bits>>16) & 0x8000
+	exp := int(bits>>23) & 0xff
+	mant := bits & 0x7fffff
+	switch {
+	case exp == 0xff:
+		// Infinity; NaN is handled by the caller.
+		return sign | 0x7c00, true
+	case exp == 0 && mant == 0:
+		return sign, true
+	case exp == 0:
+		// Single-precision subnormals are far below the half range.
+		return 0, false
+	}
+	e := exp - 127
+	switch {
+	case e >= -14 && e <= 15 && mant&0x1fff == 0:
+		return sign | uint16(e+15)<<10 | uint16(mant>>13), true
+	case e >= -24 && e < -14:
+		// A half-precision subnormal is m * 2^-24 with m < 1024.
+		full := mant | 0x800000
+		shift := uint(-1 - e)
+		if full&(1<<shift-1) != 0 {
+			return 0, false
+		}
+		return sign | uint16(full>>shift), true
+	default:
+		return 0, false
+	}
+}
+
+// verifyCBOR verifies a signature over the canonical CBOR encoding of obj, as
+// produced by canonicalCBOR. The signer must have signed the same encoding;
+// any CBOR encoder that implements the core deterministic encoding of
+// RFC 8949 produces it for the same data model.
+func verifyCBOR(ctx context.Context, client *cloudkms.Service, signature string, obj interface{}, keyPath string, opts ...Option) error {
+	message, err := canonicalCBOR(obj)
+	if err != nil {
+		return err
+	}
+	return verifySignature(ctx, client, signature, message, keyPath, opts...)
+}
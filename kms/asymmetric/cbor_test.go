@@ -0,0 +1,107 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/hex"
+	"math"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestCanonicalCBOR(t *testing.T) {
+	// Examples from RFC 8949, appendix A, in their deterministic form.
+	tests := []struct {
+		v    interface{}
+		want string
+	}{
+		{0, "00"},
+		{23, "17"},
+		{24, "1818"},
+		{uint16(1000), "1903e8"},
+		{int64(1000000000000), "1b000000e8d4a51000"},
+		{-1, "20"},
+		{-1000, "3903e7"},
+		{0.0, "f90000"},
+		{math.Copysign(0, -1), "f98000"},
+		{1.5, "f93e00"},
+		{65504.0, "f97bff"},
+		{100000.0, "fa47c35000"},
+		{3.4028234663852886e+38, "fa7f7fffff"},
+		{1.1, "fb3ff199999999999a"},
+		{5.960464477539063e-8, "f90001"},
+		{0.00006103515625, "f90400"},
+		{-4.0, "f9c400"},
+		{math.Inf(1), "f97c00"},
+		{math.NaN(), "f97e00"},
+		{float32(1.5), "f93e00"},
+		{false, "f4"},
+		{true, "f5"},
+		{nil, "f6"},
+		{"a", "6161"},
+		{"ü", "62c3bc"},
+		{[]byte{1, 2, 3, 4}, "4401020304"},
+		{[]interface{}{1, []int{2, 3}}, "8201820203"},
+		{map[string]interface{}{"b": []int{2, 3}, "a": 1}, "a26161016162820203"},
+		// Keys sort by encoding: 10 (0x0a) before -1 (0x20) before "z".
+		{map[interface{}]int{"z": 0, -1: 0, 10: 0}, "a30a00200061" + "7a00"},
+		{struct {
+			B int `cbor:"b"`
+			A int
+			c int
+			D int `cbor:"-"`
+		}{1, 2, 3, 4}, "a2614102616201"},
+	}
+	for _, test := range tests {
+		got, err := canonicalCBOR(test.v)
+		if err != nil {
+			t.Errorf("canonicalCBOR(%#v): %v", test.v, err)
+			continue
+		}
+		if hex.EncodeToString(got) != test.want {
+			t.Errorf("canonicalCBOR(%#v) = %x, want %s", test.v, got, test.want)
+		}
+	}
+
+	for _, v := range []interface{}{
+		make(chan int),
+		"\xff",
+		map[interface{}]int{1: 0, uint(1): 0},
+	} {
+		if _, err := canonicalCBOR(v); err == nil {
+			t.Errorf("canonicalCBOR(%#v) succeeded", v)
+		}
+	}
+}
+
+func TestVerifyCBOR(t *testing.T) {
+	f, client := newFakeKMS(t)
+	ctx := context.Background()
+	keyPath := testKeyPath("ec-sign")
+	f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+
+	// The signer had a map; the verifier has a struct with the same fields
+	// in another order.
+	signed, err := canonicalCBOR(map[string]interface{}{"amount": 10, "currency": "EUR"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := signAsymmetric(ctx, client, string(signed), keyPath)
+	if err != nil {
+		t.Fatalf("signAsymmetric: %v", err)
+	}
+	obj := struct {
+		Currency string `cbor:"currency"`
+		Amount   int    `cbor:"amount"`
+	}{"EUR", 10}
+	if err := verifyCBOR(ctx, client, sig, obj, keyPath); err != nil {
+		t.Errorf("verifyCBOR: %v", err)
+	}
+	obj.Amount = 11
+	if err := verifyCBOR(ctx, client, sig, obj, keyPath); err == nil {
+		t.Errorf("verifyCBOR of modified object should fail")
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// kmsAlgorithmToX509 returns the x509 signature algorithm matching a KMS
+// signing algorithm such as "RSA_SIGN_PSS_2048_SHA256".
+func kmsAlgorithmToX509(alg string) (x509.SignatureAlgorithm, error) {
+	var hash string
+	switch {
+	case strings.HasSuffix(alg, "_SHA256"):
+		hash = "SHA256"
+	case strings.HasSuffix(alg, "_SHA384"):
+		hash = "SHA384"
+	case strings.HasSuffix(alg, "_SHA512"):
+		hash = "SHA512"
+	default:
+		return x509.UnknownSignatureAlgorithm, fmt.Errorf("no x509 signature algorithm for %s", alg)
+	}
+	var family string
+	switch {
+	case strings.HasPrefix(alg, "RSA_SIGN_PSS_"):
+		family = "PSS"
+	case strings.HasPrefix(alg, "RSA_SIGN_PKCS1_"):
+		family = "PKCS1"
+	case strings.HasPrefix(alg, "EC_SIGN_P"):
+		family = "ECDSA"
+	default:
+		return x509.UnknownSignatureAlgorithm, fmt.Errorf("no x509 signature algorithm for %s", alg)
+	}
+	algorithms := map[string]x509.SignatureAlgorithm{
+		"PSS/SHA256":   x509.SHA256WithRSAPSS,
+		"PSS/SHA384":   x509.SHA384WithRSAPSS,
+		"PSS/SHA512":   x509.SHA512WithRSAPSS,
+		"PKCS1/SHA256": x509.SHA256WithRSA,
+		"PKCS1/SHA384": x509.SHA384WithRSA,
+		"PKCS1/SHA512": x509.SHA512WithRSA,
+		"ECDSA/SHA256": x509.ECDSAWithSHA256,
+		"ECDSA/SHA384": x509.ECDSAWithSHA384,
+		"ECDSA/SHA512": x509.ECDSAWithSHA512,
+	}
+	return algorithms[family+"/"+hash], nil
+}
+
+// createCertificate creates a DER-encoded certificate for pub from template,
+// signed by the KMS key behind signer on behalf of parent. Pass template as
+// parent to create a self-signed certificate. The certificate's signature
+// algorithm is set from the KMS key's algorithm so that it always matches the
+// signature KMS produces; template itself is not modified.
+func createCertificate(template, parent *x509.Certificate, pub crypto.PublicKey, signer *kmsSigner) ([]byte, error) {
+	sigAlg, err := kmsAlgorithmToX509(signer.algorithm)
+	if err != nil {
+		return nil, err
+	}
+	t := *template
+	t.SignatureAlgorithm = sigAlg
+	der, err := x509.CreateCertificate(rand.Reader, &t, parent, pub, signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+	return der, nil
+}
+
+// WithCertValidity makes verifySignatureWithCert and verifyWithChain check
+// that the signer's certificate is within its validity period, at the time
+// given by WithClock or else now. A certificate outside it fails with
+// ErrCertNotYetValid or ErrCertExpired, before the signature is checked.
+func WithCertValidity() Option {
+	return func(o *options) { o.checkCertValidity = true }
+}
+
+// certValidity enforces WithCertValidity for cert.
+func (o *options) certValidity(cert *x509.Certificate) error {
+	if !o.checkCertValidity {
+		return nil
+	}
+	now := o.now()
+	if now.Before(cert.NotBefore) {
+		return fmt.Errorf("%w: valid from %v", ErrCertNotYetValid, cert.NotBefore)
+	}
+	if now.After(cert.NotAfter) {
+		return fmt.Errorf("%w: expired at %v", ErrCertExpired, cert.NotAfter)
+	}
+	return nil
+}
+
+// parseCertificatePEM parses the first PEM block of certPEM as a certificate.
+func parseCertificatePEM(certPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("not a PEM-encoded certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+	return cert, nil
+}
+
+// verifySignatureWithCert checks that signature is a valid signature over
+// message by the key in the PEM-encoded certificate certPEM. The certificate
+// is not checked against any CA; use verifyWithChain for that.
+func verifySignatureWithCert(signature, message string, certPEM []byte, opts ...Option) error {
+	cert, err := parseCertificatePEM(certPEM)
+	if err != nil {
+		return err
+	}
+	if err := newOptions(opts).certValidity(cert); err != nil {
+		return err
+	}
+	// With the public key supplied, verifySignature makes no KMS requests.
+	opts = append(opts[:len(opts):len(opts)], WithPublicKey(cert.PublicKey))
+	return verifySignature(context.Background(), nil, signature, []byte(message), "", opts...)
+}
+
+// verifyWithChain checks that signature is a valid signature over message by
+// the key in the PEM-encoded certificate leafPEM, and that the certificate
+// was issued by one of the PEM-encoded CA certificates in caPEM. It returns
+// an error wrapping ErrChainInvalid if the certificate is not trusted, and
+// one wrapping ErrSignatureInvalid if the signature does not match.
+// The chain is checked at the time given by WithClock, or else now.
+func verifyWithChain(signature, message string, leafPEM, caPEM []byte, opts ...Option) error {
+	o := newOptions(opts)
+	leaf, err := parseCertificatePEM(leafPEM)
+	if err != nil {
+		return fmt.Errorf("%w: leaf: %w", ErrChainInvalid, err)
+	}
+	if err := o.certValidity(leaf); err != nil {
+		return err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caPEM) {
+		return errors.New("no CA certificates found in caPEM")
+	}
+	verifyOptions := x509.VerifyOptions{
+		Roots:       roots,
+		CurrentTime: o.now(),
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	if _, err := leaf.Verify(verifyOptions); err != nil {
+		return fmt.Errorf("%w: %w", ErrChainInvalid, err)
+	}
+	// With the public key supplied, verifySignature makes no KMS requests.
+	opts = append(opts[:len(opts):len(opts)], WithPublicKey(leaf.PublicKey))
+	return verifySignature(context.Background(), nil, signature, []byte(message), "", opts...)
+}
+
+// verifyCertChain builds a path from the PEM-encoded certificate leafPEM to
+// one of the PEM-encoded root certificates in rootsPEM, through any of the
+// PEM-encoded intermediate certificates in chainPEM, which may be empty and
+// need not be in order. Every signature on the path is checked, whatever
+// algorithm KMS signed it with, and every certificate must be within its
+// validity period at the time given by WithClock, or else now.
+//
+// It returns the verified chain, from the leaf to the root. If no path can
+// be built, the error wraps ErrChainInvalid and the x509 error saying why.
+func verifyCertChain(leafPEM, chainPEM, rootsPEM []byte, opts ...Option) ([]*x509.Certificate, error) {
+	o := newOptions(opts)
+	leaf, err := parseCertificatePEM(leafPEM)
+	if err != nil {
+		return nil, fmt.Errorf("%w: leaf: %w", ErrChainInvalid, err)
+	}
+	intermediates := x509.NewCertPool()
+	for rest := chainPEM; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%w: intermediate: failed to parse certificate: %w", ErrChainInvalid, err)
+		}
+		intermediates.AddCert(cert)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(rootsPEM) {
+		return nil, errors.New("no root certificates found in rootsPEM")
+	}
+	chains, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   o.now(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: no path from %q to a trusted root: %w", ErrChainInvalid, leaf.Subject.String(), err)
+	}
+	return chains[0], nil
+}
+
+// checkCertificateKey returns an error unless cert certifies the public key
+// of signer.
+func checkCertificateKey(cert *x509.Certificate, signer crypto.Signer) error {
+	certKey, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+	if err != nil {
+		return fmt.Errorf("failed to encode certificate public key: %w", err)
+	}
+	signerKey, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return fmt.Errorf("failed to encode signer public key: %w", err)
+	}
+	if !bytes.Equal(certKey, signerKey) {
+		return errors.New("certificate is not for the signer's key")
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// testCertificate creates a PEM-encoded certificate for key, issued by
+// parent and signed with parentKey, or self-signed if parent is nil.
+func testCertificate(t *testing.T, name string, key, parentKey *ecdsa.PrivateKey, parent *x509.Certificate) (*x509.Certificate, []byte) {
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("CreateCertificate(%s): %v", name, err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestVerifyWithChain(t *testing.T) {
+	newKey := func() *ecdsa.PrivateKey {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+	caKey, otherCAKey, leafKey := newKey(), newKey(), newKey()
+	ca, caPEM := testCertificate(t, "ca", caKey, nil, nil)
+	_, otherCAPEM := testCertificate(t, "other ca", otherCAKey, nil, nil)
+	_, leafPEM := testCertificate(t, "leaf", leafKey, caKey, ca)
+
+	digest := sha256.Sum256([]byte("message"))
+	sig, err := ecdsa.SignASN1(rand.Reader, leafKey, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := base64.StdEncoding.EncodeToString(sig)
+
+	if err := verifyWithChain(signature, "message", leafPEM, caPEM); err != nil {
+		t.Errorf("verifyWithChain: %v", err)
+	}
+	if err := verifyWithChain(signature, "tampered", leafPEM, caPEM); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("verifyWithChain with wrong message: got %v, want ErrSignatureInvalid", err)
+	}
+	if err := verifyWithChain(signature, "message", leafPEM, otherCAPEM); !errors.Is(err, ErrChainInvalid) {
+		t.Errorf("verifyWithChain with untrusted CA: got %v, want ErrChainInvalid", err)
+	}
+}
+
+func TestVerifySignatureWithCertValidity(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, certPEM := testCertificate(t, "signer", key, nil, nil)
+	digest := sha256.Sum256([]byte("message"))
+	sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := base64.StdEncoding.EncodeToString(sig)
+
+	at := func(t time.Time) Option { return WithClock(func() time.Time { return t }) }
+	tests := []struct {
+		name string
+		opts []Option
+		want error
+	}{
+		{"no validity check", []Option{at(cert.NotAfter.Add(time.Hour))}, nil},
+		{"within validity", []Option{WithCertValidity()}, nil},
+		{"expired", []Option{WithCertValidity(), at(cert.NotAfter.Add(time.Minute))}, ErrCertExpired},
+		{"not yet valid", []Option{WithCertValidity(), at(cert.NotBefore.Add(-time.Minute))}, ErrCertNotYetValid},
+	}
+	for _, test := range tests {
+		if err := verifySignatureWithCert(signature, "message", certPEM, test.opts...); !errors.Is(err, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.want)
+		}
+	}
+	if err := verifySignatureWithCert(signature, "tampered", certPEM, WithCertValidity()); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("wrong message: got %v, want ErrSignatureInvalid", err)
+	}
+	expired := []Option{WithCertValidity(), at(cert.NotAfter.Add(time.Minute))}
+	if err := verifyWithChain(signature, "message", certPEM, certPEM, expired...); !errors.Is(err, ErrCertExpired) {
+		t.Errorf("verifyWithChain: got %v, want ErrCertExpired", err)
+	}
+
+	// The caller's slice has room to spare, but the public key must not be
+	// appended into it, where concurrent callers sharing it would race.
+	shared := make([]Option, 1, 4)
+	shared[0] = WithCertValidity()
+	verifySignatureWithCert(signature, "message", certPEM, shared...)
+	verifyWithChain(signature, "message", certPEM, certPEM, shared...)
+	if spare := shared[1:cap(shared)]; spare[0] != nil {
+		t.Error("verifySignatureWithCert wrote into the caller's options")
+	}
+}
+
+func TestVerifyCertChain(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	newCA := func(id, algName string) *kmsSigner {
+		keyPath := testKeyPath(id)
+		f.addKey(t, keyPath, algName)
+		signer, err := newKMSSigner(ctx, client, keyPath)
+		if err != nil {
+			t.Fatalf("newKMSSigner(%s): %v", algName, err)
+		}
+		return signer
+	}
+	issue := func(name string, template, parent *x509.Certificate, pub crypto.PublicKey, signer *kmsSigner) (*x509.Certificate, []byte) {
+		template.SerialNumber = big.NewInt(time.Now().UnixNano())
+		template.Subject = pkix.Name{CommonName: name}
+		template.NotBefore = time.Now().Add(-time.Hour)
+		template.NotAfter = time.Now().Add(time.Hour)
+		if parent == nil {
+			parent = template
+		}
+		der, err := createCertificate(template, parent, pub, signer)
+		if err != nil {
+			t.Fatalf("createCertificate(%s): %v", name, err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	}
+	caTemplate := func() *x509.Certificate {
+		return &x509.Certificate{
+			KeyUsage:              x509.KeyUsageCertSign,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}
+	}
+
+	rootSigner := newCA("root", "RSA_SIGN_PSS_2048_SHA256")
+	intermediateSigner := newCA("intermediate", "EC_SIGN_P384_SHA384")
+	root, rootPEM := issue("root", caTemplate(), nil, rootSigner.Public(), rootSigner)
+	intermediate, intermediatePEM := issue("intermediate", caTemplate(), root, intermediateSigner.Public(), rootSigner)
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafTemplate := &x509.Certificate{KeyUsage: x509.KeyUsageDigitalSignature}
+	leaf, leafPEM := issue("leaf", leafTemplate, intermediate, &leafKey.PublicKey, intermediateSigner)
+	if leafTemplate.SignatureAlgorithm != x509.UnknownSignatureAlgorithm {
+		t.Errorf("createCertificate set the template's SignatureAlgorithm to %v", leafTemplate.SignatureAlgorithm)
+	}
+
+	chain, err := verifyCertChain(leafPEM, intermediatePEM, rootPEM)
+	if err != nil {
+		t.Fatalf("verifyCertChain: %v", err)
+	}
+	if len(chain) != 3 || !chain[0].Equal(leaf) || !chain[1].Equal(intermediate) || !chain[2].Equal(root) {
+		t.Errorf("verifyCertChain returned %d certificates, want leaf, intermediate, root", len(chain))
+	}
+	if chain[1].SignatureAlgorithm != x509.SHA256WithRSAPSS || chain[0].SignatureAlgorithm != x509.ECDSAWithSHA384 {
+		t.Errorf("signature algorithms = %v, %v; want %v, %v", chain[1].SignatureAlgorithm, chain[0].SignatureAlgorithm, x509.SHA256WithRSAPSS, x509.ECDSAWithSHA384)
+	}
+
+	// An intermediate with the right name but signed by another key is no
+	// path to the root.
+	otherSigner := newCA("other", "EC_SIGN_P256_SHA256")
+	otherRoot, otherRootPEM := issue("root", caTemplate(), nil, otherSigner.Public(), otherSigner)
+	_, forgedPEM := issue("intermediate", caTemplate(), otherRoot, intermediateSigner.Public(), otherSigner)
+	tests := []struct {
+		name            string
+		chainPEM, roots []byte
+	}{
+		{"missing intermediate", nil, rootPEM},
+		{"forged intermediate", forgedPEM, rootPEM},
+		{"untrusted root", intermediatePEM, otherRootPEM},
+	}
+	for _, test := range tests {
+		if _, err := verifyCertChain(leafPEM, test.chainPEM, test.roots); !errors.Is(err, ErrChainInvalid) {
+			t.Errorf("%s: got %v, want ErrChainInvalid", test.name, err)
+		}
+	}
+	expired := WithClock(func() time.Time { return leaf.NotAfter.Add(time.Minute) })
+	if _, err := verifyCertChain(leafPEM, intermediatePEM, rootPEM, expired); !errors.Is(err, ErrChainInvalid) {
+		t.Errorf("expired chain: got %v, want ErrChainInvalid", err)
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// challengeContext prefixes the nonce of a device attestation challenge
+// before it is signed, so that a signed challenge cannot be passed off as a
+// signature over any other message by the same key, nor the other way round.
+const challengeContext = "kms-device-challenge-v1\x00"
+
+// minChallengeNonce is the shortest nonce signChallenge and verifyChallenge
+// accept, in bytes, so that a server cannot issue nonces short enough to
+// repeat.
+const minChallengeNonce = 16
+
+// signChallenge proves possession of the key at keyPath by signing nonce, a
+// random challenge issued by a server, bound to the current time as
+// signTimestamped does. It returns the signature and the time signed, which
+// the device sends back to the server for verifyChallenge.
+func signChallenge(ctx context.Context, client *cloudkms.Service, nonce []byte, keyPath string, opts ...Option) (string, time.Time, error) {
+	if len(nonce) < minChallengeNonce {
+		return "", time.Time{}, fmt.Errorf("nonce is %d bytes; want at least %d", len(nonce), minChallengeNonce)
+	}
+	return signTimestamped(ctx, client, challengeMessage(nonce), keyPath, opts...)
+}
+
+// verifyChallenge checks a response to the challenge nonce made by
+// signChallenge with the key at keyPath: the signature must match, and ts
+// must be no more than maxAge old, as verifyTimestamped checks, so that an
+// old response cannot be replayed. The caller must still check that it
+// issued nonce and accept each nonce only once. Errors are those of
+// verifyTimestamped.
+func verifyChallenge(ctx context.Context, client *cloudkms.Service, signature string, nonce []byte, ts time.Time, maxAge time.Duration, keyPath string, opts ...Option) error {
+	if len(nonce) < minChallengeNonce {
+		return fmt.Errorf("nonce is %d bytes; want at least %d", len(nonce), minChallengeNonce)
+	}
+	if maxAge <= 0 {
+		return fmt.Errorf("maximum challenge age must be positive, got %v", maxAge)
+	}
+	return verifyTimestamped(ctx, client, signature, challengeMessage(nonce), ts, maxAge, keyPath, opts...)
+}
+
+// challengeMessage returns the payload signed for nonce.
+func challengeMessage(nonce []byte) []byte {
+	return append([]byte(challengeContext), nonce...)
+}
@@ -0,0 +1,56 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestSignVerifyChallenge(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("device")
+	f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+	now := time.Unix(1500000000, 0)
+	clock := WithClock(func() time.Time { return now })
+	nonce := bytes.Repeat([]byte{0x5a}, 32)
+
+	signature, ts, err := signChallenge(ctx, client, nonce, keyPath, clock)
+	if err != nil {
+		t.Fatalf("signChallenge: %v", err)
+	}
+	if !ts.Equal(now) {
+		t.Errorf("signChallenge time = %v, want %v", ts, now)
+	}
+	if err := verifyChallenge(ctx, client, signature, nonce, ts, time.Minute, keyPath, clock); err != nil {
+		t.Errorf("verifyChallenge: %v", err)
+	}
+
+	otherNonce := bytes.Repeat([]byte{0xa5}, 32)
+	if err := verifyChallenge(ctx, client, signature, otherNonce, ts, time.Minute, keyPath, clock); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("verifyChallenge with another nonce: got %v, want ErrSignatureInvalid", err)
+	}
+	later := WithClock(func() time.Time { return now.Add(2 * time.Minute) })
+	if err := verifyChallenge(ctx, client, signature, nonce, ts, time.Minute, keyPath, later); !errors.Is(err, ErrTimestampStale) {
+		t.Errorf("verifyChallenge of a replayed response: got %v, want ErrTimestampStale", err)
+	}
+
+	// A signed challenge is not a timestamped signature over the nonce.
+	if err := verifyTimestamped(ctx, client, signature, nonce, ts, time.Minute, keyPath, clock); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("verifyTimestamped of a challenge response: got %v, want ErrSignatureInvalid", err)
+	}
+
+	if _, _, err := signChallenge(ctx, client, nonce[:8], keyPath); err == nil {
+		t.Error("signChallenge with an 8-byte nonce: got nil error")
+	}
+	if err := verifyChallenge(ctx, client, signature, nonce, ts, 0, keyPath, clock); err == nil {
+		t.Error("verifyChallenge with no maximum age: got nil error")
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// A chunked file is envelope encrypted so that decryption can start at any
+// chunk:
+//
+//	header: "KMSC" || version (1 byte) || uint32 chunk size ||
+//	        nonce prefix (7 bytes) || uint16 wrapped DEK length || wrapped DEK
+//	chunks: AES-256-GCM(DEK, nonce_i, plaintext_i, additional data = header)
+//
+// Integers are big-endian. The wrapped DEK is the raw RSA-OAEP ciphertext
+// from generateAndWrapDEK. Every chunk but the last holds exactly chunk size
+// bytes of plaintext; the last holds fewer, possibly none, so a file always
+// ends with a short chunk and truncation at a chunk boundary is detected.
+// nonce_i is the nonce prefix || uint32 i || 1 for the last chunk, else 0, so
+// chunks cannot be reordered or passed off as the last one. Since chunks
+// have a fixed size, chunk i starts at len(header) + i*(chunk size + 16).
+
+const (
+	chunkedMagic   = "KMSC"
+	chunkedVersion = 1
+	// chunkedChunkSize is the plaintext size of each chunk written by
+	// encryptChunked.
+	chunkedChunkSize = 64 << 10
+	// chunkedMaxChunkSize bounds the chunk size read from a header, so that
+	// a corrupt header cannot make decryptChunked allocate without limit.
+	chunkedMaxChunkSize = 16 << 20
+	chunkedPrefixSize   = 7
+	// aesGCMOverhead is the size of the authentication tag of each chunk.
+	aesGCMOverhead = 16
+)
+
+// chunkedHeader is the parsed header of a chunked file.
+type chunkedHeader struct {
+	raw         []byte
+	chunkSize   int
+	noncePrefix []byte
+	wrappedDEK  []byte
+}
+
+// encryptChunked reads plaintext from r until EOF and writes it to w in the
+// chunked format, under a new DEK wrapped with the RSA key at keyPath.
+func encryptChunked(ctx context.Context, client *cloudkms.Service, w io.Writer, r io.Reader, keyPath string, opts ...Option) error {
+	dek, wrapped, err := generateAndWrapDEK(ctx, client, keyPath, opts...)
+	if err != nil {
+		return err
+	}
+	defer zeroize(dek)
+	return writeChunked(w, r, dek, wrapped)
+}
+
+// writeChunked writes plaintext from r to w in the chunked format, under dek,
+// whose wrapped form from generateAndWrapDEK is wrapped.
+func writeChunked(w io.Writer, r io.Reader, dek []byte, wrapped string) error {
+	wrappedDEK, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil {
+		return fmt.Errorf("failed to decode wrapped DEK: %w", err)
+	}
+	if len(wrappedDEK) > math.MaxUint16 {
+		return fmt.Errorf("wrapped DEK is %d bytes; the maximum is %d", len(wrappedDEK), math.MaxUint16)
+	}
+	prefix := make([]byte, chunkedPrefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return fmt.Errorf("failed to generate nonce prefix: %w", err)
+	}
+	header := []byte(chunkedMagic)
+	header = append(header, chunkedVersion)
+	header = binary.BigEndian.AppendUint32(header, chunkedChunkSize)
+	header = append(header, prefix...)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(wrappedDEK)))
+	header = append(header, wrappedDEK...)
+	if _, err := w.Write(header); err != nil {
+		return fmt.Errorf("failed to write header: %w", err)
+	}
+	h := &chunkedHeader{raw: header, chunkSize: chunkedChunkSize, noncePrefix: prefix, wrappedDEK: wrappedDEK}
+	aead, err := newChunkAEAD(dek)
+	if err != nil {
+		return err
+	}
+	return sealChunks(w, r, h, aead)
+}
+
+// sealChunks reads plaintext from r until EOF and writes it to w as the
+// chunks of a chunked file with header h, the last one short.
+func sealChunks(w io.Writer, r io.Reader, h *chunkedHeader, aead cipher.AEAD) error {
+	buf := make([]byte, h.chunkSize)
+	for i := uint64(0); ; i++ {
+		if i > math.MaxUint32 {
+			return errors.New("plaintext has too many chunks")
+		}
+		n, err := io.ReadFull(r, buf)
+		last := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !last {
+			return fmt.Errorf("failed to read plaintext: %w", err)
+		}
+		sealed := aead.Seal(nil, h.nonce(uint32(i), last), buf[:n], h.raw)
+		if _, err := w.Write(sealed); err != nil {
+			return fmt.Errorf("failed to write chunk %d: %w", i, err)
+		}
+		if last {
+			zeroize(buf)
+			return nil
+		}
+	}
+}
+
+// decryptChunked decrypts a file written by encryptChunked, starting at
+// plaintext offset, which must be a multiple of the file's chunk size, and
+// writes the plaintext from there on to w. It returns the number of
+// plaintext bytes written, so after an interruption a caller can resume at
+// offset + n rounded down to a chunk boundary: only the header and the
+// chunks from offset on are read, and the DEK is unwrapped once per call.
+// Plaintext is written only after its chunk is authenticated.
+func decryptChunked(ctx context.Context, client *cloudkms.Service, w io.Writer, r io.ReadSeeker, offset int64, keyPath string, opts ...Option) (int64, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("failed to seek to header: %w", err)
+	}
+	h, err := readChunkedHeader(r)
+	if err != nil {
+		return 0, err
+	}
+	if offset < 0 || offset%int64(h.chunkSize) != 0 {
+		return 0, fmt.Errorf("offset %d is not a multiple of the chunk size %d", offset, h.chunkSize)
+	}
+	first := offset / int64(h.chunkSize)
+	if first > math.MaxUint32 {
+		return 0, fmt.Errorf("offset %d is beyond the last possible chunk", offset)
+	}
+	sealedSize := int64(h.chunkSize) + int64(aesGCMOverhead)
+	if _, err := r.Seek(int64(len(h.raw))+first*sealedSize, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("failed to seek to chunk %d: %w", first, err)
+	}
+
+	dek, err := decryptRSABytes(ctx, client, base64.StdEncoding.EncodeToString(h.wrappedDEK), keyPath, opts...)
+	if err != nil {
+		return 0, err
+	}
+	defer zeroize(dek)
+	aead, err := newChunkAEAD(dek)
+	if err != nil {
+		return 0, err
+	}
+	return openChunks(w, r, h, aead, first)
+}
+
+// openChunks reads the chunks of a chunked file with header h from r,
+// starting at chunk first, authenticates and decrypts each, and writes the
+// plaintext to w. It returns the number of plaintext bytes written.
+func openChunks(w io.Writer, r io.Reader, h *chunkedHeader, aead cipher.AEAD, first int64) (int64, error) {
+	var written int64
+	buf := make([]byte, h.chunkSize+aesGCMOverhead)
+	for i := first; i <= math.MaxUint32; i++ {
+		n, err := io.ReadFull(r, buf)
+		if err == io.EOF {
+			return written, fmt.Errorf("file is truncated: chunk %d is missing", i)
+		}
+		last := err == io.ErrUnexpectedEOF
+		if err != nil && !last {
+			return written, fmt.Errorf("failed to read chunk %d: %w", i, err)
+		}
+		plaintext, err := aead.Open(buf[:0], h.nonce(uint32(i), last), buf[:n], h.raw)
+		if err != nil {
+			return written, fmt.Errorf("chunk %d failed authentication; the file is corrupt or truncated", i)
+		}
+		m, err := w.Write(plaintext)
+		written += int64(m)
+		zeroize(plaintext)
+		if err != nil {
+			return written, fmt.Errorf("failed to write plaintext: %w", err)
+		}
+		if last {
+			return written, nil
+		}
+	}
+	return written, errors.New("file has too many chunks")
+}
+
+func newChunkAEAD(dek []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(dek)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// nonce returns the nonce of chunk i.
+func (h *chunkedHeader) nonce(i uint32, last bool) []byte {
+	nonce := make([]byte, 0, chunkedPrefixSize+5)
+	nonce = append(nonce, h.noncePrefix...)
+	nonce = binary.BigEndian.AppendUint32(nonce, i)
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+// readChunkedHeader reads and parses the header of a chunked file from r.
+func readChunkedHeader(r io.Reader) (*chunkedHeader, error) {
+	fixed := make([]byte, len(chunkedMagic)+1+4+chunkedPrefixSize+2)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	if !bytes.HasPrefix(fixed, []byte(chunkedMagic)) {
+		return nil, errors.New("not a chunked encrypted file")
+	}
+	rest := fixed[len(chunkedMagic):]
+	if rest[0] != chunkedVersion {
+		return nil, fmt.Errorf("unsupported chunked format version %d", rest[0])
+	}
+	chunkSize := binary.BigEndian.Uint32(rest[1:5])
+	if chunkSize == 0 || chunkSize > chunkedMaxChunkSize {
+		return nil, fmt.Errorf("invalid chunk size %d", chunkSize)
+	}
+	prefix := rest[5 : 5+chunkedPrefixSize]
+	wrappedDEK := make([]byte, binary.BigEndian.Uint16(rest[5+chunkedPrefixSize:]))
+	if _, err := io.ReadFull(r, wrappedDEK); err != nil {
+		return nil, fmt.Errorf("failed to read wrapped DEK: %w", err)
+	}
+	return &chunkedHeader{
+		raw:         append(fixed, wrappedDEK...),
+		chunkSize:   int(chunkSize),
+		noncePrefix: prefix,
+		wrappedDEK:  wrappedDEK,
+	}, nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestChunkedResume(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("rsa-decrypt")
+	f.addKey(t, keyPath, "RSA_DECRYPT_OAEP_2048_SHA256")
+
+	for _, size := range []int{0, 100, chunkedChunkSize, 3*chunkedChunkSize + 17} {
+		plaintext := make([]byte, size)
+		if _, err := rand.Read(plaintext); err != nil {
+			t.Fatal(err)
+		}
+		var file bytes.Buffer
+		if err := encryptChunked(ctx, client, &file, bytes.NewReader(plaintext), keyPath); err != nil {
+			t.Fatalf("%d bytes: encryptChunked: %v", size, err)
+		}
+		for offset := 0; offset <= size; offset += chunkedChunkSize {
+			var out bytes.Buffer
+			n, err := decryptChunked(ctx, client, &out, bytes.NewReader(file.Bytes()), int64(offset), keyPath)
+			if err != nil {
+				t.Errorf("%d bytes from offset %d: decryptChunked: %v", size, offset, err)
+				continue
+			}
+			if n != int64(size-offset) || !bytes.Equal(out.Bytes(), plaintext[offset:]) {
+				t.Errorf("%d bytes from offset %d: got %d bytes, want %d matching the plaintext", size, offset, n, size-offset)
+			}
+		}
+	}
+}
+
+func TestChunkedTampering(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("rsa-decrypt")
+	f.addKey(t, keyPath, "RSA_DECRYPT_OAEP_2048_SHA256")
+	plaintext := make([]byte, 2*chunkedChunkSize+5)
+	var file bytes.Buffer
+	if err := encryptChunked(ctx, client, &file, bytes.NewReader(plaintext), keyPath); err != nil {
+		t.Fatalf("encryptChunked: %v", err)
+	}
+	encrypted := file.Bytes()
+	sealedSize := chunkedChunkSize + aesGCMOverhead
+	headerSize := len(encrypted) - 2*sealedSize - (5 + aesGCMOverhead)
+
+	flipped := append([]byte(nil), encrypted...)
+	flipped[headerSize+sealedSize+10] ^= 1
+	for _, tc := range []struct {
+		name   string
+		file   []byte
+		offset int64
+	}{
+		{"flipped bit in chunk 1", flipped, 0},
+		{"last chunk removed", encrypted[:headerSize+2*sealedSize], 0},
+		{"last chunk removed, resumed", encrypted[:headerSize+2*sealedSize], chunkedChunkSize},
+		{"truncated last chunk", encrypted[:len(encrypted)-1], 0},
+		{"offset not on a chunk boundary", encrypted, 10},
+	} {
+		var out bytes.Buffer
+		if _, err := decryptChunked(ctx, client, &out, bytes.NewReader(tc.file), tc.offset, keyPath); err == nil {
+			t.Errorf("%s: decryptChunked succeeded", tc.name)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/cloudkms/v1"
+	"google.golang.org/api/impersonate"
+	"google.golang.org/api/option"
+)
+
+// newKMSService creates a KMS service configured by opts, for
+// authentication setups beyond Application Default Credentials, which it
+// uses when opts is empty. For example, pass option.WithScopes to request a
+// narrower scope such as cloudkms.CloudkmsScope, option.WithTokenSource for
+// tokens from workload identity federation or impersonation, or
+// option.WithCredentialsFile for a specific credentials file. The returned
+// service works with every sample function.
+func newKMSService(ctx context.Context, opts ...option.ClientOption) (*cloudkms.Service, error) {
+	service, err := cloudkms.NewService(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create KMS service: %w", err)
+	}
+	return service, nil
+}
+
+// newImpersonatedKMSService creates a KMS service that acts as the service
+// account targetServiceAccount, given by email, rather than as the caller's
+// own identity. delegates, which may be empty, is a chain of service accounts
+// to impersonate through on the way, each able to impersonate the next.
+//
+// The caller, or the last delegate, needs roles/iam.serviceAccountTokenCreator
+// on the target service account. The target service account needs the roles
+// for the operations it performs, such as roles/cloudkms.signerVerifier on a
+// signing key, or roles/cloudkms.signer and roles/cloudkms.publicKeyViewer.
+// The caller itself needs no KMS roles.
+func newImpersonatedKMSService(ctx context.Context, targetServiceAccount string, delegates []string, opts ...option.ClientOption) (*cloudkms.Service, error) {
+	ts, err := impersonate.CredentialsTokenSource(ctx, impersonate.CredentialsConfig{
+		TargetPrincipal: targetServiceAccount,
+		Scopes:          []string{cloudkms.CloudPlatformScope},
+		Delegates:       delegates,
+	}, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to impersonate %s: %w", targetServiceAccount, err)
+	}
+	return newKMSService(ctx, option.WithTokenSource(ts))
+}
+
+// A PoolOption tunes the HTTP transport used by a servicePool.
+type PoolOption func(*poolConfig)
+
+type poolConfig struct {
+	size                int
+	maxIdleConnsPerHost int
+	maxConnsPerHost     int
+}
+
+// WithPoolSize sets the number of services in the pool. Each service has its
+// own transport, and so its own set of connections. The default is 1.
+func WithPoolSize(n int) PoolOption {
+	return func(c *poolConfig) { c.size = n }
+}
+
+// WithMaxIdleConnsPerHost sets how many idle connections each transport keeps
+// open to KMS. The default is 100, well above net/http's default of 2, which
+// otherwise forces most concurrent requests to dial a new connection.
+func WithMaxIdleConnsPerHost(n int) PoolOption {
+	return func(c *poolConfig) { c.maxIdleConnsPerHost = n }
+}
+
+// WithMaxConnsPerHost caps the total number of connections each transport
+// opens to KMS. The default is no limit.
+func WithMaxConnsPerHost(n int) PoolOption {
+	return func(c *poolConfig) { c.maxConnsPerHost = n }
+}
+
+// servicePool spreads requests over several KMS services so that a large
+// fan-out of concurrent calls does not starve a single connection pool.
+// It is safe for concurrent use.
+type servicePool struct {
+	services []*cloudkms.Service
+	next     uint32
+}
+
+// newServicePool creates a pool of KMS services authenticated with
+// Application Default Credentials.
+func newServicePool(ctx context.Context, opts ...PoolOption) (*servicePool, error) {
+	ts, err := google.DefaultTokenSource(ctx, cloudkms.CloudPlatformScope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find default credentials: %w", err)
+	}
+	return newServicePoolWithTokenSource(ts, opts...)
+}
+
+// newServicePoolWithTokenSource is like newServicePool, but authenticates
+// with the tokens from ts.
+func newServicePoolWithTokenSource(ts oauth2.TokenSource, opts ...PoolOption) (*servicePool, error) {
+	c := &poolConfig{size: 1, maxIdleConnsPerHost: 100}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.size < 1 {
+		return nil, errors.New("pool size must be at least 1")
+	}
+	p := &servicePool{}
+	for i := 0; i < c.size; i++ {
+		client := &http.Client{
+			Transport: &oauth2.Transport{Source: ts, Base: c.transport()},
+		}
+		service, err := cloudkms.New(client)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create KMS service: %w", err)
+		}
+		p.services = append(p.services, service)
+	}
+	return p, nil
+}
+
+// transport returns a new transport with the connection limits of c.
+func (c *poolConfig) transport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = c.maxIdleConnsPerHost
+	transport.MaxConnsPerHost = c.maxConnsPerHost
+	return transport
+}
+
+// Service returns the next service in the pool, round-robin.
+func (p *servicePool) Service() *cloudkms.Service {
+	n := atomic.AddUint32(&p.next, 1)
+	return p.services[int(n-1)%len(p.services)]
+}
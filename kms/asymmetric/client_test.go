@@ -0,0 +1,95 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"testing"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/cloudkms/v1"
+	"google.golang.org/api/option"
+)
+
+func TestNewKMSService(t *testing.T) {
+	ctx := context.Background()
+	f, fakeClient := newFakeKMS(t)
+	keyPath := testKeyPath("ec-sign")
+	f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+
+	client, err := newKMSService(ctx,
+		option.WithEndpoint(fakeClient.BasePath),
+		option.WithScopes(cloudkms.CloudkmsScope),
+		option.WithHTTPClient(http.DefaultClient))
+	if err != nil {
+		t.Fatalf("newKMSService: %v", err)
+	}
+	signature, err := signAsymmetric(ctx, client, "message", keyPath)
+	if err != nil {
+		t.Fatalf("signAsymmetric: %v", err)
+	}
+	if err := verifySignatureEC(ctx, client, signature, "message", keyPath); err != nil {
+		t.Errorf("verifySignatureEC: %v", err)
+	}
+}
+
+func TestNewServicePool(t *testing.T) {
+	ctx := context.Background()
+	f, fakeClient := newFakeKMS(t)
+	keyPath := testKeyPath("ec-sign")
+	f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+
+	c := &poolConfig{}
+	for _, opt := range []PoolOption{WithPoolSize(3), WithMaxIdleConnsPerHost(7), WithMaxConnsPerHost(9)} {
+		opt(c)
+	}
+	if transport := c.transport(); transport.MaxIdleConnsPerHost != 7 || transport.MaxConnsPerHost != 9 {
+		t.Errorf("transport has MaxIdleConnsPerHost %d and MaxConnsPerHost %d, want 7 and 9", transport.MaxIdleConnsPerHost, transport.MaxConnsPerHost)
+	}
+
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"})
+	p, err := newServicePoolWithTokenSource(ts, WithPoolSize(3), WithMaxIdleConnsPerHost(7))
+	if err != nil {
+		t.Fatalf("newServicePoolWithTokenSource: %v", err)
+	}
+	if len(p.services) != 3 {
+		t.Fatalf("pool has %d services, want 3", len(p.services))
+	}
+	for i := 0; i < 6; i++ {
+		if got := p.Service(); got != p.services[i%3] {
+			t.Errorf("Service() call %d returned service %p, want %p", i, got, p.services[i%3])
+		}
+	}
+	// Each pooled service reaches KMS through its own transport.
+	for _, service := range p.services {
+		service.BasePath = fakeClient.BasePath
+		if _, err := signAsymmetric(ctx, service, "message", keyPath); err != nil {
+			t.Errorf("signAsymmetric with a pooled service: %v", err)
+		}
+	}
+
+	if _, err := newServicePoolWithTokenSource(ts, WithPoolSize(0)); err == nil {
+		t.Error("newServicePoolWithTokenSource with size 0: got nil error")
+	}
+}
+
+// This example signs as the service account signer@PROJECT.iam.gserviceaccount.com,
+// whatever the identity of the process running it.
+func Example_impersonation() {
+	ctx := context.Background()
+	client, err := newImpersonatedKMSService(ctx, "signer@PROJECT.iam.gserviceaccount.com", nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	keyPath := "projects/PROJECT/locations/global/keyRings/RING/cryptoKeys/KEY/cryptoKeyVersions/1"
+	signature, err := signAsymmetric(ctx, client, "message", keyPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(signature)
+}
@@ -0,0 +1,490 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+)
+
+// Object identifiers used in CMS SignedData (RFC 5652) and its algorithms.
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+
+	oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+
+	oidSHA256WithRSA = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+	oidSHA384WithRSA = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}
+	oidSHA512WithRSA = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}
+	oidRSASSAPSS     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 10}
+	oidMGF1          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 8}
+
+	oidECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidECDSAWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
+	oidECDSAWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}
+)
+
+type cmsAlgorithmIdentifier struct {
+	Algorithm  asn1.ObjectIdentifier
+	Parameters asn1.RawValue `asn1:"optional"`
+}
+
+type cmsContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+type cmsEncapContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+}
+
+type cmsSignedData struct {
+	Version          int
+	DigestAlgorithms []cmsAlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo cmsEncapContentInfo
+	Certificates     asn1.RawValue
+	SignerInfos      []cmsSignerInfo `asn1:"set"`
+}
+
+type cmsIssuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type cmsSignerInfo struct {
+	Version            int
+	SID                cmsIssuerAndSerialNumber
+	DigestAlgorithm    cmsAlgorithmIdentifier
+	SignedAttrs        asn1.RawValue
+	SignatureAlgorithm cmsAlgorithmIdentifier
+	Signature          []byte
+}
+
+type cmsAttribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
+
+type pssParameters struct {
+	Hash       cmsAlgorithmIdentifier `asn1:"explicit,tag:0"`
+	MGF        cmsAlgorithmIdentifier `asn1:"explicit,tag:1"`
+	SaltLength int                    `asn1:"explicit,tag:2"`
+}
+
+// cmsAlgorithms returns the CMS digest and signature algorithm identifiers
+// for the KMS algorithm alg.
+func cmsAlgorithms(alg AlgorithmInfo) (digestAlg, sigAlg cmsAlgorithmIdentifier, err error) {
+	digestOIDs := map[crypto.Hash]asn1.ObjectIdentifier{
+		crypto.SHA256: oidSHA256,
+		crypto.SHA384: oidSHA384,
+		crypto.SHA512: oidSHA512,
+	}
+	digestOID, ok := digestOIDs[alg.Hash]
+	if !ok || alg.Purpose != "ASYMMETRIC_SIGN" {
+		return digestAlg, sigAlg, fmt.Errorf("no CMS signature algorithm for %s", alg.Name)
+	}
+	digestAlg = cmsAlgorithmIdentifier{Algorithm: digestOID}
+	switch {
+	case alg.KeyType == "RSA" && alg.Padding == "PKCS1":
+		sigAlg.Algorithm = map[crypto.Hash]asn1.ObjectIdentifier{
+			crypto.SHA256: oidSHA256WithRSA,
+			crypto.SHA384: oidSHA384WithRSA,
+			crypto.SHA512: oidSHA512WithRSA,
+		}[alg.Hash]
+		sigAlg.Parameters = asn1.NullRawValue
+	case alg.KeyType == "RSA" && alg.Padding == "PSS":
+		// KMS uses MGF1 with the message hash and a salt as long as the hash.
+		hashAlg := cmsAlgorithmIdentifier{Algorithm: digestOID, Parameters: asn1.NullRawValue}
+		hashAlgDER, err := asn1.Marshal(hashAlg)
+		if err != nil {
+			return digestAlg, sigAlg, err
+		}
+		params, err := asn1.Marshal(pssParameters{
+			Hash:       hashAlg,
+			MGF:        cmsAlgorithmIdentifier{Algorithm: oidMGF1, Parameters: asn1.RawValue{FullBytes: hashAlgDER}},
+			SaltLength: alg.Hash.Size(),
+		})
+		if err != nil {
+			return digestAlg, sigAlg, err
+		}
+		sigAlg = cmsAlgorithmIdentifier{Algorithm: oidRSASSAPSS, Parameters: asn1.RawValue{FullBytes: params}}
+	case alg.KeyType == "EC":
+		sigAlg.Algorithm = map[crypto.Hash]asn1.ObjectIdentifier{
+			crypto.SHA256: oidECDSAWithSHA256,
+			crypto.SHA384: oidECDSAWithSHA384,
+			crypto.SHA512: oidECDSAWithSHA512,
+		}[alg.Hash]
+	default:
+		return digestAlg, sigAlg, fmt.Errorf("no CMS signature algorithm for %s", alg.Name)
+	}
+	return digestAlg, sigAlg, nil
+}
+
+// signCMSDetached returns a DER-encoded CMS SignedData (PKCS #7) detached
+// signature over message, made by the KMS key behind signer. cert is the
+// signer's certificate, which is embedded in the output and identifies the
+// signer; its public key must be the KMS key's. The digest and signature
+// algorithm identifiers are chosen from the KMS key's algorithm.
+func signCMSDetached(message []byte, cert *x509.Certificate, signer *kmsSigner) ([]byte, error) {
+	if err := checkCertificateKey(cert, signer); err != nil {
+		return nil, err
+	}
+	alg, ok := lookupAlgorithm(signer.algorithm)
+	if !ok {
+		return nil, fmt.Errorf("unsupported algorithm %s", signer.algorithm)
+	}
+	digestAlg, sigAlg, err := cmsAlgorithms(alg)
+	if err != nil {
+		return nil, err
+	}
+
+	h := alg.Hash.New()
+	h.Write(message)
+	signedAttrs, err := cmsSignedAttributes(h.Sum(nil), time.Now())
+	if err != nil {
+		return nil, err
+	}
+	// The signature covers the DER encoding of the attributes as a SET
+	// (RFC 5652, section 5.4), not the implicitly tagged form embedded below.
+	h = alg.Hash.New()
+	h.Write(signedAttrs.FullBytes)
+	var signerOpts crypto.SignerOpts = alg.Hash
+	if alg.Padding == "PSS" {
+		signerOpts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: alg.Hash}
+	}
+	signature, err := signer.Sign(rand.Reader, h.Sum(nil), signerOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	signedData, err := asn1.Marshal(cmsSignedData{
+		Version:          1,
+		DigestAlgorithms: []cmsAlgorithmIdentifier{digestAlg},
+		EncapContentInfo: cmsEncapContentInfo{ContentType: oidData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: cert.Raw},
+		SignerInfos: []cmsSignerInfo{{
+			Version: 1,
+			SID: cmsIssuerAndSerialNumber{
+				Issuer:       asn1.RawValue{FullBytes: cert.RawIssuer},
+				SerialNumber: cert.SerialNumber,
+			},
+			DigestAlgorithm:    digestAlg,
+			SignedAttrs:        asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedAttrs.Bytes},
+			SignatureAlgorithm: sigAlg,
+			Signature:          signature,
+		}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode SignedData: %w", err)
+	}
+	return asn1.Marshal(cmsContentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedData},
+	})
+}
+
+// cmsSignedAttributes returns the signed attributes for a detached signature
+// over data with the given digest, encoded as a DER SET OF Attribute.
+func cmsSignedAttributes(digest []byte, signingTime time.Time) (asn1.RawValue, error) {
+	values := []struct {
+		oid   asn1.ObjectIdentifier
+		value interface{}
+	}{
+		{oidContentType, oidData},
+		{oidMessageDigest, digest},
+		{oidSigningTime, signingTime.UTC()},
+	}
+	var encoded [][]byte
+	for _, v := range values {
+		value, err := asn1.Marshal(v.value)
+		if err != nil {
+			return asn1.RawValue{}, err
+		}
+		attr, err := asn1.Marshal(cmsAttribute{
+			Type:   v.oid,
+			Values: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: value},
+		})
+		if err != nil {
+			return asn1.RawValue{}, err
+		}
+		encoded = append(encoded, attr)
+	}
+	// DER requires the elements of a SET OF in ascending order of encoding.
+	sort.Slice(encoded, func(i, j int) bool { return bytes.Compare(encoded[i], encoded[j]) < 0 })
+	set := asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: bytes.Join(encoded, nil)}
+	der, err := asn1.Marshal(set)
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+	set.FullBytes = der
+	return set, nil
+}
+
+// The structures below parse SignedData from other producers, which may
+// include content, CRLs, several certificates, subject key identifiers and
+// unsigned attributes that signCMSDetached never writes.
+
+type cmsSignedDataIn struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	EncapContentInfo cmsEncapContentInfoIn
+	Certificates     asn1.RawValue     `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue     `asn1:"optional,tag:1"`
+	SignerInfos      []cmsSignerInfoIn `asn1:"set"`
+}
+
+type cmsEncapContentInfoIn struct {
+	ContentType asn1.ObjectIdentifier
+	Content     []byte `asn1:"explicit,optional,tag:0"`
+}
+
+type cmsSignerInfoIn struct {
+	Version            int
+	SID                asn1.RawValue
+	DigestAlgorithm    cmsAlgorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm cmsAlgorithmIdentifier
+	Signature          []byte
+	UnsignedAttrs      asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+// oidRSAEncryption is accepted as a signature algorithm meaning PKCS #1 v1.5
+// with the signer's digest algorithm, as many CMS producers write it.
+var oidRSAEncryption = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+
+// verifyPKCS7 checks a DER-encoded CMS SignedData (PKCS #7), such as one made
+// by signCMSDetached, over message. Each signer's certificate is looked up
+// among the certificates embedded in der and must chain to trustedCerts,
+// with the other embedded certificates as intermediates, at the time given by
+// WithClock or else now. If der carries the content itself, it must equal
+// message. Every signer must have signed message: a SignedData with a bad
+// signer fails even if another signer is valid.
+//
+// It returns an error wrapping ErrSignatureMalformed if der cannot be parsed,
+// ErrChainInvalid if a signer's certificate is missing or untrusted, and
+// ErrSignatureInvalid if a signature or message digest does not match.
+func verifyPKCS7(der, message []byte, trustedCerts *x509.CertPool, opts ...Option) error {
+	o := newOptions(opts)
+	var contentInfo cmsContentInfo
+	if rest, err := asn1.Unmarshal(der, &contentInfo); err != nil || len(rest) > 0 {
+		return fmt.Errorf("%w: not a DER-encoded ContentInfo", ErrSignatureMalformed)
+	}
+	if !contentInfo.ContentType.Equal(oidSignedData) {
+		return fmt.Errorf("%w: content type %v is not SignedData", ErrSignatureMalformed, contentInfo.ContentType)
+	}
+	var signedData cmsSignedDataIn
+	if rest, err := asn1.Unmarshal(contentInfo.Content.Bytes, &signedData); err != nil {
+		return fmt.Errorf("%w: SignedData: %w", ErrSignatureMalformed, err)
+	} else if len(rest) > 0 {
+		return fmt.Errorf("%w: trailing data after SignedData", ErrSignatureMalformed)
+	}
+	if content := signedData.EncapContentInfo.Content; content != nil && !bytes.Equal(content, message) {
+		return fmt.Errorf("%w: embedded content does not match message", ErrSignatureInvalid)
+	}
+	certs, err := x509.ParseCertificates(signedData.Certificates.Bytes)
+	if err != nil {
+		return fmt.Errorf("%w: certificates: %w", ErrSignatureMalformed, err)
+	}
+	if len(signedData.SignerInfos) == 0 {
+		return fmt.Errorf("%w: SignedData has no signers", ErrSignatureMalformed)
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs {
+		intermediates.AddCert(cert)
+	}
+	verifyOptions := x509.VerifyOptions{
+		Roots:         trustedCerts,
+		Intermediates: intermediates,
+		CurrentTime:   o.now(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	for i, signerInfo := range signedData.SignerInfos {
+		if err := verifyCMSSigner(signerInfo, signedData.EncapContentInfo.ContentType, message, certs, verifyOptions); err != nil {
+			return fmt.Errorf("signer %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// verifyCMSSigner checks one SignerInfo of a SignedData over message.
+func verifyCMSSigner(signerInfo cmsSignerInfoIn, contentType asn1.ObjectIdentifier, message []byte, certs []*x509.Certificate, verifyOptions x509.VerifyOptions) error {
+	cert, err := findCMSSigner(signerInfo.SID, certs)
+	if err != nil {
+		return err
+	}
+	if _, err := cert.Verify(verifyOptions); err != nil {
+		return fmt.Errorf("%w: %w", ErrChainInvalid, err)
+	}
+	alg, err := cmsSignerAlgorithm(signerInfo, cert.PublicKey)
+	if err != nil {
+		return err
+	}
+	h := alg.Hash.New()
+	h.Write(message)
+	digest := h.Sum(nil)
+
+	// Without signed attributes the signature covers the content directly,
+	// which RFC 5652 allows only for id-data.
+	if len(signerInfo.SignedAttrs.FullBytes) == 0 {
+		if !contentType.Equal(oidData) {
+			return fmt.Errorf("%w: content type %v requires signed attributes", ErrSignatureMalformed, contentType)
+		}
+		return verifyDigest(cert.PublicKey, alg, digest, signerInfo.Signature)
+	}
+	attrs, err := parseCMSAttributes(signerInfo.SignedAttrs.Bytes)
+	if err != nil {
+		return err
+	}
+	var signedType asn1.ObjectIdentifier
+	if v, ok := attrs[oidContentType.String()]; !ok {
+		return fmt.Errorf("%w: missing content type attribute", ErrSignatureMalformed)
+	} else if rest, err := asn1.Unmarshal(v, &signedType); err != nil || len(rest) > 0 {
+		return fmt.Errorf("%w: bad content type attribute", ErrSignatureMalformed)
+	}
+	if !signedType.Equal(contentType) {
+		return fmt.Errorf("%w: signed content type %v does not match %v", ErrSignatureInvalid, signedType, contentType)
+	}
+	v, ok := attrs[oidMessageDigest.String()]
+	if !ok {
+		return fmt.Errorf("%w: missing message digest attribute", ErrSignatureMalformed)
+	}
+	var signedDigest []byte
+	if rest, err := asn1.Unmarshal(v, &signedDigest); err != nil || len(rest) > 0 {
+		return fmt.Errorf("%w: bad message digest attribute", ErrSignatureMalformed)
+	}
+	if !bytes.Equal(signedDigest, digest) {
+		return fmt.Errorf("%w: message digest does not match", ErrSignatureInvalid)
+	}
+	// The signature covers the attributes re-tagged as a SET.
+	set, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: signerInfo.SignedAttrs.Bytes})
+	if err != nil {
+		return err
+	}
+	h = alg.Hash.New()
+	h.Write(set)
+	return verifyDigest(cert.PublicKey, alg, h.Sum(nil), signerInfo.Signature)
+}
+
+// findCMSSigner returns the certificate in certs that sid, a SignerIdentifier,
+// names by issuer and serial number or by subject key identifier.
+func findCMSSigner(sid asn1.RawValue, certs []*x509.Certificate) (*x509.Certificate, error) {
+	var match func(*x509.Certificate) bool
+	switch {
+	case sid.Class == asn1.ClassUniversal && sid.Tag == asn1.TagSequence:
+		var ias cmsIssuerAndSerialNumber
+		if rest, err := asn1.Unmarshal(sid.FullBytes, &ias); err != nil || len(rest) > 0 {
+			return nil, fmt.Errorf("%w: bad signer identifier", ErrSignatureMalformed)
+		}
+		match = func(cert *x509.Certificate) bool {
+			return bytes.Equal(cert.RawIssuer, ias.Issuer.FullBytes) && cert.SerialNumber.Cmp(ias.SerialNumber) == 0
+		}
+	case sid.Class == asn1.ClassContextSpecific && sid.Tag == 0 && !sid.IsCompound:
+		match = func(cert *x509.Certificate) bool {
+			return len(cert.SubjectKeyId) > 0 && bytes.Equal(cert.SubjectKeyId, sid.Bytes)
+		}
+	default:
+		return nil, fmt.Errorf("%w: bad signer identifier", ErrSignatureMalformed)
+	}
+	for _, cert := range certs {
+		if match(cert) {
+			return cert, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: signer's certificate is not in the SignedData", ErrChainInvalid)
+}
+
+// cmsSignerAlgorithm returns the algorithm of signerInfo, for checking its
+// signature with verifyDigest. Only the combinations that cmsAlgorithms
+// produces, and rsaEncryption, are accepted; in particular an RSA-PSS
+// signature must use MGF1 with the message hash and a salt as long as it.
+func cmsSignerAlgorithm(signerInfo cmsSignerInfoIn, publicKey crypto.PublicKey) (AlgorithmInfo, error) {
+	alg := AlgorithmInfo{Name: "CMS signature", Purpose: "ASYMMETRIC_SIGN"}
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		alg.KeyType, alg.KeySize = "RSA", key.N.BitLen()
+	case *ecdsa.PublicKey:
+		alg.KeyType, alg.KeySize = "EC", key.Curve.Params().BitSize
+	default:
+		return alg, fmt.Errorf("%w: unsupported public key type %T", ErrKeyTypeMismatch, publicKey)
+	}
+	hashes := map[string]crypto.Hash{
+		oidSHA256.String(): crypto.SHA256,
+		oidSHA384.String(): crypto.SHA384,
+		oidSHA512.String(): crypto.SHA512,
+	}
+	alg.Hash = hashes[signerInfo.DigestAlgorithm.Algorithm.String()]
+	if alg.Hash == 0 {
+		return alg, fmt.Errorf("unsupported CMS digest algorithm %v", signerInfo.DigestAlgorithm.Algorithm)
+	}
+	if signerInfo.SignatureAlgorithm.Algorithm.Equal(oidRSAEncryption) && alg.KeyType == "RSA" {
+		alg.Padding = "PKCS1"
+		return alg, nil
+	}
+	if alg.KeyType == "RSA" {
+		alg.Padding = "PKCS1"
+		if signerInfo.SignatureAlgorithm.Algorithm.Equal(oidRSASSAPSS) {
+			alg.Padding = "PSS"
+		}
+	}
+	// Compare the full identifier, parameters included, with the one
+	// cmsAlgorithms writes for this key type, hash and padding.
+	if _, want, err := cmsAlgorithms(alg); err == nil && cmsAlgorithmEqual(signerInfo.SignatureAlgorithm, want) {
+		return alg, nil
+	}
+	return alg, fmt.Errorf("unsupported CMS signature algorithm %v with %v for a %s key",
+		signerInfo.SignatureAlgorithm.Algorithm, signerInfo.DigestAlgorithm.Algorithm, alg.KeyType)
+}
+
+// cmsAlgorithmEqual reports whether a and b are the same algorithm with the
+// same parameters. Absent and NULL parameters are treated alike, as RFC 5754
+// allows either for the RSA PKCS #1 v1.5 algorithms.
+func cmsAlgorithmEqual(a, b cmsAlgorithmIdentifier) bool {
+	params := func(v asn1.RawValue) []byte {
+		if bytes.Equal(v.FullBytes, asn1.NullBytes) {
+			return nil
+		}
+		return v.FullBytes
+	}
+	return a.Algorithm.Equal(b.Algorithm) && bytes.Equal(params(a.Parameters), params(b.Parameters))
+}
+
+// parseCMSAttributes parses the contents of a SET OF Attribute and returns
+// the DER encoding of each attribute's single value, by OID. An attribute
+// with zero or several values, or appearing twice, is rejected.
+func parseCMSAttributes(der []byte) (map[string][]byte, error) {
+	attrs := make(map[string][]byte)
+	for rest := der; len(rest) > 0; {
+		var attr cmsAttribute
+		var err error
+		rest, err = asn1.Unmarshal(rest, &attr)
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad signed attribute: %w", ErrSignatureMalformed, err)
+		}
+		var value asn1.RawValue
+		if extra, err := asn1.Unmarshal(attr.Values.Bytes, &value); err != nil || len(extra) > 0 {
+			return nil, fmt.Errorf("%w: signed attribute %v must have exactly one value", ErrSignatureMalformed, attr.Type)
+		}
+		if _, dup := attrs[attr.Type.String()]; dup {
+			return nil, fmt.Errorf("%w: duplicate signed attribute %v", ErrSignatureMalformed, attr.Type)
+		}
+		attrs[attr.Type.String()] = value.FullBytes
+	}
+	return attrs, nil
+}
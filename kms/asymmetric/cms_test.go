@@ -0,0 +1,151 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestSignCMSDetached(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	message := []byte("document")
+	for _, algName := range []string{"RSA_SIGN_PSS_2048_SHA256", "RSA_SIGN_PKCS1_2048_SHA256", "EC_SIGN_P384_SHA384"} {
+		keyPath := testKeyPath(algName)
+		f.addKey(t, keyPath, algName)
+		signer, err := newKMSSigner(ctx, client, keyPath)
+		if err != nil {
+			t.Fatalf("newKMSSigner: %v", err)
+		}
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(42),
+			Subject:      pkix.Name{CommonName: "signer"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+		}
+		der, err := createCertificate(template, template, signer.Public(), signer)
+		if err != nil {
+			t.Fatalf("%s: createCertificate: %v", algName, err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cms, err := signCMSDetached(message, cert, signer)
+		if err != nil {
+			t.Fatalf("%s: signCMSDetached: %v", algName, err)
+		}
+		var contentInfo cmsContentInfo
+		if _, err := asn1.Unmarshal(cms, &contentInfo); err != nil {
+			t.Fatalf("%s: parsing ContentInfo: %v", algName, err)
+		}
+		if !contentInfo.ContentType.Equal(oidSignedData) {
+			t.Errorf("%s: content type %v, want %v", algName, contentInfo.ContentType, oidSignedData)
+		}
+		var signedData cmsSignedData
+		if _, err := asn1.Unmarshal(contentInfo.Content.Bytes, &signedData); err != nil {
+			t.Fatalf("%s: parsing SignedData: %v", algName, err)
+		}
+		if !bytes.Equal(signedData.Certificates.Bytes, cert.Raw) {
+			t.Errorf("%s: embedded certificate does not match", algName)
+		}
+		signerInfo := signedData.SignerInfos[0]
+		alg, _ := lookupAlgorithm(algName)
+		wantDigestAlg, wantSigAlg, err := cmsAlgorithms(alg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !signerInfo.DigestAlgorithm.Algorithm.Equal(wantDigestAlg.Algorithm) || !signerInfo.SignatureAlgorithm.Algorithm.Equal(wantSigAlg.Algorithm) {
+			t.Errorf("%s: algorithms %v, %v; want %v, %v", algName,
+				signerInfo.DigestAlgorithm.Algorithm, signerInfo.SignatureAlgorithm.Algorithm,
+				wantDigestAlg.Algorithm, wantSigAlg.Algorithm)
+		}
+
+		// Check the message digest attribute and the signature over the
+		// attributes, re-tagged as a SET.
+		h := alg.Hash.New()
+		h.Write(message)
+		if !bytes.Contains(signerInfo.SignedAttrs.Bytes, h.Sum(nil)) {
+			t.Errorf("%s: signed attributes do not contain the message digest", algName)
+		}
+		set, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: signerInfo.SignedAttrs.Bytes})
+		if err != nil {
+			t.Fatal(err)
+		}
+		h = alg.Hash.New()
+		h.Write(set)
+		if err := verifyDigest(cert.PublicKey, alg, h.Sum(nil), signerInfo.Signature); err != nil {
+			t.Errorf("%s: signature over signed attributes: %v", algName, err)
+		}
+	}
+}
+
+func TestVerifyPKCS7(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	message := []byte("document")
+	for _, algName := range []string{"RSA_SIGN_PSS_2048_SHA256", "RSA_SIGN_PKCS1_2048_SHA256", "EC_SIGN_P384_SHA384"} {
+		keyPath := testKeyPath(algName)
+		f.addKey(t, keyPath, algName)
+		signer, err := newKMSSigner(ctx, client, keyPath)
+		if err != nil {
+			t.Fatalf("newKMSSigner: %v", err)
+		}
+		notAfter := time.Now().Add(time.Hour)
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(42),
+			Subject:      pkix.Name{CommonName: "signer"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     notAfter,
+		}
+		certDER, err := createCertificate(template, template, signer.Public(), signer)
+		if err != nil {
+			t.Fatalf("%s: createCertificate: %v", algName, err)
+		}
+		cert, err := x509.ParseCertificate(certDER)
+		if err != nil {
+			t.Fatal(err)
+		}
+		trusted := x509.NewCertPool()
+		trusted.AddCert(cert)
+
+		der, err := signCMSDetached(message, cert, signer)
+		if err != nil {
+			t.Fatalf("%s: signCMSDetached: %v", algName, err)
+		}
+		if err := verifyPKCS7(der, message, trusted); err != nil {
+			t.Errorf("%s: verifyPKCS7: %v", algName, err)
+		}
+		if err := verifyPKCS7(der, []byte("other"), trusted); !errors.Is(err, ErrSignatureInvalid) {
+			t.Errorf("%s: verifyPKCS7 with the wrong message: got %v, want ErrSignatureInvalid", algName, err)
+		}
+		if err := verifyPKCS7(der, message, x509.NewCertPool()); !errors.Is(err, ErrChainInvalid) {
+			t.Errorf("%s: verifyPKCS7 with no trusted certificates: got %v, want ErrChainInvalid", algName, err)
+		}
+		later := func() time.Time { return notAfter.Add(time.Minute) }
+		if err := verifyPKCS7(der, message, trusted, WithClock(later)); !errors.Is(err, ErrChainInvalid) {
+			t.Errorf("%s: verifyPKCS7 after the certificate expired: got %v, want ErrChainInvalid", algName, err)
+		}
+		// The signature is the last field of the structure.
+		tampered := append([]byte(nil), der...)
+		tampered[len(tampered)-1] ^= 1
+		if err := verifyPKCS7(tampered, message, trusted); !errors.Is(err, ErrSignatureInvalid) {
+			t.Errorf("%s: verifyPKCS7 with a tampered signature: got %v, want ErrSignatureInvalid", algName, err)
+		}
+		if err := verifyPKCS7(der[:len(der)-1], message, trusted); !errors.Is(err, ErrSignatureMalformed) {
+			t.Errorf("%s: verifyPKCS7 with truncated input: got %v, want ErrSignatureMalformed", algName, err)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// coseAlgorithms maps JWS algorithms to their COSE identifiers, from the IANA
+// COSE Algorithms registry (RFC 9053 and RFC 8812).
+var coseAlgorithms = map[string]int64{
+	"ES256": -7,
+	"ES384": -35,
+	"PS256": -37,
+	"PS384": -38,
+	"PS512": -39,
+	"RS256": -257,
+	"RS384": -258,
+	"RS512": -259,
+}
+
+// COSE header labels and the CBOR tag of COSE_Sign1 (RFC 9052).
+const (
+	coseHeaderAlg  = 1
+	coseHeaderKID  = 4
+	coseSign1Tag   = 18
+	coseSign1Label = "Signature1"
+)
+
+// signCOSESign1 signs payload with the KMS key at keyPath and returns the
+// CBOR encoding of a tagged COSE_Sign1 message (RFC 9052, section 4.2)
+// carrying it. The protected header holds the key's COSE algorithm, and the
+// unprotected header its computeKID as the kid. As COSE requires, an ECDSA
+// signature is the raw r||s form rather than the DER that KMS returns.
+func signCOSESign1(ctx context.Context, client *cloudkms.Service, payload []byte, keyPath string, opts ...Option) ([]byte, error) {
+	response, publicKey, err := fetchPublicKey(ctx, client, keyPath, opts...)
+	if err != nil {
+		return nil, err
+	}
+	jwsAlg, err := joseAlgorithm(response.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	alg, ok := coseAlgorithms[jwsAlg]
+	if !ok {
+		return nil, fmt.Errorf("no COSE algorithm for %s", response.Algorithm)
+	}
+	protected := cborHead(5, 1)
+	protected = append(protected, cborInt(coseHeaderAlg)...)
+	protected = append(protected, cborInt(alg)...)
+
+	signature, err := signAsymmetricBytes(ctx, client, string(coseSigStructure(protected, payload)), keyPath, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if ecKey, ok := publicKey.(*ecdsa.PublicKey); ok {
+		size := (ecKey.Curve.Params().BitSize + 7) / 8
+		if signature, err = ecdsaDERToRaw(signature, size); err != nil {
+			return nil, err
+		}
+	}
+
+	message := cborHead(6, coseSign1Tag)
+	message = append(message, cborHead(4, 4)...)
+	message = append(message, cborBytes(protected)...)
+	message = append(message, cborHead(5, 1)...)
+	message = append(message, cborInt(coseHeaderKID)...)
+	message = append(message, cborBytes([]byte(computeKID(keyPath, publicKey)))...)
+	message = append(message, cborBytes(payload)...)
+	return append(message, cborBytes(signature)...), nil
+}
+
+// coseSigStructure returns the bytes signed for a COSE_Sign1 message with no
+// external additional data:
+//
+//	["Signature1", protected, h'', payload]
+func coseSigStructure(protected, payload []byte) []byte {
+	b := cborHead(4, 4)
+	b = append(b, cborHead(3, uint64(len(coseSign1Label)))...)
+	b = append(b, coseSign1Label...)
+	b = append(b, cborBytes(protected)...)
+	b = append(b, cborBytes(nil)...)
+	return append(b, cborBytes(payload)...)
+}
+
+// cborHead returns the head of a CBOR data item of the given major type and
+// argument, in the shortest form, as deterministic encoding requires.
+func cborHead(major byte, n uint64) []byte {
+	m := major << 5
+	switch {
+	case n < 24:
+		return []byte{m | byte(n)}
+	case n <= 0xff:
+		return []byte{m | 24, byte(n)}
+	case n <= 0xffff:
+		return []byte{m | 25, byte(n >> 8), byte(n)}
+	case n <= 0xffffffff:
+		return []byte{m | 26, byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}
+	default:
+		return []byte{m | 27, byte(n >> 56), byte(n >> 48), byte(n >> 40), byte(n >> 32),
+			byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}
+	}
+}
+
+// cborInt encodes n as a CBOR integer.
+func cborInt(n int64) []byte {
+	if n < 0 {
+		return cborHead(1, uint64(-1-n))
+	}
+	return cborHead(0, uint64(n))
+}
+
+// cborBytes encodes b as a CBOR byte string.
+func cborBytes(b []byte) []byte {
+	return append(cborHead(2, uint64(len(b))), b...)
+}
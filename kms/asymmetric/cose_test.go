@@ -0,0 +1,81 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestCBOREncoding(t *testing.T) {
+	// Examples from RFC 8949, appendix A.
+	for _, tc := range []struct {
+		got  []byte
+		want string
+	}{
+		{cborInt(0), "00"},
+		{cborInt(23), "17"},
+		{cborInt(24), "1818"},
+		{cborInt(1000), "1903e8"},
+		{cborInt(1000000), "1a000f4240"},
+		{cborInt(-1), "20"},
+		{cborInt(-1000), "3903e7"},
+		{cborInt(-257), "390100"},
+		{cborBytes([]byte{1, 2, 3, 4}), "4401020304"},
+		{cborHead(6, 18), "d2"},
+	} {
+		if got := hex.EncodeToString(tc.got); got != tc.want {
+			t.Errorf("got %s, want %s", got, tc.want)
+		}
+	}
+}
+
+func TestSignCOSESign1(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	for _, tc := range []struct {
+		alg, jws  string
+		protected string
+	}{
+		{"EC_SIGN_P256_SHA256", "ES256", "a10126"},
+		{"RSA_SIGN_PSS_2048_SHA256", "PS256", "a1013824"},
+	} {
+		keyPath := testKeyPath(tc.alg)
+		f.addKey(t, keyPath, tc.alg)
+		payload := []byte("This is the content.")
+		message, err := signCOSESign1(ctx, client, payload, keyPath)
+		if err != nil {
+			t.Fatalf("%s: signCOSESign1: %v", tc.alg, err)
+		}
+		_, publicKey, err := fetchPublicKey(ctx, client, keyPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		protected, _ := hex.DecodeString(tc.protected)
+		prefix := append([]byte{0xd2, 0x84}, cborBytes(protected)...)
+		prefix = append(prefix, 0xa1, 0x04)
+		prefix = append(prefix, cborBytes([]byte(computeKID(keyPath, publicKey)))...)
+		prefix = append(prefix, cborBytes(payload)...)
+		if !bytes.HasPrefix(message, prefix) {
+			t.Fatalf("%s: message %x does not start with %x", tc.alg, message, prefix)
+		}
+		// The rest is the signature byte string, whose head is two bytes for
+		// a 64-byte r||s signature and three for a 256-byte RSA one.
+		rest := message[len(prefix):]
+		signature := rest[2:]
+		if rest[0] == 0x59 {
+			signature = rest[3:]
+		}
+		if tc.jws == "ES256" && len(signature) != 64 {
+			t.Errorf("%s: signature is %d bytes, want 64-byte r||s", tc.alg, len(signature))
+		}
+		if err := verifyJOSESignature(publicKey, tc.jws, coseSigStructure(protected, payload), signature); err != nil {
+			t.Errorf("%s: signature does not verify over Sig_structure: %v", tc.alg, err)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// KMS protects request and response payloads with CRC32C (Castagnoli)
+// checksums. crc32c computes one over a whole buffer; newCRC32C and
+// crc32cOf compute the identical value incrementally, for data that is read
+// or written in pieces.
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// crc32c returns the CRC32C checksum of data.
+func crc32c(data []byte) uint32 {
+	return crc32.Checksum(data, crc32cTable)
+}
+
+// newCRC32C returns a hash that computes the same checksum as crc32c over
+// everything written to it.
+func newCRC32C() hash.Hash32 {
+	return crc32.New(crc32cTable)
+}
+
+// crc32cOf reads r to EOF and returns the CRC32C checksum of its contents,
+// without holding more than a small buffer in memory.
+func crc32cOf(r io.Reader) (uint32, error) {
+	h := newCRC32C()
+	if _, err := io.Copy(h, r); err != nil {
+		return 0, err
+	}
+	return h.Sum32(), nil
+}
+
+// decryptRSAWithCRC is like decryptRSABytes, but also returns the CRC32C of
+// the plaintext: the value KMS reported and decryptRSA checked it against.
+// Store it alongside the plaintext to re-verify its integrity later with
+// crc32c.
+func decryptRSAWithCRC(ctx context.Context, client *cloudkms.Service, ciphertext, keyPath string, opts ...Option) ([]byte, uint32, error) {
+	response, plaintext, err := decryptRSAFull(ctx, client, ciphertext, keyPath, opts...)
+	if err != nil {
+		return nil, 0, err
+	}
+	return plaintext, uint32(response.PlaintextCrc32c), nil
+}
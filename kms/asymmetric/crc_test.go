@@ -0,0 +1,51 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+	"testing/iotest"
+
+	"golang.org/x/net/context"
+)
+
+func TestCRC32CStreaming(t *testing.T) {
+	data := bytes.Repeat([]byte("ciphertext"), 10000)
+	want := crc32c(data)
+	// OneByteReader forces many small reads.
+	got, err := crc32cOf(iotest.OneByteReader(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatalf("crc32cOf: %v", err)
+	}
+	if got != want {
+		t.Errorf("crc32cOf = %d; want: %d", got, want)
+	}
+	// Known value for "123456789" from RFC 3720.
+	if got := crc32c([]byte("123456789")); got != 0xe3069283 {
+		t.Errorf("crc32c(123456789) = %#x; want: %#x", got, 0xe3069283)
+	}
+}
+
+func TestDecryptRSAWithCRC(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("decrypt")
+	f.addKey(t, keyPath, "RSA_DECRYPT_OAEP_2048_SHA256")
+	ciphertext, err := encryptRSA(ctx, client, "secret", keyPath)
+	if err != nil {
+		t.Fatalf("encryptRSA: %v", err)
+	}
+	plaintext, crc, err := decryptRSAWithCRC(ctx, client, ciphertext, keyPath)
+	if err != nil {
+		t.Fatalf("decryptRSAWithCRC: %v", err)
+	}
+	if string(plaintext) != "secret" {
+		t.Errorf("plaintext = %q, want %q", plaintext, "secret")
+	}
+	if want := crc32c([]byte("secret")); crc != want {
+		t.Errorf("crc = %#x, want %#x", crc, want)
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+)
+
+// WithRequestToken makes signAsymmetric, signDigest and signAsymmetricReader
+// set *token to signRequestToken of the request after a successful
+// signature, so that a caller can cache the signature under it and skip
+// signing the same input with the same key again. *token is left alone if
+// signing fails.
+func WithRequestToken(token *string) Option {
+	return func(o *options) { o.requestToken = token }
+}
+
+// signRequestToken returns a deterministic token for a request to sign
+// digest, computed with hash, with the key at keyPath: the hex SHA-256 of
+// the three, each length-prefixed. Equal requests always have equal tokens,
+// so to dedupe before signing, compute it from the digest that signDigest
+// would be given.
+//
+// RSA-PSS and ECDSA signatures are randomized, so signing the same request
+// twice gives different signatures. Both verify, though, so serving a cached
+// signature for a token is as good as signing again, for as long as the key
+// version stays enabled.
+func signRequestToken(keyPath string, hash crypto.Hash, digest []byte) string {
+	h := sha256.New()
+	for _, field := range [][]byte{[]byte(keyPath), []byte(hash.String()), digest} {
+		var length [4]byte
+		binary.BigEndian.PutUint32(length[:], uint32(len(field)))
+		h.Write(length[:])
+		h.Write(field)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
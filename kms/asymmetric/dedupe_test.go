@@ -0,0 +1,52 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestWithRequestToken(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("ec-sign")
+	otherPath := testKeyPath("ec-sign-2")
+	f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+	f.addKey(t, otherPath, "EC_SIGN_P256_SHA256")
+
+	var first, second, other string
+	if _, err := signAsymmetric(ctx, client, "message", keyPath, WithRequestToken(&first)); err != nil {
+		t.Fatalf("signAsymmetric: %v", err)
+	}
+	if _, err := signAsymmetric(ctx, client, "message", keyPath, WithRequestToken(&second)); err != nil {
+		t.Fatalf("signAsymmetric: %v", err)
+	}
+	if _, err := signAsymmetric(ctx, client, "message", otherPath, WithRequestToken(&other)); err != nil {
+		t.Fatalf("signAsymmetric: %v", err)
+	}
+	if first == "" || first != second {
+		t.Errorf("tokens for the same request differ: %q, %q", first, second)
+	}
+	if first == other {
+		t.Error("tokens for different keys are equal")
+	}
+	// The caller can compute the token before signing.
+	digest := sha256.Sum256([]byte("message"))
+	if got := signRequestToken(keyPath, crypto.SHA256, digest[:]); got != first {
+		t.Errorf("signRequestToken = %q, want %q", got, first)
+	}
+	if signRequestToken(keyPath, crypto.SHA256, []byte("other")) == first {
+		t.Error("tokens for different digests are equal")
+	}
+
+	token := "unchanged"
+	if _, err := signAsymmetric(ctx, client, "message", testKeyPath("missing"), WithRequestToken(&token)); err == nil || token != "unchanged" {
+		t.Errorf("failed signAsymmetric: got (%v, token %q), want an error and the token unchanged", err, token)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"encoding/base64"
+	"fmt"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// WithTruncatedDigest makes verifyDigestSignature accept ECDSA signatures
+// over a digest shorter than the key's hash, as made by some legacy systems.
+// Such signatures are weaker than the key suggests, so enable it only for
+// data that cannot be re-signed. RSA signatures encode the full digest and
+// are never accepted over a truncated one, and KMS cannot make them.
+func WithTruncatedDigest() Option {
+	return func(o *options) { o.allowTruncatedDigest = true }
+}
+
+// checkDigestLength returns an error unless digest is as long as the output
+// of hash, or, if allowTruncated is set, a non-empty prefix of that length.
+// A truncated digest is reported with ErrTruncatedDigest.
+func checkDigestLength(digest []byte, hash crypto.Hash, allowTruncated bool) error {
+	switch size := hash.Size(); {
+	case len(digest) == size:
+		return nil
+	case len(digest) > 0 && len(digest) < size && allowTruncated:
+		return nil
+	case len(digest) < size:
+		return fmt.Errorf("%w: key requires %v digest, got %d bytes", ErrTruncatedDigest, hash, len(digest))
+	default:
+		return fmt.Errorf("key requires %v digest, got %d bytes", hash, len(digest))
+	}
+}
+
+// verifyDigestSignature verifies signature over a precomputed digest, as
+// made by signDigest, with the key at keyPath. The digest must have been
+// computed with the hash the key's algorithm requires, and must not be
+// truncated unless WithTruncatedDigest is given.
+func verifyDigestSignature(ctx context.Context, client *cloudkms.Service, signature string, digest []byte, keyPath string, opts ...Option) error {
+	o := newOptions(opts)
+	alg, err := getKeyAlgorithm(ctx, client, keyPath, opts...)
+	if err != nil {
+		return err
+	}
+	if err := o.checkAllowedAlgorithm(alg.Name, keyPath); err != nil {
+		return err
+	}
+	publicKey, err := o.getPublicKey(ctx, client, keyPath)
+	if err != nil {
+		return err
+	}
+	decodedSignature, err := o.decodeSignature(signature)
+	if err != nil {
+		return err
+	}
+	if err := verifyDigestLength(publicKey, alg, digest, decodedSignature, o.allowTruncatedDigest); err != nil {
+		return err
+	}
+	o.reportAlgorithm(func() string { return alg.Name })
+	return nil
+}
+
+// computeSignDigest hashes message as a key with the KMS algorithm alg, such
+// as "EC_SIGN_P256_SHA256", requires, for signing on another machine with
+// signComputedDigest. It needs no KMS access, so it can run on an air-gapped
+// host. It returns the base64-encoded digest and the name of its hash, such
+// as "SHA-256", which is carried along with the digest so that the signing
+// side can check that it matches the key.
+func computeSignDigest(message string, alg string) (digestB64 string, hash string, err error) {
+	info, ok := lookupAlgorithm(alg)
+	if !ok {
+		return "", "", fmt.Errorf("unsupported algorithm %s", alg)
+	}
+	if info.Purpose != "ASYMMETRIC_SIGN" || info.Hash == 0 {
+		return "", "", fmt.Errorf("%w: %s does not sign digests", ErrKeyTypeMismatch, alg)
+	}
+	h := info.Hash.New()
+	h.Write([]byte(message))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), info.Hash.String(), nil
+}
+
+// signComputedDigest signs a digest made by computeSignDigest with the key at
+// keyPath. It returns an error wrapping ErrKeyTypeMismatch, without signing,
+// if hash is not the hash the key's algorithm requires.
+func signComputedDigest(ctx context.Context, client *cloudkms.Service, digestB64, hash, keyPath string, opts ...Option) (string, error) {
+	alg, err := getKeyAlgorithm(ctx, client, keyPath, opts...)
+	if err != nil {
+		return "", err
+	}
+	if alg.Hash == 0 || alg.Hash.String() != hash {
+		return "", fmt.Errorf("%w: digest was computed with %s, but %s signs %v digests", ErrKeyTypeMismatch, hash, alg.Name, alg.Hash)
+	}
+	digest, err := decodeBase64(digestB64)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode digest: %w", err)
+	}
+	return signDigestForAlgorithm(ctx, client, digest, alg, keyPath, opts...)
+}
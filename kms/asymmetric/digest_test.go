@@ -0,0 +1,97 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestTruncatedDigest(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("ec-sign")
+	f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+	digest := sha256.Sum256([]byte("message"))
+	truncated := digest[:20]
+
+	if _, err := signDigest(ctx, client, truncated, keyPath); !errors.Is(err, ErrTruncatedDigest) {
+		t.Errorf("signDigest: got %v, want ErrTruncatedDigest", err)
+	}
+	signature, err := signDigest(ctx, client, digest[:], keyPath)
+	if err != nil {
+		t.Fatalf("signDigest: %v", err)
+	}
+	if err := verifyDigestSignature(ctx, client, signature, digest[:], keyPath); err != nil {
+		t.Errorf("verifyDigestSignature: %v", err)
+	}
+
+	// A legacy signer that signed only the first 20 bytes of the digest.
+	ecKey := testPrivateKey(t, "EC_SIGN_P256_SHA256").(*ecdsa.PrivateKey)
+	legacy, err := ecdsa.SignASN1(rand.Reader, ecKey, truncated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacySignature := base64.StdEncoding.EncodeToString(legacy)
+	if err := verifyDigestSignature(ctx, client, legacySignature, truncated, keyPath); !errors.Is(err, ErrTruncatedDigest) {
+		t.Errorf("truncated digest: got %v, want ErrTruncatedDigest", err)
+	}
+	if err := verifyDigestSignature(ctx, client, legacySignature, truncated, keyPath, WithTruncatedDigest()); err != nil {
+		t.Errorf("truncated digest with WithTruncatedDigest: %v", err)
+	}
+}
+
+func TestComputeSignDigest(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	ecPath := testKeyPath("ec-sign")
+	rsaPath := testKeyPath("rsa-sign")
+	f.addKey(t, ecPath, "EC_SIGN_P384_SHA384")
+	f.addKey(t, rsaPath, "RSA_SIGN_PKCS1_4096_SHA512")
+
+	for keyPath, alg := range map[string]string{ecPath: "EC_SIGN_P384_SHA384", rsaPath: "RSA_SIGN_PKCS1_4096_SHA512"} {
+		digestB64, hash, err := computeSignDigest("message", alg)
+		if err != nil {
+			t.Fatalf("computeSignDigest(%s): %v", alg, err)
+		}
+		signature, err := signComputedDigest(ctx, client, digestB64, hash, keyPath)
+		if err != nil {
+			t.Fatalf("signComputedDigest(%s): %v", alg, err)
+		}
+		verify, err := newVerifier(ctx, client, keyPath)
+		if err != nil {
+			t.Fatalf("newVerifier(%s): %v", alg, err)
+		}
+		if err := verify(signature, "message"); err != nil {
+			t.Errorf("%s: signature does not verify: %v", alg, err)
+		}
+	}
+
+	digestB64, hash, err := computeSignDigest("message", "EC_SIGN_P256_SHA256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("message"))
+	if want := base64.StdEncoding.EncodeToString(sum[:]); digestB64 != want || hash != "SHA-256" {
+		t.Errorf("computeSignDigest(EC_SIGN_P256_SHA256) = %q, %q; want %q, SHA-256", digestB64, hash, want)
+	}
+	if _, err := signComputedDigest(ctx, client, digestB64, hash, ecPath); !errors.Is(err, ErrKeyTypeMismatch) {
+		t.Errorf("signComputedDigest with a SHA-256 digest for a SHA-384 key: got %v, want ErrKeyTypeMismatch", err)
+	}
+	for _, alg := range []string{"EC_SIGN_ED25519", "RSA_DECRYPT_OAEP_2048_SHA256"} {
+		if _, _, err := computeSignDigest("message", alg); !errors.Is(err, ErrKeyTypeMismatch) {
+			t.Errorf("computeSignDigest(%s): got %v, want ErrKeyTypeMismatch", alg, err)
+		}
+	}
+	if _, _, err := computeSignDigest("message", "NOT_AN_ALGORITHM"); err == nil {
+		t.Error("computeSignDigest with an unknown algorithm succeeded")
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// inTotoPayloadType is the DSSE payload type of in-toto statements.
+const inTotoPayloadType = "application/vnd.in-toto+json"
+
+// dsseEnvelope is a Dead Simple Signing Envelope, as used by in-toto and
+// Sigstore: https://github.com/secure-systems-lab/dsse/blob/master/envelope.md
+type dsseEnvelope struct {
+	PayloadType string          `json:"payloadType"`
+	Payload     string          `json:"payload"`
+	Signatures  []dsseSignature `json:"signatures"`
+}
+
+type dsseSignature struct {
+	KeyID string `json:"keyid,omitempty"`
+	Sig   string `json:"sig"`
+}
+
+// dssePAE returns the DSSE pre-authentication encoding of a payload, the
+// bytes that are actually signed:
+//
+//	"DSSEv1" SP LEN(payloadType) SP payloadType SP LEN(payload) SP payload
+//
+// where LEN is the length in bytes as ASCII decimal and SP is a space.
+// Binding the payload type into the signature stops a payload of one type
+// from being passed off as another.
+func dssePAE(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
+
+// signDSSE signs payload, of the given payload type, with the KMS key at
+// keyPath and returns the JSON DSSE envelope. The signature's keyid is the
+// key's computeKID.
+func signDSSE(ctx context.Context, client *cloudkms.Service, payloadType string, payload []byte, keyPath string, opts ...Option) ([]byte, error) {
+	_, publicKey, err := fetchPublicKey(ctx, client, keyPath, opts...)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := signAsymmetricBytes(ctx, client, string(dssePAE(payloadType, payload)), keyPath, opts...)
+	if err != nil {
+		return nil, err
+	}
+	envelope := dsseEnvelope{
+		PayloadType: payloadType,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures: []dsseSignature{{
+			KeyID: computeKID(keyPath, publicKey),
+			Sig:   base64.StdEncoding.EncodeToString(signature),
+		}},
+	}
+	return json.Marshal(envelope)
+}
+
+// dsseDecode decodes a DSSE payload or signature. The specification allows
+// either the standard or the URL-safe base64 alphabet, with or without
+// padding.
+func dsseDecode(s string) ([]byte, error) {
+	s = strings.TrimRight(s, "=")
+	if strings.ContainsAny(s, "-_") {
+		return base64.RawURLEncoding.DecodeString(s)
+	}
+	return base64.RawStdEncoding.DecodeString(s)
+}
+
+// verifyDSSE verifies the JSON DSSE envelope envelopeJSON against the KMS key
+// at keyPath and returns the keyid, the key's computeKID, that matched. Signatures
+// carrying another keyid are skipped; those without a keyid are tried. If none match, the error wraps ErrSignatureInvalid.
+// With WithRequiredAudience, a verified payload must also name the audience.
+func verifyDSSE(ctx context.Context, client *cloudkms.Service, envelopeJSON []byte, keyPath string, opts ...Option) (string, error) {
+	var envelope dsseEnvelope
+	if err := json.Unmarshal(envelopeJSON, &envelope); err != nil {
+		return "", fmt.Errorf("%w: envelope: %w", ErrSignatureMalformed, err)
+	}
+	if len(envelope.Signatures) == 0 {
+		return "", fmt.Errorf("%w: envelope has no signatures", ErrSignatureMalformed)
+	}
+	payload, err := dsseDecode(envelope.Payload)
+	if err != nil {
+		return "", fmt.Errorf("%w: payload: %w", ErrSignatureMalformed, err)
+	}
+	o := newOptions(opts)
+	publicKey, alg, err := o.getPublicKeyAlgorithm(ctx, client, keyPath)
+	if err != nil {
+		return "", err
+	}
+	kid := computeKID(keyPath, publicKey)
+	pae := dssePAE(envelope.PayloadType, payload)
+	opts = append(opts[:len(opts):len(opts)], withFetchedPublicKey(publicKey), WithKeyAlgorithm(alg))
+	var errs []error
+	for i, s := range envelope.Signatures {
+		if s.KeyID != "" && s.KeyID != kid {
+			continue
+		}
+		sig, err := dsseDecode(s.Sig)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%w: signature %d: %w", ErrSignatureMalformed, i, err))
+			continue
+		}
+		err = verifySignature(ctx, client, base64.StdEncoding.EncodeToString(sig), pae, keyPath, opts...)
+		if err == nil {
+			if err := o.checkPayloadAudience(payload); err != nil {
+				return "", err
+			}
+			return kid, nil
+		}
+		errs = append(errs, fmt.Errorf("signature %d: %w", i, err))
+	}
+	if len(errs) == 0 {
+		return "", fmt.Errorf("%w: no signature with keyid %s", ErrSignatureInvalid, kid)
+	}
+	return "", errors.Join(errs...)
+}
+
+// An AttestationSubject is an artifact covered by an attestation.
+type AttestationSubject struct {
+	Name string `json:"name"`
+	// Digest maps a hash algorithm, such as "sha256", to the hex digest of
+	// the artifact.
+	Digest map[string]string `json:"digest"`
+}
+
+// inTotoStatement is an in-toto attestation statement, version 1.
+type inTotoStatement struct {
+	Type          string               `json:"_type"`
+	Subject       []AttestationSubject `json:"subject"`
+	PredicateType string               `json:"predicateType"`
+	Predicate     interface{}          `json:"predicate"`
+}
+
+// signAttestation builds an in-toto statement about subjects, with the given
+// predicate type (for example "https://slsa.dev/provenance/v1") and
+// predicate, and signs it with signDSSE. predicate must marshal to a JSON
+// object.
+func signAttestation(ctx context.Context, client *cloudkms.Service, subjects []AttestationSubject, predicateType string, predicate interface{}, keyPath string, opts ...Option) ([]byte, error) {
+	if len(subjects) == 0 {
+		return nil, fmt.Errorf("attestation needs at least one subject")
+	}
+	statement, err := json.Marshal(inTotoStatement{
+		Type:          "https://in-toto.io/Statement/v1",
+		Subject:       subjects,
+		PredicateType: predicateType,
+		Predicate:     predicate,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal statement: %w", err)
+	}
+	return signDSSE(ctx, client, inTotoPayloadType, statement, keyPath, opts...)
+}
@@ -0,0 +1,130 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestDSSEPAE(t *testing.T) {
+	// The example from the DSSE protocol specification.
+	got := string(dssePAE("http://example.com/HelloWorld", []byte("hello world")))
+	want := "DSSEv1 29 http://example.com/HelloWorld 11 hello world"
+	if got != want {
+		t.Errorf("dssePAE = %q, want %q", got, want)
+	}
+}
+
+func TestSignAttestation(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("ec-sign")
+	f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+	subjects := []AttestationSubject{{Name: "app.tar.gz", Digest: map[string]string{"sha256": "ab12"}}}
+	predicate := map[string]interface{}{"builder": map[string]string{"id": "ci"}}
+
+	envelopeJSON, err := signAttestation(ctx, client, subjects, "https://slsa.dev/provenance/v1", predicate, keyPath)
+	if err != nil {
+		t.Fatalf("signAttestation: %v", err)
+	}
+	var envelope dsseEnvelope
+	if err := json.Unmarshal(envelopeJSON, &envelope); err != nil {
+		t.Fatal(err)
+	}
+	if envelope.PayloadType != inTotoPayloadType || len(envelope.Signatures) != 1 {
+		t.Fatalf("unexpected envelope %s", envelopeJSON)
+	}
+	payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var statement inTotoStatement
+	if err := json.Unmarshal(payload, &statement); err != nil {
+		t.Fatal(err)
+	}
+	if statement.Type != "https://in-toto.io/Statement/v1" || statement.Subject[0].Name != "app.tar.gz" {
+		t.Errorf("unexpected statement %s", payload)
+	}
+
+	// The signature covers the PAE, not the bare payload.
+	_, publicKey, err := fetchPublicKey(ctx, client, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid := computeKID(keyPath, publicKey); envelope.Signatures[0].KeyID != kid {
+		t.Errorf("keyid = %q, want %q", envelope.Signatures[0].KeyID, kid)
+	}
+	sig, err := base64.StdEncoding.DecodeString(envelope.Signatures[0].Sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alg, _ := lookupAlgorithm("EC_SIGN_P256_SHA256")
+	digest := sha256.Sum256(dssePAE(envelope.PayloadType, payload))
+	if err := verifyDigest(publicKey, alg, digest[:], sig); err != nil {
+		t.Errorf("signature does not verify over the PAE: %v", err)
+	}
+}
+
+func TestVerifyDSSE(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("rsa-sign")
+	otherPath := testKeyPath("ec-sign")
+	f.addKey(t, keyPath, "RSA_SIGN_PSS_2048_SHA256")
+	f.addKey(t, otherPath, "EC_SIGN_P256_SHA256")
+
+	envelopeJSON, err := signDSSE(ctx, client, "text/plain", []byte("hello"), keyPath)
+	if err != nil {
+		t.Fatalf("signDSSE: %v", err)
+	}
+	var envelope dsseEnvelope
+	if err := json.Unmarshal(envelopeJSON, &envelope); err != nil {
+		t.Fatal(err)
+	}
+	kid, err := verifyDSSE(ctx, client, envelopeJSON, keyPath)
+	if err != nil || kid != envelope.Signatures[0].KeyID {
+		t.Errorf("verifyDSSE = (%q, %v), want (%q, nil)", kid, err, envelope.Signatures[0].KeyID)
+	}
+	if _, err := verifyDSSE(ctx, client, envelopeJSON, otherPath); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("verifyDSSE with another key: got %v, want ErrSignatureInvalid", err)
+	}
+
+	// Unpadded URL-safe base64 is accepted, and the signature without a keyid
+	// is still tried.
+	sig, _ := base64.StdEncoding.DecodeString(envelope.Signatures[0].Sig)
+	envelope.Signatures = []dsseSignature{{KeyID: "other"}, {Sig: base64.RawURLEncoding.EncodeToString(sig)}}
+	envelope.Payload = base64.RawURLEncoding.EncodeToString([]byte("hello"))
+	rewritten, _ := json.Marshal(envelope)
+	if _, err := verifyDSSE(ctx, client, rewritten, keyPath); err != nil {
+		t.Errorf("verifyDSSE with URL-safe encoding: %v", err)
+	}
+
+	// Changing the payload type invalidates the signature.
+	envelope.PayloadType = "application/json"
+	rewritten, _ = json.Marshal(envelope)
+	if _, err := verifyDSSE(ctx, client, rewritten, keyPath); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("verifyDSSE with changed payload type: got %v, want ErrSignatureInvalid", err)
+	}
+	if _, err := verifyDSSE(ctx, client, []byte("{"), keyPath); !errors.Is(err, ErrSignatureMalformed) {
+		t.Errorf("verifyDSSE with bad JSON: got %v, want ErrSignatureMalformed", err)
+	}
+
+	// The key's own algorithm is used, not RSA-PSS with SHA-256.
+	pkcs1Path := testKeyPath("rsa-pkcs1")
+	f.addKey(t, pkcs1Path, "RSA_SIGN_PKCS1_2048_SHA256")
+	envelopeJSON, err = signDSSE(ctx, client, "text/plain", []byte("hello"), pkcs1Path)
+	if err != nil {
+		t.Fatalf("signDSSE with a PKCS#1 key: %v", err)
+	}
+	if _, err := verifyDSSE(ctx, client, envelopeJSON, pkcs1Path); err != nil {
+		t.Errorf("verifyDSSE with a PKCS#1 key: %v", err)
+	}
+}
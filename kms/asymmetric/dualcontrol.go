@@ -0,0 +1,66 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// A DualControlError reports which of the two signatures given to
+// verifyDualControl failed. Err1 and Err2 are the errors verifying the
+// signatures with KeyPath1 and KeyPath2, or nil for one that passed.
+type DualControlError struct {
+	KeyPath1, KeyPath2 string
+	Err1, Err2         error
+}
+
+func (e *DualControlError) Error() string {
+	var failed []string
+	if e.Err1 != nil {
+		failed = append(failed, fmt.Sprintf("signature 1 (%s): %v", e.KeyPath1, e.Err1))
+	}
+	if e.Err2 != nil {
+		failed = append(failed, fmt.Sprintf("signature 2 (%s): %v", e.KeyPath2, e.Err2))
+	}
+	return "dual control failed: " + strings.Join(failed, "; ")
+}
+
+// Unwrap returns the errors of the signatures that failed, so that errors.Is
+// finds, for example, ErrSignatureInvalid in either.
+func (e *DualControlError) Unwrap() []error {
+	var errs []error
+	for _, err := range []error{e.Err1, e.Err2} {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// verifyDualControl verifies that sig1, made with the key version at
+// keyPath1, and sig2, made with the one at keyPath2, are both valid
+// signatures over message, for operations that need two signers to agree.
+// Both signatures are always checked. If either fails, it returns a
+// *DualControlError saying which, so that the right signer can be
+// investigated.
+//
+// The two key versions must belong to different keys: two versions of one
+// key are controlled by the same people, so they are rejected before any
+// signature is checked.
+func verifyDualControl(ctx context.Context, client *cloudkms.Service, sig1, sig2, message, keyPath1, keyPath2 string, opts ...Option) error {
+	if logicalKeyID(keyPath1) == logicalKeyID(keyPath2) {
+		return fmt.Errorf("%s and %s are versions of the same key; dual control needs two independent keys", keyPath1, keyPath2)
+	}
+	err1 := verifySignature(ctx, client, sig1, []byte(message), keyPath1, opts...)
+	err2 := verifySignature(ctx, client, sig2, []byte(message), keyPath2, opts...)
+	if err1 != nil || err2 != nil {
+		return &DualControlError{KeyPath1: keyPath1, KeyPath2: keyPath2, Err1: err1, Err2: err2}
+	}
+	return nil
+}
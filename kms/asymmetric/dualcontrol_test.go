@@ -0,0 +1,66 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestVerifyDualControl(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath1 := testKeyPath("approver-1")
+	keyPath2 := testKeyPath("approver-2")
+	f.addKey(t, keyPath1, "EC_SIGN_P256_SHA256")
+	f.addKey(t, keyPath2, "RSA_SIGN_PSS_2048_SHA256")
+	const message = "transfer 1000000"
+	sign := func(message, keyPath string) string {
+		signature, err := signAsymmetric(ctx, client, message, keyPath)
+		if err != nil {
+			t.Fatalf("signAsymmetric: %v", err)
+		}
+		return signature
+	}
+	sig1, sig2 := sign(message, keyPath1), sign(message, keyPath2)
+	if err := verifyDualControl(ctx, client, sig1, sig2, message, keyPath1, keyPath2); err != nil {
+		t.Fatalf("verifyDualControl: %v", err)
+	}
+
+	other1, other2 := sign("transfer 1", keyPath1), sign("transfer 1", keyPath2)
+	tests := []struct {
+		name       string
+		sig1, sig2 string
+		want1      bool
+		want2      bool
+	}{
+		{"first fails", other1, sig2, true, false},
+		{"second fails", sig1, other2, false, true},
+		{"both fail", other1, other2, true, true},
+		{"swapped", sig2, sig1, true, true},
+	}
+	for _, test := range tests {
+		err := verifyDualControl(ctx, client, test.sig1, test.sig2, message, keyPath1, keyPath2)
+		var dcErr *DualControlError
+		if !errors.As(err, &dcErr) {
+			t.Errorf("%s: got %v, want a *DualControlError", test.name, err)
+			continue
+		}
+		if (dcErr.Err1 != nil) != test.want1 || (dcErr.Err2 != nil) != test.want2 {
+			t.Errorf("%s: got errors %v and %v, want failures %v and %v", test.name, dcErr.Err1, dcErr.Err2, test.want1, test.want2)
+		}
+		if !errors.Is(err, ErrSignatureInvalid) && test.name != "swapped" {
+			t.Errorf("%s: got %v, want ErrSignatureInvalid", test.name, err)
+		}
+	}
+
+	sameKey := parentKeyPath(keyPath1) + "/cryptoKeyVersions/2"
+	f.addKey(t, sameKey, "EC_SIGN_P256_SHA256")
+	if err := verifyDualControl(ctx, client, sig1, sign(message, sameKey), message, keyPath1, sameKey); err == nil {
+		t.Error("two versions of one key: got nil error")
+	}
+}
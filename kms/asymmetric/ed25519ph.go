@@ -0,0 +1,53 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"fmt"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// verifyEd25519ph verifies an Ed25519ph signature over message with the
+// Ed25519 key at keyPath. message is the message itself; it is hashed with
+// SHA-512 here, as Ed25519ph requires (RFC 8032, section 5.1).
+//
+// Ed25519ph and pure Ed25519 are different algorithms: a signature made
+// with one never verifies with the other, even with the same key. KMS
+// EC_SIGN_ED25519 keys sign with pure Ed25519, so use this only for
+// signatures made elsewhere with the Ed25519ph variant, such as by libraries
+// that sign large messages in a single pass. If the key is not an Ed25519
+// key, for example because the deployment has none, verifyEd25519ph returns
+// an error wrapping ErrKeyTypeMismatch without checking the signature.
+func verifyEd25519ph(ctx context.Context, client *cloudkms.Service, signature string, message []byte, keyPath string, opts ...Option) error {
+	o := newOptions(opts)
+	if err := o.checkMessageLength(message); err != nil {
+		return err
+	}
+	publicKey, err := o.getPublicKey(ctx, client, keyPath)
+	if err != nil {
+		return err
+	}
+	key, ok := publicKey.(ed25519.PublicKey)
+	if !ok {
+		return fmt.Errorf("%w: Ed25519ph is unsupported with %T; it needs an EC_SIGN_ED25519 key", ErrKeyTypeMismatch, publicKey)
+	}
+	decoded, err := o.decodeSignature(signature)
+	if err != nil {
+		return err
+	}
+	if len(decoded) != ed25519.SignatureSize {
+		return fmt.Errorf("%w: Ed25519 signature is %d bytes, want %d", ErrSignatureMalformed, len(decoded), ed25519.SignatureSize)
+	}
+	digest := sha512.Sum512(message)
+	if err := ed25519.VerifyWithOptions(key, digest[:], decoded, &ed25519.Options{Hash: crypto.SHA512}); err != nil {
+		return fmt.Errorf("%w: %w", ErrSignatureInvalid, err)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestVerifyEd25519ph(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("ed25519")
+	ecPath := testKeyPath("ec-sign")
+	f.addKey(t, keyPath, "EC_SIGN_ED25519")
+	f.addKey(t, ecPath, "EC_SIGN_P256_SHA256")
+	key := testPrivateKey(t, "EC_SIGN_ED25519")
+	message := []byte("message")
+
+	digest := sha512.Sum512(message)
+	ph, err := key.Sign(rand.Reader, digest[:], &ed25519.Options{Hash: crypto.SHA512})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pure, err := key.Sign(rand.Reader, message, crypto.Hash(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := base64.StdEncoding.EncodeToString(ph)
+	if err := verifyEd25519ph(ctx, client, signature, message, keyPath); err != nil {
+		t.Fatalf("verifyEd25519ph: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		signature string
+		message   string
+		keyPath   string
+		want      error
+	}{
+		{"other message", signature, "other message", keyPath, ErrSignatureInvalid},
+		{"pure Ed25519 signature", base64.StdEncoding.EncodeToString(pure), "message", keyPath, ErrSignatureInvalid},
+		{"short signature", base64.StdEncoding.EncodeToString(ph[:10]), "message", keyPath, ErrSignatureMalformed},
+		{"EC key", signature, "message", ecPath, ErrKeyTypeMismatch},
+	}
+	for _, test := range tests {
+		if err := verifyEd25519ph(ctx, client, test.signature, []byte(test.message), test.keyPath); !errors.Is(err, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// KMS models signatures as protobuf bytes fields, which the REST API carries
+// as standard base64 strings. signAsymmetric hands that string back untouched,
+// so its result is already base64: decode it exactly once to get the raw
+// signature and never encode it again.
+
+// decodeBase64 decodes s as strict, padded standard base64. Unlike
+// base64.StdEncoding, it rejects line breaks and non-zero padding bits, so a
+// signature or ciphertext has exactly one accepted encoding. The error names
+// the offending byte and its offset, and wraps the base64.CorruptInputError
+// when there is one.
+func decodeBase64(s string) ([]byte, error) {
+	if i := strings.IndexAny(s, "\r\n"); i >= 0 {
+		return nil, fmt.Errorf("invalid base64: line break at offset %d", i)
+	}
+	if len(s)%4 != 0 {
+		return nil, fmt.Errorf("invalid base64: length %d is not a multiple of 4; padding may be missing", len(s))
+	}
+	decoded, err := base64.StdEncoding.Strict().DecodeString(s)
+	var corrupt base64.CorruptInputError
+	switch {
+	case err == nil:
+		return decoded, nil
+	case !errors.As(err, &corrupt) || int(corrupt) >= len(s):
+		return nil, fmt.Errorf("invalid base64: %w", err)
+	case s[corrupt] == '=':
+		return nil, fmt.Errorf("invalid base64: bad padding at offset %d: %w", int64(corrupt), err)
+	default:
+		return nil, fmt.Errorf("invalid base64: unexpected %q at offset %d: %w", s[corrupt], int64(corrupt), err)
+	}
+}
+
+// decodeSignature converts a base64 signature, as returned by signAsymmetric,
+// to the raw signature bytes.
+func decodeSignature(signature string) ([]byte, error) {
+	decoded, err := decodeBase64(signature)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to decode signature string: %w", ErrSignatureMalformed, err)
+	}
+	return decoded, nil
+}
+
+// encodeSignature converts raw signature bytes to the base64 form accepted by
+// verifySignatureRSA and verifySignatureEC.
+func encodeSignature(signature []byte) string {
+	return base64.StdEncoding.EncodeToString(signature)
+}
+
+// signaturesEqual reports whether a and b are the same signature. It takes
+// time independent of the contents, so comparing an attacker-supplied
+// signature against an expected one reveals nothing about where they differ.
+func signaturesEqual(a, b []byte) bool {
+	return subtle.ConstantTimeCompare(a, b) == 1
+}
+
+// signAsymmetricBytes signs message like signAsymmetric, but returns the raw
+// signature bytes instead of their base64 encoding. WithSignatureEncoding has
+// no effect on it.
+func signAsymmetricBytes(ctx context.Context, client *cloudkms.Service, message, keyPath string, opts ...Option) ([]byte, error) {
+	opts = append(opts[:len(opts):len(opts)], WithSignatureEncoding(SignatureBase64))
+	signature, err := signAsymmetric(ctx, client, message, keyPath, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return decodeSignature(signature)
+}
+
+// SignatureEncoding is the encoding of a signature returned by signAsymmetric
+// or passed to the verify functions.
+type SignatureEncoding int
+
+const (
+	// SignatureBase64 is standard base64, as produced by signAsymmetric.
+	SignatureBase64 SignatureEncoding = iota
+	// SignatureHex is hexadecimal, as printed by tools such as openssl.
+	SignatureHex
+	// SignatureAutoDetect accepts either. A string made only of hex digits is
+	// treated as hex; anything else must be strict, padded base64. A base64
+	// signature of an RSA or EC key is never all hex digits in practice, as
+	// it essentially always contains letters beyond 'f' or padding. PEM, as
+	// produced with SignaturePEM, is recognized by its "-----BEGIN" line.
+	// signAsymmetric treats it as SignatureBase64.
+	SignatureAutoDetect
+	// SignaturePEM is a PEM block of type "SIGNATURE" holding the raw
+	// signature, with an "Algorithm" header naming the KMS algorithm.
+	SignaturePEM
+	// SignatureRaw is the raw signature bytes, held in a string.
+	SignatureRaw
+)
+
+// signaturePEMType is the PEM block type used by SignaturePEM.
+const signaturePEMType = "SIGNATURE"
+
+// WithSignatureEncoding sets how signAsymmetric encodes the signatures it
+// returns and how the verify functions decode signatures. The default is
+// SignatureBase64.
+func WithSignatureEncoding(e SignatureEncoding) Option {
+	return func(o *options) { o.signatureEncoding = e }
+}
+
+// decodeSignature decodes signature according to WithSignatureEncoding.
+func (o *options) decodeSignature(signature string) ([]byte, error) {
+	switch o.signatureEncoding {
+	case SignatureHex:
+		decoded, err := hex.DecodeString(signature)
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to decode hex signature: %w", ErrSignatureMalformed, err)
+		}
+		return decoded, nil
+	case SignaturePEM:
+		return decodeSignaturePEM(signature)
+	case SignatureRaw:
+		return []byte(signature), nil
+	case SignatureAutoDetect:
+		if strings.HasPrefix(strings.TrimSpace(signature), "-----BEGIN ") {
+			return decodeSignaturePEM(signature)
+		}
+		if decoded, err := hex.DecodeString(signature); err == nil {
+			return decoded, nil
+		}
+		if decoded, err := decodeBase64(signature); err == nil {
+			return decoded, nil
+		}
+		return nil, ErrUnrecognizedEncoding
+	default:
+		return decodeSignature(signature)
+	}
+}
+
+// encodeSignature converts signature, base64-encoded as KMS returns it, to
+// the encoding set with WithSignatureEncoding. alg is the KMS algorithm of the
+// signing key, recorded in PEM output.
+func (o *options) encodeSignature(signature, alg string) (string, error) {
+	switch o.signatureEncoding {
+	case SignatureHex, SignaturePEM, SignatureRaw:
+	default:
+		return signature, nil
+	}
+	raw, err := decodeSignature(signature)
+	if err != nil {
+		return "", err
+	}
+	switch o.signatureEncoding {
+	case SignatureHex:
+		return hex.EncodeToString(raw), nil
+	case SignaturePEM:
+		block := &pem.Block{
+			Type:    signaturePEMType,
+			Headers: map[string]string{"Algorithm": alg},
+			Bytes:   raw,
+		}
+		return string(pem.EncodeToMemory(block)), nil
+	default:
+		return string(raw), nil
+	}
+}
+
+// decodeSignaturePEM returns the raw signature in a SignaturePEM block.
+func decodeSignaturePEM(signature string) ([]byte, error) {
+	block, _ := pem.Decode([]byte(signature))
+	if block == nil {
+		return nil, fmt.Errorf("%w: failed to decode PEM signature", ErrSignatureMalformed)
+	}
+	if block.Type != signaturePEMType {
+		return nil, fmt.Errorf("%w: PEM block is %q, not %q", ErrSignatureMalformed, block.Type, signaturePEMType)
+	}
+	return block.Bytes, nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestDecodeSignatureEncodings(t *testing.T) {
+	raw := bytes.Repeat([]byte{0x30, 0x45, 0xfe, 0x01}, 16)
+	tests := []struct {
+		name      string
+		encoding  SignatureEncoding
+		signature string
+	}{
+		{"base64", SignatureBase64, encodeSignature(raw)},
+		{"hex", SignatureHex, hex.EncodeToString(raw)},
+		{"auto base64", SignatureAutoDetect, encodeSignature(raw)},
+		{"auto hex", SignatureAutoDetect, hex.EncodeToString(raw)},
+	}
+	for _, test := range tests {
+		o := newOptions([]Option{WithSignatureEncoding(test.encoding)})
+		got, err := o.decodeSignature(test.signature)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !bytes.Equal(got, raw) {
+			t.Errorf("%s: decoded %x; want: %x", test.name, got, raw)
+		}
+	}
+
+	o := newOptions([]Option{WithSignatureEncoding(SignatureAutoDetect)})
+	if _, err := o.decodeSignature("not a signature!"); !errors.Is(err, ErrUnrecognizedEncoding) {
+		t.Errorf("auto-detect of garbage: got %v; want ErrUnrecognizedEncoding", err)
+	}
+}
+
+func TestSignaturesEqual(t *testing.T) {
+	a := []byte{1, 2, 3}
+	if !signaturesEqual(a, []byte{1, 2, 3}) {
+		t.Errorf("signaturesEqual of identical signatures = false")
+	}
+	if signaturesEqual(a, []byte{1, 2, 4}) || signaturesEqual(a, []byte{1, 2}) {
+		t.Errorf("signaturesEqual of different signatures = true")
+	}
+}
+
+func TestSignatureOutputEncodings(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("ec-sign")
+	f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+
+	for _, encoding := range []SignatureEncoding{SignatureBase64, SignatureHex, SignaturePEM, SignatureRaw} {
+		signature, err := signAsymmetric(ctx, client, "message", keyPath, WithSignatureEncoding(encoding))
+		if err != nil {
+			t.Fatalf("signAsymmetric with encoding %d: %v", encoding, err)
+		}
+		if err := verifySignatureEC(ctx, client, signature, "message", keyPath, WithSignatureEncoding(encoding)); err != nil {
+			t.Errorf("verifySignatureEC with encoding %d: %v", encoding, err)
+		}
+		if encoding == SignaturePEM {
+			if !strings.Contains(signature, "Algorithm: EC_SIGN_P256_SHA256") {
+				t.Errorf("PEM signature has no Algorithm header:\n%s", signature)
+			}
+			if err := verifySignatureEC(ctx, client, signature, "message", keyPath, WithSignatureEncoding(SignatureAutoDetect)); err != nil {
+				t.Errorf("verifySignatureEC with auto-detected PEM: %v", err)
+			}
+		}
+	}
+}
+
+func TestDecodeSignatureStrictBase64(t *testing.T) {
+	for _, tc := range []struct {
+		name, signature, wantErr string
+	}{
+		{"valid", "MEUC", ""},
+		{"unpadded", "MEU", "not a multiple of 4"},
+		{"non-alphabet character", "ME*C", `unexpected '*' at offset 2`},
+		{"URL-safe alphabet", "ME-_", `unexpected '-' at offset 2`},
+		{"non-zero padding bits", "MEV=", "bad padding at offset 3"},
+		{"line break", "ME\nUC", "line break at offset 2"},
+		{"data after padding", "ME==MEUC", "offset"},
+	} {
+		_, err := decodeSignature(tc.signature)
+		if tc.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: decodeSignature(%q): %v", tc.name, tc.signature, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrSignatureMalformed) || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Errorf("%s: decodeSignature(%q) = %v, want ErrSignatureMalformed containing %q", tc.name, tc.signature, err, tc.wantErr)
+		}
+	}
+}
+
+func TestResponsesDecodedStrictly(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	signPath := testKeyPath("ec-sign")
+	decryptPath := testKeyPath("decrypt")
+	f.addKey(t, signPath, "EC_SIGN_P256_SHA256")
+	f.addKey(t, decryptPath, "RSA_DECRYPT_OAEP_2048_SHA256")
+	ciphertext, err := encryptRSA(ctx, client, "secret", decryptPath)
+	if err != nil {
+		t.Fatalf("encryptRSA: %v", err)
+	}
+	f.mu.Lock()
+	f.lineBreaks = true
+	f.mu.Unlock()
+
+	if _, err := signAsymmetric(ctx, client, "message", signPath); err == nil || !strings.Contains(err.Error(), "line break") {
+		t.Errorf("signAsymmetric with a line break in the signature: got %v, want an invalid base64 error", err)
+	}
+	if _, err := decryptRSA(ctx, client, ciphertext, decryptPath); err == nil || !strings.Contains(err.Error(), "line break") {
+		t.Errorf("decryptRSA with a line break in the plaintext: got %v, want an invalid base64 error", err)
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"runtime"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// dekSize is the size in bytes of generated data encryption keys, suitable
+// for AES-256.
+const dekSize = 32
+
+// generateAndWrapDEK creates a random data encryption key (DEK) and wraps it
+// with the RSA key at keyPath. Use dek to encrypt data locally, then discard it
+// and store only wrapped, which decryptRSA turns back into the DEK.
+// It fails before generating the DEK unless keyPath is a decryption key.
+func generateAndWrapDEK(ctx context.Context, client *cloudkms.Service, keyPath string, opts ...Option) (dek []byte, wrapped string, err error) {
+	if err := checkEnvelopeKey(ctx, client, keyPath, opts...); err != nil {
+		return nil, "", err
+	}
+	dek = make([]byte, dekSize)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, "", fmt.Errorf("failed to generate key: %w", err)
+	}
+	wrapped, err = encryptRSABytes(ctx, client, dek, keyPath, opts...)
+	if err != nil {
+		zeroize(dek)
+		return nil, "", err
+	}
+	return dek, wrapped, nil
+}
+
+// rewrapDEK moves a wrapped DEK from the RSA key at oldKeyPath to the one at
+// newKeyPath. The DEK is decrypted by KMS and immediately re-encrypted
+// locally with the new public key; the plaintext exists only in a buffer
+// that is overwritten before rewrapDEK returns. newKeyPath is checked to be
+// a decryption key before the DEK is decrypted. opts apply to every KMS call.
+func rewrapDEK(ctx context.Context, client *cloudkms.Service, wrapped string, oldKeyPath, newKeyPath string, opts ...Option) (string, error) {
+	if err := checkEnvelopeKey(ctx, client, newKeyPath, opts...); err != nil {
+		return "", err
+	}
+	_, dek, err := decryptRSAFull(ctx, client, wrapped, oldKeyPath, opts...)
+	if err != nil {
+		return "", err
+	}
+	return wrapAndZeroize(ctx, client, dek, newKeyPath, opts...)
+}
+
+// wrapAndZeroize wraps dek with the RSA key at keyPath, then overwrites dek,
+// whether or not wrapping succeeded.
+func wrapAndZeroize(ctx context.Context, client *cloudkms.Service, dek []byte, keyPath string, opts ...Option) (string, error) {
+	defer zeroize(dek)
+	return encryptRSABytes(ctx, client, dek, keyPath, opts...)
+}
+
+// checkEnvelopeKey returns an error wrapping ErrKeyTypeMismatch unless the
+// key owning keyPath is an ASYMMETRIC_DECRYPT key, which a wrapped DEK needs.
+func checkEnvelopeKey(ctx context.Context, client *cloudkms.Service, keyPath string, opts ...Option) error {
+	key, err := getCryptoKey(ctx, client, keyPath, opts...)
+	if err != nil {
+		return err
+	}
+	if key.Purpose != "ASYMMETRIC_DECRYPT" {
+		return fmt.Errorf("%w: envelope encryption requires a decrypt key, got %s key %s",
+			ErrKeyTypeMismatch, purposeName(key.Purpose), key.Name)
+	}
+	return nil
+}
+
+// purposeName returns a short name for a CryptoKey purpose, such as "sign"
+// for ASYMMETRIC_SIGN.
+func purposeName(purpose string) string {
+	switch purpose {
+	case "ASYMMETRIC_SIGN":
+		return "sign"
+	case "ASYMMETRIC_DECRYPT":
+		return "decrypt"
+	case "ENCRYPT_DECRYPT":
+		return "symmetric"
+	case "MAC":
+		return "MAC"
+	default:
+		return purpose
+	}
+}
+
+// zeroize overwrites b with zeros. Call it on buffers holding plaintext or
+// keys, such as those returned by decryptRSABytes and generateAndWrapDEK, as
+// soon as they are no longer needed.
+func zeroize(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+	// Keep b reachable so the compiler cannot drop the stores as dead.
+	runtime.KeepAlive(b)
+}
@@ -0,0 +1,106 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestDecryptRSABytes(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("decrypt")
+	f.addKey(t, keyPath, "RSA_DECRYPT_OAEP_2048_SHA256")
+
+	dek, wrapped, err := generateAndWrapDEK(ctx, client, keyPath)
+	if err != nil {
+		t.Fatalf("generateAndWrapDEK: %v", err)
+	}
+	plaintext, err := decryptRSABytes(ctx, client, wrapped, keyPath)
+	if err != nil {
+		t.Fatalf("decryptRSABytes: %v", err)
+	}
+	if !bytes.Equal(plaintext, dek) {
+		t.Errorf("decryptRSABytes returned %x, want %x", plaintext, dek)
+	}
+	zeroize(plaintext)
+	if !bytes.Equal(plaintext, make([]byte, len(dek))) {
+		t.Errorf("zeroize left %x", plaintext)
+	}
+}
+
+func TestGenerateAndWrapDEKWithSignKey(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("sign")
+	f.addKey(t, keyPath, "RSA_SIGN_PSS_2048_SHA256")
+
+	dek, _, err := generateAndWrapDEK(ctx, client, keyPath)
+	if !errors.Is(err, ErrKeyTypeMismatch) || !strings.Contains(err.Error(), "requires a decrypt key, got sign key") {
+		t.Errorf("generateAndWrapDEK with a sign key: got %v, want ErrKeyTypeMismatch", err)
+	}
+	if dek != nil {
+		t.Errorf("generateAndWrapDEK with a sign key returned a DEK")
+	}
+}
+
+func TestRewrapDEK(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	// The fake shares keys between keys of one algorithm.
+	oldKeyPath := testKeyPath("dek-old")
+	newKeyPath := testKeyPath("dek-new")
+	signKeyPath := testKeyPath("dek-sign")
+	f.addKey(t, oldKeyPath, "RSA_DECRYPT_OAEP_2048_SHA1")
+	f.addKey(t, newKeyPath, "RSA_DECRYPT_OAEP_2048_SHA256")
+	f.addKey(t, signKeyPath, "RSA_SIGN_PSS_2048_SHA256")
+
+	dek, wrapped, err := generateAndWrapDEK(ctx, client, oldKeyPath)
+	if err != nil {
+		t.Fatalf("generateAndWrapDEK: %v", err)
+	}
+	rewrapped, err := rewrapDEK(ctx, client, wrapped, oldKeyPath, newKeyPath)
+	if err != nil {
+		t.Fatalf("rewrapDEK: %v", err)
+	}
+	plaintext, err := decryptRSABytes(ctx, client, rewrapped, newKeyPath)
+	if err != nil {
+		t.Fatalf("decryptRSABytes with the new key: %v", err)
+	}
+	if !bytes.Equal(plaintext, dek) {
+		t.Errorf("rewrapped DEK is %x, want %x", plaintext, dek)
+	}
+	if _, err := decryptRSABytes(ctx, client, rewrapped, oldKeyPath); err == nil {
+		t.Error("decryptRSABytes of the rewrapped DEK with the old key: got nil error")
+	}
+
+	// A sign key is rejected before the DEK is decrypted.
+	f.mu.Lock()
+	before := f.requests
+	f.mu.Unlock()
+	_, err = rewrapDEK(ctx, client, wrapped, oldKeyPath, signKeyPath)
+	if !errors.Is(err, ErrKeyTypeMismatch) {
+		t.Errorf("rewrapDEK to a sign key: got %v, want ErrKeyTypeMismatch", err)
+	}
+	f.mu.Lock()
+	if n := f.requests - before; n != 1 {
+		t.Errorf("rewrapDEK to a sign key sent %d requests, want 1 to look up the key", n)
+	}
+	f.mu.Unlock()
+
+	// The plaintext DEK is overwritten whether or not it is wrapped.
+	for _, keyPath := range []string{newKeyPath, signKeyPath} {
+		buf := append([]byte(nil), dek...)
+		wrapAndZeroize(ctx, client, buf, keyPath)
+		if !bytes.Equal(buf, make([]byte, len(dek))) {
+			t.Errorf("wrapAndZeroize with %s left %x", keyPath, buf)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+
+	"google.golang.org/api/googleapi"
+)
+
+// Errors returned by the sample functions. Use errors.Is to test for them, as
+// they are usually wrapped with more detail.
+var (
+	// ErrKeyTypeMismatch means the key is not of the type the operation
+	// needs, for example an EC key passed to verifySignatureRSA.
+	ErrKeyTypeMismatch = errors.New("key type mismatch")
+
+	// ErrSignatureInvalid means the signature was checked and does not match
+	// the message and key.
+	ErrSignatureInvalid = errors.New("signature verification failed")
+
+	// ErrMACInvalid means a MAC tag was checked by KMS and does not match
+	// the data and key.
+	ErrMACInvalid = errors.New("MAC verification failed")
+
+	// ErrNonCanonicalS means an ECDSA signature is not in the low-S form
+	// required by WithLowS.
+	ErrNonCanonicalS = errors.New("ECDSA signature is not low-S")
+
+	// ErrMerkleProofInvalid means a Merkle inclusion proof does not connect
+	// the leaf to the signed root.
+	ErrMerkleProofInvalid = errors.New("Merkle inclusion proof invalid")
+
+	// ErrChainInvalid means a certificate does not chain to a trusted CA.
+	ErrChainInvalid = errors.New("certificate chain verification failed")
+
+	// ErrCertExpired and ErrCertNotYetValid mean a certificate is outside
+	// its validity period and WithCertValidity was given.
+	ErrCertExpired     = errors.New("certificate expired")
+	ErrCertNotYetValid = errors.New("certificate not yet valid")
+
+	// ErrTimestampStale and ErrTimestampInFuture mean a timestamped
+	// signature is valid, but was made too long ago or claims to be made
+	// later than now.
+	ErrTimestampStale    = errors.New("timestamp too old")
+	ErrTimestampInFuture = errors.New("timestamp in the future")
+
+	// ErrTimestampInvalid means an RFC 3161 timestamp token does not cover
+	// the signature it accompanies, or is not signed by a trusted
+	// time-stamping authority.
+	ErrTimestampInvalid = errors.New("timestamp token verification failed")
+
+	// ErrSignatureMalformed means a signature could not be decoded from the
+	// encoding it was expected in.
+	ErrSignatureMalformed = errors.New("malformed signature")
+
+	// ErrTrailingSignatureData means a DER-encoded ECDSA signature is
+	// followed by extra bytes.
+	ErrTrailingSignatureData = errors.New("trailing data after signature")
+
+	// ErrUnrecognizedEncoding means a signature is neither valid hex nor
+	// valid base64.
+	ErrUnrecognizedEncoding = errors.New("unrecognized signature encoding")
+
+	// ErrAlgorithmNotAllowed means a key's algorithm is not on the list
+	// given to WithAllowedAlgorithms or enforceAlgorithm.
+	ErrAlgorithmNotAllowed = errors.New("key algorithm not allowed")
+
+	// ErrUnexpectedAlgorithm means a key's algorithm is not the one given
+	// to verifyStrict.
+	ErrUnexpectedAlgorithm = errors.New("unexpected key algorithm")
+
+	// ErrWeakKey means an RSA key is shorter than the minimum set with
+	// WithMinRSABits.
+	ErrWeakKey = errors.New("key too weak")
+
+	// ErrKeyTooOld means a key version was generated longer ago than the
+	// maximum age given to WithMaxKeyAge.
+	ErrKeyTooOld = errors.New("key version too old")
+
+	// ErrInsufficientProtection means a key version is not protected by an
+	// HSM and WithRequireHSM was given.
+	ErrInsufficientProtection = errors.New("key not protected by an HSM")
+
+	// ErrKeyPinMismatch means a public key does not have the fingerprint
+	// given to WithExpectedKeyFingerprint.
+	ErrKeyPinMismatch = errors.New("public key does not match pinned fingerprint")
+
+	// ErrInvalidKeyPath means a key path is empty or is not the resource
+	// name of a key version. It is returned before any request is sent.
+	ErrInvalidKeyPath = errors.New("invalid key path")
+
+	// ErrEmptyMessage means a message to verify was empty and
+	// WithRejectEmptyMessage was given.
+	ErrEmptyMessage = errors.New("empty message")
+
+	// ErrUnsupportedCurve means an elliptic curve key is on a curve this
+	// package cannot verify with, such as secp256k1.
+	ErrUnsupportedCurve = errors.New("unsupported elliptic curve")
+
+	// ErrUnsupportedOAEP means the OAEP parameters given to WithOAEPOptions
+	// cannot be decrypted by the KMS key.
+	ErrUnsupportedOAEP = errors.New("unsupported OAEP configuration")
+
+	// ErrContextMismatch means a ciphertext from encryptRSAWithContext was
+	// encrypted for another context than the one given to decrypt it.
+	ErrContextMismatch = errors.New("ciphertext context mismatch")
+
+	// ErrRateLimited means a request was not sent because it would exceed
+	// the limit set with WithRateLimit.
+	ErrRateLimited = errors.New("client-side rate limit exceeded")
+
+	// ErrCircuitOpen means a PublicKeyBreaker did not call KMS because
+	// recent fetches failed, and it had no cached key to serve instead.
+	ErrCircuitOpen = errors.New("circuit breaker open")
+
+	// ErrPayloadTooLarge means a compressed payload inflates to more than
+	// the limit set with WithMaxDecompressedSize.
+	ErrPayloadTooLarge = errors.New("decompressed payload too large")
+
+	// ErrMessageTooLarge means a message read from an io.Reader is longer
+	// than the limit set with WithMaxMessageBytes.
+	ErrMessageTooLarge = errors.New("message too large")
+
+	// ErrPlaintextTooLarge means a plaintext is longer than the RSA key's
+	// OAEP limit, so it needs envelope encryption instead.
+	ErrPlaintextTooLarge = errors.New("plaintext too large for RSA-OAEP")
+
+	// ErrUnsupportedXML means an XML document uses a construct that
+	// canonicalXML does not canonicalize.
+	ErrUnsupportedXML = errors.New("unsupported XML construct")
+
+	// ErrFetchFailed means the message to verify could not be fetched, so no
+	// signature was checked.
+	ErrFetchFailed = errors.New("failed to fetch message")
+
+	// ErrFrameMissing and ErrFrameOutOfOrder mean the frames given to
+	// decryptFromFrames are not the complete sequence, in order, and
+	// ErrFrameAuthentication that they do not match their trailing MAC.
+	ErrFrameMissing        = errors.New("frame missing")
+	ErrFrameOutOfOrder     = errors.New("frame out of order")
+	ErrFrameAuthentication = errors.New("frame authentication failed")
+
+	// ErrTruncatedDigest means a digest is shorter than the output of the
+	// hash it claims to be, and WithTruncatedDigest was not given.
+	ErrTruncatedDigest = errors.New("truncated digest")
+
+	// ErrDigestMismatch means that, with WithHashDiagnostics, a signature
+	// that does not match was found to be over another digest of the
+	// message than the key's algorithm uses.
+	ErrDigestMismatch = errors.New("signature uses an unexpected digest")
+
+	// ErrRecordLength means a padded record is not of its format's fixed
+	// length, or a payload does not fit in it. ErrRecordPadding means the
+	// padding of a record is not well formed.
+	ErrRecordLength  = errors.New("wrong record length")
+	ErrRecordPadding = errors.New("malformed record padding")
+
+	// Token verification errors.
+	ErrTokenMalformed   = errors.New("malformed token")
+	ErrTokenExpired     = errors.New("token expired")
+	ErrTokenNotYetValid = errors.New("token not yet valid")
+	ErrTokenIssuer      = errors.New("unexpected token issuer")
+	ErrTokenAudience    = errors.New("unexpected token audience")
+	// ErrTokenAlgorithm means a token's "alg" header is "none" or is not the
+	// algorithm of the key that verifies it, as in an algorithm downgrade or
+	// confusion attack.
+	ErrTokenAlgorithm = errors.New("unexpected token algorithm")
+
+	// ErrAudienceMismatch means a validly signed token or payload does not
+	// name the audience required with WithRequiredAudience.
+	ErrAudienceMismatch = errors.New("audience mismatch")
+)
+
+// apiError returns the *googleapi.Error that KMS returned somewhere in err's
+// chain, with the HTTP status, message and error details, so that callers
+// can handle, for example, a permission error without matching on text. The
+// sample functions wrap KMS errors with %w, so errors.As works just as well.
+func apiError(err error) (*googleapi.Error, bool) {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr, true
+	}
+	return nil, false
+}
@@ -0,0 +1,116 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+)
+
+func TestAPIError(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	signKeyPath := testKeyPath("ec-sign")
+	decKeyPath := testKeyPath("rsa-decrypt")
+	f.addKey(t, signKeyPath, "EC_SIGN_P256_SHA256")
+	f.addKey(t, decKeyPath, "RSA_DECRYPT_OAEP_2048_SHA256")
+	ciphertext, err := encryptRSA(ctx, client, "secret", decKeyPath)
+	if err != nil {
+		t.Fatalf("encryptRSA: %v", err)
+	}
+
+	calls := map[string]func() error{
+		"signAsymmetric": func() error {
+			_, err := signAsymmetric(ctx, client, "message", signKeyPath)
+			return err
+		},
+		"verifySignature": func() error {
+			return verifySignature(ctx, client, "AAAA", []byte("message"), signKeyPath)
+		},
+		"decryptRSA": func() error {
+			_, err := decryptRSA(ctx, client, ciphertext, decKeyPath)
+			return err
+		},
+		"getKeyVersion": func() error {
+			_, err := getKeyVersion(ctx, client, signKeyPath)
+			return err
+		},
+	}
+	for name, call := range calls {
+		f.mu.Lock()
+		f.failures = []int{http.StatusForbidden}
+		f.mu.Unlock()
+		err := call()
+		apiErr, ok := apiError(err)
+		if !ok || apiErr.Code != http.StatusForbidden {
+			t.Errorf("%s: got %v, want a wrapped %d *googleapi.Error", name, err, http.StatusForbidden)
+		}
+	}
+
+	// Both regions' errors stay in the chain.
+	f.mu.Lock()
+	f.failures = []int{http.StatusServiceUnavailable, http.StatusBadGateway}
+	f.mu.Unlock()
+	_, _, err = signAsymmetricWithFailover(ctx, client, "message", signKeyPath, signKeyPath)
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusServiceUnavailable {
+		t.Errorf("signAsymmetricWithFailover: got %v, want the primary's %d error first", err, http.StatusServiceUnavailable)
+	}
+	if err == nil || !strings.Contains(err.Error(), "502") {
+		t.Errorf("signAsymmetricWithFailover: got %v, want the secondary's %d error too", err, http.StatusBadGateway)
+	}
+	if _, ok := apiError(ErrSignatureInvalid); ok {
+		t.Error("apiError(ErrSignatureInvalid) found an API error")
+	}
+}
+
+func TestErrorWrapping(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	rsaKeyPath := testKeyPath("rsa-sign")
+	decKeyPath := testKeyPath("rsa-decrypt")
+	f.addKey(t, rsaKeyPath, "RSA_SIGN_PSS_2048_SHA256")
+	f.addKey(t, decKeyPath, "RSA_DECRYPT_OAEP_2048_SHA256")
+
+	expired, cancel := context.WithTimeout(ctx, 0)
+	defer cancel()
+	if _, err := signAsymmetric(expired, client, "message", rsaKeyPath); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("signAsymmetric with an expired context: got %v, want context.DeadlineExceeded", err)
+	}
+
+	err := verifySignature(ctx, client, "ME*C", []byte("message"), rsaKeyPath)
+	var corrupt base64.CorruptInputError
+	if !errors.Is(err, ErrSignatureMalformed) || !errors.As(err, &corrupt) {
+		t.Errorf("verifySignature with bad base64: got %v, want ErrSignatureMalformed wrapping a base64.CorruptInputError", err)
+	}
+
+	signature, err := signAsymmetric(ctx, client, "message", rsaKeyPath)
+	if err != nil {
+		t.Fatalf("signAsymmetric: %v", err)
+	}
+	err = verifySignatureRSA(ctx, client, signature, "other", rsaKeyPath)
+	if !errors.Is(err, ErrSignatureInvalid) || !errors.Is(err, rsa.ErrVerification) {
+		t.Errorf("verifySignatureRSA with the wrong message: got %v, want ErrSignatureInvalid wrapping rsa.ErrVerification", err)
+	}
+
+	ciphertext, err := encryptRSAJSON(ctx, client, []string{"a"}, decKeyPath)
+	if err != nil {
+		t.Fatalf("encryptRSAJSON: %v", err)
+	}
+	var v map[string]string
+	err = decryptRSAJSON(ctx, client, ciphertext, decKeyPath, &v)
+	var typeErr *json.UnmarshalTypeError
+	if !errors.As(err, &typeErr) {
+		t.Errorf("decryptRSAJSON into the wrong type: got %v, want a wrapped *json.UnmarshalTypeError", err)
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// signAsymmetricWithFailover signs message with the key at primaryKeyPath and
+// retries once with secondaryKeyPath, a replica of the key in another region,
+// if the primary region is unavailable. It returns the signature together with
+// the location that served the request.
+// Errors that are not caused by an outage, such as a missing permission, are
+// returned immediately without trying the secondary.
+func signAsymmetricWithFailover(ctx context.Context, client *cloudkms.Service, message, primaryKeyPath, secondaryKeyPath string) (signature, location string, err error) {
+	signature, err = signAsymmetric(ctx, client, message, primaryKeyPath)
+	if err == nil {
+		return signature, keyLocation(primaryKeyPath), nil
+	}
+	if !isUnavailable(err) || ctx.Err() != nil {
+		return "", "", err
+	}
+	primaryErr := err
+	signature, err = signAsymmetric(ctx, client, message, secondaryKeyPath)
+	if err != nil {
+		return "", "", fmt.Errorf("primary (%s) failed: %w; secondary (%s) failed: %w",
+			keyLocation(primaryKeyPath), primaryErr, keyLocation(secondaryKeyPath), err)
+	}
+	return signature, keyLocation(secondaryKeyPath), nil
+}
+
+// isUnavailable reports whether err indicates that the KMS region could not
+// serve the request: a 5xx response or a transport-level failure.
+func isUnavailable(err error) bool {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
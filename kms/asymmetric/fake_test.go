@@ -0,0 +1,571 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// fakeKMS is an in-memory implementation of the parts of the KMS REST API
+// used by this package, so that tests and benchmarks can run without a
+// Google Cloud project.
+type fakeKMS struct {
+	mu   sync.Mutex
+	keys map[string]*fakeKey
+
+	// lastHeader holds the headers of the most recent request.
+	lastHeader http.Header
+	// corrupt makes sign and decrypt responses carry the wrong checksum.
+	corrupt bool
+	// lineBreaks makes sign and decrypt responses break their base64
+	// payload across lines, which lenient decoders accept.
+	lineBreaks bool
+	// failures holds HTTP status codes with which to fail the next
+	// requests, one per request.
+	failures []int
+	// requests counts the requests served.
+	requests int
+}
+
+// fakeKey is a key version held by fakeKMS.
+type fakeKey struct {
+	version *cloudkms.CryptoKeyVersion
+	private crypto.Signer
+	// secret is the key of an HMAC key version.
+	secret []byte
+	// pendingGets is the number of times a new version is read before it
+	// becomes enabled.
+	pendingGets int
+}
+
+// testKeyPath returns the resource name of version 1 of key id in a fake
+// key ring.
+func testKeyPath(id string) string {
+	return "projects/test/locations/global/keyRings/ring/cryptoKeys/" + id + "/cryptoKeyVersions/1"
+}
+
+var (
+	privateKeysMu sync.Mutex
+	privateKeys   = map[string]crypto.Signer{}
+)
+
+// testPrivateKey returns a private key for the KMS algorithm alg. Keys are
+// shared by all tests, as RSA key generation is slow.
+func testPrivateKey(t testing.TB, alg string) crypto.Signer {
+	privateKeysMu.Lock()
+	defer privateKeysMu.Unlock()
+	if key, ok := privateKeys[alg]; ok {
+		return key
+	}
+	info, ok := lookupAlgorithm(alg)
+	if !ok {
+		t.Fatalf("unknown algorithm %s", alg)
+	}
+	var key crypto.Signer
+	var err error
+	switch {
+	case info.KeyType == "Ed25519":
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	case info.KeySize == 256:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case info.KeySize == 384:
+		key, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	default:
+		key, err = rsa.GenerateKey(rand.Reader, info.KeySize)
+	}
+	if err != nil {
+		t.Fatalf("failed to generate %s key: %v", alg, err)
+	}
+	privateKeys[alg] = key
+	return key
+}
+
+// newFakeKMS starts a fake KMS server and returns it with a client for it.
+// The server is stopped when the test ends.
+func newFakeKMS(t testing.TB) (*fakeKMS, *cloudkms.Service) {
+	f := &fakeKMS{keys: map[string]*fakeKey{}}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	client, err := cloudkms.New(server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.BasePath = server.URL + "/"
+	return f, client
+}
+
+// addKey creates an enabled key version at keyPath using algorithm alg.
+func (f *fakeKMS) addKey(t testing.TB, keyPath, alg string) {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.keys[keyPath] = &fakeKey{
+		version: &cloudkms.CryptoKeyVersion{
+			Name:            keyPath,
+			Algorithm:       alg,
+			State:           "ENABLED",
+			ProtectionLevel: "SOFTWARE",
+			CreateTime:      now,
+			GenerateTime:    now,
+		},
+		private: testPrivateKey(t, alg),
+	}
+}
+
+// addMACKey creates an enabled HMAC_SHA256 key version at keyPath.
+func (f *fakeKMS) addMACKey(t testing.TB, keyPath string) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		t.Fatal(err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.keys[keyPath] = &fakeKey{
+		version: &cloudkms.CryptoKeyVersion{
+			Name:            keyPath,
+			Algorithm:       "HMAC_SHA256",
+			State:           "ENABLED",
+			ProtectionLevel: "SOFTWARE",
+		},
+		secret: secret,
+	}
+}
+
+// key returns the key version called name.
+func (f *fakeKMS) key(name string) (*fakeKey, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	k, ok := f.keys[name]
+	return k, ok
+}
+
+func (f *fakeKMS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.lastHeader = r.Header.Clone()
+	f.requests++
+	w.Header().Set(requestIDHeader, fmt.Sprintf("fake-%d", f.requests))
+	var failure int
+	if len(f.failures) > 0 {
+		failure, f.failures = f.failures[0], f.failures[1:]
+	}
+	f.mu.Unlock()
+	if failure != 0 {
+		if failure == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "1")
+		}
+		writeFakeError(w, failure, fmt.Errorf("injected failure %d", failure))
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/v1/")
+	method := ""
+	if i := strings.LastIndex(name, ":"); i >= 0 {
+		name, method = name[:i], name[i+1:]
+	}
+	if strings.HasSuffix(name, "/publicKey") {
+		name, method = strings.TrimSuffix(name, "/publicKey"), "getPublicKey"
+	}
+	response, status, err := f.handle(name, method, r)
+	if err != nil {
+		writeFakeError(w, status, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// handle serves one API call.
+func (f *fakeKMS) handle(name, method string, r *http.Request) (interface{}, int, error) {
+	if method == "" && strings.HasSuffix(name, "/cryptoKeys") {
+		return f.listCryptoKeys(name, r.URL.Query().Get("pageToken"))
+	}
+	if method == "" && strings.HasSuffix(name, "/cryptoKeyVersions") && r.Method == http.MethodPost {
+		return f.createCryptoKeyVersion(strings.TrimSuffix(name, "/cryptoKeyVersions"))
+	}
+	if method == "" && strings.HasSuffix(name, "/cryptoKeyVersions") {
+		return f.listCryptoKeyVersions(strings.TrimSuffix(name, "/cryptoKeyVersions"), r.URL.Query().Get("pageToken"))
+	}
+	if method == "" && strings.Contains(name, "/cryptoKeyVersions/") {
+		return f.getCryptoKeyVersion(name)
+	}
+	if method == "" && strings.Count(name, "/") == 3 {
+		return f.getParent(name, &cloudkms.Location{Name: name, LocationId: keyLocation(name)})
+	}
+	if method == "" && strings.Count(name, "/") == 5 {
+		return f.getParent(name, &cloudkms.KeyRing{Name: name})
+	}
+	if method == "" {
+		return f.getCryptoKey(name)
+	}
+	k, ok := f.key(name)
+	if !ok {
+		return nil, http.StatusNotFound, fmt.Errorf("%s not found", name)
+	}
+	info, _ := lookupAlgorithm(k.version.Algorithm)
+	switch method {
+	case "getPublicKey":
+		der, err := x509.MarshalPKIXPublicKey(k.private.Public())
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		pemStr := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+		return &cloudkms.PublicKey{
+			Name:            k.version.Name,
+			Algorithm:       k.version.Algorithm,
+			Pem:             pemStr,
+			PemCrc32c:       int64(crc32c([]byte(pemStr))),
+			ProtectionLevel: k.version.ProtectionLevel,
+		}, 0, nil
+	case "asymmetricSign":
+		var req cloudkms.AsymmetricSignRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Digest == nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("bad request: %v", err)
+		}
+		digestStr := map[crypto.Hash]string{
+			crypto.SHA256: req.Digest.Sha256,
+			crypto.SHA384: req.Digest.Sha384,
+			crypto.SHA512: req.Digest.Sha512,
+		}[info.Hash]
+		digest, err := base64.StdEncoding.DecodeString(digestStr)
+		if err != nil || len(digest) != info.Hash.Size() {
+			return nil, http.StatusBadRequest, fmt.Errorf("digest does not match algorithm %s", info.Name)
+		}
+		var opts crypto.SignerOpts = info.Hash
+		if info.Padding == "PSS" {
+			opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: info.Hash}
+		}
+		signature, err := k.private.Sign(rand.Reader, digest, opts)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		return &cloudkms.AsymmetricSignResponse{
+			Name:                 name,
+			Signature:            f.encode(signature),
+			SignatureCrc32c:      f.checksum(signature),
+			VerifiedDigestCrc32c: req.DigestCrc32c == int64(crc32c(digest)),
+			ProtectionLevel:      k.version.ProtectionLevel,
+		}, 0, nil
+	case "macSign":
+		var req cloudkms.MacSignRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("bad request: %v", err)
+		}
+		data, err := base64.StdEncoding.DecodeString(req.Data)
+		if err != nil || k.secret == nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("cannot MAC with %s", name)
+		}
+		mac := hmacSHA256(k.secret, data)
+		return &cloudkms.MacSignResponse{
+			Name:               name,
+			Mac:                base64.StdEncoding.EncodeToString(mac),
+			MacCrc32c:          int64(crc32c(mac)),
+			VerifiedDataCrc32c: req.DataCrc32c == int64(crc32c(data)),
+			ProtectionLevel:    k.version.ProtectionLevel,
+		}, 0, nil
+	case "macVerify":
+		var req cloudkms.MacVerifyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("bad request: %v", err)
+		}
+		data, err := base64.StdEncoding.DecodeString(req.Data)
+		if err != nil || k.secret == nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("cannot MAC with %s", name)
+		}
+		mac, err := base64.StdEncoding.DecodeString(req.Mac)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		success := hmac.Equal(mac, hmacSHA256(k.secret, data))
+		return &cloudkms.MacVerifyResponse{
+			Name:                     name,
+			Success:                  success,
+			VerifiedDataCrc32c:       req.DataCrc32c == int64(crc32c(data)),
+			VerifiedMacCrc32c:        req.MacCrc32c == int64(crc32c(mac)),
+			VerifiedSuccessIntegrity: success,
+			ProtectionLevel:          k.version.ProtectionLevel,
+		}, 0, nil
+	case "asymmetricDecrypt":
+		var req cloudkms.AsymmetricDecryptRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("bad request: %v", err)
+		}
+		ciphertext, err := base64.StdEncoding.DecodeString(req.Ciphertext)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		rsaKey, ok := k.private.(*rsa.PrivateKey)
+		if !ok || info.Padding != "OAEP" {
+			return nil, http.StatusBadRequest, fmt.Errorf("%s is not a decryption key", name)
+		}
+		plaintext, err := rsa.DecryptOAEP(info.Hash.New(), rand.Reader, rsaKey, ciphertext, nil)
+		if err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("decryption failed: %v", err)
+		}
+		return &cloudkms.AsymmetricDecryptResponse{
+			Plaintext:                f.encode(plaintext),
+			PlaintextCrc32c:          f.checksum(plaintext),
+			VerifiedCiphertextCrc32c: req.CiphertextCrc32c == int64(crc32c(ciphertext)),
+			ProtectionLevel:          k.version.ProtectionLevel,
+		}, 0, nil
+	}
+	return nil, http.StatusNotImplemented, fmt.Errorf("method %s not implemented by fake", method)
+}
+
+// checksum returns the CRC32C of data to put in a response, or a wrong one
+// if f.corrupt is set.
+func (f *fakeKMS) checksum(data []byte) int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sum := int64(crc32c(data))
+	if f.corrupt {
+		sum++
+	}
+	return sum
+}
+
+// encode returns data as base64 to put in a response, with a line break
+// after the first four characters if f.lineBreaks is set.
+func (f *fakeKMS) encode(data []byte) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	encoded := base64.StdEncoding.EncodeToString(data)
+	if f.lineBreaks && len(encoded) > 4 {
+		encoded = encoded[:4] + "\r\n" + encoded[4:]
+	}
+	return encoded
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data under secret.
+func hmacSHA256(secret, data []byte) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// getCryptoKey returns the CryptoKey called name, derived from its versions.
+func (f *fakeKMS) getCryptoKey(name string) (interface{}, int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for keyPath, k := range f.keys {
+		if parentKeyPath(keyPath) != name {
+			continue
+		}
+		info, _ := lookupAlgorithm(k.version.Algorithm)
+		return &cloudkms.CryptoKey{
+			Name:    name,
+			Purpose: info.Purpose,
+			Primary: k.version,
+			VersionTemplate: &cloudkms.CryptoKeyVersionTemplate{
+				Algorithm: k.version.Algorithm,
+			},
+		}, 0, nil
+	}
+	return nil, http.StatusNotFound, fmt.Errorf("%s not found", name)
+}
+
+// getParent returns resource, the location or key ring called name, if any
+// key is in it.
+func (f *fakeKMS) getParent(name string, resource interface{}) (interface{}, int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for keyPath := range f.keys {
+		if strings.HasPrefix(keyPath, name+"/") {
+			return resource, 0, nil
+		}
+	}
+	return nil, http.StatusNotFound, fmt.Errorf("%s not found", name)
+}
+
+// getCryptoKeyVersion returns a copy of the key version called name. A
+// version created by createCryptoKeyVersion becomes enabled after it has
+// been read pendingGets times.
+func (f *fakeKMS) getCryptoKeyVersion(name string) (interface{}, int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	k, ok := f.keys[name]
+	if !ok {
+		return nil, http.StatusNotFound, fmt.Errorf("%s not found", name)
+	}
+	version := *k.version
+	if k.pendingGets > 0 {
+		k.pendingGets--
+		if k.pendingGets == 0 {
+			k.version.State = "ENABLED"
+		}
+	}
+	return &version, 0, nil
+}
+
+// createCryptoKeyVersion adds a version to the CryptoKey parent, using the
+// algorithm of its existing versions. Like a real key, the new version is
+// PENDING_GENERATION for a while before it becomes ENABLED.
+func (f *fakeKMS) createCryptoKeyVersion(parent string) (interface{}, int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var existing *fakeKey
+	count := 0
+	for keyPath, k := range f.keys {
+		if parentKeyPath(keyPath) == parent {
+			existing = k
+			count++
+		}
+	}
+	if existing == nil {
+		return nil, http.StatusNotFound, fmt.Errorf("%s not found", parent)
+	}
+	name := fmt.Sprintf("%s/cryptoKeyVersions/%d", parent, count+1)
+	k := &fakeKey{
+		version: &cloudkms.CryptoKeyVersion{
+			Name:            name,
+			Algorithm:       existing.version.Algorithm,
+			State:           "PENDING_GENERATION",
+			ProtectionLevel: existing.version.ProtectionLevel,
+			CreateTime:      time.Now().UTC().Format(time.RFC3339Nano),
+		},
+		private:     existing.private,
+		pendingGets: 2,
+	}
+	f.keys[name] = k
+	version := *k.version
+	return &version, 0, nil
+}
+
+// fakePageSize is the number of resources in each page of a list response,
+// kept small so that tests exercise pagination.
+const fakePageSize = 2
+
+// page returns the page of names starting at pageToken, and the token of the
+// next page.
+func page(names []string, pageToken string) ([]string, string) {
+	sort.Strings(names)
+	start, _ := strconv.Atoi(pageToken)
+	if start > len(names) {
+		start = len(names)
+	}
+	end := start + fakePageSize
+	if end >= len(names) {
+		return names[start:], ""
+	}
+	return names[start:end], strconv.Itoa(end)
+}
+
+// listCryptoKeys lists the CryptoKeys in the key ring parent.
+func (f *fakeKMS) listCryptoKeys(parent, pageToken string) (interface{}, int, error) {
+	f.mu.Lock()
+	seen := map[string]bool{}
+	var names []string
+	for keyPath := range f.keys {
+		name := parentKeyPath(keyPath)
+		if strings.HasPrefix(name, parent+"/") && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	f.mu.Unlock()
+	names, next := page(names, pageToken)
+	response := &cloudkms.ListCryptoKeysResponse{NextPageToken: next, TotalSize: int64(len(seen))}
+	for _, name := range names {
+		key, status, err := f.getCryptoKey(name)
+		if err != nil {
+			return nil, status, err
+		}
+		response.CryptoKeys = append(response.CryptoKeys, key.(*cloudkms.CryptoKey))
+	}
+	return response, 0, nil
+}
+
+// listCryptoKeyVersions lists the versions of the CryptoKey parent.
+func (f *fakeKMS) listCryptoKeyVersions(parent, pageToken string) (interface{}, int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var names []string
+	for keyPath := range f.keys {
+		if parentKeyPath(keyPath) == parent {
+			names = append(names, keyPath)
+		}
+	}
+	total := len(names)
+	names, next := page(names, pageToken)
+	response := &cloudkms.ListCryptoKeyVersionsResponse{NextPageToken: next, TotalSize: int64(total)}
+	for _, name := range names {
+		response.CryptoKeyVersions = append(response.CryptoKeyVersions, f.keys[name].version)
+	}
+	return response, 0, nil
+}
+
+// writeFakeError writes an error in the format of Google APIs.
+func writeFakeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    status,
+			"message": err.Error(),
+		},
+	})
+}
+
+// TestFakeRoundTrip exercises the main sample functions against fakeKMS, so
+// that they are covered without a Google Cloud project.
+func TestFakeRoundTrip(t *testing.T) {
+	f, client := newFakeKMS(t)
+	ctx := context.Background()
+	decryptPath := testKeyPath("rsa-decrypt")
+	rsaSignPath := testKeyPath("rsa-sign")
+	ecSignPath := testKeyPath("ec-sign")
+	f.addKey(t, decryptPath, "RSA_DECRYPT_OAEP_2048_SHA256")
+	f.addKey(t, rsaSignPath, "RSA_SIGN_PSS_2048_SHA256")
+	f.addKey(t, ecSignPath, "EC_SIGN_P256_SHA256")
+	message := "test message 123"
+
+	ciphertext, err := encryptRSA(ctx, client, message, decryptPath)
+	if err != nil {
+		t.Fatalf("encryptRSA: %v", err)
+	}
+	plaintext, err := decryptRSA(ctx, client, ciphertext, decryptPath)
+	if err != nil {
+		t.Fatalf("decryptRSA: %v", err)
+	}
+	if plaintext != message {
+		t.Errorf("decryptRSA = %q; want: %q", plaintext, message)
+	}
+
+	sig, err := signAsymmetric(ctx, client, message, rsaSignPath)
+	if err != nil {
+		t.Fatalf("signAsymmetric(%s): %v", rsaSignPath, err)
+	}
+	if err := verifySignatureRSA(ctx, client, sig, message, rsaSignPath); err != nil {
+		t.Errorf("verifySignatureRSA: %v", err)
+	}
+	sig, err = signAsymmetric(ctx, client, message, ecSignPath)
+	if err != nil {
+		t.Fatalf("signAsymmetric(%s): %v", ecSignPath, err)
+	}
+	if err := verifySignatureEC(ctx, client, sig, message, ecSignPath); err != nil {
+		t.Errorf("verifySignatureEC: %v", err)
+	}
+}
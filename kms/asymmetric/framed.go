@@ -0,0 +1,92 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// framedMessage returns the bytes signed for a header and body:
+//
+//	uint32 length of header, big-endian || header || body
+//
+// The length prefix makes the split between header and body unambiguous, so
+// bytes cannot be moved from one to the other without breaking the
+// signature.
+func framedMessage(header, body []byte) ([]byte, error) {
+	if uint64(len(header)) > math.MaxUint32 {
+		return nil, fmt.Errorf("header is %d bytes; the maximum is %d", len(header), uint32(math.MaxUint32))
+	}
+	message := make([]byte, 4, 4+len(header)+len(body))
+	binary.BigEndian.PutUint32(message, uint32(len(header)))
+	message = append(message, header...)
+	return append(message, body...), nil
+}
+
+// signFramed signs header and body, framed by framedMessage, with the key at
+// keyPath.
+func signFramed(ctx context.Context, client *cloudkms.Service, header, body []byte, keyPath string, opts ...Option) (string, error) {
+	message, err := framedMessage(header, body)
+	if err != nil {
+		return "", err
+	}
+	return signAsymmetric(ctx, client, string(message), keyPath, opts...)
+}
+
+// verifyFramed verifies a signature made by signFramed, or by any signer
+// using the framing of framedMessage, over header and body.
+func verifyFramed(ctx context.Context, client *cloudkms.Service, signature string, header, body []byte, keyPath string, opts ...Option) error {
+	message, err := framedMessage(header, body)
+	if err != nil {
+		return err
+	}
+	return verifySignature(ctx, client, signature, message, keyPath, opts...)
+}
+
+// typedMessage returns the bytes signed for a body of type contentType:
+//
+//	contentType || ":" || body
+//
+// contentType, such as "application/json", must be non-empty and contain no
+// colon, so that the first colon always ends it and a body of one type
+// cannot pass as a body of another. It is used exactly as given; agree on
+// one spelling, such as the lowercase form without parameters, with the
+// signer.
+func typedMessage(contentType string, body []byte) ([]byte, error) {
+	if contentType == "" || strings.Contains(contentType, ":") {
+		return nil, fmt.Errorf("invalid content type %q: it must be non-empty and contain no colon", contentType)
+	}
+	message := make([]byte, 0, len(contentType)+1+len(body))
+	message = append(message, contentType...)
+	message = append(message, ':')
+	return append(message, body...), nil
+}
+
+// signTyped signs body as content of type contentType, framed by
+// typedMessage, with the key at keyPath.
+func signTyped(ctx context.Context, client *cloudkms.Service, contentType string, body []byte, keyPath string, opts ...Option) (string, error) {
+	message, err := typedMessage(contentType, body)
+	if err != nil {
+		return "", err
+	}
+	return signAsymmetric(ctx, client, string(message), keyPath, opts...)
+}
+
+// verifyTyped verifies a signature made by signTyped, or by any signer using
+// the framing of typedMessage, over body as content of type contentType. A
+// signature over the same body with another content type does not verify.
+func verifyTyped(ctx context.Context, client *cloudkms.Service, signature, contentType string, body []byte, keyPath string, opts ...Option) error {
+	message, err := typedMessage(contentType, body)
+	if err != nil {
+		return err
+	}
+	return verifySignature(ctx, client, signature, message, keyPath, opts...)
+}
@@ -0,0 +1,62 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestVerifyFramed(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("rsa-sign")
+	f.addKey(t, keyPath, "RSA_SIGN_PSS_2048_SHA256")
+
+	signature, err := signFramed(ctx, client, []byte("head"), []byte("body"), keyPath)
+	if err != nil {
+		t.Fatalf("signFramed: %v", err)
+	}
+	if err := verifyFramed(ctx, client, signature, []byte("head"), []byte("body"), keyPath); err != nil {
+		t.Errorf("verifyFramed: %v", err)
+	}
+	// The same bytes split differently must not verify.
+	if err := verifyFramed(ctx, client, signature, []byte("hea"), []byte("dbody"), keyPath); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("verifyFramed with moved boundary: got %v, want ErrSignatureInvalid", err)
+	}
+}
+
+func TestVerifyTyped(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("ec-sign")
+	f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+	body := []byte(`{"amount":100}`)
+
+	signature, err := signTyped(ctx, client, "application/json", body, keyPath)
+	if err != nil {
+		t.Fatalf("signTyped: %v", err)
+	}
+	if err := verifyTyped(ctx, client, signature, "application/json", body, keyPath); err != nil {
+		t.Errorf("verifyTyped: %v", err)
+	}
+	// The signing input is the documented framing.
+	if err := verifySignature(ctx, client, signature, []byte(`application/json:{"amount":100}`), keyPath); err != nil {
+		t.Errorf("verifySignature over the framed input: %v", err)
+	}
+	if err := verifyTyped(ctx, client, signature, "text/plain", body, keyPath); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("verifyTyped with another content type: got %v, want ErrSignatureInvalid", err)
+	}
+	for _, contentType := range []string{"", "application/json:x"} {
+		if _, err := signTyped(ctx, client, contentType, body, keyPath); err == nil {
+			t.Errorf("signTyped with content type %q succeeded", contentType)
+		}
+		if err := verifyTyped(ctx, client, signature, contentType, body, keyPath); err == nil {
+			t.Errorf("verifyTyped with content type %q succeeded", contentType)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// WithVerifyGraceVersions makes verifySignature, when the signature does not
+// match the requested key version, try up to n of the key's most recent
+// earlier versions that are still enabled, newest first. This accepts
+// signatures made just before a rotation. If report is not nil, it is called
+// with the name of the version that verified the signature, whichever it
+// was, so that traffic still signed by old versions can be monitored.
+// It has no effect when the public key is supplied directly.
+func WithVerifyGraceVersions(n int, report func(keyPath string)) Option {
+	return func(o *options) {
+		o.graceVersions = n
+		o.onVerifiedVersion = report
+	}
+}
+
+// verifyWithGrace implements WithVerifyGraceVersions for verifySignature.
+func verifyWithGrace(ctx context.Context, client *cloudkms.Service, signature string, message []byte, keyPath string, o *options) error {
+	inner := *o
+	inner.graceVersions = 0
+	err := verifySignature(ctx, client, signature, message, keyPath, inner.option())
+	if err == nil {
+		o.reportVerifiedVersion(keyPath)
+		return nil
+	}
+	if !errors.Is(err, ErrSignatureInvalid) {
+		return err
+	}
+	previous, listErr := previousVersions(ctx, client, keyPath, o.graceVersions, o)
+	if listErr != nil {
+		return fmt.Errorf("%w (previous versions not tried: %v)", err, listErr)
+	}
+	for _, version := range previous {
+		versionErr := verifySignature(ctx, client, signature, message, version, inner.option())
+		if versionErr == nil {
+			o.reportVerifiedVersion(version)
+			return nil
+		}
+		if !errors.Is(versionErr, ErrSignatureInvalid) {
+			return versionErr
+		}
+	}
+	return err
+}
+
+func (o *options) reportVerifiedVersion(keyPath string) {
+	if o.onVerifiedVersion != nil {
+		o.onVerifiedVersion(keyPath)
+	}
+}
+
+// previousVersions returns the names of up to n enabled versions of keyPath's
+// key that are older than keyPath, newest first.
+func previousVersions(ctx context.Context, client *cloudkms.Service, keyPath string, n int, o *options) ([]string, error) {
+	current, err := strconv.Atoi(path.Base(keyPath))
+	if err != nil {
+		return nil, fmt.Errorf("%w: version of %s is not a number", ErrInvalidKeyPath, keyPath)
+	}
+	type numbered struct {
+		name   string
+		number int
+	}
+	var older []numbered
+	call := client.Projects.Locations.KeyRings.CryptoKeys.CryptoKeyVersions.
+		List(parentKeyPath(keyPath)).Filter("state=ENABLED")
+	for pageToken := ""; ; {
+		var versions *cloudkms.ListCryptoKeyVersionsResponse
+		err := o.kmsCall(ctx, "ListCryptoKeyVersions", parentKeyPath(keyPath), call.Header(), func() (http.Header, error) {
+			var err error
+			versions, err = call.PageToken(pageToken).Context(ctx).Do()
+			if err != nil {
+				return nil, err
+			}
+			return versions.Header, nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list versions of %s: %w", parentKeyPath(keyPath), err)
+		}
+		for _, version := range versions.CryptoKeyVersions {
+			number, err := strconv.Atoi(path.Base(version.Name))
+			if err != nil || version.State != "ENABLED" || number >= current {
+				continue
+			}
+			older = append(older, numbered{version.Name, number})
+		}
+		if pageToken = versions.NextPageToken; pageToken == "" {
+			break
+		}
+	}
+	sort.Slice(older, func(i, j int) bool { return older[i].number > older[j].number })
+	if len(older) > n {
+		older = older[:n]
+	}
+	names := make([]string, len(older))
+	for i, version := range older {
+		names[i] = version.name
+	}
+	return names, nil
+}
@@ -0,0 +1,61 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestVerifyGraceVersions(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyName := parentKeyPath(testKeyPath("ec-sign"))
+	for _, version := range []string{"1", "2", "3"} {
+		keyPath := keyName + "/cryptoKeyVersions/" + version
+		f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+		// Give each version its own key, as rotation would.
+		private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.mu.Lock()
+		f.keys[keyPath].private = private
+		f.mu.Unlock()
+	}
+	oldest, current := keyName+"/cryptoKeyVersions/1", keyName+"/cryptoKeyVersions/3"
+	signature, err := signAsymmetric(ctx, client, "message", oldest)
+	if err != nil {
+		t.Fatalf("signAsymmetric: %v", err)
+	}
+	message := []byte("message")
+
+	if err := verifySignature(ctx, client, signature, message, current); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("without grace versions: got %v, want ErrSignatureInvalid", err)
+	}
+	if err := verifySignature(ctx, client, signature, message, current, WithVerifyGraceVersions(1, nil)); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("with 1 grace version: got %v, want ErrSignatureInvalid", err)
+	}
+	var verified string
+	report := func(keyPath string) { verified = keyPath }
+	if err := verifySignature(ctx, client, signature, message, current, WithVerifyGraceVersions(2, report)); err != nil {
+		t.Errorf("with 2 grace versions: %v", err)
+	}
+	if verified != oldest {
+		t.Errorf("reported version %q, want %q", verified, oldest)
+	}
+
+	f.mu.Lock()
+	f.keys[oldest].version.State = "DISABLED"
+	f.mu.Unlock()
+	if err := verifySignature(ctx, client, signature, message, current, WithVerifyGraceVersions(2, nil)); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("with the signing version disabled: got %v, want ErrSignatureInvalid", err)
+	}
+}
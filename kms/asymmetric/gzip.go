@@ -0,0 +1,52 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// defaultMaxDecompressedSize is the largest payload verifyGzipped inflates
+// unless WithMaxDecompressedSize is given.
+const defaultMaxDecompressedSize = 64 << 20
+
+// WithMaxDecompressedSize makes verifyGzipped reject payloads that inflate to
+// more than n bytes. The default is 64 MiB.
+func WithMaxDecompressedSize(n int64) Option {
+	return func(o *options) { o.maxDecompressedSize = n }
+}
+
+// verifyGzipped verifies a signature over the uncompressed contents of
+// gzData, a gzip stream. The signer must have signed the bytes before
+// compression. Decompression stops, with an error wrapping
+// ErrPayloadTooLarge, as soon as the output exceeds the limit set with
+// WithMaxDecompressedSize, so a small malicious stream cannot exhaust
+// memory.
+func verifyGzipped(ctx context.Context, client *cloudkms.Service, signature string, gzData []byte, keyPath string, opts ...Option) error {
+	limit := newOptions(opts).maxDecompressedSize
+	if limit <= 0 {
+		limit = defaultMaxDecompressedSize
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(gzData))
+	if err != nil {
+		return fmt.Errorf("failed to read gzip header: %w", err)
+	}
+	defer zr.Close()
+	// Read one byte past the limit to tell an exact fit from an overflow.
+	message, err := io.ReadAll(io.LimitReader(zr, limit+1))
+	if err != nil {
+		return fmt.Errorf("failed to decompress payload: %w", err)
+	}
+	if int64(len(message)) > limit {
+		return fmt.Errorf("%w: payload inflates to more than %d bytes", ErrPayloadTooLarge, limit)
+	}
+	return verifySignature(ctx, client, signature, message, keyPath, opts...)
+}
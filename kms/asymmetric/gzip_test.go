@@ -0,0 +1,56 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestVerifyGzipped(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("ec-sign")
+	f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+	payload := bytes.Repeat([]byte("payload "), 1000)
+	signature, err := signAsymmetric(ctx, client, string(payload), keyPath)
+	if err != nil {
+		t.Fatalf("signAsymmetric: %v", err)
+	}
+	gzData := gzipBytes(t, payload)
+
+	if err := verifyGzipped(ctx, client, signature, gzData, keyPath); err != nil {
+		t.Errorf("verifyGzipped: %v", err)
+	}
+	if err := verifyGzipped(ctx, client, signature, gzData, keyPath, WithMaxDecompressedSize(int64(len(payload)))); err != nil {
+		t.Errorf("verifyGzipped at exactly the limit: %v", err)
+	}
+	if err := verifyGzipped(ctx, client, signature, gzData, keyPath, WithMaxDecompressedSize(int64(len(payload)-1))); !errors.Is(err, ErrPayloadTooLarge) {
+		t.Errorf("verifyGzipped over the limit: got %v, want ErrPayloadTooLarge", err)
+	}
+	if err := verifyGzipped(ctx, client, signature, gzipBytes(t, []byte("other")), keyPath); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("verifyGzipped of another payload: got %v, want ErrSignatureInvalid", err)
+	}
+	if err := verifyGzipped(ctx, client, signature, payload, keyPath); err == nil {
+		t.Error("verifyGzipped accepted data that is not gzip")
+	}
+}
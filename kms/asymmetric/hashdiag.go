@@ -0,0 +1,56 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+)
+
+// diagnosticHashes are the digests KMS signs, which WithHashDiagnostics
+// tries.
+var diagnosticHashes = []crypto.Hash{crypto.SHA256, crypto.SHA384, crypto.SHA512}
+
+// WithHashDiagnostics makes verifySignature, verifySignatureRSA,
+// verifySignatureEC, verifyWithHint, verifyStrict and the functions returned
+// by newVerifier, when a signature does not match, check it again with each
+// other digest KMS signs: SHA-256, SHA-384 and SHA-512. If one matches, the
+// error, which still wraps ErrSignatureInvalid, also wraps ErrDigestMismatch
+// and says which, as in "signature appears to use SHA-512, not the expected
+// SHA-256". The checks are local, with no further KMS requests, but cost up
+// to two more verifications for each bad signature, so enable it to debug a
+// mismatch rather than on a path that rejects forgeries at volume.
+func WithHashDiagnostics() Option {
+	return func(o *options) { o.hashDiagnostics = true }
+}
+
+// diagnoseHash implements WithHashDiagnostics. err is the error of verifying
+// the raw signature over message with publicKey, a key of the KMS algorithm
+// named alg; diagnoseHash returns it, with the digest the signature appears
+// to use if it is a mismatch that another digest explains.
+func (o *options) diagnoseHash(err error, publicKey crypto.PublicKey, alg string, message, signature []byte) error {
+	if !o.hashDiagnostics || !errors.Is(err, ErrSignatureInvalid) {
+		return err
+	}
+	info, ok := lookupAlgorithm(alg)
+	if !ok {
+		return err
+	}
+	message = o.normalizeMessage(message)
+	for _, hash := range diagnosticHashes {
+		if hash == info.Hash {
+			continue
+		}
+		other := info
+		other.Hash = hash
+		digest := hash.New()
+		digest.Write(message)
+		if verifyDigest(publicKey, other, digest.Sum(nil), signature) == nil {
+			return fmt.Errorf("%w: %w: signature appears to use %v, not the expected %v", ErrSignatureInvalid, ErrDigestMismatch, hash, info.Hash)
+		}
+	}
+	return err
+}
@@ -0,0 +1,67 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestWithHashDiagnostics(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	ecPath := testKeyPath("diag-ec")
+	rsaPath := testKeyPath("diag-rsa")
+	f.addKey(t, ecPath, "EC_SIGN_P256_SHA256")
+	f.addKey(t, rsaPath, "RSA_SIGN_PSS_2048_SHA256")
+	const message = "message"
+	digest := sha512.Sum512([]byte(message))
+
+	ecKey := testPrivateKey(t, "EC_SIGN_P256_SHA256").(*ecdsa.PrivateKey)
+	ecSig, err := ecdsa.SignASN1(rand.Reader, ecKey, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey := testPrivateKey(t, "RSA_SIGN_PSS_2048_SHA256").(*rsa.PrivateKey)
+	rsaSig, err := rsa.SignPSS(rand.Reader, rsaKey, crypto.SHA512, digest[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for keyPath, raw := range map[string][]byte{ecPath: ecSig, rsaPath: rsaSig} {
+		signature := base64.StdEncoding.EncodeToString(raw)
+		err := verifySignature(ctx, client, signature, []byte(message), keyPath, WithHashDiagnostics())
+		if !errors.Is(err, ErrSignatureInvalid) || !errors.Is(err, ErrDigestMismatch) {
+			t.Errorf("%s: got %v, want ErrSignatureInvalid and ErrDigestMismatch", keyPath, err)
+		} else if !strings.Contains(err.Error(), "signature appears to use SHA-512, not the expected SHA-256") {
+			t.Errorf("%s: error %q does not name the digests", keyPath, err)
+		}
+		verify, err := newVerifier(ctx, client, keyPath, WithHashDiagnostics())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := verify(signature, message); !errors.Is(err, ErrDigestMismatch) {
+			t.Errorf("%s: verifier got %v, want ErrDigestMismatch", keyPath, err)
+		}
+
+		// Without the option, or for a signature over another message, only
+		// ErrSignatureInvalid is reported.
+		if err := verifySignature(ctx, client, signature, []byte(message), keyPath); !errors.Is(err, ErrSignatureInvalid) || errors.Is(err, ErrDigestMismatch) {
+			t.Errorf("%s without diagnostics: got %v, want only ErrSignatureInvalid", keyPath, err)
+		}
+		if err := verifySignature(ctx, client, signature, []byte("other"), keyPath, WithHashDiagnostics()); !errors.Is(err, ErrSignatureInvalid) || errors.Is(err, ErrDigestMismatch) {
+			t.Errorf("%s over another message: got %v, want only ErrSignatureInvalid", keyPath, err)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+
+	"google.golang.org/api/googleapi"
+)
+
+// requestIDHeader is the response header carrying the ID that Google
+// support uses to find a request in its logs.
+const requestIDHeader = "X-Goog-Request-Id"
+
+// WithQuotaProject bills quota and charges for the call to project, which
+// may differ from the project that owns the key. The caller needs the
+// serviceusage.services.use permission on project.
+func WithQuotaProject(project string) Option {
+	return WithHeader("X-Goog-User-Project", project)
+}
+
+// WithHeader adds an HTTP header to every KMS request made by the call.
+func WithHeader(key, value string) Option {
+	return func(o *options) {
+		if o.headers == nil {
+			o.headers = make(http.Header)
+		}
+		o.headers.Add(key, value)
+	}
+}
+
+// setHeaders copies the headers from WithHeader and WithQuotaProject to the
+// headers of an API call, replacing any set before, so that the call can be
+// sent again for the next page of a list.
+func (o *options) setHeaders(h http.Header) {
+	for key, values := range o.headers {
+		h.Del(key)
+		for _, v := range values {
+			h.Add(key, v)
+		}
+	}
+}
+
+// WithResponseHeaders calls f with the HTTP header of every KMS response
+// received by the call, including error responses, for example to log the
+// request ID with requestID. Functions that make concurrent requests may
+// call f concurrently.
+func WithResponseHeaders(f func(header http.Header)) Option {
+	return func(o *options) { o.onResponseHeader = f }
+}
+
+// requestID returns the request ID in the header of a KMS response, or ""
+// if there is none. Quote it when filing a support case about the request.
+func requestID(header http.Header) string {
+	return header.Get(requestIDHeader)
+}
+
+// captureHeader passes header, or the header of err if err is the error
+// response of a KMS call, to the function given to WithResponseHeaders, and
+// its QuotaStatus to the one given to WithQuotaObserver and the tracker given
+// to WithQuotaTracker. Errors that only
+// wrap an API error are ignored, since the call that returned it has already
+// reported its header.
+func (o *options) captureHeader(header http.Header, err error) {
+	if o.onResponseHeader == nil && o.onQuota == nil && o.quotaTracker == nil {
+		return
+	}
+	apiErr, isAPIErr := err.(*googleapi.Error)
+	if isAPIErr {
+		header = apiErr.Header
+	}
+	if header != nil && o.onResponseHeader != nil {
+		o.onResponseHeader(header)
+	}
+	if header == nil && !isAPIErr {
+		return
+	}
+	status := parseQuotaStatus(header, apiErr)
+	if o.onQuota != nil {
+		o.onQuota(status)
+	}
+	if o.quotaTracker != nil {
+		o.quotaTracker.Observe(status)
+	}
+}
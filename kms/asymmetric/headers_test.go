@@ -0,0 +1,59 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestWithQuotaProject(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("ec")
+	f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+
+	if _, err := signAsymmetric(ctx, client, "message", keyPath, WithQuotaProject("billing"), WithHeader("X-Test", "1")); err != nil {
+		t.Fatalf("signAsymmetric: %v", err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if got := f.lastHeader.Get("X-Goog-User-Project"); got != "billing" {
+		t.Errorf("X-Goog-User-Project = %q, want %q", got, "billing")
+	}
+	if got := f.lastHeader.Get("X-Test"); got != "1" {
+		t.Errorf("X-Test = %q, want %q", got, "1")
+	}
+}
+
+func TestWithResponseHeaders(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("ec")
+	f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+
+	var ids []string
+	capture := WithResponseHeaders(func(h http.Header) { ids = append(ids, requestID(h)) })
+	if _, err := signAsymmetric(ctx, client, "message", keyPath, capture); err != nil {
+		t.Fatalf("signAsymmetric: %v", err)
+	}
+	if want := []string{"fake-1", "fake-2"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("request IDs = %q, want %q", ids, want)
+	}
+
+	ids = nil
+	f.mu.Lock()
+	f.failures = []int{http.StatusServiceUnavailable}
+	f.mu.Unlock()
+	if _, err := getAsymmetricPublicKey(ctx, client, keyPath, capture); err == nil {
+		t.Fatal("getAsymmetricPublicKey succeeded despite an injected failure")
+	}
+	if want := []string{"fake-3"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("request IDs of failed call = %q, want %q", ids, want)
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// KeyHealth is the result of probeKey.
+type KeyHealth struct {
+	KeyPath string
+	// OK is true if the public key was fetched and parsed.
+	OK bool
+	// Algorithm is the key's algorithm, if it was fetched.
+	Algorithm string
+	// Latency is the time the probe took, including any failure.
+	Latency time.Duration
+	// Err is the reason the probe failed, if it did.
+	Err error
+}
+
+// probeKey checks that the asymmetric key version at keyPath is reachable
+// and that the caller may read it, by fetching its public key. Nothing is
+// signed or decrypted, so probes do not add to the key's usage audit log.
+// The result is returned rather than an error so that it can be reported
+// by a health check as is.
+func probeKey(ctx context.Context, client *cloudkms.Service, keyPath string, opts ...Option) KeyHealth {
+	health := KeyHealth{KeyPath: keyPath}
+	start := time.Now()
+	response, _, err := fetchPublicKey(ctx, client, keyPath, opts...)
+	health.Latency = time.Since(start)
+	if err != nil {
+		health.Err = err
+		return health
+	}
+	health.OK = true
+	health.Algorithm = response.Algorithm
+	return health
+}
+
+// validateHierarchy checks that the key version at keyPath exists and that
+// the caller may read it. If it cannot be read, it finds the first of the
+// location, key ring, key and key version that is missing or inaccessible
+// and returns an error naming it, such as "key ring
+// projects/p/locations/global/keyRings/ring does not exist", in place of the
+// bare 404 or 403 of the version. The KMS error stays in the chain, for
+// apiError. When everything exists, only one request is made.
+func validateHierarchy(ctx context.Context, client *cloudkms.Service, keyPath string, opts ...Option) error {
+	if err := validateKeyPath(keyPath); err != nil {
+		return err
+	}
+	_, versionErr := getKeyVersion(ctx, client, keyPath, opts...)
+	if versionErr == nil {
+		return nil
+	}
+	if !isMissingOrForbidden(versionErr) {
+		return versionErr
+	}
+
+	o := newOptions(opts)
+	keyName := parentKeyPath(keyPath)
+	keyRingName := keyName[:strings.LastIndex(keyName, "/cryptoKeys/")]
+	locationName := keyRingName[:strings.LastIndex(keyRingName, "/keyRings/")]
+	projectName := locationName[:strings.LastIndex(locationName, "/locations/")]
+	levels := []struct {
+		kind, name string
+		get        func() error
+	}{
+		{"location", locationName, func() error {
+			call := client.Projects.Locations.Get(locationName)
+			return o.kmsCall(ctx, "GetLocation", locationName, call.Header(), func() (http.Header, error) {
+				response, err := call.Context(ctx).Do()
+				if err != nil {
+					return nil, err
+				}
+				return response.Header, nil
+			})
+		}},
+		{"key ring", keyRingName, func() error {
+			call := client.Projects.Locations.KeyRings.Get(keyRingName)
+			return o.kmsCall(ctx, "GetKeyRing", keyRingName, call.Header(), func() (http.Header, error) {
+				response, err := call.Context(ctx).Do()
+				if err != nil {
+					return nil, err
+				}
+				return response.Header, nil
+			})
+		}},
+		{"key", keyName, func() error {
+			call := client.Projects.Locations.KeyRings.CryptoKeys.Get(keyName)
+			return o.kmsCall(ctx, "GetCryptoKey", keyName, call.Header(), func() (http.Header, error) {
+				response, err := call.Context(ctx).Do()
+				if err != nil {
+					return nil, err
+				}
+				return response.Header, nil
+			})
+		}},
+	}
+	for _, level := range levels {
+		err := level.get()
+		if err == nil {
+			continue
+		}
+		if !isMissingOrForbidden(err) {
+			return fmt.Errorf("failed to check %s %s: %w", level.kind, level.name, err)
+		}
+		if level.kind == "location" {
+			// KMS does not reveal whether a project it cannot read exists.
+			return hierarchyError(err, fmt.Sprintf("location %s, or project %s,", locationName, projectName))
+		}
+		return hierarchyError(err, level.kind+" "+level.name)
+	}
+	return hierarchyError(versionErr, "key version "+keyPath)
+}
+
+// isMissingOrForbidden reports whether err is a KMS 404 or 403 error.
+func isMissingOrForbidden(err error) bool {
+	apiErr, ok := apiError(err)
+	return ok && (apiErr.Code == http.StatusNotFound || apiErr.Code == http.StatusForbidden)
+}
+
+// hierarchyError describes a 404 or 403 err for the resource described by
+// what.
+func hierarchyError(err error, what string) error {
+	if apiErr, ok := apiError(err); ok && apiErr.Code == http.StatusForbidden {
+		return fmt.Errorf("permission denied on %s, or it does not exist: %w", what, err)
+	}
+	return fmt.Errorf("%s does not exist: %w", what, err)
+}
@@ -0,0 +1,76 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestProbeKey(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("ec-sign")
+	f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+
+	health := probeKey(ctx, client, keyPath)
+	if !health.OK || health.Err != nil || health.Algorithm != "EC_SIGN_P256_SHA256" || health.Latency <= 0 {
+		t.Errorf("probeKey(%s) = %+v, want a healthy EC_SIGN_P256_SHA256 key", keyPath, health)
+	}
+	health = probeKey(ctx, client, testKeyPath("missing"))
+	if health.OK || health.Err == nil {
+		t.Errorf("probeKey for a missing key = %+v, want a failure", health)
+	}
+}
+
+func TestValidateHierarchy(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("ec-sign")
+	f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+
+	if err := validateHierarchy(ctx, client, keyPath); err != nil {
+		t.Fatalf("validateHierarchy(%s): %v", keyPath, err)
+	}
+
+	keyName := parentKeyPath(keyPath)
+	ring := keyName[:strings.LastIndex(keyName, "/cryptoKeys/")]
+	location := ring[:strings.LastIndex(ring, "/keyRings/")]
+	otherLocation := strings.Replace(location, "/locations/"+keyLocation(location), "/locations/nowhere", 1)
+	tests := []struct {
+		keyPath string
+		want    string
+	}{
+		{keyName + "/cryptoKeyVersions/9", "key version " + keyName + "/cryptoKeyVersions/9 does not exist"},
+		{ring + "/cryptoKeys/missing/cryptoKeyVersions/1", "key " + ring + "/cryptoKeys/missing does not exist"},
+		{location + "/keyRings/missing/cryptoKeys/k/cryptoKeyVersions/1", "key ring " + location + "/keyRings/missing does not exist"},
+		{otherLocation + "/keyRings/r/cryptoKeys/k/cryptoKeyVersions/1", "location " + otherLocation + ", or project"},
+	}
+	for _, test := range tests {
+		err := validateHierarchy(ctx, client, test.keyPath)
+		if err == nil || !strings.HasPrefix(err.Error(), test.want) {
+			t.Errorf("validateHierarchy(%s) = %v, want an error starting %q", test.keyPath, err, test.want)
+		}
+		if apiErr, ok := apiError(err); !ok || apiErr.Code != http.StatusNotFound {
+			t.Errorf("validateHierarchy(%s) = %v, want the KMS 404 in the chain", test.keyPath, err)
+		}
+	}
+
+	f.mu.Lock()
+	f.failures = []int{http.StatusForbidden, http.StatusForbidden}
+	f.mu.Unlock()
+	err := validateHierarchy(ctx, client, keyPath)
+	if err == nil || !strings.HasPrefix(err.Error(), "permission denied on location "+location) {
+		t.Errorf("validateHierarchy with permission denied = %v, want it to name the location", err)
+	}
+
+	if err := validateHierarchy(ctx, client, "not-a-key-path"); !errors.Is(err, ErrInvalidKeyPath) {
+		t.Errorf("validateHierarchy(not-a-key-path) = %v, want ErrInvalidKeyPath", err)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"fmt"
+)
+
+// verifyWithHint checks signature over message with the PEM-encoded public
+// key pemStr, entirely offline. alg is the key's KMS algorithm, such as
+// "RSA_SIGN_PKCS1_2048_SHA256", kept with the PEM in configuration; it
+// selects the padding and hash, so nothing is asked of KMS. It fails with
+// ErrKeyTypeMismatch if the key is not of the type and size alg implies.
+func verifyWithHint(pemStr, signature, message string, alg string, opts ...Option) error {
+	info, ok := lookupAlgorithm(alg)
+	if !ok || info.Purpose != "ASYMMETRIC_SIGN" || info.Hash == 0 {
+		return fmt.Errorf("%w: %q is not a KMS algorithm that signs digests", ErrKeyTypeMismatch, alg)
+	}
+	o := newOptions(opts)
+	if err := o.checkMessageLength([]byte(message)); err != nil {
+		return err
+	}
+	if err := o.checkAllowedAlgorithm(alg, "public key"); err != nil {
+		return err
+	}
+	publicKey, err := parsePublicKeyPEM(pemStr)
+	if err != nil {
+		return err
+	}
+	if err := checkKeyAlgorithm(publicKey, info); err != nil {
+		return err
+	}
+	return o.verifyWithAlgorithm(publicKey, info, signature, []byte(message), "public key")
+}
+
+// verifyWithAlgorithm checks signature over message with publicKey, hashing
+// and padding as alg requires, after applying the key checks of
+// WithMinRSABits and WithExpectedKeyFingerprint. keyName identifies the key
+// in errors.
+func (o *options) verifyWithAlgorithm(publicKey crypto.PublicKey, alg AlgorithmInfo, signature string, message []byte, keyName string) error {
+	if err := o.checkKeyStrength(publicKey, keyName); err != nil {
+		return err
+	}
+	if err := o.checkKeyPin(publicKey, keyName); err != nil {
+		return err
+	}
+	decoded, err := o.decodeSignature(signature)
+	if err != nil {
+		return err
+	}
+	digest := alg.Hash.New()
+	digest.Write(o.normalizeMessage(message))
+	if err := verifyDigest(publicKey, alg, digest.Sum(nil), decoded); err != nil {
+		return o.diagnoseHash(err, publicKey, alg.Name, message, decoded)
+	}
+	o.reportAlgorithm(func() string { return alg.Name })
+	return nil
+}
+
+// checkKeyAlgorithm returns ErrKeyTypeMismatch unless publicKey is a key of
+// the type and size used by alg.
+func checkKeyAlgorithm(publicKey crypto.PublicKey, alg AlgorithmInfo) error {
+	var keyType string
+	var size int
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		keyType, size = "RSA", key.N.BitLen()
+	case *ecdsa.PublicKey:
+		keyType, size = "EC", key.Curve.Params().BitSize
+	default:
+		return fmt.Errorf("%w: unsupported public key type %T", ErrKeyTypeMismatch, publicKey)
+	}
+	if keyType != alg.KeyType || size != alg.KeySize {
+		return fmt.Errorf("%w: %d-bit %s key cannot be used with %s", ErrKeyTypeMismatch, size, keyType, alg.Name)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestVerifyWithHint(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	for _, alg := range []string{"RSA_SIGN_PSS_2048_SHA256", "RSA_SIGN_PKCS1_2048_SHA256", "EC_SIGN_P384_SHA384"} {
+		keyPath := testKeyPath(alg)
+		f.addKey(t, keyPath, alg)
+		signature, err := signAsymmetric(ctx, client, "message", keyPath)
+		if err != nil {
+			t.Fatalf("%s: signAsymmetric: %v", alg, err)
+		}
+		response, _, err := fetchPublicKey(ctx, client, keyPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := verifyWithHint(response.Pem, signature, "message", alg); err != nil {
+			t.Errorf("%s: verifyWithHint: %v", alg, err)
+		}
+		if err := verifyWithHint(response.Pem, signature, "other", alg); !errors.Is(err, ErrSignatureInvalid) {
+			t.Errorf("%s: verifyWithHint with another message: got %v, want ErrSignatureInvalid", alg, err)
+		}
+	}
+
+	// A hint that does not fit the key is rejected before verifying.
+	response, _, err := fetchPublicKey(ctx, client, testKeyPath("EC_SIGN_P384_SHA384"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, alg := range []string{"EC_SIGN_P256_SHA256", "RSA_SIGN_PSS_2048_SHA256", "RSA_DECRYPT_OAEP_2048_SHA256", "UNKNOWN"} {
+		if err := verifyWithHint(response.Pem, "MEUC", "message", alg); !errors.Is(err, ErrKeyTypeMismatch) {
+			t.Errorf("P-384 key with hint %s: got %v, want ErrKeyTypeMismatch", alg, err)
+		}
+	}
+}
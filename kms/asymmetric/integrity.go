@@ -0,0 +1,36 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import "errors"
+
+// An IntegrityFailure describes a CRC32C check that failed, meaning a request
+// or response was corrupted between this process and KMS.
+type IntegrityFailure struct {
+	// Operation is the API method, such as "AsymmetricSign".
+	Operation string
+	// KeyPath is the key version the call was made with.
+	KeyPath string
+	// Response is true if the response was corrupted, and false if KMS
+	// reported that the request was.
+	Response bool
+}
+
+// WithIntegrityFailureHandler makes the sign, decrypt and MAC functions call
+// handle each time a CRC32C check fails, before returning the error. Use it
+// to count corruption events, for example by incrementing a metric.
+func WithIntegrityFailureHandler(handle func(IntegrityFailure)) Option {
+	return func(o *options) { o.onIntegrityFailure = handle }
+}
+
+// integrityFailure reports a failed CRC32C check to the handler, if any, and
+// returns the error for it. what describes the corrupted message, such as
+// "asymmetric sign request".
+func (o *options) integrityFailure(operation, keyPath, what string, response bool) error {
+	if o.onIntegrityFailure != nil {
+		o.onIntegrityFailure(IntegrityFailure{Operation: operation, KeyPath: keyPath, Response: response})
+	}
+	return errors.New(what + " corrupted in-transit")
+}
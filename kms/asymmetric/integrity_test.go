@@ -0,0 +1,50 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestIntegrityFailureHandler(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	signPath := testKeyPath("ec-sign")
+	decryptPath := testKeyPath("decrypt")
+	f.addKey(t, signPath, "EC_SIGN_P256_SHA256")
+	f.addKey(t, decryptPath, "RSA_DECRYPT_OAEP_2048_SHA256")
+	ciphertext, err := encryptRSA(ctx, client, "secret", decryptPath)
+	if err != nil {
+		t.Fatalf("encryptRSA: %v", err)
+	}
+	f.mu.Lock()
+	f.corrupt = true
+	f.mu.Unlock()
+
+	var failures []IntegrityFailure
+	handler := WithIntegrityFailureHandler(func(failure IntegrityFailure) {
+		failures = append(failures, failure)
+	})
+	if _, err := signAsymmetric(ctx, client, "message", signPath, handler); err == nil {
+		t.Error("signAsymmetric with corrupted response: got nil error")
+	}
+	if _, err := decryptRSA(ctx, client, ciphertext, decryptPath, handler); err == nil {
+		t.Error("decryptRSA with corrupted response: got nil error")
+	}
+	want := []IntegrityFailure{
+		{Operation: "AsymmetricSign", KeyPath: signPath, Response: true},
+		{Operation: "AsymmetricDecrypt", KeyPath: decryptPath, Response: true},
+	}
+	if len(failures) != len(want) {
+		t.Fatalf("got failures %+v, want %+v", failures, want)
+	}
+	for i := range want {
+		if failures[i] != want[i] {
+			t.Errorf("failure %d = %+v, want %+v", i, failures[i], want[i])
+		}
+	}
+}
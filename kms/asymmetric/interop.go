@@ -0,0 +1,117 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/elliptic"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+)
+
+// Signatures made outside KMS, for example by an on-premises HSM over a key
+// that was imported into KMS, can be checked with verifySignatureRSA and
+// verifySignatureEC as long as they use the same hash. The encodings
+// accepted are:
+//
+//	Key  KMS produces                  Also accepted
+//	RSA  PSS, salt length = hash size  any fixed salt length, or any salt
+//	                                   length, with WithPSSSaltLength
+//	EC   ASN.1 DER (r, s)              raw r||s (IEEE P1363, as used by
+//	                                   PKCS#11 and JOSE), detected from its
+//	                                   length
+//
+// RSA PKCS#1 v1.5 signatures are not accepted by verifySignatureRSA.
+//
+// How the ECDSA nonce was chosen does not matter: KMS uses random nonces,
+// while some tools derive them deterministically (RFC 6979), but both give
+// an ordinary (r, s) pair that verifies the same way.
+
+// WithPSSSaltLength makes verifySignatureRSA expect a PSS salt of n bytes
+// instead of the hash size that KMS uses. Pass rsa.PSSSaltLengthAuto to
+// accept any salt length; n may also be rsa.PSSSaltLengthEqualsHash.
+func WithPSSSaltLength(n int) Option {
+	return func(o *options) {
+		o.pssSaltLength = n
+		o.setPSSSaltLength = true
+	}
+}
+
+// saltLength returns the PSS salt length to verify with, for a hash of
+// hashSize bytes.
+func (o *options) saltLength(hashSize int) int {
+	if o.setPSSSaltLength {
+		return o.pssSaltLength
+	}
+	return hashSize
+}
+
+// parseECDSASignature returns r and s from an ECDSA signature over curve,
+// encoded either as ASN.1 DER or as raw fixed-width r||s.
+func parseECDSASignature(signature []byte, curve elliptic.Curve) (r, s *big.Int, err error) {
+	var parsedSig struct{ R, S *big.Int }
+	rest, derErr := asn1.Unmarshal(signature, &parsedSig)
+	if derErr == nil {
+		// Bytes after the DER value would otherwise be ignored, letting the
+		// same signature be presented in many forms.
+		if len(rest) != 0 {
+			return nil, nil, fmt.Errorf("%w: %d bytes after the DER signature", ErrTrailingSignatureData, len(rest))
+		}
+		return parsedSig.R, parsedSig.S, nil
+	}
+	size := (curve.Params().BitSize + 7) / 8
+	if len(signature) == 2*size {
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		return r, s, nil
+	}
+	return nil, nil, fmt.Errorf("%w: signature is neither ASN.1 DER nor %d-byte r||s: %w", ErrSignatureInvalid, 2*size, derErr)
+}
+
+// An ECDSA signature (r, s) is equally valid as (r, n-s), where n is the
+// order of the curve, so anyone can change a signature without knowing the
+// key. Systems that identify a signed message by its signature, such as
+// those that deduplicate transactions by hash, must therefore accept only
+// one of the two forms: by convention the "low-S" one, with s <= n/2. KMS
+// does not guarantee low-S signatures; use normalizeLowS before handing a
+// signature to such a system, and WithLowS to require the form on input.
+
+// WithLowS makes verifySignatureEC fail with ErrNonCanonicalS if the
+// signature's s value is greater than half the curve order.
+func WithLowS() Option {
+	return func(o *options) { o.requireLowS = true }
+}
+
+// checkLowS enforces WithLowS for s on curve.
+func (o *options) checkLowS(s *big.Int, curve elliptic.Curve) error {
+	if o.requireLowS && !isLowS(s, curve) {
+		return ErrNonCanonicalS
+	}
+	return nil
+}
+
+// isLowS reports whether s <= n/2 for the order n of curve.
+func isLowS(s *big.Int, curve elliptic.Curve) bool {
+	halfOrder := new(big.Int).Rsh(curve.Params().N, 1)
+	return s.Cmp(halfOrder) <= 0
+}
+
+// normalizeLowS returns signature, an ASN.1 DER or raw r||s ECDSA signature
+// over curve, in low-S form, as ASN.1 DER. The result verifies exactly as
+// the input does.
+func normalizeLowS(signature []byte, curve elliptic.Curve) ([]byte, error) {
+	r, s, err := parseECDSASignature(signature, curve)
+	if err != nil {
+		return nil, err
+	}
+	if !isLowS(s, curve) {
+		s = new(big.Int).Sub(curve.Params().N, s)
+	}
+	der, err := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode signature: %w", err)
+	}
+	return der, nil
+}
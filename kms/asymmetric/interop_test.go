@@ -0,0 +1,188 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// TestExternalSignatures checks signatures made locally, as an HSM holding
+// the same key would make them, against the public key served by KMS.
+func TestExternalSignatures(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	rsaPath := testKeyPath("rsa-sign")
+	ecPath := testKeyPath("ec-sign")
+	f.addKey(t, rsaPath, "RSA_SIGN_PSS_2048_SHA256")
+	f.addKey(t, ecPath, "EC_SIGN_P256_SHA256")
+	digest := sha256.Sum256([]byte("message"))
+
+	rsaKey := testPrivateKey(t, "RSA_SIGN_PSS_2048_SHA256").(*rsa.PrivateKey)
+	sig, err := rsa.SignPSS(rand.Reader, rsaKey, crypto.SHA256, digest[:], &rsa.PSSOptions{SaltLength: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := base64.StdEncoding.EncodeToString(sig)
+	if err := verifySignatureRSA(ctx, client, signature, "message", rsaPath); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("20-byte salt with default options: got %v, want ErrSignatureInvalid", err)
+	}
+	if err := verifySignatureRSA(ctx, client, signature, "message", rsaPath, WithPSSSaltLength(20)); err != nil {
+		t.Errorf("20-byte salt with WithPSSSaltLength(20): %v", err)
+	}
+	if err := verifySignatureRSA(ctx, client, signature, "message", rsaPath, WithPSSSaltLength(rsa.PSSSaltLengthAuto)); err != nil {
+		t.Errorf("20-byte salt with PSSSaltLengthAuto: %v", err)
+	}
+
+	ecKey := testPrivateKey(t, "EC_SIGN_P256_SHA256").(*ecdsa.PrivateKey)
+	r, s, err := ecdsa.Sign(rand.Reader, ecKey, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := make([]byte, 64)
+	r.FillBytes(raw[:32])
+	s.FillBytes(raw[32:])
+	if err := verifySignatureEC(ctx, client, base64.StdEncoding.EncodeToString(raw), "message", ecPath); err != nil {
+		t.Errorf("raw r||s signature: %v", err)
+	}
+	if err := verifySignatureEC(ctx, client, base64.StdEncoding.EncodeToString(raw[:63]), "message", ecPath); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("truncated r||s signature: got %v, want ErrSignatureInvalid", err)
+	}
+}
+
+// signPSSVector signs message locally with signer, never through KMS, using
+// an explicit PSS salt length, and returns the base64 signature. It builds
+// the test vectors that KMS cannot produce, since KMS always uses a salt as
+// long as the hash.
+func signPSSVector(t *testing.T, signer crypto.Signer, message []byte, saltLength int) string {
+	t.Helper()
+	digest := sha256.Sum256(message)
+	sig, err := signer.Sign(rand.Reader, digest[:], &rsa.PSSOptions{SaltLength: saltLength, Hash: crypto.SHA256})
+	if err != nil {
+		t.Fatalf("signing with %d-byte salt: %v", saltLength, err)
+	}
+	return base64.StdEncoding.EncodeToString(sig)
+}
+
+func TestPSSSaltLengthVectors(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("rsa-sign")
+	f.addKey(t, keyPath, "RSA_SIGN_PSS_2048_SHA256")
+	signer := testPrivateKey(t, "RSA_SIGN_PSS_2048_SHA256").(*rsa.PrivateKey)
+	// The largest salt for a 2048-bit key and SHA-256: 256 - 32 - 2 bytes.
+	maxSalt := 222
+	for _, saltLength := range []int{0, 20, 32, 64, maxSalt} {
+		signature := signPSSVector(t, signer, []byte("message"), saltLength)
+		if err := verifySignatureRSA(ctx, client, signature, "message", keyPath, WithPSSSaltLength(saltLength)); err != nil {
+			t.Errorf("%d-byte salt with WithPSSSaltLength(%d): %v", saltLength, saltLength, err)
+		}
+		if err := verifySignatureRSA(ctx, client, signature, "message", keyPath, WithPSSSaltLength(rsa.PSSSaltLengthAuto)); err != nil {
+			t.Errorf("%d-byte salt with PSSSaltLengthAuto: %v", saltLength, err)
+		}
+		err := verifySignatureRSA(ctx, client, signature, "message", keyPath)
+		if saltLength == sha256.Size && err != nil {
+			t.Errorf("%d-byte salt with default options: %v", saltLength, err)
+		}
+		if saltLength != sha256.Size && !errors.Is(err, ErrSignatureInvalid) {
+			t.Errorf("%d-byte salt with default options: got %v, want ErrSignatureInvalid", saltLength, err)
+		}
+	}
+}
+
+func TestTrailingSignatureData(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("ec-sign")
+	f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+	signature, err := signAsymmetricBytes(ctx, client, "message", keyPath)
+	if err != nil {
+		t.Fatalf("signAsymmetricBytes: %v", err)
+	}
+	if err := verifySignatureEC(ctx, client, encodeSignature(signature), "message", keyPath); err != nil {
+		t.Fatalf("verifySignatureEC: %v", err)
+	}
+	padded := append(signature[:len(signature):len(signature)], 0x00, 0xff)
+	if err := verifySignatureEC(ctx, client, encodeSignature(padded), "message", keyPath); !errors.Is(err, ErrTrailingSignatureData) {
+		t.Errorf("signature with trailing bytes: got %v, want ErrTrailingSignatureData", err)
+	}
+}
+
+func TestLowS(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("ec-sign")
+	f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+	ecKey := testPrivateKey(t, "EC_SIGN_P256_SHA256").(*ecdsa.PrivateKey)
+	n := ecKey.Curve.Params().N
+	digest := sha256.Sum256([]byte("message"))
+
+	r, s, err := ecdsa.Sign(rand.Reader, ecKey, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isLowS(s, ecKey.Curve) {
+		s = new(big.Int).Sub(n, s)
+	}
+	high, err := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+	if err != nil {
+		t.Fatal(err)
+	}
+	highSig := base64.StdEncoding.EncodeToString(high)
+	if err := verifySignatureEC(ctx, client, highSig, "message", keyPath); err != nil {
+		t.Errorf("high-S signature without WithLowS: %v", err)
+	}
+	if err := verifySignatureEC(ctx, client, highSig, "message", keyPath, WithLowS()); !errors.Is(err, ErrNonCanonicalS) {
+		t.Errorf("high-S signature with WithLowS: got %v, want ErrNonCanonicalS", err)
+	}
+
+	low, err := normalizeLowS(high, ecKey.Curve)
+	if err != nil {
+		t.Fatalf("normalizeLowS: %v", err)
+	}
+	if err := verifySignatureEC(ctx, client, base64.StdEncoding.EncodeToString(low), "message", keyPath, WithLowS()); err != nil {
+		t.Errorf("normalized signature with WithLowS: %v", err)
+	}
+}
+
+// TestRFC6979Signature verifies the deterministic ECDSA test vector of RFC
+// 6979, appendix A.2.5 (P-256, SHA-256, message "sample").
+func TestRFC6979Signature(t *testing.T) {
+	hexInt := func(s string) *big.Int {
+		n, ok := new(big.Int).SetString(s, 16)
+		if !ok {
+			t.Fatalf("bad hex %q", s)
+		}
+		return n
+	}
+	publicKey := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     hexInt("60FED4BA255A9D31C961EB74C6356D68C049B8923B61FA6CE669622E60F29FB6"),
+		Y:     hexInt("7903FE1008B8BC99A41AE9E95628BC64F2F1B20C2D7E9F5177A3C294D4462299"),
+	}
+	der, err := asn1.Marshal(struct{ R, S *big.Int }{
+		hexInt("EFD48B2AACB6A8FD1140DD9CD45E81D69D2C877B56AAF991C34D0EA84EAF3716"),
+		hexInt("F7CB1C942D657C41D436C7A1B6E29F65F3E900DBB9AFF4064DC4AB2F843ACDA8"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := base64.StdEncoding.EncodeToString(der)
+	// With the public key supplied, no KMS client is needed.
+	if err := verifySignatureEC(context.Background(), nil, signature, "sample", "", WithPublicKey(publicKey)); err != nil {
+		t.Errorf("verifySignatureEC with RFC 6979 signature: %v", err)
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// JOSE (JWS, JWT, JWK) differs from the KMS conventions used elsewhere in this
+// package in two ways: binary values are base64url-encoded without padding,
+// and ECDSA signatures are the fixed-size concatenation R||S rather than
+// ASN.1 DER.
+
+// joseHash returns the hash used by a JWS "alg" value.
+func joseHash(alg string) (crypto.Hash, error) {
+	switch alg {
+	case "RS256", "PS256", "ES256":
+		return crypto.SHA256, nil
+	case "RS384", "PS384", "ES384":
+		return crypto.SHA384, nil
+	case "RS512", "PS512", "ES512":
+		return crypto.SHA512, nil
+	default:
+		return 0, fmt.Errorf("unsupported JWS algorithm %q", alg)
+	}
+}
+
+// joseCurves maps each JWS ECDSA algorithm to its curve.
+var joseCurves = map[string]string{
+	"ES256": "P-256",
+	"ES384": "P-384",
+	"ES512": "P-521",
+}
+
+// verifyJOSESignature verifies a raw JWS signature over signingInput using the
+// JWS algorithm alg.
+func verifyJOSESignature(publicKey crypto.PublicKey, alg string, signingInput, signature []byte) error {
+	hash, err := joseHash(alg)
+	if err != nil {
+		return err
+	}
+	h := hash.New()
+	h.Write(signingInput)
+	digest := h.Sum(nil)
+
+	switch alg[0] {
+	case 'R', 'P':
+		rsaKey, ok := publicKey.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: %s needs *rsa.PublicKey, got %T", ErrKeyTypeMismatch, alg, publicKey)
+		}
+		if alg[0] == 'R' {
+			err = rsa.VerifyPKCS1v15(rsaKey, hash, digest, signature)
+		} else {
+			err = rsa.VerifyPSS(rsaKey, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrSignatureInvalid, err)
+		}
+		return nil
+	default:
+		ecKey, ok := publicKey.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: %s needs *ecdsa.PublicKey, got %T", ErrKeyTypeMismatch, alg, publicKey)
+		}
+		// Each ES algorithm is defined for one curve only (RFC 7518,
+		// section 3.4).
+		if curve := ecKey.Curve.Params().Name; curve != joseCurves[alg] {
+			return fmt.Errorf("%w: %s needs a %s key, got %s", ErrKeyTypeMismatch, alg, joseCurves[alg], curve)
+		}
+		size := (ecKey.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return fmt.Errorf("%w: signature is %d bytes; want %d", ErrSignatureInvalid, len(signature), 2*size)
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(ecKey, digest, r, s) {
+			return ErrSignatureInvalid
+		}
+		return nil
+	}
+}
+
+// decodeSegment decodes an unpadded base64url JOSE value.
+func decodeSegment(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
+
+// encodeSegment encodes b as an unpadded base64url JOSE value.
+func encodeSegment(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// joseAlgorithm returns the JWS "alg" value for a KMS signing algorithm.
+func joseAlgorithm(kmsAlgorithm string) (string, error) {
+	switch kmsAlgorithm {
+	case "RSA_SIGN_PKCS1_2048_SHA256", "RSA_SIGN_PKCS1_3072_SHA256", "RSA_SIGN_PKCS1_4096_SHA256":
+		return "RS256", nil
+	case "RSA_SIGN_PKCS1_4096_SHA512":
+		return "RS512", nil
+	case "RSA_SIGN_PSS_2048_SHA256", "RSA_SIGN_PSS_3072_SHA256", "RSA_SIGN_PSS_4096_SHA256":
+		return "PS256", nil
+	case "RSA_SIGN_PSS_4096_SHA512":
+		return "PS512", nil
+	case "EC_SIGN_P256_SHA256":
+		return "ES256", nil
+	case "EC_SIGN_P384_SHA384":
+		return "ES384", nil
+	default:
+		return "", fmt.Errorf("no JWS algorithm for %s", kmsAlgorithm)
+	}
+}
+
+// ecdsaDERToRaw converts an ASN.1 DER ECDSA signature, as produced by KMS, to
+// the JOSE R||S form for a curve of size bytes per coordinate.
+func ecdsaDERToRaw(der []byte, size int) ([]byte, error) {
+	var sig struct{ R, S *big.Int }
+	rest, err := asn1.Unmarshal(der, &sig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signature bytes: %w", err)
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("%w: %d bytes after the DER signature", ErrTrailingSignatureData, len(rest))
+	}
+	if sig.R.Sign() <= 0 || sig.S.Sign() <= 0 || sig.R.BitLen() > 8*size || sig.S.BitLen() > 8*size {
+		return nil, errors.New("signature values out of range for curve")
+	}
+	raw := make([]byte, 2*size)
+	sig.R.FillBytes(raw[:size])
+	sig.S.FillBytes(raw[size:])
+	return raw, nil
+}
+
+// signJWSDetached signs payload with the KMS key at keyPath and returns a
+// detached JWS (RFC 7515, appendix F): the compact serialization with the
+// payload segment left empty, "<header>..<signature>". The recipient, who
+// already has the payload, reinserts it as base64url to verify.
+func signJWSDetached(ctx context.Context, client *cloudkms.Service, payload []byte, keyPath string) (string, error) {
+	response, publicKey, err := fetchPublicKey(ctx, client, keyPath)
+	if err != nil {
+		return "", err
+	}
+	alg, err := joseAlgorithm(response.Algorithm)
+	if err != nil {
+		return "", err
+	}
+	header, err := json.Marshal(jwtHeader{Alg: alg, Kid: computeKID(keyPath, publicKey)})
+	if err != nil {
+		return "", err
+	}
+	encodedHeader := encodeSegment(header)
+	signingInput := encodedHeader + "." + encodeSegment(payload)
+	signature, err := signAsymmetricBytes(ctx, client, signingInput, keyPath)
+	if err != nil {
+		return "", err
+	}
+	if ecKey, ok := publicKey.(*ecdsa.PublicKey); ok {
+		size := (ecKey.Curve.Params().BitSize + 7) / 8
+		if signature, err = ecdsaDERToRaw(signature, size); err != nil {
+			return "", err
+		}
+	}
+	return encodedHeader + ".." + encodeSegment(signature), nil
+}
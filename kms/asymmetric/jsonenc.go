@@ -0,0 +1,52 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// encryptRSAJSON marshals v to JSON and encrypts it with the RSA key at
+// keyPath. RSA-OAEP can only encrypt a short plaintext; if the JSON is longer
+// than the key allows, it fails with ErrPlaintextTooLarge and nothing is
+// encrypted. Larger values need envelope encryption, as with
+// generateAndWrapDEK.
+func encryptRSAJSON(ctx context.Context, client *cloudkms.Service, v interface{}, keyPath string, opts ...Option) (string, error) {
+	plaintext, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal value: %w", err)
+	}
+	defer zeroize(plaintext)
+	alg, err := getKeyAlgorithm(ctx, client, keyPath, opts...)
+	if err != nil {
+		return "", err
+	}
+	if alg.Purpose != "ASYMMETRIC_DECRYPT" {
+		return "", fmt.Errorf("%w: %s is a %s key, not an encryption key", ErrKeyTypeMismatch, keyPath, alg.Name)
+	}
+	if len(plaintext) > alg.MaxMessageLen {
+		return "", fmt.Errorf("%w: JSON is %d bytes, but %s encrypts at most %d; use envelope encryption",
+			ErrPlaintextTooLarge, len(plaintext), alg.Name, alg.MaxMessageLen)
+	}
+	return encryptRSABytes(ctx, client, plaintext, keyPath, opts...)
+}
+
+// decryptRSAJSON decrypts a ciphertext made by encryptRSAJSON and unmarshals
+// the JSON into v, which must be a pointer.
+func decryptRSAJSON(ctx context.Context, client *cloudkms.Service, ciphertext, keyPath string, v interface{}, opts ...Option) error {
+	_, plaintext, err := decryptRSAFull(ctx, client, ciphertext, keyPath, opts...)
+	if err != nil {
+		return err
+	}
+	defer zeroize(plaintext)
+	if err := json.Unmarshal(plaintext, v); err != nil {
+		return fmt.Errorf("failed to unmarshal decrypted JSON: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestEncryptRSAJSON(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("rsa-decrypt")
+	f.addKey(t, keyPath, "RSA_DECRYPT_OAEP_2048_SHA256")
+
+	type credentials struct {
+		User     string `json:"user"`
+		Password string `json:"password"`
+	}
+	in := credentials{User: "admin", Password: "hunter2"}
+	ciphertext, err := encryptRSAJSON(ctx, client, in, keyPath)
+	if err != nil {
+		t.Fatalf("encryptRSAJSON: %v", err)
+	}
+	var out credentials
+	if err := decryptRSAJSON(ctx, client, ciphertext, keyPath, &out); err != nil {
+		t.Fatalf("decryptRSAJSON: %v", err)
+	}
+	if out != in {
+		t.Errorf("decryptRSAJSON = %+v, want %+v", out, in)
+	}
+
+	// A 2048-bit key with SHA-256 encrypts at most 190 bytes.
+	large := credentials{User: "admin", Password: strings.Repeat("x", 200)}
+	if _, err := encryptRSAJSON(ctx, client, large, keyPath); !errors.Is(err, ErrPlaintextTooLarge) {
+		t.Errorf("encryptRSAJSON of large value: got %v, want ErrPlaintextTooLarge", err)
+	}
+}
@@ -0,0 +1,252 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// jwk is a JSON Web Key (RFC 7517) holding an RSA or EC public key.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+
+	// RSA keys.
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// EC keys.
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// jwkSet is a JSON Web Key Set.
+type jwkSet struct {
+	Keys []jwk `json:"keys"`
+}
+
+// computeKID returns the key ID used in JWKS and JWS headers for a key
+// version. It is the unpadded base64url SHA-256 hash of the DER-encoded
+// SubjectPublicKeyInfo of publicKey, so verifiers can recompute it from the
+// public key alone. If publicKey is nil or cannot be encoded, the kid is
+// keyVersionName.
+func computeKID(keyVersionName string, publicKey crypto.PublicKey) string {
+	if publicKey == nil {
+		return keyVersionName
+	}
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return keyVersionName
+	}
+	sum := sha256.Sum256(der)
+	return encodeSegment(sum[:])
+}
+
+// newJWK returns the JWK for publicKey, for verifying signatures made with
+// JWS algorithm alg.
+func newJWK(publicKey crypto.PublicKey, kid, alg string) (jwk, error) {
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		return jwk{
+			Kty: "RSA",
+			Kid: kid,
+			Use: "sig",
+			Alg: alg,
+			N:   encodeSegment(key.N.Bytes()),
+			E:   encodeSegment(big.NewInt(int64(key.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		params := key.Curve.Params()
+		size := (params.BitSize + 7) / 8
+		x := make([]byte, size)
+		y := make([]byte, size)
+		key.X.FillBytes(x)
+		key.Y.FillBytes(y)
+		return jwk{
+			Kty: "EC",
+			Kid: kid,
+			Use: "sig",
+			Alg: alg,
+			Crv: params.Name,
+			X:   encodeSegment(x),
+			Y:   encodeSegment(y),
+		}, nil
+	default:
+		return jwk{}, fmt.Errorf("%w: unsupported public key type %T", ErrKeyTypeMismatch, publicKey)
+	}
+}
+
+// exportJWKS returns a JSON Web Key Set holding the public keys of the
+// signing key versions in keyPaths, with kids from computeKID. Publish it
+// for verifiers of tokens signed by signJWSDetached. The keys are fetched
+// concurrently, up to the limit set with WithMaxInFlight.
+func exportJWKS(ctx context.Context, client *cloudkms.Service, keyPaths []string, opts ...Option) ([]byte, error) {
+	fetched, err := fetchPublicKeys(ctx, client, keyPaths, newOptions(opts).maxInFlight, opts...)
+	if err != nil {
+		return nil, err
+	}
+	set := jwkSet{Keys: []jwk{}}
+	for _, keyPath := range keyPaths {
+		f := fetched[keyPath]
+		alg, err := joseAlgorithm(f.response.Algorithm)
+		if err != nil {
+			return nil, err
+		}
+		key, err := newJWK(f.publicKey, computeKID(keyPath, f.publicKey), alg)
+		if err != nil {
+			return nil, err
+		}
+		set.Keys = append(set.Keys, key)
+	}
+	return json.Marshal(set)
+}
+
+// writeJWKS writes the JSON Web Key Set of exportJWKS for keyPaths to the
+// file at path, for hosting as a static file. The file is replaced
+// atomically: the set is written to a temporary file in the same directory,
+// which is then renamed over path, so a server reading path never sees a
+// partial set. The file is world-readable, as a JWKS holds only public keys.
+func writeJWKS(ctx context.Context, client *cloudkms.Service, keyPaths []string, path string, opts ...Option) error {
+	set, err := exportJWKS(ctx, client, keyPaths, opts...)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, set, 0644, true, "write JWKS file")
+}
+
+// publicKey reconstructs the public key described by k.
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeSegment(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWK modulus: %w", err)
+		}
+		e, err := decodeSegment(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWK exponent: %w", err)
+		}
+		if len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid JWK RSA parameters")
+		}
+		key, err := rsaKeyFromModExp(n, int(new(big.Int).SetBytes(e).Int64()))
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWK RSA parameters: %w", err)
+		}
+		return key, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported JWK curve %q", k.Crv)
+		}
+		x, err := decodeSegment(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWK x coordinate: %w", err)
+		}
+		y, err := decodeSegment(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWK y coordinate: %w", err)
+		}
+		key := &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("JWK point is not on the curve")
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported JWK key type %q", k.Kty)
+	}
+}
+
+// rsaKeyFromModExp returns the RSA public key with the big-endian modulus
+// nBytes and public exponent e, for keys stored as a bare (n, e) pair rather
+// than PEM or DER, for example taken from a JWK. Pass it to the verify
+// functions with WithPublicKey. e must be odd and at least 3; as with any
+// key, use WithMinRSABits to reject a modulus that is too short.
+func rsaKeyFromModExp(nBytes []byte, e int) (*rsa.PublicKey, error) {
+	n := new(big.Int).SetBytes(nBytes)
+	if n.Sign() == 0 {
+		return nil, errors.New("RSA modulus is zero")
+	}
+	if e < 3 || e%2 == 0 {
+		return nil, fmt.Errorf("invalid RSA public exponent %d", e)
+	}
+	return &rsa.PublicKey{N: n, E: e}, nil
+}
+
+// findKey returns the public key in s with the given kid.
+func (s *jwkSet) findKey(kid string) (crypto.PublicKey, error) {
+	for _, k := range s.Keys {
+		if k.Kid == kid {
+			return k.publicKey()
+		}
+	}
+	return nil, fmt.Errorf("no key with kid %q in JWKS", kid)
+}
+
+// jwkAlgorithm returns the JWS algorithm a token must use to be verified with
+// publicKey, the key of a JWK whose "alg" member is alg: that member, or else
+// the ES algorithm an elliptic curve key's curve fixes. An RSA key can be
+// used with several algorithms, so without "alg" it is rejected with
+// ErrTokenAlgorithm rather than letting the token header choose.
+func jwkAlgorithm(alg string, publicKey crypto.PublicKey) (string, error) {
+	if alg != "" {
+		return alg, nil
+	}
+	if ecKey, ok := publicKey.(*ecdsa.PublicKey); ok {
+		for joseAlg, curve := range joseCurves {
+			if curve == ecKey.Curve.Params().Name {
+				return joseAlg, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("%w: JWK has no \"alg\" member, and a %T does not fix the algorithm", ErrTokenAlgorithm, publicKey)
+}
+
+// verifyWithJWKS verifies a JWS signature entirely offline, using the key
+// identified by kid in the JSON Web Key Set jwksJSON.
+// signature is the base64url-encoded signature from a JWS, message is the
+// signing input (for a compact JWS, everything before the second '.'), and
+// alg is the JWS algorithm, such as "RS256", "PS256" or "ES256".
+func verifyWithJWKS(jwksJSON []byte, kid, signature, message string, alg string) error {
+	var set jwkSet
+	if err := json.Unmarshal(jwksJSON, &set); err != nil {
+		return fmt.Errorf("failed to parse JWKS: %w", err)
+	}
+	publicKey, err := set.findKey(kid)
+	if err != nil {
+		return err
+	}
+	decodedSignature, err := decodeSegment(signature)
+	if err != nil {
+		return fmt.Errorf("%w: failed to decode signature string: %w", ErrSignatureMalformed, err)
+	}
+	return verifyJOSESignature(publicKey, alg, []byte(message), decodedSignature)
+}
@@ -0,0 +1,213 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestVerifyWithJWKS(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := jwkSet{Keys: []jwk{
+		{
+			Kty: "EC", Kid: "ec", Crv: "P-256",
+			X: encodeSegment(ecKey.X.Bytes()),
+			Y: encodeSegment(ecKey.Y.Bytes()),
+		},
+		{
+			Kty: "RSA", Kid: "rsa",
+			N: encodeSegment(rsaKey.N.Bytes()),
+			E: encodeSegment(big.NewInt(int64(rsaKey.E)).Bytes()),
+		},
+	}}
+	jwksJSON, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := "header.payload"
+	digest := sha256.Sum256([]byte(message))
+
+	r, s, err := ecdsa.Sign(rand.Reader, ecKey, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawSig := make([]byte, 64)
+	r.FillBytes(rawSig[:32])
+	s.FillBytes(rawSig[32:])
+	if err := verifyWithJWKS(jwksJSON, "ec", encodeSegment(rawSig), message, "ES256"); err != nil {
+		t.Errorf("verifyWithJWKS(ES256): %v", err)
+	}
+	if err := verifyWithJWKS(jwksJSON, "ec", encodeSegment(rawSig), message+".", "ES256"); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("verifyWithJWKS(ES256) for modified message: got %v; want ErrSignatureInvalid", err)
+	}
+
+	pssSig, err := rsa.SignPSS(rand.Reader, rsaKey, crypto.SHA256, digest[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyWithJWKS(jwksJSON, "rsa", encodeSegment(pssSig), message, "PS256"); err != nil {
+		t.Errorf("verifyWithJWKS(PS256): %v", err)
+	}
+	if err := verifyWithJWKS(jwksJSON, "rsa", encodeSegment(pssSig), message, "ES256"); !errors.Is(err, ErrKeyTypeMismatch) {
+		t.Errorf("verifyWithJWKS with wrong alg: got %v; want ErrKeyTypeMismatch", err)
+	}
+	if err := verifyWithJWKS(jwksJSON, "missing", encodeSegment(pssSig), message, "PS256"); err == nil {
+		t.Errorf("verifyWithJWKS with unknown kid should fail")
+	}
+}
+
+func TestExportJWKS(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	rsaPath := testKeyPath("rsa-sign")
+	ecPath := testKeyPath("ec-sign")
+	f.addKey(t, rsaPath, "RSA_SIGN_PSS_2048_SHA256")
+	f.addKey(t, ecPath, "EC_SIGN_P256_SHA256")
+
+	jwksJSON, err := exportJWKS(ctx, client, []string{rsaPath, ecPath})
+	if err != nil {
+		t.Fatalf("exportJWKS: %v", err)
+	}
+	payload := []byte("payload")
+	for keyPath, alg := range map[string]string{rsaPath: "RSA_SIGN_PSS_2048_SHA256", ecPath: "EC_SIGN_P256_SHA256"} {
+		jws, err := signJWSDetached(ctx, client, payload, keyPath)
+		if err != nil {
+			t.Fatalf("signJWSDetached(%s): %v", keyPath, err)
+		}
+		parts := strings.Split(jws, ".")
+		headerJSON, err := decodeSegment(parts[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		var header jwtHeader
+		if err := json.Unmarshal(headerJSON, &header); err != nil {
+			t.Fatal(err)
+		}
+		if want := computeKID(keyPath, testPrivateKey(t, alg).Public()); header.Kid != want {
+			t.Errorf("%s: kid = %q, want %q", keyPath, header.Kid, want)
+		}
+		signingInput := parts[0] + "." + encodeSegment(payload)
+		if err := verifyWithJWKS(jwksJSON, header.Kid, parts[2], signingInput, header.Alg); err != nil {
+			t.Errorf("%s: verifyWithJWKS: %v", keyPath, err)
+		}
+	}
+}
+
+func TestRSAKeyFromModExp(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("rsa-sign")
+	f.addKey(t, keyPath, "RSA_SIGN_PSS_2048_SHA256")
+	private := testPrivateKey(t, "RSA_SIGN_PSS_2048_SHA256").(*rsa.PrivateKey)
+
+	key, err := rsaKeyFromModExp(private.N.Bytes(), private.E)
+	if err != nil {
+		t.Fatalf("rsaKeyFromModExp: %v", err)
+	}
+	if !key.Equal(&private.PublicKey) {
+		t.Fatal("rsaKeyFromModExp returned a different key")
+	}
+	signature, err := signAsymmetric(ctx, client, "message", keyPath)
+	if err != nil {
+		t.Fatalf("signAsymmetric: %v", err)
+	}
+	// With the key supplied, no client or key path is needed.
+	if err := verifySignature(ctx, nil, signature, []byte("message"), "", WithPublicKey(key)); err != nil {
+		t.Errorf("verifySignature with a reconstructed key: %v", err)
+	}
+	if err := verifySignature(ctx, nil, signature, []byte("message"), "", WithPublicKey(key), WithMinRSABits(3072)); err == nil {
+		t.Error("verifySignature with a reconstructed key below WithMinRSABits succeeded")
+	}
+
+	for _, tc := range []struct {
+		n []byte
+		e int
+	}{
+		{nil, 65537},
+		{[]byte{0, 0}, 65537},
+		{private.N.Bytes(), 1},
+		{private.N.Bytes(), 65536},
+	} {
+		if _, err := rsaKeyFromModExp(tc.n, tc.e); err == nil {
+			t.Errorf("rsaKeyFromModExp(%x, %d) succeeded", tc.n, tc.e)
+		}
+	}
+}
+
+func TestWriteJWKS(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	rsaPath := testKeyPath("rsa-sign")
+	ecPath := testKeyPath("ec-sign")
+	f.addKey(t, rsaPath, "RSA_SIGN_PSS_2048_SHA256")
+	f.addKey(t, ecPath, "EC_SIGN_P256_SHA256")
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "jwks.json")
+	if err := os.WriteFile(path, []byte("stale"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeJWKS(ctx, client, []string{rsaPath, ecPath}, path); err != nil {
+		t.Fatalf("writeJWKS: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var set jwkSet
+	if err := json.Unmarshal(data, &set); err != nil {
+		t.Fatalf("written file is not a JWK Set: %v", err)
+	}
+	want := map[string]string{
+		computeKID(rsaPath, testPrivateKey(t, "RSA_SIGN_PSS_2048_SHA256").Public()): "PS256",
+		computeKID(ecPath, testPrivateKey(t, "EC_SIGN_P256_SHA256").Public()):       "ES256",
+	}
+	if len(set.Keys) != len(want) {
+		t.Fatalf("got %d keys, want %d", len(set.Keys), len(want))
+	}
+	for _, k := range set.Keys {
+		if alg, ok := want[k.Kid]; !ok || k.Alg != alg || k.Use != "sig" {
+			t.Errorf("key %q: use %q, alg %q; want a known kid with use sig and alg %q", k.Kid, k.Use, k.Alg, alg)
+		}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0644 {
+		t.Errorf("file mode = %v, want 0644", perm)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 {
+		t.Errorf("directory holds %d entries, %v; want only the JWKS file", len(entries), err)
+	}
+
+	if err := writeJWKS(ctx, client, []string{rsaPath}, filepath.Join(dir, "missing", "jwks.json")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("writeJWKS into a missing directory: got %v, want fs.ErrNotExist", err)
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// jwksMinRefreshInterval is the shortest time between two JWKS fetches by a
+// jwksVerifier, so that tokens with unknown kids cannot make it fetch the
+// JWKS on every request.
+var jwksMinRefreshInterval = 30 * time.Second
+
+// cachedJWK is a parsed key of a jwksVerifier.
+type cachedJWK struct {
+	publicKey crypto.PublicKey
+	// alg is the key's "alg" member, if it has one.
+	alg string
+}
+
+// jwksVerifier verifies JWTs like verifyJWTWithJWKS, but parses each key of
+// the JWKS once and keeps it by kid. A token with a kid that is not cached
+// makes it fetch the JWKS again, at most once per jwksMinRefreshInterval,
+// which picks up keys added by rotation. A failed fetch counts too, so an
+// outage of the JWKS endpoint does not make every token fetch again. It is
+// safe for concurrent use.
+type jwksVerifier struct {
+	fetch func(ctx context.Context) ([]byte, error)
+
+	mu          sync.RWMutex
+	keys        map[string]cachedJWK
+	lastRefresh time.Time
+	// refreshErr is the error of the fetch at lastRefresh, if it failed.
+	refreshErr error
+
+	// refreshMu serializes fetches, so that concurrent misses fetch once.
+	refreshMu sync.Mutex
+
+	hits, misses atomic.Int64
+}
+
+// newJWKSVerifier returns a jwksVerifier that gets the JWKS from fetch, for
+// example with an HTTP GET of the issuer's jwks_uri. Nothing is fetched
+// until the first token is verified.
+func newJWKSVerifier(fetch func(ctx context.Context) ([]byte, error)) *jwksVerifier {
+	return &jwksVerifier{fetch: fetch}
+}
+
+// verify verifies token and checks its claims as verifyJWTWithJWKS does.
+func (v *jwksVerifier) verify(ctx context.Context, token, issuer, audience string, opts ...Option) (*TokenClaims, error) {
+	p, err := parseJWT(token)
+	if err != nil {
+		return nil, err
+	}
+	key, err := v.key(ctx, p.header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	alg, err := jwkAlgorithm(key.alg, key.publicKey)
+	if err != nil {
+		return nil, err
+	}
+	return p.verify(key.publicKey, alg, issuer, audience, newOptions(opts))
+}
+
+// hitRate returns the fraction of key lookups answered from the cache, or 0
+// if no token has been verified yet.
+func (v *jwksVerifier) hitRate() float64 {
+	hits, misses := v.hits.Load(), v.misses.Load()
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
+
+// key returns the cached key with the given kid, refreshing the cache if it
+// is not there.
+func (v *jwksVerifier) key(ctx context.Context, kid string) (cachedJWK, error) {
+	v.mu.RLock()
+	key, ok := v.keys[kid]
+	v.mu.RUnlock()
+	if ok {
+		v.hits.Add(1)
+		return key, nil
+	}
+	v.misses.Add(1)
+
+	v.refreshMu.Lock()
+	defer v.refreshMu.Unlock()
+	// Another goroutine may have refreshed while this one waited.
+	v.mu.RLock()
+	key, ok = v.keys[kid]
+	recent := !v.lastRefresh.IsZero() && time.Since(v.lastRefresh) < jwksMinRefreshInterval
+	refreshErr := v.refreshErr
+	v.mu.RUnlock()
+	if ok {
+		return key, nil
+	}
+	if recent && refreshErr != nil {
+		return cachedJWK{}, refreshErr
+	}
+	if recent {
+		return cachedJWK{}, fmt.Errorf("no key with kid %q in JWKS", kid)
+	}
+	if err := v.refresh(ctx); err != nil {
+		return cachedJWK{}, err
+	}
+	v.mu.RLock()
+	key, ok = v.keys[kid]
+	v.mu.RUnlock()
+	if !ok {
+		return cachedJWK{}, fmt.Errorf("no key with kid %q in JWKS", kid)
+	}
+	return key, nil
+}
+
+// refresh fetches the JWKS and replaces the cached keys. The time of the
+// attempt is recorded before fetching, so that a JWKS that cannot be fetched
+// or parsed is not tried again within jwksMinRefreshInterval either; the
+// cached keys are then kept.
+func (v *jwksVerifier) refresh(ctx context.Context) error {
+	v.mu.Lock()
+	v.lastRefresh = time.Now()
+	v.mu.Unlock()
+	keys, err := v.fetchKeys(ctx)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.refreshErr = err
+	if err != nil {
+		return err
+	}
+	v.keys = keys
+	return nil
+}
+
+// fetchKeys fetches and parses the JWKS. Keys that cannot be parsed are left
+// out, so one bad entry does not disable the rest.
+func (v *jwksVerifier) fetchKeys(ctx context.Context) (map[string]cachedJWK, error) {
+	jwksJSON, err := v.fetch(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	var set jwkSet
+	if err := json.Unmarshal(jwksJSON, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS: %w", err)
+	}
+	keys := make(map[string]cachedJWK, len(set.Keys))
+	for _, k := range set.Keys {
+		publicKey, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = cachedJWK{publicKey: publicKey, alg: k.Alg}
+	}
+	return keys, nil
+}
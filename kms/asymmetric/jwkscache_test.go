@@ -0,0 +1,148 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestJWKSVerifier(t *testing.T) {
+	defer func(d time.Duration) { jwksMinRefreshInterval = d }(jwksMinRefreshInterval)
+	jwksMinRefreshInterval = 0
+
+	newKey := func(kid string) (*ecdsa.PrivateKey, jwk) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		k, err := newJWK(&key.PublicKey, kid, "ES256")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key, k
+	}
+	key1, jwk1 := newKey("k1")
+	key2, jwk2 := newKey("k2")
+
+	var mu sync.Mutex
+	var fetches atomic.Int64
+	set := jwkSet{Keys: []jwk{jwk1}}
+	v := newJWKSVerifier(func(ctx context.Context) ([]byte, error) {
+		fetches.Add(1)
+		mu.Lock()
+		defer mu.Unlock()
+		return json.Marshal(set)
+	})
+	ctx := context.Background()
+	claims := map[string]interface{}{"exp": time.Now().Unix() + 300}
+	token1 := signTestJWT(t, key1, "k1", claims)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := v.verify(ctx, token1, "", ""); err != nil {
+				t.Errorf("verify: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("20 concurrent verifications fetched the JWKS %d times, want 1", n)
+	}
+
+	// After rotation, an unknown kid triggers a refresh.
+	mu.Lock()
+	set.Keys = append(set.Keys, jwk2)
+	mu.Unlock()
+	if _, err := v.verify(ctx, signTestJWT(t, key2, "k2", claims), "", ""); err != nil {
+		t.Errorf("verify with rotated key: %v", err)
+	}
+	if n := fetches.Load(); n != 2 {
+		t.Errorf("JWKS fetched %d times, want 2", n)
+	}
+	if rate := v.hitRate(); rate <= 0.5 || rate >= 1 {
+		t.Errorf("hitRate = %v, want between 0.5 and 1", rate)
+	}
+
+	// Fetches for unknown kids are rate limited.
+	jwksMinRefreshInterval = time.Hour
+	if _, err := v.verify(ctx, signTestJWT(t, key2, "k3", claims), "", ""); err == nil {
+		t.Error("verify with unknown kid succeeded")
+	}
+	if n := fetches.Load(); n != 2 {
+		t.Errorf("JWKS fetched %d times after refresh interval, want 2", n)
+	}
+}
+
+func TestJWKSVerifierFetchFailure(t *testing.T) {
+	defer func(d time.Duration) { jwksMinRefreshInterval = d }(jwksMinRefreshInterval)
+	jwksMinRefreshInterval = time.Hour
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unavailable := errors.New("JWKS endpoint unavailable")
+	var fetches atomic.Int64
+	v := newJWKSVerifier(func(ctx context.Context) ([]byte, error) {
+		fetches.Add(1)
+		return nil, unavailable
+	})
+	ctx := context.Background()
+	claims := map[string]interface{}{"exp": time.Now().Unix() + 300}
+	// During an outage, tokens with unknown kids must not each fetch again.
+	for _, kid := range []string{"k1", "k2", "k3"} {
+		if _, err := v.verify(ctx, signTestJWT(t, key, kid, claims), "", ""); !errors.Is(err, unavailable) {
+			t.Errorf("verify with kid %s: got %v, want the fetch error", kid, err)
+		}
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("JWKS fetched %d times during an outage, want 1", n)
+	}
+
+	jwksMinRefreshInterval = 0
+	if _, err := v.verify(ctx, signTestJWT(t, key, "k1", claims), "", ""); !errors.Is(err, unavailable) {
+		t.Errorf("verify after the refresh interval: got %v, want the fetch error", err)
+	}
+	if n := fetches.Load(); n != 2 {
+		t.Errorf("JWKS fetched %d times after the refresh interval, want 2", n)
+	}
+}
+
+func TestJWKSVerifierAlgorithm(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	noAlg, err := newJWK(&key.PublicKey, "k1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := newJWKSVerifier(func(ctx context.Context) ([]byte, error) {
+		return json.Marshal(jwkSet{Keys: []jwk{noAlg}})
+	})
+	ctx := context.Background()
+	claims := map[string]interface{}{"exp": time.Now().Unix() + 300}
+
+	if _, err := v.verify(ctx, signTestJWT(t, key, "k1", claims), "", ""); err != nil {
+		t.Errorf("verify ES256 with a P-256 key without alg: %v", err)
+	}
+	forged := forgeJWT(t, jwtHeader{Alg: "ES384", Kid: "k1", Typ: "JWT"}, claims, nil)
+	if _, err := v.verify(ctx, forged, "", ""); !errors.Is(err, ErrTokenAlgorithm) {
+		t.Errorf("verify ES384 with a P-256 key without alg: got %v, want ErrTokenAlgorithm", err)
+	}
+}
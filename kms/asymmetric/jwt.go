@@ -0,0 +1,259 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// jwtHeader is the JOSE header of a JWT.
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// TokenClaims holds the claims of a verified JWT.
+type TokenClaims struct {
+	Issuer    string
+	Subject   string
+	Audience  []string
+	ExpiresAt time.Time
+	NotBefore time.Time
+	IssuedAt  time.Time
+	// Raw holds every claim in the token, including non-standard ones.
+	Raw map[string]interface{}
+}
+
+// WithClock makes token and certificate verification use now, instead of
+// time.Now, as the current time when checking the exp and nbf claims of
+// tokens and the validity period of certificates, and WithMaxKeyAge use it
+// to compute a key's age.
+func WithClock(now func() time.Time) Option {
+	return func(o *options) { o.clock = now }
+}
+
+// WithClockSkew makes token verification tolerate clocks that differ from
+// the token issuer's by up to d: a token is accepted until d after its exp
+// claim and from d before its nbf claim. The default is 0, which checks the
+// claims strictly; a minute or so absorbs typical skew between machines
+// without meaningfully extending a token's lifetime.
+func WithClockSkew(d time.Duration) Option {
+	return func(o *options) { o.clockSkew = d }
+}
+
+// now returns the current time according to WithClock.
+func (o *options) now() time.Time {
+	if o.clock != nil {
+		return o.clock()
+	}
+	return time.Now()
+}
+
+// parsedJWT is a JWT split into its parts, before any verification.
+type parsedJWT struct {
+	header       jwtHeader
+	claims       map[string]interface{}
+	signingInput string
+	signature    []byte
+}
+
+// parseJWT decodes a compact serialized JWT without verifying it.
+func parseJWT(token string) (*parsedJWT, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: want 3 segments, got %d", ErrTokenMalformed, len(parts))
+	}
+	p := &parsedJWT{signingInput: parts[0] + "." + parts[1]}
+	headerJSON, err := decodeSegment(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad header encoding: %w", ErrTokenMalformed, err)
+	}
+	if err := json.Unmarshal(headerJSON, &p.header); err != nil {
+		return nil, fmt.Errorf("%w: bad header: %w", ErrTokenMalformed, err)
+	}
+	claimsJSON, err := decodeSegment(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad claims encoding: %w", ErrTokenMalformed, err)
+	}
+	if err := json.Unmarshal(claimsJSON, &p.claims); err != nil {
+		return nil, fmt.Errorf("%w: bad claims: %w", ErrTokenMalformed, err)
+	}
+	if p.signature, err = decodeSegment(parts[2]); err != nil {
+		return nil, fmt.Errorf("%w: bad signature encoding: %w", ErrTokenMalformed, err)
+	}
+	return p, nil
+}
+
+// verifyJWT verifies a JWT signed by the KMS key at keyPath and checks its
+// standard claims: the token must not be expired or not yet valid, and must
+// carry the given issuer and audience. An empty issuer or audience skips that
+// check.
+// The token's "alg" header must be the JOSE name of the key's KMS algorithm;
+// "none" and any other algorithm are rejected before the signature is checked.
+// Each failure has its own error, testable with errors.Is: ErrTokenMalformed,
+// ErrTokenAlgorithm, ErrSignatureInvalid, ErrTokenExpired,
+// ErrTokenNotYetValid, ErrTokenIssuer and ErrTokenAudience, and
+// ErrAudienceMismatch with WithRequiredAudience. The key is checked like the
+// verify functions check it, so WithPublicKey, WithExpectedKeyFingerprint and
+// WithMinRSABits apply.
+func verifyJWT(ctx context.Context, client *cloudkms.Service, token, keyPath, issuer, audience string, opts ...Option) (*TokenClaims, error) {
+	p, err := parseJWT(token)
+	if err != nil {
+		return nil, err
+	}
+	o := newOptions(opts)
+	publicKey, kmsAlgorithm, err := o.getPublicKeyAlgorithm(ctx, client, keyPath)
+	if err != nil {
+		return nil, err
+	}
+	if kmsAlgorithm == "" {
+		return nil, fmt.Errorf("%w: the algorithm of a key given by WithPublicKey must be given by WithKeyAlgorithm", ErrTokenAlgorithm)
+	}
+	// The key, not the token, decides the algorithm.
+	alg, err := joseAlgorithm(kmsAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	return p.verify(publicKey, alg, issuer, audience, o)
+}
+
+// verifyJWTWithJWKS is like verifyJWT, but verifies the token offline with
+// the key named by the token's "kid" header in the JSON Web Key Set jwksJSON.
+// The token's "alg" header must match the key's "alg" member or, if it has
+// none, the algorithm of its curve; an RSA key without "alg" is rejected.
+func verifyJWTWithJWKS(token string, jwksJSON []byte, issuer, audience string, opts ...Option) (*TokenClaims, error) {
+	p, err := parseJWT(token)
+	if err != nil {
+		return nil, err
+	}
+	var set jwkSet
+	if err := json.Unmarshal(jwksJSON, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS: %w", err)
+	}
+	publicKey, err := set.findKey(p.header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	var keyAlg string
+	for _, k := range set.Keys {
+		if k.Kid == p.header.Kid {
+			keyAlg = k.Alg
+			break
+		}
+	}
+	alg, err := jwkAlgorithm(keyAlg, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	return p.verify(publicKey, alg, issuer, audience, newOptions(opts))
+}
+
+// verify checks that p is signed with alg, then its signature and then its
+// claims.
+func (p *parsedJWT) verify(publicKey crypto.PublicKey, alg, issuer, audience string, o *options) (*TokenClaims, error) {
+	if err := p.checkAlgorithm(alg); err != nil {
+		return nil, err
+	}
+	if err := verifyJOSESignature(publicKey, alg, []byte(p.signingInput), p.signature); err != nil {
+		return nil, err
+	}
+	claims, err := p.tokenClaims()
+	if err != nil {
+		return nil, err
+	}
+	now := o.now()
+	skew := o.clockSkew
+	if skew < 0 {
+		skew = 0
+	}
+	if now.After(claims.ExpiresAt.Add(skew)) {
+		return nil, fmt.Errorf("%w: expired at %v", ErrTokenExpired, claims.ExpiresAt)
+	}
+	if !claims.NotBefore.IsZero() && now.Before(claims.NotBefore.Add(-skew)) {
+		return nil, fmt.Errorf("%w: valid from %v", ErrTokenNotYetValid, claims.NotBefore)
+	}
+	if issuer != "" && claims.Issuer != issuer {
+		return nil, fmt.Errorf("%w: got %q, want %q", ErrTokenIssuer, claims.Issuer, issuer)
+	}
+	if audience != "" && !containsString(claims.Audience, audience) {
+		return nil, fmt.Errorf("%w: got %q, want %q", ErrTokenAudience, claims.Audience, audience)
+	}
+	if err := o.checkAudience(claims.Audience); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// checkAlgorithm returns an error wrapping ErrTokenAlgorithm unless p's
+// "alg" header is exactly alg. An unsigned token is always rejected.
+func (p *parsedJWT) checkAlgorithm(alg string) error {
+	if p.header.Alg == "" || strings.EqualFold(p.header.Alg, "none") {
+		return fmt.Errorf("%w: token is unsigned (alg %q)", ErrTokenAlgorithm, p.header.Alg)
+	}
+	if p.header.Alg != alg {
+		return fmt.Errorf("%w: token header says %q, but the key uses %q", ErrTokenAlgorithm, p.header.Alg, alg)
+	}
+	return nil
+}
+
+// tokenClaims extracts the standard claims from p. The exp claim is required.
+func (p *parsedJWT) tokenClaims() (*TokenClaims, error) {
+	c := &TokenClaims{Raw: p.claims}
+	var ok bool
+	var err error
+	if v, present := p.claims["iss"]; present {
+		if c.Issuer, ok = v.(string); !ok {
+			return nil, fmt.Errorf("%w: iss is not a string", ErrTokenMalformed)
+		}
+	}
+	if v, present := p.claims["sub"]; present {
+		if c.Subject, ok = v.(string); !ok {
+			return nil, fmt.Errorf("%w: sub is not a string", ErrTokenMalformed)
+		}
+	}
+	if c.Audience, err = parseAudience(p.claims["aud"]); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrTokenMalformed, err)
+	}
+	times := []struct {
+		name string
+		dst  *time.Time
+	}{
+		{"exp", &c.ExpiresAt},
+		{"nbf", &c.NotBefore},
+		{"iat", &c.IssuedAt},
+	}
+	for _, t := range times {
+		v, present := p.claims[t.name]
+		if !present {
+			continue
+		}
+		seconds, ok := v.(float64)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s is not a number", ErrTokenMalformed, t.name)
+		}
+		*t.dst = time.Unix(int64(seconds), 0)
+	}
+	if c.ExpiresAt.IsZero() {
+		return nil, fmt.Errorf("%w: missing exp claim", ErrTokenMalformed)
+	}
+	return c, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,294 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// signTestJWT creates an ES256 JWT signed by key.
+func signTestJWT(t *testing.T, key *ecdsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	header, err := json.Marshal(jwtHeader{Alg: "ES256", Kid: kid, Typ: "JWT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingInput := encodeSegment(header) + "." + encodeSegment(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return signingInput + "." + encodeSegment(sig)
+}
+
+func TestVerifyJWTWithJWKS(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwksJSON, err := json.Marshal(jwkSet{Keys: []jwk{{
+		Kty: "EC", Kid: "k1", Crv: "P-256",
+		X: encodeSegment(key.X.Bytes()),
+		Y: encodeSegment(key.Y.Bytes()),
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().Unix()
+	valid := map[string]interface{}{
+		"iss": "issuer", "aud": "service", "sub": "user",
+		"exp": now + 300, "iat": now,
+	}
+
+	claims, err := verifyJWTWithJWKS(signTestJWT(t, key, "k1", valid), jwksJSON, "issuer", "service")
+	if err != nil {
+		t.Fatalf("verifyJWTWithJWKS: %v", err)
+	}
+	if claims.Subject != "user" {
+		t.Errorf("Subject = %q; want: %q", claims.Subject, "user")
+	}
+
+	expired := map[string]interface{}{"iss": "issuer", "aud": "service", "exp": now - 10}
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"expired", signTestJWT(t, key, "k1", expired), ErrTokenExpired},
+		{"wrong audience", signTestJWT(t, key, "k1", valid), ErrTokenAudience},
+		{"bad signature", signTestJWT(t, other, "k1", valid), ErrSignatureInvalid},
+		{"malformed", "not-a-token", ErrTokenMalformed},
+	}
+	for _, test := range tests {
+		audience := "service"
+		if test.want == ErrTokenAudience {
+			audience = "another-service"
+		}
+		if _, err := verifyJWTWithJWKS(test.token, jwksJSON, "issuer", audience); !errors.Is(err, test.want) {
+			t.Errorf("%s: got %v; want %v", test.name, err, test.want)
+		}
+	}
+}
+
+func TestVerifyJWTWithClock(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwksJSON, err := json.Marshal(jwkSet{Keys: []jwk{{
+		Kty: "EC", Kid: "k1", Crv: "P-256",
+		X: encodeSegment(key.X.Bytes()),
+		Y: encodeSegment(key.Y.Bytes()),
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	issued := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	token := signTestJWT(t, key, "k1", map[string]interface{}{
+		"nbf": issued.Unix(),
+		"exp": issued.Add(time.Hour).Unix(),
+	})
+	tests := []struct {
+		now  time.Time
+		want error
+	}{
+		{issued.Add(-time.Minute), ErrTokenNotYetValid},
+		{issued.Add(30 * time.Minute), nil},
+		{issued.Add(2 * time.Hour), ErrTokenExpired},
+	}
+	for _, test := range tests {
+		clock := WithClock(func() time.Time { return test.now })
+		if _, err := verifyJWTWithJWKS(token, jwksJSON, "", "", clock); !errors.Is(err, test.want) {
+			t.Errorf("at %v: got %v; want %v", test.now, err, test.want)
+		}
+	}
+}
+
+func TestVerifyJWTWithClockSkew(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwksJSON, err := json.Marshal(jwkSet{Keys: []jwk{{
+		Kty: "EC", Kid: "k1", Crv: "P-256",
+		X: encodeSegment(key.X.Bytes()),
+		Y: encodeSegment(key.Y.Bytes()),
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	issued := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	expires := issued.Add(time.Hour)
+	token := signTestJWT(t, key, "k1", map[string]interface{}{
+		"nbf": issued.Unix(),
+		"exp": expires.Unix(),
+	})
+	tests := []struct {
+		now  time.Time
+		skew time.Duration
+		want error
+	}{
+		{issued.Add(-30 * time.Second), 0, ErrTokenNotYetValid},
+		{issued.Add(-30 * time.Second), time.Minute, nil},
+		{issued.Add(-2 * time.Minute), time.Minute, ErrTokenNotYetValid},
+		{expires.Add(30 * time.Second), 0, ErrTokenExpired},
+		{expires.Add(30 * time.Second), time.Minute, nil},
+		{expires.Add(2 * time.Minute), time.Minute, ErrTokenExpired},
+		{expires.Add(30 * time.Second), -time.Minute, ErrTokenExpired},
+	}
+	for _, test := range tests {
+		clock := WithClock(func() time.Time { return test.now })
+		if _, err := verifyJWTWithJWKS(token, jwksJSON, "", "", clock, WithClockSkew(test.skew)); !errors.Is(err, test.want) {
+			t.Errorf("at %v with skew %v: got %v; want %v", test.now, test.skew, err, test.want)
+		}
+	}
+}
+
+// forgeJWT assembles a JWT from header, claims and a signature over them
+// made with sign, which may be nil to leave the token unsigned.
+func forgeJWT(t *testing.T, header jwtHeader, claims map[string]interface{}, sign func(signingInput []byte) []byte) string {
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingInput := encodeSegment(headerJSON) + "." + encodeSegment(payload)
+	var sig []byte
+	if sign != nil {
+		sig = sign([]byte(signingInput))
+	}
+	return signingInput + "." + encodeSegment(sig)
+}
+
+func TestVerifyJWTAlgorithm(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("ec-sign")
+	f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+	key := testPrivateKey(t, "EC_SIGN_P256_SHA256").(*ecdsa.PrivateKey)
+	response, _, err := fetchPublicKey(ctx, client, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwksJSON, err := exportJWKS(ctx, client, []string{keyPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kid := computeKID(keyPath, key.Public())
+	claims := map[string]interface{}{"exp": time.Now().Unix() + 300}
+
+	if _, err := verifyJWT(ctx, client, signTestJWT(t, key, kid, claims), keyPath, "", ""); err != nil {
+		t.Fatalf("verifyJWT: %v", err)
+	}
+	if _, err := verifyJWTWithJWKS(signTestJWT(t, key, kid, claims), jwksJSON, "", ""); err != nil {
+		t.Fatalf("verifyJWTWithJWKS: %v", err)
+	}
+
+	// HS256 keyed with the public key, which a verifier that trusts the
+	// header would check with the key it already holds.
+	hs256 := func(signingInput []byte) []byte {
+		mac := hmac.New(sha256.New, []byte(response.Pem))
+		mac.Write(signingInput)
+		return mac.Sum(nil)
+	}
+	es384 := func(signingInput []byte) []byte {
+		digest := sha512.Sum384(signingInput)
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return sig
+	}
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"alg none", forgeJWT(t, jwtHeader{Alg: "none", Kid: kid, Typ: "JWT"}, claims, nil)},
+		{"alg NONE", forgeJWT(t, jwtHeader{Alg: "NONE", Kid: kid, Typ: "JWT"}, claims, nil)},
+		{"alg missing", forgeJWT(t, jwtHeader{Kid: kid, Typ: "JWT"}, claims, nil)},
+		{"HMAC with the public key", forgeJWT(t, jwtHeader{Alg: "HS256", Kid: kid, Typ: "JWT"}, claims, hs256)},
+		{"other ECDSA algorithm", forgeJWT(t, jwtHeader{Alg: "ES384", Kid: kid, Typ: "JWT"}, claims, es384)},
+	}
+	for _, test := range tests {
+		if _, err := verifyJWT(ctx, client, test.token, keyPath, "", ""); !errors.Is(err, ErrTokenAlgorithm) {
+			t.Errorf("verifyJWT, %s: got %v, want ErrTokenAlgorithm", test.name, err)
+		}
+		if _, err := verifyJWTWithJWKS(test.token, jwksJSON, "", ""); !errors.Is(err, ErrTokenAlgorithm) {
+			t.Errorf("verifyJWTWithJWKS, %s: got %v, want ErrTokenAlgorithm", test.name, err)
+		}
+	}
+
+	// Without "alg", an EC JWK's curve decides the algorithm, never the
+	// token header.
+	noAlg, err := json.Marshal(jwkSet{Keys: []jwk{{
+		Kty: "EC", Kid: kid, Crv: "P-256",
+		X: encodeSegment(key.X.Bytes()),
+		Y: encodeSegment(key.Y.Bytes()),
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifyJWTWithJWKS(signTestJWT(t, key, kid, claims), noAlg, "", ""); err != nil {
+		t.Errorf("verifyJWTWithJWKS, ES256 and a P-256 JWK without alg: %v", err)
+	}
+	for _, test := range tests {
+		if _, err := verifyJWTWithJWKS(test.token, noAlg, "", ""); !errors.Is(err, ErrTokenAlgorithm) {
+			t.Errorf("verifyJWTWithJWKS, %s and a JWK without alg: got %v, want ErrTokenAlgorithm", test.name, err)
+		}
+	}
+
+	// An RSA key fits several algorithms, so a JWK without "alg" is refused.
+	rsaKey := testPrivateKey(t, "RSA_SIGN_PKCS1_2048_SHA256").Public().(*rsa.PublicKey)
+	rsaJWK, err := newJWK(rsaKey, "rsa", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaJWKS, err := json.Marshal(jwkSet{Keys: []jwk{rsaJWK}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs256 := func(signingInput []byte) []byte {
+		digest := sha256.Sum256(signingInput)
+		sig, err := rsa.SignPKCS1v15(rand.Reader, testPrivateKey(t, "RSA_SIGN_PKCS1_2048_SHA256").(*rsa.PrivateKey), crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig
+	}
+	token := forgeJWT(t, jwtHeader{Alg: "RS256", Kid: "rsa", Typ: "JWT"}, claims, rs256)
+	if _, err := verifyJWTWithJWKS(token, rsaJWKS, "", ""); !errors.Is(err, ErrTokenAlgorithm) {
+		t.Errorf("verifyJWTWithJWKS with an RSA JWK without alg: got %v, want ErrTokenAlgorithm", err)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// kemSeedSize is the size in bytes of the random seed wrapped by
+// wrapDerivedKey.
+const kemSeedSize = 32
+
+// wrapDerivedKey generates a random seed, wraps it with the RSA key at
+// keyPath, and derives a 256-bit AES key from it with deriveAESKey. Encrypt
+// locally with key, for example with AES-GCM, then discard it and store
+// wrappedSeed, from which unwrapDerivedKey derives the same key. info binds
+// the key to its use, such as a file name or protocol label, and must be
+// passed unchanged to unwrapDerivedKey.
+func wrapDerivedKey(ctx context.Context, client *cloudkms.Service, keyPath string, info []byte, opts ...Option) (key []byte, wrappedSeed string, err error) {
+	seed := make([]byte, kemSeedSize)
+	defer zeroize(seed)
+	if _, err := rand.Read(seed); err != nil {
+		return nil, "", fmt.Errorf("failed to generate seed: %w", err)
+	}
+	wrappedSeed, err = encryptRSABytes(ctx, client, seed, keyPath, opts...)
+	if err != nil {
+		return nil, "", err
+	}
+	key, err = deriveAESKey(seed, info)
+	if err != nil {
+		return nil, "", err
+	}
+	return key, wrappedSeed, nil
+}
+
+// unwrapDerivedKey decrypts wrappedSeed, as returned by wrapDerivedKey, with
+// the KMS key at keyPath, and derives the AES key from it. The seed is
+// cleared from memory before unwrapDerivedKey returns; the caller should
+// clear the key with zeroize when done.
+func unwrapDerivedKey(ctx context.Context, client *cloudkms.Service, wrappedSeed, keyPath string, info []byte, opts ...Option) ([]byte, error) {
+	seed, err := decryptRSABytes(ctx, client, wrappedSeed, keyPath, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer zeroize(seed)
+	return deriveAESKey(seed, info)
+}
+
+// deriveAESKey expands seed into a 256-bit AES key with HKDF-SHA256 (RFC
+// 5869), using no salt and info as the context string.
+func deriveAESKey(seed, info []byte) ([]byte, error) {
+	key, err := hkdf.Key(sha256.New, seed, nil, string(info), 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	return key, nil
+}
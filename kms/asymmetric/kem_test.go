@@ -0,0 +1,72 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestWrapDerivedKey(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("decrypt")
+	f.addKey(t, keyPath, "RSA_DECRYPT_OAEP_2048_SHA256")
+	info := []byte("file.txt")
+
+	key, wrappedSeed, err := wrapDerivedKey(ctx, client, keyPath, info)
+	if err != nil {
+		t.Fatalf("wrapDerivedKey: %v", err)
+	}
+	unwrapped, err := unwrapDerivedKey(ctx, client, wrappedSeed, keyPath, info)
+	if err != nil {
+		t.Fatalf("unwrapDerivedKey: %v", err)
+	}
+	if !bytes.Equal(key, unwrapped) {
+		t.Fatalf("unwrapDerivedKey returned %x, want %x", unwrapped, key)
+	}
+	other, err := unwrapDerivedKey(ctx, client, wrappedSeed, keyPath, []byte("other.txt"))
+	if err != nil {
+		t.Fatalf("unwrapDerivedKey: %v", err)
+	}
+	if bytes.Equal(key, other) {
+		t.Error("keys derived with different info are equal")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	sealed := gcm.Seal(nil, nonce, []byte("secret"), nil)
+	block, _ = aes.NewCipher(unwrapped)
+	gcm, _ = cipher.NewGCM(block)
+	if opened, err := gcm.Open(nil, nonce, sealed, nil); err != nil || string(opened) != "secret" {
+		t.Errorf("AES-GCM with unwrapped key: got (%q, %v)", opened, err)
+	}
+}
+
+// TestDeriveAESKey checks deriveAESKey against RFC 5869 test case 3, which
+// uses no salt and no info.
+func TestDeriveAESKey(t *testing.T) {
+	seed, _ := hex.DecodeString("0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b")
+	want := "8da4e775a563c18f715f802a063c5a31b8a11f5c5ee1879ec3454e5f3c738d2d"
+	key, err := deriveAESKey(seed, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(key); got != want {
+		t.Errorf("deriveAESKey = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,293 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// keyBitLength returns the size of the key at keyPath in bits: the modulus
+// size for RSA keys or the curve size for elliptic curve keys.
+func keyBitLength(ctx context.Context, client *cloudkms.Service, keyPath string) (int, error) {
+	abstractKey, err := getAsymmetricPublicKey(ctx, client, keyPath)
+	if err != nil {
+		return 0, err
+	}
+	switch key := abstractKey.(type) {
+	case *rsa.PublicKey:
+		return key.N.BitLen(), nil
+	case *ecdsa.PublicKey:
+		return key.Curve.Params().BitSize, nil
+	default:
+		return 0, fmt.Errorf("unsupported public key type %T", abstractKey)
+	}
+}
+
+// getECCurve returns the curve of the elliptic curve key at keyPath, for
+// converting its signatures between the raw and DER forms, whose size
+// depends on the curve. It returns an error wrapping ErrKeyTypeMismatch if
+// the key is not an ECDSA key.
+func getECCurve(ctx context.Context, client *cloudkms.Service, keyPath string) (elliptic.Curve, error) {
+	abstractKey, err := getAsymmetricPublicKey(ctx, client, keyPath)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := abstractKey.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: want *ecdsa.PublicKey, got %T", ErrKeyTypeMismatch, abstractKey)
+	}
+	return key.Curve, nil
+}
+
+// fetchPublicKey retrieves the public key at keyPath, returning both the KMS
+// response, which carries metadata such as the algorithm, and the parsed key.
+func fetchPublicKey(ctx context.Context, client *cloudkms.Service, keyPath string, opts ...Option) (*cloudkms.PublicKey, crypto.PublicKey, error) {
+	if err := validateKeyPath(keyPath); err != nil {
+		return nil, nil, err
+	}
+	o := newOptions(opts)
+	call := client.Projects.Locations.KeyRings.CryptoKeys.CryptoKeyVersions.GetPublicKey(keyPath)
+	var response *cloudkms.PublicKey
+	err := o.kmsCall(ctx, "GetPublicKey", keyPath, call.Header(), func() (http.Header, error) {
+		var err error
+		response, err = call.Context(ctx).Do()
+		if err != nil {
+			return nil, err
+		}
+		return response.Header, nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch public key: %w", err)
+	}
+	// Make sure KMS answered for the key that was asked for.
+	if err := checkResponseName(keyPath, response.Name); err != nil {
+		return nil, nil, err
+	}
+	if err := o.checkProtectionLevel(response.ProtectionLevel, keyPath); err != nil {
+		return nil, nil, err
+	}
+	publicKey, err := parsePublicKeyPEM(response.Pem)
+	if err != nil {
+		return nil, nil, err
+	}
+	return response, publicKey, nil
+}
+
+// parsePublicKeyPEM parses a PEM-encoded PKIX public key, the format KMS
+// uses to publish public keys.
+func parsePublicKeyPEM(pemStr string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(pemStr))
+	if block == nil {
+		return nil, errors.New("failed to parse public key: no PEM data found")
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		if curve, ok := unsupportedCurve(block.Bytes); ok {
+			return nil, fmt.Errorf("%w: %s; only P-256 and P-384 keys can be used", ErrUnsupportedCurve, curve)
+		}
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+	return publicKey, nil
+}
+
+var (
+	oidECPublicKey = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidSecp256k1   = asn1.ObjectIdentifier{1, 3, 132, 0, 10}
+)
+
+// unsupportedCurve reports whether the DER-encoded SubjectPublicKeyInfo der
+// is an elliptic curve key, on a curve that crypto/x509 cannot parse, such as
+// the secp256k1 curve of EC_SIGN_SECP256K1_SHA256, and if so, names the
+// curve.
+func unsupportedCurve(der []byte) (string, bool) {
+	var spki struct {
+		Algorithm struct {
+			Algorithm  asn1.ObjectIdentifier
+			Parameters asn1.RawValue `asn1:"optional"`
+		}
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(der, &spki); err != nil || !spki.Algorithm.Algorithm.Equal(oidECPublicKey) {
+		return "", false
+	}
+	var curve asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(spki.Algorithm.Parameters.FullBytes, &curve); err != nil {
+		return "explicitly specified curve", true
+	}
+	if curve.Equal(oidSecp256k1) {
+		return "secp256k1", true
+	}
+	return "curve " + curve.String(), true
+}
+
+// ecHash returns the hash that KMS signs with on curve, and an error
+// wrapping ErrUnsupportedCurve for a curve KMS does not sign with, whose
+// signatures cannot be checked without guessing the hash.
+func ecHash(curve elliptic.Curve) (crypto.Hash, error) {
+	switch curve {
+	case elliptic.P256():
+		return crypto.SHA256, nil
+	case elliptic.P384():
+		return crypto.SHA384, nil
+	default:
+		return 0, fmt.Errorf("%w: %s", ErrUnsupportedCurve, curve.Params().Name)
+	}
+}
+
+// VersionInfo summarizes a CryptoKeyVersion for debugging.
+type VersionInfo struct {
+	Name            string
+	Algorithm       string
+	State           string
+	ProtectionLevel string
+	CreateTime      time.Time
+	// GenerateTime is zero while the key material is still being generated.
+	GenerateTime time.Time
+	// PublicKeyPEM is empty for versions that are not enabled, such as
+	// versions still being generated, and for symmetric keys.
+	PublicKeyPEM string
+}
+
+// String formats v for display, one field per line.
+func (v *VersionInfo) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Name:            %s\n", v.Name)
+	fmt.Fprintf(&b, "Algorithm:       %s\n", v.Algorithm)
+	fmt.Fprintf(&b, "State:           %s\n", v.State)
+	fmt.Fprintf(&b, "ProtectionLevel: %s\n", v.ProtectionLevel)
+	fmt.Fprintf(&b, "CreateTime:      %s\n", formatTime(v.CreateTime))
+	fmt.Fprintf(&b, "GenerateTime:    %s\n", formatTime(v.GenerateTime))
+	if v.PublicKeyPEM != "" {
+		b.WriteString(v.PublicKeyPEM)
+	}
+	return b.String()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
+
+// describeKeyVersion fetches the metadata of the key version at keyPath and,
+// if it is an enabled asymmetric key, its public key.
+func describeKeyVersion(ctx context.Context, client *cloudkms.Service, keyPath string, opts ...Option) (*VersionInfo, error) {
+	version, err := getKeyVersion(ctx, client, keyPath, opts...)
+	if err != nil {
+		return nil, err
+	}
+	info := &VersionInfo{
+		Name:            version.Name,
+		Algorithm:       version.Algorithm,
+		State:           version.State,
+		ProtectionLevel: version.ProtectionLevel,
+	}
+	if info.CreateTime, err = parseTimestamp(version.CreateTime); err != nil {
+		return nil, err
+	}
+	if info.GenerateTime, err = parseTimestamp(version.GenerateTime); err != nil {
+		return nil, err
+	}
+	if _, ok := lookupAlgorithm(version.Algorithm); ok && version.State == "ENABLED" {
+		response, _, err := fetchPublicKey(ctx, client, keyPath, opts...)
+		if err != nil {
+			return nil, err
+		}
+		info.PublicKeyPEM = response.Pem
+	}
+	return info, nil
+}
+
+// parseTimestamp parses an RFC 3339 timestamp from the KMS API. An empty
+// string, used for fields that are not set yet, gives the zero time.
+func parseTimestamp(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse timestamp %q: %w", s, err)
+	}
+	return t, nil
+}
+
+// WithKeyAlgorithm tells the signing functions that the key version they are
+// given uses the KMS algorithm alg, such as "EC_SIGN_P256_SHA256", so that
+// they need not look it up, which costs one extra KMS call per signature. The
+// algorithm is trusted as given: WithAllowedAlgorithms checks it rather than
+// the key's, and a wrong one fails when KMS rejects the digest or when the
+// signature is verified. WithMaxKeyAge still needs the lookup. It also tells
+// verifySignature how to check RSA signatures with a key given by
+// WithPublicKey.
+func WithKeyAlgorithm(alg string) Option {
+	return func(o *options) { o.keyAlgorithm = alg }
+}
+
+// getKeyAlgorithm returns the parameters of the algorithm used by the key
+// version at keyPath, or given by WithKeyAlgorithm. It also enforces
+// WithMaxKeyAge, as the signing functions all call it before signing.
+func getKeyAlgorithm(ctx context.Context, client *cloudkms.Service, keyPath string, opts ...Option) (AlgorithmInfo, error) {
+	if o := newOptions(opts); o.keyAlgorithm != "" && o.maxKeyAge <= 0 {
+		if err := validateKeyPath(keyPath); err != nil {
+			return AlgorithmInfo{}, err
+		}
+		alg, ok := lookupAlgorithm(o.keyAlgorithm)
+		if !ok {
+			return AlgorithmInfo{}, fmt.Errorf("unsupported algorithm %s", o.keyAlgorithm)
+		}
+		return alg, nil
+	}
+	version, err := getKeyVersion(ctx, client, keyPath, opts...)
+	if err != nil {
+		return AlgorithmInfo{}, err
+	}
+	if err := newOptions(opts).checkKeyAge(version); err != nil {
+		return AlgorithmInfo{}, err
+	}
+	alg, ok := lookupAlgorithm(version.Algorithm)
+	if !ok {
+		return AlgorithmInfo{}, fmt.Errorf("unsupported algorithm %s", version.Algorithm)
+	}
+	return alg, nil
+}
+
+// getKeyVersion fetches the CryptoKeyVersion resource at keyPath.
+func getKeyVersion(ctx context.Context, client *cloudkms.Service, keyPath string, opts ...Option) (*cloudkms.CryptoKeyVersion, error) {
+	if err := validateKeyPath(keyPath); err != nil {
+		return nil, err
+	}
+	o := newOptions(opts)
+	var version *cloudkms.CryptoKeyVersion
+	err := o.retry(ctx, func() error {
+		call := client.Projects.Locations.KeyRings.CryptoKeys.CryptoKeyVersions.Get(keyPath)
+		return o.kmsCall(ctx, "GetCryptoKeyVersion", keyPath, call.Header(), func() (http.Header, error) {
+			var err error
+			version, err = call.Context(ctx).Do()
+			if err != nil {
+				return nil, err
+			}
+			return version.Header, nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get key version %s: %w", keyPath, err)
+	}
+	return version, nil
+}
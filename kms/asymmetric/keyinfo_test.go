@@ -0,0 +1,145 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestGetECCurve(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	for algName, want := range map[string]elliptic.Curve{
+		"EC_SIGN_P256_SHA256": elliptic.P256(),
+		"EC_SIGN_P384_SHA384": elliptic.P384(),
+	} {
+		keyPath := testKeyPath(algName)
+		f.addKey(t, keyPath, algName)
+		curve, err := getECCurve(ctx, client, keyPath)
+		if err != nil {
+			t.Fatalf("getECCurve(%s): %v", algName, err)
+		}
+		if curve != want {
+			t.Errorf("getECCurve(%s) = %s, want %s", algName, curve.Params().Name, want.Params().Name)
+		}
+	}
+
+	rsaPath := testKeyPath("rsa-sign")
+	f.addKey(t, rsaPath, "RSA_SIGN_PSS_2048_SHA256")
+	if _, err := getECCurve(ctx, client, rsaPath); !errors.Is(err, ErrKeyTypeMismatch) {
+		t.Errorf("getECCurve with an RSA key: got %v, want ErrKeyTypeMismatch", err)
+	}
+}
+
+func TestUnsupportedCurve(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+
+	// crypto/x509 cannot build a secp256k1 key, so encode one by hand.
+	type algorithmIdentifier struct {
+		Algorithm  asn1.ObjectIdentifier
+		Parameters asn1.ObjectIdentifier
+	}
+	point := append([]byte{4}, make([]byte, 64)...)
+	der, err := asn1.Marshal(struct {
+		Algorithm algorithmIdentifier
+		PublicKey asn1.BitString
+	}{
+		algorithmIdentifier{oidECPublicKey, oidSecp256k1},
+		asn1.BitString{Bytes: point, BitLength: 8 * len(point)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secp256k1PEM := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	_, err = parsePublicKeyPEM(secp256k1PEM)
+	if !errors.Is(err, ErrUnsupportedCurve) || !strings.Contains(err.Error(), "secp256k1") {
+		t.Errorf("parsePublicKeyPEM(secp256k1) = %v, want ErrUnsupportedCurve naming secp256k1", err)
+	}
+
+	// Curves that parse but that KMS does not sign with cannot be verified
+	// without guessing the hash.
+	p521, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = verifySignatureEC(ctx, client, "AAAA", "message", testKeyPath("unused"), WithPublicKey(&p521.PublicKey))
+	if !errors.Is(err, ErrUnsupportedCurve) || !strings.Contains(err.Error(), "P-521") {
+		t.Errorf("verifySignatureEC with a P-521 key = %v, want ErrUnsupportedCurve naming P-521", err)
+	}
+
+	// P-384 signatures are checked with SHA-384, as KMS makes them.
+	keyPath := testKeyPath("ec-p384")
+	f.addKey(t, keyPath, "EC_SIGN_P384_SHA384")
+	signature, err := signAsymmetric(ctx, client, "message", keyPath)
+	if err != nil {
+		t.Fatalf("signAsymmetric: %v", err)
+	}
+	if err := verifySignatureEC(ctx, client, signature, "message", keyPath); err != nil {
+		t.Errorf("verifySignatureEC with a P-384 key: %v", err)
+	}
+}
+
+func TestWithKeyAlgorithm(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("known-alg")
+	f.addKey(t, keyPath, "EC_SIGN_P384_SHA384")
+	requests := func() int {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return f.requests
+	}
+
+	before := requests()
+	sig, err := signAsymmetric(ctx, client, "message", keyPath, WithKeyAlgorithm("EC_SIGN_P384_SHA384"))
+	if err != nil {
+		t.Fatalf("signAsymmetric: %v", err)
+	}
+	if n := requests() - before; n != 1 {
+		t.Errorf("signAsymmetric with WithKeyAlgorithm sent %d requests, want 1", n)
+	}
+	if err := verifySignatureEC(ctx, client, sig, "message", keyPath); err != nil {
+		t.Errorf("verifySignatureEC: %v", err)
+	}
+
+	// Without it, the key version is looked up first, and the lookup is
+	// retried like any other call.
+	f.mu.Lock()
+	f.failures, f.requests = []int{503}, 0
+	f.mu.Unlock()
+	if _, err := signAsymmetric(ctx, client, "message", keyPath, WithRetryBudget(2, time.Minute)); err != nil {
+		t.Errorf("signAsymmetric after a failed key lookup: %v", err)
+	}
+	if n := requests(); n != 3 {
+		t.Errorf("signAsymmetric sent %d requests, want 3: a failed lookup, the lookup again and the signature", n)
+	}
+
+	// A wrong algorithm is caught when KMS gets a digest the key does not
+	// sign.
+	_, err = signAsymmetric(ctx, client, "message", keyPath, WithKeyAlgorithm("EC_SIGN_P256_SHA256"))
+	if err == nil {
+		t.Error("signAsymmetric with the wrong algorithm: got nil error")
+	}
+	if _, err := signAsymmetric(ctx, client, "message", keyPath, WithKeyAlgorithm("NOT_AN_ALGORITHM")); err == nil {
+		t.Error("signAsymmetric with an unknown algorithm: got nil error")
+	}
+
+	_, err = signDigestWithHash(ctx, client, make([]byte, 32), crypto.SHA384, keyPath)
+	if want := "key requires SHA-384 digest, got 32 bytes"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("signDigestWithHash with a short digest: got %v, want %q", err, want)
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// keyVersionPattern matches the resource name of a CryptoKeyVersion.
+var keyVersionPattern = regexp.MustCompile(
+	`^projects/[^/]+/locations/[^/]+/keyRings/[^/]+/cryptoKeys/[^/]+/cryptoKeyVersions/[^/]+$`)
+
+// validateKeyPath returns ErrInvalidKeyPath, with a hint about the likely
+// mistake, unless keyPath is the resource name of a key version.
+// It is called before any request is sent, so that a typo or an unset
+// environment variable is reported clearly rather than as an obscure 404.
+func validateKeyPath(keyPath string) error {
+	switch {
+	case strings.TrimSpace(keyPath) == "":
+		return fmt.Errorf("%w: key path is empty; check that it is configured", ErrInvalidKeyPath)
+	case strings.TrimSpace(keyPath) != keyPath:
+		return fmt.Errorf("%w: %q has leading or trailing whitespace", ErrInvalidKeyPath, keyPath)
+	case !keyVersionPattern.MatchString(keyPath):
+		return fmt.Errorf("%w: %q is not of the form "+
+			"projects/PROJECT/locations/LOCATION/keyRings/KEY_RING/cryptoKeys/KEY/cryptoKeyVersions/VERSION",
+			ErrInvalidKeyPath, keyPath)
+	}
+	return nil
+}
+
+// keyLocation returns the location segment of a KMS resource name, for example
+// "us-east1" for "projects/p/locations/us-east1/keyRings/r/...".
+func keyLocation(keyPath string) string {
+	parts := strings.Split(keyPath, "/")
+	for i := 0; i+1 < len(parts); i++ {
+		if parts[i] == "locations" {
+			return parts[i+1]
+		}
+	}
+	return ""
+}
+
+// parentKeyPath returns the name of the CryptoKey that owns the key version
+// keyPath, by removing the "/cryptoKeyVersions/N" suffix.
+func parentKeyPath(keyPath string) string {
+	if i := strings.Index(keyPath, "/cryptoKeyVersions/"); i >= 0 {
+		return keyPath[:i]
+	}
+	return keyPath
+}
+
+// logicalKeyID returns a stable identifier for the key version keyPath: the
+// name of its CryptoKey, which stays the same as the key is rotated to new
+// versions. Use it to group logs and metrics by key, alongside keyPath to
+// trace the exact version.
+func logicalKeyID(keyPath string) string {
+	return parentKeyPath(keyPath)
+}
+
+// checkResponseName returns an error unless name, the resource name in a KMS
+// response, is keyPath or a resource beneath it. A mismatch means a proxy or
+// misconfiguration returned a different key than the one requested.
+func checkResponseName(keyPath, name string) error {
+	if name == keyPath || strings.HasPrefix(name, keyPath+"/") {
+		return nil
+	}
+	return fmt.Errorf("requested key %s but KMS returned %q", keyPath, name)
+}
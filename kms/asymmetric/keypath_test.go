@@ -0,0 +1,61 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestValidateKeyPath(t *testing.T) {
+	valid := "projects/p/locations/global/keyRings/r/cryptoKeys/k/cryptoKeyVersions/1"
+	if err := validateKeyPath(valid); err != nil {
+		t.Errorf("validateKeyPath(%q): %v", valid, err)
+	}
+	for _, keyPath := range []string{
+		"",
+		"   ",
+		valid + "\n",
+		"projects/p/locations/global/keyRings/r/cryptoKeys/k",
+		"projects//locations/global/keyRings/r/cryptoKeys/k/cryptoKeyVersions/1",
+	} {
+		if err := validateKeyPath(keyPath); !errors.Is(err, ErrInvalidKeyPath) {
+			t.Errorf("validateKeyPath(%q): got %v; want ErrInvalidKeyPath", keyPath, err)
+		}
+	}
+}
+
+func TestLogicalKeyID(t *testing.T) {
+	const key = "projects/p/locations/global/keyRings/r/cryptoKeys/k"
+	for _, keyPath := range []string{key + "/cryptoKeyVersions/1", key + "/cryptoKeyVersions/27", key} {
+		if got := logicalKeyID(keyPath); got != key {
+			t.Errorf("logicalKeyID(%q) = %q, want %q", keyPath, got, key)
+		}
+	}
+}
+
+func TestCheckResponseName(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("requested")
+	f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+	if _, err := getAsymmetricPublicKey(ctx, client, keyPath); err != nil {
+		t.Fatalf("getAsymmetricPublicKey: %v", err)
+	}
+
+	// Serve another key's name for the requested path, as a misrouting
+	// proxy would.
+	f.mu.Lock()
+	f.keys[keyPath].version.Name = testKeyPath("other")
+	f.mu.Unlock()
+	if _, err := getAsymmetricPublicKey(ctx, client, keyPath); err == nil {
+		t.Error("getAsymmetricPublicKey with mismatched response name: got nil error")
+	}
+	if _, _, err := fetchPublicKey(ctx, client, keyPath); err == nil {
+		t.Error("fetchPublicKey with mismatched response name: got nil error")
+	}
+}
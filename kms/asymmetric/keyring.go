@@ -0,0 +1,85 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// exportKeyRingPublicKeys returns the PEM-encoded public keys of every
+// enabled version of every signing key in the key ring keyRingPath, such as
+// "projects/PROJECT/locations/LOCATION/keyRings/RING", keyed by version
+// resource name. Versions that are still being generated, or are disabled or
+// destroyed, are left out.
+func exportKeyRingPublicKeys(ctx context.Context, client *cloudkms.Service, keyRingPath string, opts ...Option) (map[string]string, error) {
+	o := newOptions(opts)
+	bundle := make(map[string]string)
+	keysCall := client.Projects.Locations.KeyRings.CryptoKeys.List(keyRingPath)
+	for keysToken := ""; ; {
+		var keys *cloudkms.ListCryptoKeysResponse
+		err := o.kmsCall(ctx, "ListCryptoKeys", keyRingPath, keysCall.Header(), func() (http.Header, error) {
+			var err error
+			keys, err = keysCall.PageToken(keysToken).Context(ctx).Do()
+			if err != nil {
+				return nil, err
+			}
+			return keys.Header, nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to export public keys of %s: %w", keyRingPath, err)
+		}
+		for _, key := range keys.CryptoKeys {
+			if key.Purpose != "ASYMMETRIC_SIGN" {
+				continue
+			}
+			if err := exportKeyPublicKeys(ctx, client, key.Name, bundle, opts...); err != nil {
+				return nil, fmt.Errorf("failed to export public keys of %s: %w", keyRingPath, err)
+			}
+		}
+		if keysToken = keys.NextPageToken; keysToken == "" {
+			break
+		}
+	}
+	return bundle, nil
+}
+
+// exportKeyPublicKeys adds the PEM-encoded public keys of every enabled
+// version of the key keyName to bundle, for exportKeyRingPublicKeys.
+func exportKeyPublicKeys(ctx context.Context, client *cloudkms.Service, keyName string, bundle map[string]string, opts ...Option) error {
+	o := newOptions(opts)
+	call := client.Projects.Locations.KeyRings.CryptoKeys.CryptoKeyVersions.
+		List(keyName).Filter("state=ENABLED")
+	for pageToken := ""; ; {
+		var versions *cloudkms.ListCryptoKeyVersionsResponse
+		err := o.kmsCall(ctx, "ListCryptoKeyVersions", keyName, call.Header(), func() (http.Header, error) {
+			var err error
+			versions, err = call.PageToken(pageToken).Context(ctx).Do()
+			if err != nil {
+				return nil, err
+			}
+			return versions.Header, nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list versions of %s: %w", keyName, err)
+		}
+		for _, version := range versions.CryptoKeyVersions {
+			if version.State != "ENABLED" {
+				continue
+			}
+			response, _, err := fetchPublicKey(ctx, client, version.Name, opts...)
+			if err != nil {
+				return err
+			}
+			bundle[version.Name] = response.Pem
+		}
+		if pageToken = versions.NextPageToken; pageToken == "" {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestExportKeyRingPublicKeys(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyRingPath := "projects/test/locations/global/keyRings/ring"
+	var want []string
+	for _, id := range []string{"a", "b", "c"} {
+		keyPath := testKeyPath("sign-" + id)
+		f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+		want = append(want, keyPath)
+	}
+	generating := parentKeyPath(testKeyPath("sign-a")) + "/cryptoKeyVersions/2"
+	destroyed := parentKeyPath(testKeyPath("sign-a")) + "/cryptoKeyVersions/3"
+	f.addKey(t, generating, "EC_SIGN_P256_SHA256")
+	f.addKey(t, destroyed, "EC_SIGN_P256_SHA256")
+	f.keys[generating].version.State = "PENDING_GENERATION"
+	f.keys[destroyed].version.State = "DESTROYED"
+	f.addKey(t, testKeyPath("decrypt"), "RSA_DECRYPT_OAEP_2048_SHA256")
+
+	bundle, err := exportKeyRingPublicKeys(ctx, client, keyRingPath)
+	if err != nil {
+		t.Fatalf("exportKeyRingPublicKeys: %v", err)
+	}
+	if len(bundle) != len(want) {
+		t.Errorf("got %d keys, want %d: %v", len(bundle), len(want), bundle)
+	}
+	for _, keyPath := range want {
+		if !strings.Contains(bundle[keyPath], "BEGIN PUBLIC KEY") {
+			t.Errorf("bundle[%s] = %q, want a PEM public key", keyPath, bundle[keyPath])
+		}
+	}
+}
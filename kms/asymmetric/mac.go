@@ -0,0 +1,103 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// macSign computes a MAC tag over data with the HMAC key version at keyPath
+// and returns it base64-encoded. The key never leaves KMS.
+func macSign(ctx context.Context, client *cloudkms.Service, data, keyPath string, opts ...Option) (string, error) {
+	if err := validateKeyPath(keyPath); err != nil {
+		return "", err
+	}
+	macSignRequest := &cloudkms.MacSignRequest{
+		Data:       base64.StdEncoding.EncodeToString([]byte(data)),
+		DataCrc32c: int64(crc32c([]byte(data))),
+	}
+	o := newOptions(opts)
+	var response *cloudkms.MacSignResponse
+	err := o.retry(ctx, func() error {
+		call := client.Projects.Locations.KeyRings.CryptoKeys.CryptoKeyVersions.
+			MacSign(keyPath, macSignRequest)
+		return o.kmsCall(ctx, "MacSign", keyPath, call.Header(), func() (http.Header, error) {
+			var err error
+			response, err = call.Context(ctx).Do()
+			if err != nil {
+				return nil, err
+			}
+			return response.Header, nil
+		})
+	})
+	if err != nil {
+		return "", fmt.Errorf("MAC sign request failed: %w", err)
+	}
+	if !response.VerifiedDataCrc32c {
+		return "", o.integrityFailure("MacSign", keyPath, "MAC sign request", false)
+	}
+	mac, err := base64.StdEncoding.DecodeString(response.Mac)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode MAC string: %w", err)
+	}
+	if int64(crc32c(mac)) != response.MacCrc32c {
+		return "", o.integrityFailure("MacSign", keyPath, "MAC sign response", true)
+	}
+	return response.Mac, nil
+}
+
+// macVerify checks that mac, a base64-encoded tag returned by macSign, is
+// valid for data under the HMAC key version at keyPath. It returns an error
+// wrapping ErrMACInvalid if the tag does not match. The comparison is done by
+// KMS.
+func macVerify(ctx context.Context, client *cloudkms.Service, data, mac, keyPath string, opts ...Option) error {
+	if err := validateKeyPath(keyPath); err != nil {
+		return err
+	}
+	macBytes, err := decodeBase64(mac)
+	if err != nil {
+		return fmt.Errorf("failed to decode MAC string: %w", err)
+	}
+	macVerifyRequest := &cloudkms.MacVerifyRequest{
+		Data:       base64.StdEncoding.EncodeToString([]byte(data)),
+		DataCrc32c: int64(crc32c([]byte(data))),
+		Mac:        mac,
+		MacCrc32c:  int64(crc32c(macBytes)),
+	}
+	o := newOptions(opts)
+	var response *cloudkms.MacVerifyResponse
+	err = o.retry(ctx, func() error {
+		call := client.Projects.Locations.KeyRings.CryptoKeys.CryptoKeyVersions.
+			MacVerify(keyPath, macVerifyRequest)
+		return o.kmsCall(ctx, "MacVerify", keyPath, call.Header(), func() (http.Header, error) {
+			var err error
+			response, err = call.Context(ctx).Do()
+			if err != nil {
+				return nil, err
+			}
+			return response.Header, nil
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("MAC verify request failed: %w", err)
+	}
+	if !response.VerifiedDataCrc32c || !response.VerifiedMacCrc32c {
+		return o.integrityFailure("MacVerify", keyPath, "MAC verify request", false)
+	}
+	// VerifiedSuccessIntegrity repeats Success so that a corrupted verdict
+	// can be detected.
+	if response.VerifiedSuccessIntegrity != response.Success {
+		return o.integrityFailure("MacVerify", keyPath, "MAC verify response", true)
+	}
+	if !response.Success {
+		return ErrMACInvalid
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestMACSignVerify(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("hmac")
+	f.addMACKey(t, keyPath)
+
+	mac, err := macSign(ctx, client, "data", keyPath)
+	if err != nil {
+		t.Fatalf("macSign: %v", err)
+	}
+	if err := macVerify(ctx, client, "data", mac, keyPath); err != nil {
+		t.Errorf("macVerify: %v", err)
+	}
+	if err := macVerify(ctx, client, "tampered", mac, keyPath); !errors.Is(err, ErrMACInvalid) {
+		t.Errorf("macVerify with wrong data: got %v, want ErrMACInvalid", err)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/hex"
+	"fmt"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// A ManifestEntry is one artifact in a release manifest: a file, its SHA-256
+// digest in hex, and a signature over that digest made with signDigest.
+type ManifestEntry struct {
+	File      string `json:"file"`
+	SHA256    string `json:"sha256"`
+	Signature string `json:"signature"`
+}
+
+// A ManifestResult is the outcome of verifying one ManifestEntry. Err is nil
+// if the entry's signature is valid.
+type ManifestResult struct {
+	File string
+	Err  error
+}
+
+// verifyManifest verifies the signature of every entry in a release manifest
+// against the key version at keyPath, treating each listed digest as
+// precomputed. The files themselves are not read. It returns one result per
+// entry, in order; the error is non-nil only if the key could not be used,
+// in which case no entries were checked.
+func verifyManifest(ctx context.Context, client *cloudkms.Service, entries []ManifestEntry, keyPath string, opts ...Option) ([]ManifestResult, error) {
+	o := newOptions(opts)
+	alg, err := getKeyAlgorithm(ctx, client, keyPath, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if alg.Hash != crypto.SHA256 {
+		return nil, fmt.Errorf("%w: %s signs %v digests, not SHA-256", ErrKeyTypeMismatch, keyPath, alg.Hash)
+	}
+	publicKey, err := o.getPublicKey(ctx, client, keyPath)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]ManifestResult, len(entries))
+	for i, entry := range entries {
+		results[i] = ManifestResult{File: entry.File, Err: verifyManifestEntry(o, publicKey, alg, entry)}
+	}
+	return results, nil
+}
+
+// verifyManifestEntry checks the signature of a single entry.
+func verifyManifestEntry(o *options, publicKey crypto.PublicKey, alg AlgorithmInfo, entry ManifestEntry) error {
+	digest, err := hex.DecodeString(entry.SHA256)
+	if err != nil || len(digest) != crypto.SHA256.Size() {
+		return fmt.Errorf("invalid sha256 %q", entry.SHA256)
+	}
+	signature, err := o.decodeSignature(entry.Signature)
+	if err != nil {
+		return err
+	}
+	return verifyDigest(publicKey, alg, digest, signature)
+}
+
+// verifyDigest checks signature over a precomputed digest using publicKey
+// and the padding of the KMS algorithm alg. The digest must be exactly as
+// long as the algorithm's hash.
+func verifyDigest(publicKey crypto.PublicKey, alg AlgorithmInfo, digest, signature []byte) error {
+	return verifyDigestLength(publicKey, alg, digest, signature, false)
+}
+
+// verifyDigestLength is like verifyDigest, but also accepts an ECDSA
+// signature over a truncated digest if allowTruncated is set.
+func verifyDigestLength(publicKey crypto.PublicKey, alg AlgorithmInfo, digest, signature []byte, allowTruncated bool) error {
+	// Fail closed: an algorithm that does not sign digests, or a key that
+	// does not fit alg, is an error rather than something to verify anyway.
+	if alg.Purpose != "ASYMMETRIC_SIGN" || alg.Hash == 0 || !alg.Hash.Available() {
+		return fmt.Errorf("%w: %s does not sign digests", ErrKeyTypeMismatch, alg.Name)
+	}
+	if err := checkKeyAlgorithm(publicKey, alg); err != nil {
+		return err
+	}
+	_, isEC := publicKey.(*ecdsa.PublicKey)
+	if err := checkDigestLength(digest, alg.Hash, allowTruncated && isEC); err != nil {
+		return err
+	}
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		var err error
+		switch alg.Padding {
+		case "PSS":
+			err = rsa.VerifyPSS(key, alg.Hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		case "PKCS1":
+			err = rsa.VerifyPKCS1v15(key, alg.Hash, digest, signature)
+		default:
+			return fmt.Errorf("%w: %s is not an RSA signing algorithm", ErrKeyTypeMismatch, alg.Name)
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrSignatureInvalid, err)
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		r, s, err := parseECDSASignature(signature, key.Curve)
+		if err != nil {
+			return err
+		}
+		if !ecdsa.Verify(key, digest, r, s) {
+			return ErrSignatureInvalid
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: unsupported public key type %T", ErrKeyTypeMismatch, publicKey)
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestVerifyManifest(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	for _, alg := range []string{"RSA_SIGN_PSS_2048_SHA256", "RSA_SIGN_PKCS1_2048_SHA256", "EC_SIGN_P256_SHA256"} {
+		keyPath := testKeyPath(alg)
+		f.addKey(t, keyPath, alg)
+
+		var entries []ManifestEntry
+		for _, file := range []string{"a.tar.gz", "b.tar.gz"} {
+			digest := sha256.Sum256([]byte(file))
+			signature, err := signDigest(ctx, client, digest[:], keyPath)
+			if err != nil {
+				t.Fatalf("%s: signDigest: %v", alg, err)
+			}
+			entries = append(entries, ManifestEntry{File: file, SHA256: hex.EncodeToString(digest[:]), Signature: signature})
+		}
+		// An entry carrying the signature of a different file.
+		tampered := sha256.Sum256([]byte("c.tar.gz"))
+		entries = append(entries, ManifestEntry{File: "c.tar.gz", SHA256: hex.EncodeToString(tampered[:]), Signature: entries[0].Signature})
+
+		results, err := verifyManifest(ctx, client, entries, keyPath)
+		if err != nil {
+			t.Fatalf("%s: verifyManifest: %v", alg, err)
+		}
+		if len(results) != len(entries) {
+			t.Fatalf("%s: got %d results, want %d", alg, len(results), len(entries))
+		}
+		for i, result := range results[:2] {
+			if result.File != entries[i].File || result.Err != nil {
+				t.Errorf("%s: result %d = %+v, want %s to verify", alg, i, result, entries[i].File)
+			}
+		}
+		if err := results[2].Err; !errors.Is(err, ErrSignatureInvalid) {
+			t.Errorf("%s: tampered entry: got %v, want ErrSignatureInvalid", alg, err)
+		}
+	}
+}
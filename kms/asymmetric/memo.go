@@ -0,0 +1,145 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// defaultMemoEntries is the number of signatures a MemoizingSigner keeps
+// unless told otherwise. A signature is at most 512 bytes, plus the digest
+// and bookkeeping, so the default bounds the cache to about 1 MiB.
+const defaultMemoEntries = 1024
+
+// A MemoizingSigner signs messages with one KMS key and remembers the
+// signatures, so that signing a message again, as a retried request does,
+// returns the same signature without calling KMS. Signatures are kept by the
+// SHA-256 digest of the message, and the least recently used one is evicted
+// once the cache is full. Concurrent requests to sign the same message share
+// one KMS call. Failures are not cached. A MemoizingSigner is safe for
+// concurrent use.
+//
+// ECDSA signatures are randomized, so without the cache each request would
+// get a different, equally valid signature; with it, duplicates get
+// identical ones.
+type MemoizingSigner struct {
+	client     *cloudkms.Service
+	keyPath    string
+	maxEntries int
+	opts       []Option
+
+	mu       sync.Mutex
+	lru      *list.List // of *memoEntry, most recently used first
+	entries  map[[sha256.Size]byte]*list.Element
+	inFlight map[[sha256.Size]byte]*memoCall
+	clears   int // number of calls to Clear, so calls from before one are not cached
+}
+
+type memoEntry struct {
+	digest    [sha256.Size]byte
+	signature string
+}
+
+// memoCall is a KMS call in progress, which waiters for the same message
+// share.
+type memoCall struct {
+	done      chan struct{}
+	signature string
+	err       error
+}
+
+// newMemoizingSigner returns a MemoizingSigner for the key version at
+// keyPath that keeps up to maxEntries signatures, or defaultMemoEntries if
+// maxEntries is not positive. opts are used for every signing request.
+func newMemoizingSigner(client *cloudkms.Service, keyPath string, maxEntries int, opts ...Option) (*MemoizingSigner, error) {
+	if err := validateKeyPath(keyPath); err != nil {
+		return nil, err
+	}
+	if maxEntries <= 0 {
+		maxEntries = defaultMemoEntries
+	}
+	return &MemoizingSigner{
+		client:     client,
+		keyPath:    keyPath,
+		maxEntries: maxEntries,
+		opts:       opts,
+		lru:        list.New(),
+		entries:    make(map[[sha256.Size]byte]*list.Element),
+		inFlight:   make(map[[sha256.Size]byte]*memoCall),
+	}, nil
+}
+
+// Sign returns the base64-encoded signature of message, from the cache if
+// message has been signed before and otherwise from KMS, like
+// signAsymmetric. If ctx is done while Sign waits for another caller's
+// request for the same message, it returns ctx's error and leaves that
+// request running.
+func (m *MemoizingSigner) Sign(ctx context.Context, message string) (string, error) {
+	digest := sha256.Sum256([]byte(message))
+	m.mu.Lock()
+	if e, ok := m.entries[digest]; ok {
+		m.lru.MoveToFront(e)
+		m.mu.Unlock()
+		return e.Value.(*memoEntry).signature, nil
+	}
+	if call, ok := m.inFlight[digest]; ok {
+		m.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.signature, call.err
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+	call := &memoCall{done: make(chan struct{})}
+	m.inFlight[digest] = call
+	clears := m.clears
+	m.mu.Unlock()
+
+	call.signature, call.err = signAsymmetric(ctx, m.client, message, m.keyPath, m.opts...)
+
+	m.mu.Lock()
+	delete(m.inFlight, digest)
+	if call.err == nil && m.clears == clears {
+		m.add(digest, call.signature)
+	}
+	m.mu.Unlock()
+	close(call.done)
+	return call.signature, call.err
+}
+
+// add caches signature for digest, evicting the least recently used
+// signature if the cache is full. m.mu must be held.
+func (m *MemoizingSigner) add(digest [sha256.Size]byte, signature string) {
+	m.entries[digest] = m.lru.PushFront(&memoEntry{digest: digest, signature: signature})
+	for m.lru.Len() > m.maxEntries {
+		oldest := m.lru.Back()
+		m.lru.Remove(oldest)
+		delete(m.entries, oldest.Value.(*memoEntry).digest)
+	}
+}
+
+// Len returns the number of cached signatures.
+func (m *MemoizingSigner) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lru.Len()
+}
+
+// Clear empties the cache, for example after the key version is disabled,
+// so that its signatures are no longer handed out. Requests already in
+// flight still complete, but their signatures are not cached.
+func (m *MemoizingSigner) Clear() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clears++
+	m.lru.Init()
+	m.entries = make(map[[sha256.Size]byte]*list.Element)
+}
@@ -0,0 +1,107 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestMemoizingSigner(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("ec-sign")
+	f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+	requests := func() int {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return f.requests
+	}
+
+	m, err := newMemoizingSigner(client, keyPath, 2)
+	if err != nil {
+		t.Fatalf("newMemoizingSigner: %v", err)
+	}
+	before := requests()
+	first, err := m.Sign(ctx, "a")
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	perSign := requests() - before
+	if err := verifySignatureEC(ctx, client, first, "a", keyPath); err != nil {
+		t.Errorf("verifySignatureEC: %v", err)
+	}
+	before = requests()
+	if again, err := m.Sign(ctx, "a"); err != nil || again != first {
+		t.Errorf("second Sign(a) = %q, %v; want the cached %q", again, err, first)
+	}
+	if n := requests() - before; n != 0 {
+		t.Errorf("cache hit made %d KMS requests, want 0", n)
+	}
+
+	// With room for two, signing b and c evicts a, the least recently used.
+	for _, message := range []string{"b", "c"} {
+		if _, err := m.Sign(ctx, message); err != nil {
+			t.Fatalf("Sign(%s): %v", message, err)
+		}
+	}
+	if n := m.Len(); n != 2 {
+		t.Errorf("Len = %d, want 2", n)
+	}
+	before = requests()
+	if _, err := m.Sign(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if n := requests() - before; n != perSign {
+		t.Errorf("Sign of an evicted message made %d KMS requests, want %d", n, perSign)
+	}
+
+	// Concurrent duplicates share one KMS call.
+	before = requests()
+	var wg sync.WaitGroup
+	signatures := make([]string, 20)
+	for i := range signatures {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			signatures[i], _ = m.Sign(ctx, "d")
+		}(i)
+	}
+	wg.Wait()
+	if n := requests() - before; n != perSign {
+		t.Errorf("20 concurrent Sign(d) made %d KMS requests, want %d", n, perSign)
+	}
+	for i, signature := range signatures {
+		if signature != signatures[0] || signature == "" {
+			t.Fatalf("concurrent Sign(d) %d returned %q, want %q", i, signature, signatures[0])
+		}
+	}
+
+	m.Clear()
+	if n := m.Len(); n != 0 {
+		t.Errorf("Len after Clear = %d, want 0", n)
+	}
+	before = requests()
+	if _, err := m.Sign(ctx, "d"); err != nil {
+		t.Fatal(err)
+	}
+	if n := requests() - before; n != perSign {
+		t.Errorf("Sign after Clear made %d KMS requests, want %d", n, perSign)
+	}
+
+	// Failures are not cached.
+	f.mu.Lock()
+	f.failures = []int{http.StatusForbidden}
+	f.mu.Unlock()
+	if _, err := m.Sign(ctx, "e"); err == nil {
+		t.Fatal("Sign with a failing KMS succeeded")
+	}
+	if _, err := m.Sign(ctx, "e"); err != nil {
+		t.Errorf("Sign after a failure: %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// The Merkle trees here use SHA-256 with the domain separation of RFC 6962:
+// a leaf hashes as SHA-256(0x00 || leaf) and an inner node as
+// SHA-256(0x01 || a || b), so a leaf can never pass for a node. Unlike RFC
+// 6962, the two children of a node are ordered by value, smaller first,
+// rather than by position, so a proof is just the list of sibling hashes
+// from the leaf up, with no leaf index. A node without a sibling on its
+// level moves up unchanged.
+
+func merkleLeafHash(leaf []byte) []byte {
+	sum := sha256.Sum256(append([]byte{0}, leaf...))
+	return sum[:]
+}
+
+func merkleNodeHash(a, b []byte) []byte {
+	if bytes.Compare(a, b) > 0 {
+		a, b = b, a
+	}
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(a)
+	h.Write(b)
+	return h.Sum(nil)
+}
+
+// merkleRoot returns the root of the tree over leaves. Sign it with
+// signAsymmetric to cover every leaf with one KMS request.
+func merkleRoot(leaves [][]byte) ([]byte, error) {
+	levels, err := merkleLevels(leaves)
+	if err != nil {
+		return nil, err
+	}
+	return levels[len(levels)-1][0], nil
+}
+
+// merkleProof returns the inclusion proof of leaves[i], for
+// verifyMerkleInclusion.
+func merkleProof(leaves [][]byte, i int) ([][]byte, error) {
+	if i < 0 || i >= len(leaves) {
+		return nil, fmt.Errorf("leaf %d out of range [0, %d)", i, len(leaves))
+	}
+	levels, err := merkleLevels(leaves)
+	if err != nil {
+		return nil, err
+	}
+	var proof [][]byte
+	for _, level := range levels[:len(levels)-1] {
+		if sibling := i ^ 1; sibling < len(level) {
+			proof = append(proof, level[sibling])
+		}
+		i /= 2
+	}
+	return proof, nil
+}
+
+// merkleLevels returns the hashes of each level of the tree over leaves,
+// from the leaf hashes up to the root.
+func merkleLevels(leaves [][]byte) ([][][]byte, error) {
+	if len(leaves) == 0 {
+		return nil, errors.New("a Merkle tree needs at least one leaf")
+	}
+	level := make([][]byte, len(leaves))
+	for i, leaf := range leaves {
+		level[i] = merkleLeafHash(leaf)
+	}
+	levels := [][][]byte{level}
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			next = append(next, merkleNodeHash(level[i], level[i+1]))
+		}
+		levels = append(levels, next)
+		level = next
+	}
+	return levels, nil
+}
+
+// verifyMerkleInclusion checks that leaf is in the Merkle tree with the given
+// root, using proof from merkleProof, and then that signature is a valid
+// signature over root by the key at keyPath. A bad proof fails with
+// ErrMerkleProofInvalid before KMS is contacted; a bad signature over the
+// root fails with ErrSignatureInvalid.
+func verifyMerkleInclusion(ctx context.Context, client *cloudkms.Service, signature string, root []byte, leaf []byte, proof [][]byte, keyPath string, opts ...Option) error {
+	node := merkleLeafHash(leaf)
+	for i, sibling := range proof {
+		if len(sibling) != sha256.Size {
+			return fmt.Errorf("%w: proof element %d is %d bytes; want %d", ErrMerkleProofInvalid, i, len(sibling), sha256.Size)
+		}
+		node = merkleNodeHash(node, sibling)
+	}
+	if subtle.ConstantTimeCompare(node, root) != 1 {
+		return fmt.Errorf("%w: proof does not lead to the root", ErrMerkleProofInvalid)
+	}
+	return verifySignature(ctx, client, signature, root, keyPath, opts...)
+}
@@ -0,0 +1,59 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestVerifyMerkleInclusion(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("ec-sign")
+	f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+
+	for _, n := range []int{1, 2, 5, 8} {
+		var leaves [][]byte
+		for i := 0; i < n; i++ {
+			leaves = append(leaves, []byte(fmt.Sprintf("item %d", i)))
+		}
+		root, err := merkleRoot(leaves)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature, err := signAsymmetric(ctx, client, string(root), keyPath)
+		if err != nil {
+			t.Fatalf("signAsymmetric: %v", err)
+		}
+		for i, leaf := range leaves {
+			proof, err := merkleProof(leaves, i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := verifyMerkleInclusion(ctx, client, signature, root, leaf, proof, keyPath); err != nil {
+				t.Errorf("%d leaves, leaf %d: %v", n, i, err)
+			}
+			if err := verifyMerkleInclusion(ctx, client, signature, root, []byte("forged"), proof, keyPath); !errors.Is(err, ErrMerkleProofInvalid) {
+				t.Errorf("%d leaves, forged leaf %d: got %v, want ErrMerkleProofInvalid", n, i, err)
+			}
+		}
+	}
+
+	// A valid proof under a root the key did not sign.
+	leaves := [][]byte{[]byte("a"), []byte("b")}
+	root, _ := merkleRoot(leaves)
+	proof, _ := merkleProof(leaves, 0)
+	signature, err := signAsymmetric(ctx, client, "another root", keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyMerkleInclusion(ctx, client, signature, root, leaves[0], proof, keyPath); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("unsigned root: got %v, want ErrSignatureInvalid", err)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// A MultiSignature holds signatures over one message by several keys, as
+// made by signMulti: it maps the resource name of each key version that
+// signed to its base64-encoded signature. It marshals to a compact JSON
+// object.
+type MultiSignature map[string]string
+
+// signMulti signs message with each of the key versions at keyPaths, for
+// quorum signing with verifyMulti. It fails if any key cannot sign, as a
+// partial MultiSignature could fall short of the quorum without saying so.
+func signMulti(ctx context.Context, client *cloudkms.Service, message string, keyPaths []string, opts ...Option) (MultiSignature, error) {
+	multi := make(MultiSignature, len(keyPaths))
+	for _, keyPath := range keyPaths {
+		if _, dup := multi[keyPath]; dup {
+			return nil, fmt.Errorf("key %s is listed twice", keyPath)
+		}
+		signature, err := signAsymmetric(ctx, client, message, keyPath, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("signing with %s: %w", keyPath, err)
+		}
+		multi[keyPath] = signature
+	}
+	return multi, nil
+}
+
+// verifyMulti checks that at least threshold of the key versions at
+// keyPaths, such as 2 of 3 release signers, have a valid signature over
+// message in multi. Signatures in multi by keys not in keyPaths are ignored,
+// so that nobody can make up a quorum with keys of their own, and versions
+// of the same key count once, as they have the same owners.
+//
+// If too few signatures are valid, the error wraps ErrSignatureInvalid and
+// the errors of the signatures that failed.
+func verifyMulti(ctx context.Context, client *cloudkms.Service, multi MultiSignature, message string, keyPaths []string, threshold int, opts ...Option) error {
+	if threshold <= 0 || threshold > len(keyPaths) {
+		return fmt.Errorf("threshold %d is not between 1 and the %d keys", threshold, len(keyPaths))
+	}
+	valid := make(map[string]bool)
+	var errs []error
+	for _, keyPath := range keyPaths {
+		signature, ok := multi[keyPath]
+		if !ok {
+			continue
+		}
+		if err := verifySignature(ctx, client, signature, []byte(message), keyPath, opts...); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", keyPath, err))
+			continue
+		}
+		valid[logicalKeyID(keyPath)] = true
+	}
+	if len(valid) >= threshold {
+		return nil
+	}
+	var signers []string
+	for key := range valid {
+		signers = append(signers, key)
+	}
+	sort.Strings(signers)
+	err := fmt.Errorf("%w: %d of the required %d keys signed, %v", ErrSignatureInvalid, len(valid), threshold, signers)
+	if len(errs) > 0 {
+		err = fmt.Errorf("%w: %w", err, errors.Join(errs...))
+	}
+	return err
+}
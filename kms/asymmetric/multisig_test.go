@@ -0,0 +1,87 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestSignVerifyMulti(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	// The fake shares keys between keys of one algorithm, so each signer
+	// gets its own.
+	var keyPaths []string
+	for i, alg := range []string{"EC_SIGN_P256_SHA256", "EC_SIGN_P384_SHA384", "RSA_SIGN_PSS_2048_SHA256"} {
+		keyPath := testKeyPath(fmt.Sprintf("release-%d", i+1))
+		f.addKey(t, keyPath, alg)
+		keyPaths = append(keyPaths, keyPath)
+	}
+	outsider := testKeyPath("outsider")
+	f.addKey(t, outsider, "RSA_SIGN_PKCS1_2048_SHA256")
+	const message = "v1.2.3"
+
+	multi, err := signMulti(ctx, client, message, keyPaths[:2])
+	if err != nil {
+		t.Fatalf("signMulti: %v", err)
+	}
+	if len(multi) != 2 {
+		t.Fatalf("signMulti returned %d signatures, want 2", len(multi))
+	}
+	if err := verifyMulti(ctx, client, multi, message, keyPaths, 2); err != nil {
+		t.Errorf("verifyMulti 2 of 3: %v", err)
+	}
+	if err := verifyMulti(ctx, client, multi, message, keyPaths, 3); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("verifyMulti 3 of 3 with two signatures: got %v, want ErrSignatureInvalid", err)
+	}
+
+	// The structure survives a JSON round trip.
+	data, err := json.Marshal(multi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded MultiSignature
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyMulti(ctx, client, decoded, message, keyPaths, 2); err != nil {
+		t.Errorf("verifyMulti after a JSON round trip: %v", err)
+	}
+
+	// A bad signature, an outsider's signature and a second version of a
+	// key that already signed do not count towards the quorum.
+	outsiderSig, err := signAsymmetric(ctx, client, message, outsider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondVersion := parentKeyPath(keyPaths[0]) + "/cryptoKeyVersions/2"
+	f.addKey(t, secondVersion, "EC_SIGN_P256_SHA256")
+	secondSig, err := signAsymmetric(ctx, client, message, secondVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := MultiSignature{keyPaths[0]: multi[keyPaths[0]], keyPaths[1]: multi[keyPaths[0]], outsider: outsiderSig}
+	if err := verifyMulti(ctx, client, forged, message, append(keyPaths, secondVersion), 2); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("forged quorum: got %v, want ErrSignatureInvalid", err)
+	}
+	forged[secondVersion] = secondSig
+	if err := verifyMulti(ctx, client, forged, message, append(keyPaths, secondVersion), 2); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("quorum of two versions of one key: got %v, want ErrSignatureInvalid", err)
+	}
+
+	for _, threshold := range []int{0, 4} {
+		if err := verifyMulti(ctx, client, multi, message, keyPaths, threshold); err == nil {
+			t.Errorf("threshold %d of 3: got nil error", threshold)
+		}
+	}
+	if _, err := signMulti(ctx, client, message, []string{keyPaths[0], keyPaths[0]}); err == nil {
+		t.Error("signMulti with a key listed twice: got nil error")
+	}
+}
@@ -0,0 +1,29 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import "golang.org/x/text/unicode/norm"
+
+// WithUnicodeNormalization makes verification hash the message after
+// normalizing it to form, such as norm.NFC, so that text that looks the same
+// but was entered with precomposed characters on one side and combining
+// marks on the other still verifies. signAsymmetric normalizes the same way
+// when given it. The signer and the verifier must use the same form: a
+// signature over the NFC form of a text does not verify against its NFD
+// form. Without this option messages are hashed exactly as given.
+func WithUnicodeNormalization(form norm.Form) Option {
+	return func(o *options) {
+		o.normalize = true
+		o.normForm = form
+	}
+}
+
+// normalizeMessage applies WithUnicodeNormalization to message.
+func (o *options) normalizeMessage(message []byte) []byte {
+	if !o.normalize {
+		return message
+	}
+	return o.normForm.Bytes(message)
+}
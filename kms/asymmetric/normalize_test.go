@@ -0,0 +1,66 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/net/context"
+	"golang.org/x/text/unicode/norm"
+)
+
+func TestWithUnicodeNormalization(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	rsaPath := testKeyPath("normalize-rsa")
+	ecPath := testKeyPath("normalize-ec")
+	f.addKey(t, rsaPath, "RSA_SIGN_PSS_2048_SHA256")
+	f.addKey(t, ecPath, "EC_SIGN_P256_SHA256")
+	composed := "caf\u00e9"    // é as one code point
+	decomposed := "cafe\u0301" // e followed by a combining acute accent
+	if composed == decomposed || norm.NFC.String(decomposed) != composed {
+		t.Fatal("test strings are not two forms of the same text")
+	}
+	nfc := WithUnicodeNormalization(norm.NFC)
+
+	for _, keyPath := range []string{rsaPath, ecPath} {
+		signature, err := signAsymmetric(ctx, client, decomposed, keyPath, nfc)
+		if err != nil {
+			t.Fatalf("signAsymmetric: %v", err)
+		}
+		for _, message := range []string{composed, decomposed} {
+			if err := verifySignature(ctx, client, signature, []byte(message), keyPath, nfc); err != nil {
+				t.Errorf("%s: verifying %q with NFC: %v", keyPath, message, err)
+			}
+		}
+		// Without the option, or with another form, the bytes differ.
+		if err := verifySignature(ctx, client, signature, []byte(decomposed), keyPath); !errors.Is(err, ErrSignatureInvalid) {
+			t.Errorf("%s: verifying the NFD text without normalization: got %v, want ErrSignatureInvalid", keyPath, err)
+		}
+		if err := verifySignature(ctx, client, signature, []byte(composed), keyPath, WithUnicodeNormalization(norm.NFD)); !errors.Is(err, ErrSignatureInvalid) {
+			t.Errorf("%s: verifying with NFD: got %v, want ErrSignatureInvalid", keyPath, err)
+		}
+	}
+
+	response, _, err := fetchPublicKey(ctx, client, ecPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := signAsymmetric(ctx, client, composed, ecPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyWithHint(response.Pem, signature, decomposed, "EC_SIGN_P256_SHA256", nfc); err != nil {
+		t.Errorf("verifyWithHint with NFC: %v", err)
+	}
+	verify, err := newVerifier(ctx, client, ecPath, nfc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verify(signature, decomposed); err != nil {
+		t.Errorf("newVerifier with NFC: %v", err)
+	}
+}
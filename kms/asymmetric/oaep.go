@@ -0,0 +1,184 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// KMS decrypts RSA_DECRYPT_OAEP_* keys with the hash named in the algorithm,
+// such as SHA-256 for RSA_DECRYPT_OAEP_2048_SHA256, used both as the OAEP
+// digest and for MGF1, and with an empty label. AsymmetricDecrypt takes no
+// label, so ciphertexts made with any other label, or with a different MGF1
+// hash, can never be decrypted by KMS. encryptRSA always picks the key's hash
+// and an empty label; WithOAEPOptions lets callers that need specific
+// parameters fail early instead of producing such ciphertexts.
+
+// WithOAEPOptions makes encryptRSA check that the OAEP parameters in opts
+// are the ones KMS uses for the key, returning an error wrapping
+// ErrUnsupportedOAEP if they are not. A zero Hash or MGFHash means the key's
+// hash.
+func WithOAEPOptions(opts *rsa.OAEPOptions) Option {
+	return func(o *options) {
+		o.oaep = opts
+	}
+}
+
+// oaepHash returns the hash to encrypt with for a key using the KMS
+// algorithm alg.
+func (o *options) oaepHash(alg string) (crypto.Hash, error) {
+	info, ok := lookupAlgorithm(alg)
+	if !ok || info.Padding != "OAEP" {
+		return 0, fmt.Errorf("%w: %s is not an OAEP decryption algorithm", ErrKeyTypeMismatch, alg)
+	}
+	if o.oaep == nil {
+		return info.Hash, nil
+	}
+	if o.oaep.Hash != 0 && o.oaep.Hash != info.Hash {
+		return 0, fmt.Errorf("%w: %s keys use %v, not %v", ErrUnsupportedOAEP, alg, info.Hash, o.oaep.Hash)
+	}
+	if o.oaep.MGFHash != 0 && o.oaep.MGFHash != info.Hash {
+		return 0, fmt.Errorf("%w: %s keys use %v for MGF1, not %v", ErrUnsupportedOAEP, alg, info.Hash, o.oaep.MGFHash)
+	}
+	if len(o.oaep.Label) > 0 {
+		return 0, fmt.Errorf("%w: KMS does not support OAEP labels", ErrUnsupportedOAEP)
+	}
+	return info.Hash, nil
+}
+
+// oaepHint adds a likely cause to err, a failed decryption with the key at
+// keyPath, when KMS rejected the ciphertext itself. Ciphertexts made with the
+// wrong OAEP hash, such as SHA-256 for a SHA-512 key or the SHA-1 default of
+// many tools, are the most common cause, and KMS reports them only as an
+// invalid argument. The key's algorithm is looked up to name the hash it
+// expects; if that fails, err is returned unchanged. withoutOAEPHint skips
+// the lookup.
+func oaepHint(ctx context.Context, client *cloudkms.Service, keyPath string, err error, opts ...Option) error {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusBadRequest {
+		return err
+	}
+	if newOptions(opts).skipOAEPHint {
+		return err
+	}
+	version, lookupErr := getKeyVersion(ctx, client, keyPath, opts...)
+	if lookupErr != nil {
+		return err
+	}
+	info, ok := lookupAlgorithm(version.Algorithm)
+	if !ok || info.Padding != "OAEP" {
+		return err
+	}
+	return fmt.Errorf("%w (ciphertext may have been encrypted with the wrong OAEP hash for this %v key; %s requires %v for both OAEP and MGF1)", err, info.Hash, info.Name, info.Hash)
+}
+
+// withoutOAEPHint makes a failed decryption return the KMS error without
+// the extra request oaepHint makes, for callers that expect some attempts to
+// fail.
+func withoutOAEPHint() Option {
+	return func(o *options) { o.skipOAEPHint = true }
+}
+
+// An OAEPMigrationResult is the outcome of migrating one ciphertext with
+// migrateOAEPCiphertexts.
+type OAEPMigrationResult struct {
+	// Ciphertext is the base64-encoded ciphertext for the new key version,
+	// or empty if Err is set.
+	Ciphertext string
+	Err        error
+}
+
+// migrateOAEPCiphertexts re-encrypts ciphertexts made for the RSA key
+// version at oldKeyPath for the one at newKeyPath, to complete an upgrade of
+// the OAEP hash, such as from an RSA_DECRYPT_OAEP_2048_SHA1 version to an
+// RSA_DECRYPT_OAEP_2048_SHA256 one, which cannot decrypt the old
+// ciphertexts. KMS decrypts each ciphertext with the old version, under its
+// hash, and it is re-encrypted locally with the new version's public key and
+// hash; the plaintext is overwritten as soon as that is done. The new public
+// key is fetched once, and up to concurrency ciphertexts, or
+// defaultMaxInFlight if concurrency is 0 or less, are migrated at a time.
+//
+// It returns one result per ciphertext, in order. A ciphertext that cannot
+// be migrated, for example because it was not made for oldKeyPath, or
+// because its plaintext exceeds the new algorithm's smaller limit, which
+// fails with ErrPlaintextTooLarge, does not stop the others. Keep the
+// original of any such ciphertext, and the old version, until it is dealt
+// with. The error is non-nil only if newKeyPath cannot be used, in which
+// case nothing is decrypted, or if ctx is done before every ciphertext was
+// started.
+func migrateOAEPCiphertexts(ctx context.Context, client *cloudkms.Service, ciphertexts []string, oldKeyPath, newKeyPath string, concurrency int, opts ...Option) ([]OAEPMigrationResult, error) {
+	o := newOptions(opts)
+	response, publicKey, err := fetchPublicKey(ctx, client, newKeyPath, opts...)
+	if err != nil {
+		return nil, err
+	}
+	alg, ok := lookupAlgorithm(response.Algorithm)
+	if !ok || alg.Padding != "OAEP" {
+		return nil, fmt.Errorf("%w: %s is a %s key, not an OAEP decryption key", ErrKeyTypeMismatch, newKeyPath, response.Algorithm)
+	}
+	rsaKey, ok := publicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: want *rsa.PublicKey, got %T", ErrKeyTypeMismatch, publicKey)
+	}
+	if err := o.checkKeyStrength(rsaKey, newKeyPath); err != nil {
+		return nil, err
+	}
+	if concurrency <= 0 {
+		concurrency = defaultMaxInFlight
+	}
+
+	results := make([]OAEPMigrationResult, len(ciphertexts))
+	var wg sync.WaitGroup
+	inFlight := make(chan struct{}, concurrency)
+	for i, ciphertext := range ciphertexts {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			for j := range results[i:] {
+				results[i+j] = OAEPMigrationResult{Err: ctx.Err()}
+			}
+			return results, ctx.Err()
+		case inFlight <- struct{}{}:
+		}
+		wg.Add(1)
+		go func(result *OAEPMigrationResult, ciphertext string) {
+			defer wg.Done()
+			defer func() { <-inFlight }()
+			result.Ciphertext, result.Err = migrateOAEPCiphertext(ctx, client, ciphertext, oldKeyPath, rsaKey, alg, opts...)
+		}(&results[i], ciphertext)
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// migrateOAEPCiphertext decrypts ciphertext with the key version at
+// oldKeyPath and encrypts the plaintext with newKey, of algorithm newAlg.
+func migrateOAEPCiphertext(ctx context.Context, client *cloudkms.Service, ciphertext, oldKeyPath string, newKey *rsa.PublicKey, newAlg AlgorithmInfo, opts ...Option) (string, error) {
+	_, plaintext, err := decryptRSAFull(ctx, client, ciphertext, oldKeyPath, opts...)
+	if err != nil {
+		return "", err
+	}
+	defer zeroize(plaintext)
+	if len(plaintext) > newAlg.MaxMessageLen {
+		return "", fmt.Errorf("%w: plaintext is %d bytes, but %s encrypts at most %d",
+			ErrPlaintextTooLarge, len(plaintext), newAlg.Name, newAlg.MaxMessageLen)
+	}
+	migrated, err := rsa.EncryptOAEP(newAlg.Hash.New(), rand.Reader, newKey, plaintext, nil)
+	if err != nil {
+		return "", fmt.Errorf("encryption failed: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(migrated), nil
+}
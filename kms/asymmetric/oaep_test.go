@@ -0,0 +1,132 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestEncryptRSAOAEPHash(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	for _, alg := range []string{"RSA_DECRYPT_OAEP_2048_SHA256", "RSA_DECRYPT_OAEP_4096_SHA512", "RSA_DECRYPT_OAEP_2048_SHA1"} {
+		keyPath := testKeyPath(alg)
+		f.addKey(t, keyPath, alg)
+		ciphertext, err := encryptRSA(ctx, client, "secret", keyPath)
+		if err != nil {
+			t.Fatalf("%s: encryptRSA: %v", alg, err)
+		}
+		plaintext, err := decryptRSA(ctx, client, ciphertext, keyPath)
+		if err != nil {
+			t.Fatalf("%s: decryptRSA: %v", alg, err)
+		}
+		if plaintext != "secret" {
+			t.Errorf("%s: decrypted %q, want %q", alg, plaintext, "secret")
+		}
+	}
+}
+
+func TestEncryptRSAUnsupportedOAEP(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("oaep")
+	f.addKey(t, keyPath, "RSA_DECRYPT_OAEP_2048_SHA256")
+
+	if _, err := encryptRSA(ctx, client, "secret", keyPath, WithOAEPOptions(&rsa.OAEPOptions{Hash: crypto.SHA256})); err != nil {
+		t.Errorf("matching options: %v", err)
+	}
+	for name, opts := range map[string]*rsa.OAEPOptions{
+		"hash":  {Hash: crypto.SHA512},
+		"mgf":   {Hash: crypto.SHA256, MGFHash: crypto.SHA1},
+		"label": {Label: []byte("context")},
+	} {
+		_, err := encryptRSA(ctx, client, "secret", keyPath, WithOAEPOptions(opts))
+		if !errors.Is(err, ErrUnsupportedOAEP) {
+			t.Errorf("%s: got %v, want ErrUnsupportedOAEP", name, err)
+		}
+	}
+}
+
+func TestDecryptRSAWrongOAEPHash(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("oaep-sha512")
+	f.addKey(t, keyPath, "RSA_DECRYPT_OAEP_4096_SHA512")
+	publicKey := testPrivateKey(t, "RSA_DECRYPT_OAEP_4096_SHA512").(*rsa.PrivateKey).Public().(*rsa.PublicKey)
+	ciphertext, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKey, []byte("secret"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = decryptRSA(ctx, client, base64.StdEncoding.EncodeToString(ciphertext), keyPath)
+	if err == nil || !strings.Contains(err.Error(), "wrong OAEP hash for this SHA-512 key") {
+		t.Errorf("got %v, want a wrong OAEP hash hint", err)
+	}
+}
+
+func TestMigrateOAEPCiphertexts(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	oldPath := testKeyPath("oaep-sha1")
+	newPath := testKeyPath("oaep-sha256")
+	signPath := testKeyPath("ec-sign")
+	f.addKey(t, oldPath, "RSA_DECRYPT_OAEP_2048_SHA1")
+	f.addKey(t, newPath, "RSA_DECRYPT_OAEP_2048_SHA256")
+	f.addKey(t, signPath, "EC_SIGN_P256_SHA256")
+
+	// 200 bytes fit under SHA-1, whose limit is 214, but not SHA-256, 190.
+	long := strings.Repeat("x", 200)
+	var ciphertexts []string
+	for _, plaintext := range []string{"first", "second", long, "third"} {
+		ciphertext, err := encryptRSA(ctx, client, plaintext, oldPath)
+		if err != nil {
+			t.Fatalf("encryptRSA: %v", err)
+		}
+		ciphertexts = append(ciphertexts, ciphertext)
+	}
+	ciphertexts = append(ciphertexts, "bm90IGEgY2lwaGVydGV4dA==")
+
+	results, err := migrateOAEPCiphertexts(ctx, client, ciphertexts, oldPath, newPath, 2)
+	if err != nil {
+		t.Fatalf("migrateOAEPCiphertexts: %v", err)
+	}
+	if len(results) != len(ciphertexts) {
+		t.Fatalf("got %d results for %d ciphertexts", len(results), len(ciphertexts))
+	}
+	for i, want := range []string{"first", "second", "", "third"} {
+		if want == "" {
+			continue
+		}
+		if results[i].Err != nil {
+			t.Errorf("ciphertext %d: %v", i, results[i].Err)
+			continue
+		}
+		if _, err := decryptRSA(ctx, client, results[i].Ciphertext, oldPath); err == nil {
+			t.Errorf("ciphertext %d: the migrated ciphertext still decrypts with the old version", i)
+		}
+		got, err := decryptRSA(ctx, client, results[i].Ciphertext, newPath)
+		if err != nil || got != want {
+			t.Errorf("ciphertext %d: decrypting with the new version = %q, %v; want %q", i, got, err, want)
+		}
+	}
+	if !errors.Is(results[2].Err, ErrPlaintextTooLarge) || results[2].Ciphertext != "" {
+		t.Errorf("too long for the new version: got %+v, want ErrPlaintextTooLarge", results[2])
+	}
+	if results[4].Err == nil || results[4].Ciphertext != "" {
+		t.Errorf("not a ciphertext for the old version: got %+v, want an error", results[4])
+	}
+
+	if _, err := migrateOAEPCiphertexts(ctx, client, ciphertexts, oldPath, signPath, 0); !errors.Is(err, ErrKeyTypeMismatch) {
+		t.Errorf("migrating to a signing key: got %v, want ErrKeyTypeMismatch", err)
+	}
+}
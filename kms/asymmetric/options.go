@@ -0,0 +1,140 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// An Option configures an optional behavior of the sample functions.
+// Functions ignore options that do not apply to them.
+type Option func(*options)
+
+type options struct {
+	// checkLength is set when expectedLength should be enforced.
+	checkLength    bool
+	expectedLength int
+
+	rejectEmptyMessage bool
+
+	clock             func() time.Time
+	clockSkew         time.Duration
+	checkCertValidity bool
+
+	// requireAudience is set when requiredAudience should be enforced.
+	requireAudience  bool
+	requiredAudience string
+
+	timing *VerifyTiming
+	stats  StatsCollector
+
+	maxAttempts      int
+	maxRetryDuration time.Duration
+
+	signatureEncoding SignatureEncoding
+
+	// setPSSSaltLength is set when pssSaltLength should be used.
+	setPSSSaltLength bool
+	pssSaltLength    int
+
+	requireLowS bool
+
+	keyAlgorithm      string
+	allowedAlgorithms []string
+	// restrictAlgorithms records that WithAllowedAlgorithms was given, so
+	// that an empty list allows nothing.
+	restrictAlgorithms bool
+	minRSABits         int
+	keyFingerprint     string
+	maxKeyAge          time.Duration
+	requireHSM         bool
+
+	// publicKey, if set, is used instead of fetching the key from KMS.
+	publicKey crypto.PublicKey
+	// publicKeyFetched records that publicKey was fetched from KMS by this
+	// package, which already enforced WithRequireHSM for it.
+	publicKeyFetched bool
+
+	headers          http.Header
+	onResponseHeader func(http.Header)
+	onQuota          func(QuotaStatus)
+	quotaTracker     *QuotaTracker
+
+	progress        func(n int64)
+	maxMessageBytes int64
+
+	maxInFlight int
+
+	onIntegrityFailure func(IntegrityFailure)
+
+	oaep         *rsa.OAEPOptions
+	skipOAEPHint bool
+
+	rateLimiter *keyRateLimiter
+
+	maxDecompressedSize int64
+
+	allowTruncatedDigest bool
+
+	// normalize is set when messages should be normalized to normForm.
+	normalize bool
+	normForm  norm.Form
+
+	graceVersions     int
+	onVerifiedVersion func(keyPath string)
+
+	onVerifiedAlgorithm func(alg string)
+	hashDiagnostics     bool
+
+	signRecord   *SignRecord
+	requestToken *string
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// option returns an Option that reproduces o, for passing already-parsed
+// options on to another function.
+func (o *options) option() Option {
+	return func(dst *options) { *dst = *o }
+}
+
+// WithExpectedLength makes verification fail early, before contacting KMS,
+// unless the message is exactly n bytes long.
+func WithExpectedLength(n int) Option {
+	return func(o *options) {
+		o.checkLength = true
+		o.expectedLength = n
+	}
+}
+
+// WithRejectEmptyMessage makes verification fail with ErrEmptyMessage,
+// before contacting KMS, if the message is empty. Without it, an empty
+// message is verified like any other.
+func WithRejectEmptyMessage() Option {
+	return func(o *options) { o.rejectEmptyMessage = true }
+}
+
+// checkMessageLength enforces WithExpectedLength and WithRejectEmptyMessage.
+func (o *options) checkMessageLength(message []byte) error {
+	if o.rejectEmptyMessage && len(message) == 0 {
+		return ErrEmptyMessage
+	}
+	if o.checkLength && len(message) != o.expectedLength {
+		return fmt.Errorf("message is %d bytes; want %d", len(message), o.expectedLength)
+	}
+	return nil
+}
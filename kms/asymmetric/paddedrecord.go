@@ -0,0 +1,68 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// A padded record is a payload padded to a fixed length with the scheme of
+// ISO/IEC 7816-4: a single 0x80 byte, then zero bytes up to the length.
+// Unlike padding with zeros alone, this can always be removed unambiguously,
+// even from a payload that ends in zero bytes, and a payload of exactly the
+// fixed length does not fit, as there is always at least one byte of padding.
+// The signature is over the whole padded record, padding included, so that
+// the padding cannot be changed without invalidating it.
+
+// padRecord pads payload to a record of recordLen bytes.
+func padRecord(payload []byte, recordLen int) ([]byte, error) {
+	if len(payload) >= recordLen {
+		return nil, fmt.Errorf("%w: payload is %d bytes; a %d-byte record holds at most %d", ErrRecordLength, len(payload), recordLen, recordLen-1)
+	}
+	record := make([]byte, recordLen)
+	copy(record, payload)
+	record[len(payload)] = 0x80
+	return record, nil
+}
+
+// unpadRecord returns the payload of the padded record.
+func unpadRecord(record []byte) ([]byte, error) {
+	i := bytes.LastIndexByte(record, 0x80)
+	if i < 0 {
+		return nil, fmt.Errorf("%w: no 0x80 marker", ErrRecordPadding)
+	}
+	for _, b := range record[i+1:] {
+		if b != 0 {
+			return nil, fmt.Errorf("%w: non-zero byte after the 0x80 marker", ErrRecordPadding)
+		}
+	}
+	return record[:i], nil
+}
+
+// verifyPaddedRecord verifies signature over record, a padded record as read
+// from a fixed-length binary format, with the key at keyPath, and returns
+// its payload with the padding removed. A record that is not exactly
+// recordLen bytes fails with ErrRecordLength, and one whose padding is not
+// well formed with ErrRecordPadding, before anything is hashed or fetched.
+func verifyPaddedRecord(ctx context.Context, client *cloudkms.Service, signature string, record []byte, recordLen int, keyPath string, opts ...Option) ([]byte, error) {
+	if recordLen <= 0 {
+		return nil, fmt.Errorf("record length must be positive, got %d", recordLen)
+	}
+	if len(record) != recordLen {
+		return nil, fmt.Errorf("%w: record is %d bytes; want %d", ErrRecordLength, len(record), recordLen)
+	}
+	payload, err := unpadRecord(record)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(ctx, client, signature, record, keyPath, opts...); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
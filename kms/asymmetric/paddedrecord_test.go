@@ -0,0 +1,63 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestVerifyPaddedRecord(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("padded-record")
+	f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+	const recordLen = 32
+	// A payload ending in zero bytes survives the round trip.
+	payload := []byte("amount=10\x00\x00")
+	record, err := padRecord(payload, recordLen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := signAsymmetric(ctx, client, string(record), keyPath)
+	if err != nil {
+		t.Fatalf("signAsymmetric: %v", err)
+	}
+
+	got, err := verifyPaddedRecord(ctx, client, signature, record, recordLen, keyPath)
+	if err != nil {
+		t.Fatalf("verifyPaddedRecord: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Errorf("payload = %q, want %q", got, payload)
+	}
+
+	badPadding := append([]byte(nil), record...)
+	badPadding[recordLen-1] = 1
+	tampered := append([]byte(nil), record...)
+	tampered[0] = 'A'
+	tests := []struct {
+		name   string
+		record []byte
+		want   error
+	}{
+		{"too short", record[:recordLen-1], ErrRecordLength},
+		{"too long", append(record[:recordLen:recordLen], 0), ErrRecordLength},
+		{"bad padding", badPadding, ErrRecordPadding},
+		{"no padding", bytes.Repeat([]byte("x"), recordLen), ErrRecordPadding},
+		{"tampered", tampered, ErrSignatureInvalid},
+	}
+	for _, test := range tests {
+		if _, err := verifyPaddedRecord(ctx, client, signature, test.record, recordLen, keyPath); !errors.Is(err, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.want)
+		}
+	}
+	if _, err := padRecord(bytes.Repeat([]byte("x"), recordLen), recordLen); !errors.Is(err, ErrRecordLength) {
+		t.Errorf("padRecord of a payload with no room for padding: got %v, want ErrRecordLength", err)
+	}
+}
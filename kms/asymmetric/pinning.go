@@ -0,0 +1,79 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// keyFingerprint returns the hex-encoded SHA-256 hash of the DER-encoded
+// SubjectPublicKeyInfo of publicKey. For a PEM public key from KMS it equals
+// the output of
+//
+//	openssl pkey -pubin -outform DER | sha256sum
+func keyFingerprint(publicKey crypto.PublicKey) (string, error) {
+	sum, err := spkiSHA256(publicKey)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(sum), nil
+}
+
+// spkiPinSHA256 returns the standard base64-encoded SHA-256 hash of the
+// DER-encoded SubjectPublicKeyInfo of publicKey: the pin-sha256 value of
+// HTTP public key pinning (RFC 7469) and the pin format of most pinning
+// tools. It equals the output of
+//
+//	openssl pkey -pubin -outform DER | openssl dgst -sha256 -binary | base64
+func spkiPinSHA256(publicKey crypto.PublicKey) (string, error) {
+	sum, err := spkiSHA256(publicKey)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sum), nil
+}
+
+// spkiSHA256 returns the SHA-256 hash of the DER-encoded SubjectPublicKeyInfo
+// of publicKey.
+func spkiSHA256(publicKey crypto.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode public key: %w", err)
+	}
+	sum := sha256.Sum256(der)
+	return sum[:], nil
+}
+
+// WithExpectedKeyFingerprint pins the public key that the verify functions
+// accept. After the key is fetched, its keyFingerprint must equal
+// fingerprint, or verification fails with ErrKeyPinMismatch before the
+// signature is checked. This guards against a different key being served
+// for the key path, for example from a compromised project.
+func WithExpectedKeyFingerprint(fingerprint string) Option {
+	return func(o *options) { o.keyFingerprint = strings.ToLower(fingerprint) }
+}
+
+// checkKeyPin enforces WithExpectedKeyFingerprint for publicKey, the key at
+// keyPath.
+func (o *options) checkKeyPin(publicKey crypto.PublicKey, keyPath string) error {
+	if o.keyFingerprint == "" {
+		return nil
+	}
+	fingerprint, err := keyFingerprint(publicKey)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(fingerprint), []byte(o.keyFingerprint)) != 1 {
+		return fmt.Errorf("%w: %s has fingerprint %s", ErrKeyPinMismatch, keyPath, fingerprint)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestWithExpectedKeyFingerprint(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("ec-sign")
+	f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+	signature, err := signAsymmetric(ctx, client, "message", keyPath)
+	if err != nil {
+		t.Fatalf("signAsymmetric: %v", err)
+	}
+	fingerprint, err := keyFingerprint(testPrivateKey(t, "EC_SIGN_P256_SHA256").Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := keyFingerprint(testPrivateKey(t, "EC_SIGN_P384_SHA384").Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := verifySignatureEC(ctx, client, signature, "message", keyPath, WithExpectedKeyFingerprint(strings.ToUpper(fingerprint))); err != nil {
+		t.Errorf("verifySignatureEC with matching pin: %v", err)
+	}
+	if err := verifySignatureEC(ctx, client, signature, "message", keyPath, WithExpectedKeyFingerprint(other)); !errors.Is(err, ErrKeyPinMismatch) {
+		t.Errorf("verifySignatureEC with wrong pin: got %v, want ErrKeyPinMismatch", err)
+	}
+
+	token := signTestJWT(t, testPrivateKey(t, "EC_SIGN_P256_SHA256").(*ecdsa.PrivateKey), "k1",
+		map[string]interface{}{"exp": time.Now().Unix() + 300})
+	if _, err := verifyJWT(ctx, client, token, keyPath, "", "", WithExpectedKeyFingerprint(fingerprint)); err != nil {
+		t.Errorf("verifyJWT with matching pin: %v", err)
+	}
+	if _, err := verifyJWT(ctx, client, token, keyPath, "", "", WithExpectedKeyFingerprint(other)); !errors.Is(err, ErrKeyPinMismatch) {
+		t.Errorf("verifyJWT with wrong pin: got %v, want ErrKeyPinMismatch", err)
+	}
+}
+
+func TestSPKIPinSHA256(t *testing.T) {
+	for _, alg := range []string{"EC_SIGN_P256_SHA256", "RSA_SIGN_PSS_2048_SHA256"} {
+		publicKey := testPrivateKey(t, alg).Public()
+		pin, err := spkiPinSHA256(publicKey)
+		if err != nil {
+			t.Fatalf("%s: spkiPinSHA256: %v", alg, err)
+		}
+		fingerprint, err := keyFingerprint(publicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Both are the SHA-256 hash of the SPKI, in different encodings.
+		decodedPin, err := base64.StdEncoding.DecodeString(pin)
+		if err != nil {
+			t.Fatalf("%s: pin %q is not base64: %v", alg, pin, err)
+		}
+		if got := hex.EncodeToString(decodedPin); got != fingerprint {
+			t.Errorf("%s: pin hashes to %s, want %s", alg, got, fingerprint)
+		}
+		if len(pin) != 44 {
+			t.Errorf("%s: pin %q is %d characters, want 44", alg, pin, len(pin))
+		}
+	}
+}
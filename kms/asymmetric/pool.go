@@ -0,0 +1,108 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"sync"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// PoolStrategy is how a PooledSigner chooses the key for each request.
+type PoolStrategy int
+
+const (
+	// PoolRoundRobin uses the keys in turn, in the order given.
+	PoolRoundRobin PoolStrategy = iota
+	// PoolLeastRecentlyUsed uses the key that was least recently chosen,
+	// skipping keys that still have a request in flight while any key is
+	// idle, so that a slow key does not hold up the callers queued behind it.
+	PoolLeastRecentlyUsed
+)
+
+// A PooledSigner spreads signing requests over several equivalent KMS keys,
+// for example to stay below the per-key quota. Since each key signs with its
+// own private key, Sign reports which key it used, and the verifier must
+// check the signature against that key. A PooledSigner is safe for
+// concurrent use.
+type PooledSigner struct {
+	client   *cloudkms.Service
+	keyPaths []string
+	strategy PoolStrategy
+	opts     []Option
+
+	mu       sync.Mutex
+	next     int      // PoolRoundRobin: index of the next key
+	tick     uint64   // PoolLeastRecentlyUsed: count of keys chosen so far
+	lastUsed []uint64 // PoolLeastRecentlyUsed: tick at which each key was last chosen
+	inFlight []int    // PoolLeastRecentlyUsed: requests in flight per key
+}
+
+// newPooledSigner returns a PooledSigner over the key versions at keyPaths,
+// which should all have the same algorithm. opts are used for every signing
+// request.
+func newPooledSigner(client *cloudkms.Service, keyPaths []string, strategy PoolStrategy, opts ...Option) (*PooledSigner, error) {
+	if len(keyPaths) == 0 {
+		return nil, errors.New("pooled signer needs at least one key")
+	}
+	for _, keyPath := range keyPaths {
+		if err := validateKeyPath(keyPath); err != nil {
+			return nil, err
+		}
+	}
+	return &PooledSigner{
+		client:   client,
+		keyPaths: append([]string(nil), keyPaths...),
+		strategy: strategy,
+		opts:     opts,
+		lastUsed: make([]uint64, len(keyPaths)),
+		inFlight: make([]int, len(keyPaths)),
+	}, nil
+}
+
+// Sign signs message, like signAsymmetric, with a key chosen from the pool,
+// and returns the signature with the path of the key that made it. keyPath
+// is returned even if signing fails, to tell which key failed.
+func (p *PooledSigner) Sign(ctx context.Context, message string) (signature, keyPath string, err error) {
+	i := p.acquire()
+	defer p.release(i)
+	keyPath = p.keyPaths[i]
+	signature, err = signAsymmetric(ctx, p.client, message, keyPath, p.opts...)
+	return signature, keyPath, err
+}
+
+// acquire chooses the index of the key for a request.
+func (p *PooledSigner) acquire() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.strategy != PoolLeastRecentlyUsed {
+		i := p.next
+		p.next = (p.next + 1) % len(p.keyPaths)
+		return i
+	}
+	best := 0
+	for i := range p.keyPaths {
+		idle, bestIdle := p.inFlight[i] == 0, p.inFlight[best] == 0
+		if idle && !bestIdle || idle == bestIdle && p.lastUsed[i] < p.lastUsed[best] {
+			best = i
+		}
+	}
+	p.tick++
+	p.lastUsed[best] = p.tick
+	p.inFlight[best]++
+	return best
+}
+
+// release records that the request using key i has finished.
+func (p *PooledSigner) release(i int) {
+	if p.strategy != PoolLeastRecentlyUsed {
+		return
+	}
+	p.mu.Lock()
+	p.inFlight[i]--
+	p.mu.Unlock()
+}
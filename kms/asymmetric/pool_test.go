@@ -0,0 +1,81 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sync"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestPooledSigner(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPaths := []string{testKeyPath("pool-a"), testKeyPath("pool-b"), testKeyPath("pool-c")}
+	for _, keyPath := range keyPaths {
+		f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+	}
+
+	for _, strategy := range []PoolStrategy{PoolRoundRobin, PoolLeastRecentlyUsed} {
+		p, err := newPooledSigner(client, keyPaths, strategy)
+		if err != nil {
+			t.Fatalf("newPooledSigner: %v", err)
+		}
+		for i := 0; i < 2*len(keyPaths); i++ {
+			signature, keyPath, err := p.Sign(ctx, "message")
+			if err != nil {
+				t.Fatalf("strategy %d: Sign: %v", strategy, err)
+			}
+			if want := keyPaths[i%len(keyPaths)]; keyPath != want {
+				t.Errorf("strategy %d: request %d used %s, want %s", strategy, i, keyPath, want)
+			}
+			if err := verifySignature(ctx, client, signature, []byte("message"), keyPath); err != nil {
+				t.Errorf("strategy %d: verifySignature with the reported key: %v", strategy, err)
+			}
+		}
+	}
+
+	if _, err := newPooledSigner(client, nil, PoolRoundRobin); err == nil {
+		t.Error("newPooledSigner with no keys succeeded")
+	}
+}
+
+func TestPooledSignerLeastRecentlyUsed(t *testing.T) {
+	keyPaths := []string{testKeyPath("pool-a"), testKeyPath("pool-b")}
+	p, err := newPooledSigner(nil, keyPaths, PoolLeastRecentlyUsed)
+	if err != nil {
+		t.Fatalf("newPooledSigner: %v", err)
+	}
+	// Key 0 is still busy, so key 1 is chosen twice in a row.
+	busy := p.acquire()
+	if got := p.acquire(); got == busy {
+		t.Fatalf("second request chose busy key %d", got)
+	} else {
+		p.release(got)
+	}
+	if got := p.acquire(); got == busy {
+		t.Errorf("third request chose busy key %d, want the idle one", got)
+	} else {
+		p.release(got)
+	}
+	p.release(busy)
+
+	// Under concurrent use, every request is counted back out.
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.release(p.acquire())
+		}()
+	}
+	wg.Wait()
+	for i, n := range p.inFlight {
+		if n != 0 {
+			t.Errorf("key %d has %d requests in flight, want 0", i, n)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// Protocol buffer serialization is not canonical: map entries may be written
+// in any order, and implementations in other languages may order or encode
+// fields differently. signProto and verifyProto therefore sign the output of
+// deterministic marshaling, which is stable for a given message and build of
+// the Go protobuf library. Both sides must use it; a signer in another
+// language must produce the same bytes, or send the serialized bytes with
+// the signature and have them verified with verifySignature instead.
+
+// deterministicProto serializes msg with deterministic marshaling.
+func deterministicProto(msg proto.Message) ([]byte, error) {
+	message, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal proto: %w", err)
+	}
+	return message, nil
+}
+
+// signProto signs the deterministic serialization of msg with the key at
+// keyPath.
+func signProto(ctx context.Context, client *cloudkms.Service, msg proto.Message, keyPath string, opts ...Option) (string, error) {
+	message, err := deterministicProto(msg)
+	if err != nil {
+		return "", err
+	}
+	return signAsymmetric(ctx, client, string(message), keyPath, opts...)
+}
+
+// verifyProto verifies a signature over the deterministic serialization of
+// msg, as made by signProto.
+func verifyProto(ctx context.Context, client *cloudkms.Service, signature string, msg proto.Message, keyPath string, opts ...Option) error {
+	message, err := deterministicProto(msg)
+	if err != nil {
+		return err
+	}
+	return verifySignature(ctx, client, signature, message, keyPath, opts...)
+}
@@ -0,0 +1,45 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestVerifyProto(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("ec-sign")
+	f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+
+	fields := map[string]interface{}{"b": 2, "a": "one", "c": true, "d": 4.5}
+	msg, err := structpb.NewStruct(fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := signProto(ctx, client, msg, keyPath)
+	if err != nil {
+		t.Fatalf("signProto: %v", err)
+	}
+	// A separately built message with the same map must verify, whatever
+	// order its entries are stored in.
+	for i := 0; i < 10; i++ {
+		rebuilt, err := structpb.NewStruct(fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := verifyProto(ctx, client, signature, rebuilt, keyPath); err != nil {
+			t.Fatalf("verifyProto: %v", err)
+		}
+	}
+	msg.Fields["a"] = structpb.NewStringValue("changed")
+	if err := verifyProto(ctx, client, signature, msg, keyPath); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("changed message: got %v, want ErrSignatureInvalid", err)
+	}
+}
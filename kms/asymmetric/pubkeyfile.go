@@ -0,0 +1,78 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// writePublicKeyPEM writes the public key of the key version at keyPath to
+// the file at path, as a PEM-encoded SubjectPublicKeyInfo ("PUBLIC KEY"
+// block) that OpenSSL and Go's x509.ParsePKIXPublicKey read. The key is
+// checked against WithMinRSABits and WithExpectedKeyFingerprint first, and
+// the file is written atomically, world-readable, so a reader never sees a
+// partial key.
+//
+// An existing file at path is trust material that others may rely on, so it
+// is replaced only if overwrite is set; otherwise the error wraps
+// fs.ErrExist and the file is left untouched.
+func writePublicKeyPEM(ctx context.Context, client *cloudkms.Service, keyPath, path string, overwrite bool, opts ...Option) error {
+	publicKey, err := newOptions(opts).getPublicKey(ctx, client, keyPath)
+	if err != nil {
+		return err
+	}
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return fmt.Errorf("failed to marshal public key: %w", err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	return writeFileAtomic(path, data, 0644, overwrite, "write public key file")
+}
+
+// writeFileAtomic writes data to the file at path with permissions perm,
+// through a temporary file in the same directory that is renamed, or linked
+// if overwrite is not set, into place, so that path never holds partial
+// data. Without overwrite, it fails with an error wrapping fs.ErrExist if
+// path exists; the check and the write are a single step, so a file created
+// concurrently is not clobbered either. op describes the write in errors.
+func writeFileAtomic(path string, data []byte, perm fs.FileMode, overwrite bool, op string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fileError(op, path, err)
+	}
+	// After a successful rename this fails harmlessly.
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fileError(op, tmp.Name(), err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fileError(op, tmp.Name(), err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fileError(op, tmp.Name(), err)
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return fileError(op, tmp.Name(), err)
+	}
+	if overwrite {
+		err = os.Rename(tmp.Name(), path)
+	} else {
+		err = os.Link(tmp.Name(), path)
+	}
+	if err != nil {
+		return fileError(op, path, err)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestWritePublicKeyPEM(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	ecPath := testKeyPath("export-ec")
+	rsaPath := testKeyPath("export-rsa")
+	f.addKey(t, ecPath, "EC_SIGN_P256_SHA256")
+	f.addKey(t, rsaPath, "RSA_SIGN_PSS_2048_SHA256")
+	dir := t.TempDir()
+	path := filepath.Join(dir, "signing.pem")
+
+	check := func(alg string) {
+		t.Helper()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block, _ := pem.Decode(data)
+		if block == nil || block.Type != "PUBLIC KEY" {
+			t.Fatalf("file does not hold a PUBLIC KEY block:\n%s", data)
+		}
+		publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := keyFingerprint(publicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, _ := keyFingerprint(testPrivateKey(t, alg).Public()); got != want {
+			t.Errorf("file holds the wrong public key, want that of %s", alg)
+		}
+	}
+	if err := writePublicKeyPEM(ctx, client, ecPath, path, false); err != nil {
+		t.Fatalf("writePublicKeyPEM: %v", err)
+	}
+	check("EC_SIGN_P256_SHA256")
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0644 {
+		t.Errorf("file mode = %v, %v; want 0644", info.Mode().Perm(), err)
+	}
+
+	if err := writePublicKeyPEM(ctx, client, rsaPath, path, false); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("writePublicKeyPEM over an existing file: got %v, want fs.ErrExist", err)
+	}
+	check("EC_SIGN_P256_SHA256")
+	if err := writePublicKeyPEM(ctx, client, rsaPath, path, true); err != nil {
+		t.Fatalf("writePublicKeyPEM with overwrite: %v", err)
+	}
+	check("RSA_SIGN_PSS_2048_SHA256")
+
+	if err := writePublicKeyPEM(ctx, client, testKeyPath("missing"), filepath.Join(dir, "missing.pem"), false); err == nil {
+		t.Error("writePublicKeyPEM for a missing key: got nil error")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("directory holds %d files, want only %s", len(entries), path)
+	}
+}
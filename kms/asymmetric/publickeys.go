@@ -0,0 +1,96 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"sync"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// fetchedPublicKey is a public key fetched by fetchPublicKeys, with the
+// response it came from.
+type fetchedPublicKey struct {
+	response  *cloudkms.PublicKey
+	publicKey crypto.PublicKey
+}
+
+// getPublicKeys fetches the public keys of the key versions in keyPaths,
+// making up to concurrency requests at a time, and returns a map from key
+// path to public key. If concurrency is not positive, defaultMaxInFlight is
+// used. Each key is fetched once, even if its path is listed more than once.
+// If any fetch fails, the returned error contains each failed key's error
+// and the map holds the keys that were fetched.
+func getPublicKeys(ctx context.Context, client *cloudkms.Service, keyPaths []string, concurrency int, opts ...Option) (map[string]crypto.PublicKey, error) {
+	fetched, err := fetchPublicKeys(ctx, client, keyPaths, concurrency, opts...)
+	publicKeys := make(map[string]crypto.PublicKey, len(fetched))
+	for keyPath, f := range fetched {
+		publicKeys[keyPath] = f.publicKey
+	}
+	return publicKeys, err
+}
+
+// fetchPublicKeys is like getPublicKeys, but also returns each KMS response.
+func fetchPublicKeys(ctx context.Context, client *cloudkms.Service, keyPaths []string, concurrency int, opts ...Option) (map[string]fetchedPublicKey, error) {
+	o := newOptions(opts)
+	if concurrency <= 0 {
+		concurrency = defaultMaxInFlight
+	}
+	var (
+		mu      sync.Mutex
+		fetched = make(map[string]fetchedPublicKey, len(keyPaths))
+		errs    = make(map[string]error)
+		wg      sync.WaitGroup
+	)
+	inFlight := make(chan struct{}, concurrency)
+	seen := make(map[string]bool, len(keyPaths))
+	for _, keyPath := range keyPaths {
+		if seen[keyPath] {
+			continue
+		}
+		seen[keyPath] = true
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return fetched, ctx.Err()
+		case inFlight <- struct{}{}:
+		}
+		wg.Add(1)
+		go func(keyPath string) {
+			defer wg.Done()
+			defer func() { <-inFlight }()
+			var f fetchedPublicKey
+			err := o.retry(ctx, func() error {
+				var err error
+				f.response, f.publicKey, err = fetchPublicKey(ctx, client, keyPath, opts...)
+				return err
+			})
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs[keyPath] = err
+				return
+			}
+			fetched[keyPath] = f
+		}(keyPath)
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		// Report errors in the order the keys were listed.
+		var joined []error
+		for _, keyPath := range keyPaths {
+			if err, ok := errs[keyPath]; ok {
+				joined = append(joined, fmt.Errorf("%s: %w", keyPath, err))
+				delete(errs, keyPath)
+			}
+		}
+		return fetched, fmt.Errorf("failed to fetch %d of %d public keys: %w", len(joined), len(seen), errors.Join(joined...))
+	}
+	return fetched, nil
+}
@@ -0,0 +1,47 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestGetPublicKeys(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	var keyPaths []string
+	for i := 0; i < 5; i++ {
+		keyPath := testKeyPath(fmt.Sprintf("sign-%d", i))
+		f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+		keyPaths = append(keyPaths, keyPath)
+	}
+	missing := testKeyPath("missing")
+	keyPaths = append(keyPaths, missing, keyPaths[0])
+
+	publicKeys, err := getPublicKeys(ctx, client, keyPaths, 2)
+	if err == nil || !strings.Contains(err.Error(), missing) {
+		t.Errorf("got error %v, want one naming %s", err, missing)
+	}
+	if len(publicKeys) != 5 {
+		t.Errorf("got %d keys, want 5", len(publicKeys))
+	}
+	for _, keyPath := range keyPaths[:5] {
+		want, err := getAsymmetricPublicKey(ctx, client, keyPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantFingerprint, err := keyFingerprint(want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := keyFingerprint(publicKeys[keyPath]); err != nil || got != wantFingerprint {
+			t.Errorf("%s: wrong public key", keyPath)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+)
+
+// KMS does not report remaining quota on successful responses; the quota
+// for a project is visible only in the Cloud console and the Service Usage
+// API. What a client can see is being throttled: a 429 RESOURCE_EXHAUSTED
+// response, often with a delay to wait in a Retry-After header or a
+// google.rpc.RetryInfo detail. Some proxies in front of KMS also add
+// X-RateLimit-Limit and X-RateLimit-Remaining headers. QuotaStatus collects
+// all of these, and QuotaTracker turns them into a concurrency for a batch.
+
+// A QuotaStatus is what one KMS response says about quota.
+type QuotaStatus struct {
+	// RateLimited is true if the request was rejected as over quota.
+	RateLimited bool
+	// RetryAfter is how long the server asked the client to wait before
+	// retrying, or zero if it did not say.
+	RetryAfter time.Duration
+	// Limit and Remaining are the X-RateLimit-Limit and X-RateLimit-Remaining
+	// headers of the response, or -1 if they are absent.
+	Limit, Remaining int
+}
+
+// WithQuotaObserver calls f with the QuotaStatus of every KMS response
+// received by the call, including error responses, so that a scheduler can
+// adapt to throttling; a QuotaTracker's Observe method can be passed
+// directly. Functions that make concurrent requests may call f
+// concurrently.
+func WithQuotaObserver(f func(QuotaStatus)) Option {
+	return func(o *options) { o.onQuota = f }
+}
+
+// parseQuotaStatus returns the QuotaStatus of a KMS response with the given
+// header, and apiErr if it was an error response.
+func parseQuotaStatus(header http.Header, apiErr *googleapi.Error) QuotaStatus {
+	status := QuotaStatus{Limit: -1, Remaining: -1}
+	if apiErr != nil {
+		status.RateLimited = apiErr.Code == http.StatusTooManyRequests
+		status.RetryAfter = retryInfoDelay(apiErr.Details)
+	}
+	if header == nil {
+		return status
+	}
+	if v := header.Get("Retry-After"); v != "" && status.RetryAfter == 0 {
+		if seconds, err := strconv.Atoi(v); err == nil && seconds > 0 {
+			status.RetryAfter = time.Duration(seconds) * time.Second
+		} else if at, err := http.ParseTime(v); err == nil && time.Until(at) > 0 {
+			status.RetryAfter = time.Until(at)
+		}
+	}
+	if n, err := strconv.Atoi(header.Get("X-RateLimit-Limit")); err == nil && n >= 0 {
+		status.Limit = n
+	}
+	if n, err := strconv.Atoi(header.Get("X-RateLimit-Remaining")); err == nil && n >= 0 {
+		status.Remaining = n
+	}
+	return status
+}
+
+// retryInfoDelay returns the delay of a google.rpc.RetryInfo among the
+// details of an error response, or zero if there is none.
+func retryInfoDelay(details []interface{}) time.Duration {
+	for _, detail := range details {
+		m, ok := detail.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if t, _ := m["@type"].(string); !strings.HasSuffix(t, "google.rpc.RetryInfo") {
+			continue
+		}
+		// The JSON form of a Duration is seconds with an "s" suffix.
+		if s, ok := m["retryDelay"].(string); ok {
+			if d, err := time.ParseDuration(s); err == nil && d > 0 {
+				return d
+			}
+		}
+	}
+	return 0
+}
+
+// A QuotaTracker suggests how many requests a batch should have in flight,
+// from the QuotaStatus of the responses it observes: the concurrency halves
+// each time a request is rate limited, and grows by one after as many
+// successful responses as the current concurrency, up to a maximum. It is
+// safe for concurrent use.
+type QuotaTracker struct {
+	max int
+
+	mu          sync.Mutex
+	concurrency int
+	successes   int
+	pauseUntil  time.Time
+	remaining   int
+	inFlight    int
+	// changed is closed, and replaced, whenever a request finishes or the
+	// concurrency may have grown.
+	changed chan struct{}
+}
+
+// WithQuotaTracker makes signAsymmetricStream pace itself with q instead of
+// a fixed limit: it keeps at most q.Concurrency() requests in flight and
+// sends none before q.PauseUntil(). Every KMS response of the call is
+// observed by q. Share one QuotaTracker between the calls of a batch.
+func WithQuotaTracker(q *QuotaTracker) Option {
+	return func(o *options) { o.quotaTracker = q }
+}
+
+// newQuotaTracker returns a QuotaTracker that starts at, and never exceeds,
+// maxConcurrency requests in flight, or defaultMaxInFlight if maxConcurrency
+// is 0 or less.
+func newQuotaTracker(maxConcurrency int) *QuotaTracker {
+	if maxConcurrency <= 0 {
+		maxConcurrency = defaultMaxInFlight
+	}
+	return &QuotaTracker{max: maxConcurrency, concurrency: maxConcurrency, remaining: -1, changed: make(chan struct{})}
+}
+
+// Observe updates the tracker with the status of one response.
+func (q *QuotaTracker) Observe(status QuotaStatus) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	defer q.signal()
+	if status.Remaining >= 0 {
+		q.remaining = status.Remaining
+	}
+	if status.RateLimited {
+		if q.concurrency /= 2; q.concurrency < 1 {
+			q.concurrency = 1
+		}
+		q.successes = 0
+		if until := time.Now().Add(status.RetryAfter); until.After(q.pauseUntil) {
+			q.pauseUntil = until
+		}
+		return
+	}
+	if q.successes++; q.successes >= q.concurrency && q.concurrency < q.max {
+		q.concurrency++
+		q.successes = 0
+	}
+}
+
+// Concurrency returns how many requests to have in flight. It is never more
+// than the headroom reported by the last X-RateLimit-Remaining header, but
+// always at least 1.
+func (q *QuotaTracker) Concurrency() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.limit()
+}
+
+// limit is Concurrency with q.mu held.
+func (q *QuotaTracker) limit() int {
+	n := q.concurrency
+	if q.remaining >= 0 && q.remaining < n {
+		n = q.remaining
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// PauseUntil returns the time before which no new request should be sent,
+// from the longest wait asked for by a rate-limited response. It is in the
+// past when there is no need to wait.
+func (q *QuotaTracker) PauseUntil() time.Time {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.pauseUntil
+}
+
+// Headroom returns the number of requests left in the current quota window,
+// from the last X-RateLimit-Remaining header, and false if no response has
+// carried one.
+func (q *QuotaTracker) Headroom() (int, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.remaining, q.remaining >= 0
+}
+
+// acquire waits until another request may be sent, as WithQuotaTracker
+// describes, or ctx is done. Call release when the request has finished.
+func (q *QuotaTracker) acquire(ctx context.Context) error {
+	for {
+		q.mu.Lock()
+		wait := time.Until(q.pauseUntil)
+		if wait <= 0 && q.inFlight < q.limit() {
+			q.inFlight++
+			q.mu.Unlock()
+			return nil
+		}
+		changed := q.changed
+		q.mu.Unlock()
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+		select {
+		case <-ctx.Done():
+		case <-changed:
+		case <-timeout:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
+
+// release records that a request started with acquire has finished.
+func (q *QuotaTracker) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.inFlight--
+	q.signal()
+}
+
+// signal wakes the callers waiting in acquire. q.mu must be held.
+func (q *QuotaTracker) signal() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
@@ -0,0 +1,169 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+)
+
+func TestParseQuotaStatus(t *testing.T) {
+	header := http.Header{}
+	header.Set("X-RateLimit-Limit", "600")
+	header.Set("X-RateLimit-Remaining", "12")
+	if got, want := parseQuotaStatus(header, nil), (QuotaStatus{Limit: 600, Remaining: 12}); got != want {
+		t.Errorf("success with rate limit headers = %+v, want %+v", got, want)
+	}
+	if got, want := parseQuotaStatus(http.Header{}, nil), (QuotaStatus{Limit: -1, Remaining: -1}); got != want {
+		t.Errorf("success without headers = %+v, want %+v", got, want)
+	}
+
+	throttled := &googleapi.Error{Code: http.StatusTooManyRequests, Details: []interface{}{
+		map[string]interface{}{"@type": "type.googleapis.com/google.rpc.RetryInfo", "retryDelay": "2.5s"},
+	}}
+	got := parseQuotaStatus(http.Header{"Retry-After": {"7"}}, throttled)
+	if !got.RateLimited || got.RetryAfter != 2500*time.Millisecond {
+		t.Errorf("429 with RetryInfo = %+v, want rate limited for 2.5s", got)
+	}
+	throttled.Details = nil
+	got = parseQuotaStatus(http.Header{"Retry-After": {"7"}}, throttled)
+	if !got.RateLimited || got.RetryAfter != 7*time.Second {
+		t.Errorf("429 with Retry-After = %+v, want rate limited for 7s", got)
+	}
+	got = parseQuotaStatus(nil, &googleapi.Error{Code: http.StatusServiceUnavailable})
+	if got.RateLimited || got.RetryAfter != 0 {
+		t.Errorf("503 = %+v, want not rate limited", got)
+	}
+}
+
+func TestWithQuotaObserver(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("quota")
+	f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+	var statuses []QuotaStatus
+	observe := WithQuotaObserver(func(s QuotaStatus) { statuses = append(statuses, s) })
+
+	if _, err := signAsymmetric(ctx, client, "message", keyPath, observe); err != nil {
+		t.Fatalf("signAsymmetric: %v", err)
+	}
+	if len(statuses) == 0 || statuses[len(statuses)-1].RateLimited {
+		t.Errorf("statuses after success = %+v, want not rate limited", statuses)
+	}
+
+	statuses = nil
+	f.mu.Lock()
+	f.failures = []int{http.StatusTooManyRequests}
+	f.mu.Unlock()
+	if _, err := signAsymmetric(ctx, client, "message", keyPath, observe); err == nil {
+		t.Fatal("signAsymmetric with a 429: got nil error")
+	}
+	if len(statuses) != 1 || !statuses[0].RateLimited || statuses[0].RetryAfter != time.Second {
+		t.Errorf("statuses after a 429 = %+v, want one rate limited for 1s", statuses)
+	}
+}
+
+func TestQuotaTracker(t *testing.T) {
+	q := newQuotaTracker(8)
+	if got := q.Concurrency(); got != 8 {
+		t.Fatalf("initial concurrency = %d, want 8", got)
+	}
+	q.Observe(QuotaStatus{RateLimited: true, Limit: -1, Remaining: -1})
+	q.Observe(QuotaStatus{RateLimited: true, Limit: -1, Remaining: -1})
+	if got := q.Concurrency(); got != 2 {
+		t.Errorf("concurrency after two 429s = %d, want 2", got)
+	}
+	for i := 0; i < 2+3; i++ {
+		q.Observe(QuotaStatus{Limit: -1, Remaining: -1})
+	}
+	if got := q.Concurrency(); got != 4 {
+		t.Errorf("concurrency after five successes = %d, want 4", got)
+	}
+	if _, ok := q.Headroom(); ok {
+		t.Error("Headroom without X-RateLimit-Remaining: got ok")
+	}
+	q.Observe(QuotaStatus{Limit: 100, Remaining: 3})
+	if got := q.Concurrency(); got != 3 {
+		t.Errorf("concurrency with 3 requests of headroom = %d, want 3", got)
+	}
+	if n, ok := q.Headroom(); n != 3 || !ok {
+		t.Errorf("Headroom = %d, %v; want 3, true", n, ok)
+	}
+
+	// A requested delay holds back new requests.
+	q = newQuotaTracker(2)
+	q.Observe(QuotaStatus{RateLimited: true, RetryAfter: 50 * time.Millisecond, Limit: -1, Remaining: -1})
+	start := time.Now()
+	if err := q.acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("acquire returned after %v, want it to wait for the requested 50ms", elapsed)
+	}
+	// With one in flight at a concurrency of 1, the next waits for release.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := q.acquire(ctx); err == nil {
+		t.Error("acquire over the concurrency: got nil error")
+	}
+	q.release()
+	if err := q.acquire(context.Background()); err != nil {
+		t.Errorf("acquire after release: %v", err)
+	}
+}
+
+func TestSignAsymmetricStreamQuotaTracker(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("quota-stream")
+	f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+	q := newQuotaTracker(3)
+
+	var mu sync.Mutex
+	maxSeen := 0
+	onHeader := WithResponseHeaders(func(http.Header) {
+		mu.Lock()
+		defer mu.Unlock()
+		if n := q.inFlightCount(); n > maxSeen {
+			maxSeen = n
+		}
+	})
+	in := make(chan string)
+	go func() {
+		defer close(in)
+		for i := 0; i < 20; i++ {
+			in <- fmt.Sprintf("message %d", i)
+		}
+	}()
+	n := 0
+	for result := range signAsymmetricStream(ctx, client, in, keyPath, WithQuotaTracker(q), onHeader) {
+		if result.Err != nil {
+			t.Errorf("message %d: %v", result.Index, result.Err)
+		}
+		n++
+	}
+	if n != 20 {
+		t.Errorf("got %d results, want 20", n)
+	}
+	if maxSeen > 3 {
+		t.Errorf("%d requests in flight, want at most 3", maxSeen)
+	}
+	if got := q.inFlightCount(); got != 0 {
+		t.Errorf("%d requests still counted in flight after the stream ended", got)
+	}
+}
+
+// inFlightCount returns the number of requests acquired and not released.
+func (q *QuotaTracker) inFlightCount() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.inFlight
+}
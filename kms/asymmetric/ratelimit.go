@@ -0,0 +1,78 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"golang.org/x/net/context"
+	"golang.org/x/time/rate"
+)
+
+// RateLimitMode is what a rate-limited call does when its key has no
+// capacity left.
+type RateLimitMode int
+
+const (
+	// RateLimitWait blocks until the request may be sent, or ctx is done.
+	RateLimitWait RateLimitMode = iota
+	// RateLimitFailFast returns an error wrapping ErrRateLimited at once.
+	RateLimitFailFast
+)
+
+// keyRateLimiter holds one token bucket per key path.
+type keyRateLimiter struct {
+	qps  float64
+	mode RateLimitMode
+
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+// WithRateLimit limits AsymmetricSign and AsymmetricDecrypt requests to qps
+// per second for each key path, with bursts of up to one second's worth, to
+// stay below the per-key quota instead of receiving 429 responses. mode
+// chooses whether a call over the limit waits or fails.
+// The buckets belong to the returned Option, so create it once and pass the
+// same value to every call that shares the limit.
+func WithRateLimit(qps float64, mode RateLimitMode) Option {
+	l := &keyRateLimiter{qps: qps, mode: mode, limiters: make(map[string]*rate.Limiter)}
+	return func(o *options) { o.rateLimiter = l }
+}
+
+// limiter returns the token bucket for keyPath.
+func (l *keyRateLimiter) limiter(keyPath string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	limiter, ok := l.limiters[keyPath]
+	if !ok {
+		burst := int(l.qps)
+		if burst < 1 {
+			burst = 1
+		}
+		limiter = rate.NewLimiter(rate.Limit(l.qps), burst)
+		l.limiters[keyPath] = limiter
+	}
+	return limiter
+}
+
+// waitRateLimit enforces WithRateLimit before a request to keyPath.
+func (o *options) waitRateLimit(ctx context.Context, keyPath string) error {
+	if o.rateLimiter == nil {
+		return nil
+	}
+	limiter := o.rateLimiter.limiter(keyPath)
+	if o.rateLimiter.mode == RateLimitFailFast {
+		if !limiter.Allow() {
+			return fmt.Errorf("%w: %s", ErrRateLimited, keyPath)
+		}
+		return nil
+	}
+	if err := limiter.Wait(ctx); err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrRateLimited, keyPath, err)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestWithRateLimit(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("ec-sign")
+	otherPath := testKeyPath("ec-sign-2")
+	f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+	f.addKey(t, otherPath, "EC_SIGN_P256_SHA256")
+
+	limit := WithRateLimit(2, RateLimitFailFast)
+	for i := 0; i < 2; i++ {
+		if _, err := signAsymmetric(ctx, client, "message", keyPath, limit); err != nil {
+			t.Fatalf("call %d within the burst: %v", i, err)
+		}
+	}
+	if _, err := signAsymmetric(ctx, client, "message", keyPath, limit); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("call over the limit: got %v, want ErrRateLimited", err)
+	}
+	if _, err := signAsymmetric(ctx, client, "message", otherPath, limit); err != nil {
+		t.Errorf("another key is limited separately: %v", err)
+	}
+
+	wait := WithRateLimit(1, RateLimitWait)
+	if _, err := signAsymmetric(ctx, client, "message", keyPath, wait); err != nil {
+		t.Fatal(err)
+	}
+	shortCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := signAsymmetric(shortCtx, client, "message", keyPath, wait); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("waiting past the deadline: got %v, want ErrRateLimited", err)
+	}
+}
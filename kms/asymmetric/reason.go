@@ -0,0 +1,83 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import "errors"
+
+// A FailureReason is the category of a verification failure, for counting
+// failures by kind without parsing error messages. The reason carries no
+// details of the failure, so it is safe to report to untrusted callers where
+// the error itself is not.
+type FailureReason int
+
+const (
+	// ReasonNone means verification succeeded.
+	ReasonNone FailureReason = iota
+	// ReasonBadSignature means the signature does not match the message.
+	ReasonBadSignature
+	// ReasonWrongKey means the key is not one that may be used: it has the
+	// wrong type, algorithm, strength or fingerprint, or its certificate is
+	// not trusted.
+	ReasonWrongKey
+	// ReasonExpired means a token, key version, certificate or timestamped
+	// signature is outside its validity period.
+	ReasonExpired
+	// ReasonMalformed means the signature, token or message could not be
+	// parsed.
+	ReasonMalformed
+	// ReasonClaims means a token is validly signed but has the wrong issuer
+	// or audience.
+	ReasonClaims
+	// ReasonOther means verification could not be completed, for example
+	// because KMS could not be reached.
+	ReasonOther
+)
+
+var failureReasonNames = [...]string{
+	ReasonNone:         "none",
+	ReasonBadSignature: "bad_signature",
+	ReasonWrongKey:     "wrong_key",
+	ReasonExpired:      "expired",
+	ReasonMalformed:    "malformed",
+	ReasonClaims:       "claims",
+	ReasonOther:        "other",
+}
+
+// String returns a short name for r, suitable as a metric label.
+func (r FailureReason) String() string {
+	if r < 0 || int(r) >= len(failureReasonNames) {
+		return "unknown"
+	}
+	return failureReasonNames[r]
+}
+
+// failureReason categorizes err, as returned by a verify function such as
+// verifySignature, verifyWithChain or verifyJWT.
+func failureReason(err error) FailureReason {
+	is := func(targets ...error) bool {
+		for _, target := range targets {
+			if errors.Is(err, target) {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case err == nil:
+		return ReasonNone
+	case is(ErrSignatureInvalid, ErrNonCanonicalS, ErrMerkleProofInvalid, ErrTimestampInvalid):
+		return ReasonBadSignature
+	case is(ErrKeyTypeMismatch, ErrAlgorithmNotAllowed, ErrUnexpectedAlgorithm, ErrWeakKey, ErrKeyPinMismatch, ErrChainInvalid, ErrInsufficientProtection, ErrTokenAlgorithm, ErrUnsupportedCurve):
+		return ReasonWrongKey
+	case is(ErrTokenExpired, ErrTokenNotYetValid, ErrKeyTooOld, ErrCertExpired, ErrCertNotYetValid, ErrTimestampStale, ErrTimestampInFuture):
+		return ReasonExpired
+	case is(ErrSignatureMalformed, ErrTrailingSignatureData, ErrUnrecognizedEncoding, ErrTokenMalformed, ErrEmptyMessage, ErrTruncatedDigest, ErrUnsupportedXML, ErrRecordLength, ErrRecordPadding):
+		return ReasonMalformed
+	case is(ErrTokenIssuer, ErrTokenAudience, ErrAudienceMismatch):
+		return ReasonClaims
+	default:
+		return ReasonOther
+	}
+}
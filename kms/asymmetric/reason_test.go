@@ -0,0 +1,42 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestFailureReason(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	ecPath := testKeyPath("ec-sign")
+	f.addKey(t, ecPath, "EC_SIGN_P256_SHA256")
+	signature, err := signAsymmetric(ctx, client, "message", ecPath)
+	if err != nil {
+		t.Fatalf("signAsymmetric: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want FailureReason
+	}{
+		{"valid", verifySignatureEC(ctx, client, signature, "message", ecPath), ReasonNone},
+		{"bad signature", verifySignatureEC(ctx, client, signature, "other", ecPath), ReasonBadSignature},
+		{"wrong key", verifySignatureRSA(ctx, client, signature, "message", ecPath), ReasonWrongKey},
+		{"malformed", verifySignatureEC(ctx, client, "!!", "message", ecPath), ReasonMalformed},
+		{"expired token", ErrTokenExpired, ReasonExpired},
+		{"audience", ErrTokenAudience, ReasonClaims},
+		{"unreachable", errors.New("connection refused"), ReasonOther},
+	}
+	for _, test := range tests {
+		if got := failureReason(test.err); got != test.want {
+			t.Errorf("%s: failureReason(%v) = %v, want %v", test.name, test.err, got, test.want)
+		}
+	}
+}
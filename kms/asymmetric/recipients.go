@@ -0,0 +1,44 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// encryptToRecipients encrypts message separately with the RSA public key of
+// each key version in keyPaths, so that any one recipient can decrypt it with
+// decryptRSA. It returns a map from key path to ciphertext holding every
+// encryption that succeeded. Each public key is fetched once, even if its key
+// path is listed more than once. If any recipient fails, the returned error
+// contains each failed recipient's error and the map holds the rest.
+func encryptToRecipients(ctx context.Context, client *cloudkms.Service, message string, keyPaths []string, opts ...Option) (map[string]string, error) {
+	ciphertexts := make(map[string]string, len(keyPaths))
+	failed := make(map[string]bool)
+	var errs []error
+	for _, keyPath := range keyPaths {
+		if _, ok := ciphertexts[keyPath]; ok || failed[keyPath] {
+			continue
+		}
+		if ctx.Err() != nil {
+			return ciphertexts, ctx.Err()
+		}
+		ciphertext, err := encryptRSA(ctx, client, message, keyPath, opts...)
+		if err != nil {
+			failed[keyPath] = true
+			errs = append(errs, fmt.Errorf("%s: %w", keyPath, err))
+			continue
+		}
+		ciphertexts[keyPath] = ciphertext
+	}
+	if len(errs) > 0 {
+		return ciphertexts, fmt.Errorf("encryption failed for %d of %d recipients: %w", len(errs), len(failed)+len(ciphertexts), errors.Join(errs...))
+	}
+	return ciphertexts, nil
+}
@@ -0,0 +1,39 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestEncryptToRecipients(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	first := testKeyPath("team-a")
+	second := testKeyPath("team-b")
+	missing := testKeyPath("missing")
+	f.addKey(t, first, "RSA_DECRYPT_OAEP_2048_SHA256")
+	f.addKey(t, second, "RSA_DECRYPT_OAEP_3072_SHA256")
+
+	ciphertexts, err := encryptToRecipients(ctx, client, "secret", []string{first, second, missing, first})
+	if err == nil {
+		t.Error("encryptToRecipients with a missing key: got nil error")
+	}
+	if len(ciphertexts) != 2 {
+		t.Fatalf("got %d ciphertexts, want 2", len(ciphertexts))
+	}
+	for _, keyPath := range []string{first, second} {
+		plaintext, err := decryptRSA(ctx, client, ciphertexts[keyPath], keyPath)
+		if err != nil {
+			t.Errorf("decryptRSA(%s): %v", keyPath, err)
+			continue
+		}
+		if plaintext != "secret" {
+			t.Errorf("decryptRSA(%s) = %q, want %q", keyPath, plaintext, "secret")
+		}
+	}
+}
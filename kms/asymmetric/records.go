@@ -0,0 +1,146 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// A signed record stream is a concatenation of frames, each holding one
+// record and its signature:
+//
+//	uint32 record length || record || uint16 signature length || signature
+//
+// Integers are big-endian and the signature is raw, as returned by
+// signAsymmetricBytes. The stream ends after the last complete frame.
+
+// defaultMaxRecordBytes bounds the length of a record read by verifyRecords
+// unless WithMaxMessageBytes is given, so that a corrupt length prefix
+// cannot make it allocate without limit.
+const defaultMaxRecordBytes = 16 << 20
+
+// A RecordResult is the outcome of verifying one record of a signed record
+// stream.
+type RecordResult struct {
+	// Index is the position of the record in the stream, counting from 0.
+	Index int
+	// Offset is the position of the record's frame in the stream, in bytes.
+	Offset int64
+	Record []byte
+	// Err is nil if the signature is valid, and otherwise wraps
+	// ErrSignatureInvalid or ErrSignatureMalformed.
+	Err error
+}
+
+// writeSignedRecord signs record with the key at keyPath and writes it to w
+// as one frame of a signed record stream.
+func writeSignedRecord(ctx context.Context, client *cloudkms.Service, w io.Writer, record []byte, keyPath string, opts ...Option) error {
+	if uint64(len(record)) > math.MaxUint32 {
+		return fmt.Errorf("record is %d bytes; the maximum is %d", len(record), uint32(math.MaxUint32))
+	}
+	signature, err := signAsymmetricBytes(ctx, client, string(record), keyPath, opts...)
+	if err != nil {
+		return err
+	}
+	frame := binary.BigEndian.AppendUint32(nil, uint32(len(record)))
+	frame = append(frame, record...)
+	frame = binary.BigEndian.AppendUint16(frame, uint16(len(signature)))
+	frame = append(frame, signature...)
+	if _, err := w.Write(frame); err != nil {
+		return fmt.Errorf("failed to write record: %w", err)
+	}
+	return nil
+}
+
+// verifyRecords reads a signed record stream from r and verifies each record
+// with the key at keyPath, calling report with the result of each, in order.
+// The public key is fetched once, before the first record is read, and every
+// record is verified locally.
+//
+// A record with a bad signature is reported, and then verifyRecords either
+// returns its error, if stopOnFailure is set, or carries on with the next
+// record. Errors that leave the rest of the stream unreadable end it either
+// way: a stream that ends partway through a frame returns an error wrapping
+// io.ErrUnexpectedEOF, after every complete record before it has been
+// reported, and a record longer than WithMaxMessageBytes, or 16 MiB by
+// default, returns ErrMessageTooLarge.
+func verifyRecords(ctx context.Context, client *cloudkms.Service, r io.Reader, keyPath string, stopOnFailure bool, report func(RecordResult), opts ...Option) error {
+	o := newOptions(opts)
+	maxRecord := int64(defaultMaxRecordBytes)
+	if o.maxMessageBytes > 0 {
+		maxRecord = o.maxMessageBytes
+	}
+	opts = append(opts[:len(opts):len(opts)], WithSignatureEncoding(SignatureRaw))
+	verify, err := newVerifier(ctx, client, keyPath, opts...)
+	if err != nil {
+		return err
+	}
+
+	var offset int64
+	for index := 0; ; index++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		record, signature, n, err := readRecordFrame(r, maxRecord)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("record %d at offset %d: %w", index, offset, err)
+		}
+		result := RecordResult{Index: index, Offset: offset, Record: record}
+		result.Err = verify(string(signature), string(record))
+		report(result)
+		if result.Err != nil && stopOnFailure {
+			return fmt.Errorf("record %d at offset %d: %w", index, offset, result.Err)
+		}
+		offset += n
+	}
+}
+
+// readRecordFrame reads one frame of a signed record stream from r and
+// returns its record, its signature and its size. It returns io.EOF if r
+// ends before the frame starts, and an error wrapping io.ErrUnexpectedEOF if
+// r ends inside it.
+func readRecordFrame(r io.Reader, maxRecord int64) (record, signature []byte, n int64, err error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		if err == io.EOF {
+			return nil, nil, 0, io.EOF
+		}
+		return nil, nil, 0, recordReadError(err)
+	}
+	recordLen := int64(binary.BigEndian.Uint32(length[:]))
+	if recordLen > maxRecord {
+		return nil, nil, 0, fmt.Errorf("%w: record is %d bytes; the maximum is %d", ErrMessageTooLarge, recordLen, maxRecord)
+	}
+	record = make([]byte, recordLen+2)
+	if _, err := io.ReadFull(r, record); err != nil {
+		return nil, nil, 0, recordReadError(err)
+	}
+	sigLen := binary.BigEndian.Uint16(record[recordLen:])
+	record = record[:recordLen]
+	signature = make([]byte, sigLen)
+	if _, err := io.ReadFull(r, signature); err != nil {
+		return nil, nil, 0, recordReadError(err)
+	}
+	return record, signature, 4 + recordLen + 2 + int64(sigLen), nil
+}
+
+// recordReadError describes a read error inside a frame, where even a clean
+// EOF means the stream was cut short.
+func recordReadError(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("stream is truncated: %w", io.ErrUnexpectedEOF)
+	}
+	return fmt.Errorf("failed to read record: %w", err)
+}
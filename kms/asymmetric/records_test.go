@@ -0,0 +1,86 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestVerifyRecords(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("ec-sign")
+	f.addKey(t, keyPath, "EC_SIGN_P384_SHA384")
+
+	var stream bytes.Buffer
+	records := []string{"first", "", "third"}
+	var ends []int
+	for _, record := range records {
+		if err := writeSignedRecord(ctx, client, &stream, []byte(record), keyPath); err != nil {
+			t.Fatalf("writeSignedRecord: %v", err)
+		}
+		ends = append(ends, stream.Len())
+	}
+
+	var got []RecordResult
+	collect := func(r RecordResult) { got = append(got, r) }
+	f.mu.Lock()
+	f.requests = 0
+	f.mu.Unlock()
+	if err := verifyRecords(ctx, client, bytes.NewReader(stream.Bytes()), keyPath, true, collect); err != nil {
+		t.Fatalf("verifyRecords: %v", err)
+	}
+	f.mu.Lock()
+	requests := f.requests
+	f.mu.Unlock()
+	if requests != 1 {
+		t.Errorf("verifyRecords made %d requests, want 1", requests)
+	}
+	if len(got) != len(records) {
+		t.Fatalf("got %d results, want %d", len(got), len(records))
+	}
+	for i, r := range got {
+		if r.Index != i || string(r.Record) != records[i] || r.Err != nil {
+			t.Errorf("result %d = %+v, want record %q with no error", i, r, records[i])
+		}
+	}
+	if got[1].Offset != int64(ends[0]) {
+		t.Errorf("second record at offset %d, want %d", got[1].Offset, ends[0])
+	}
+
+	// Corrupt the first record: with stopOnFailure that ends the stream;
+	// without it the remaining records are still verified.
+	corrupt := append([]byte(nil), stream.Bytes()...)
+	corrupt[4] ^= 1
+	got = nil
+	if err := verifyRecords(ctx, client, bytes.NewReader(corrupt), keyPath, true, collect); !errors.Is(err, ErrSignatureInvalid) || len(got) != 1 {
+		t.Errorf("verifyRecords stopping on failure: got %v after %d results, want ErrSignatureInvalid after 1", err, len(got))
+	}
+	got = nil
+	if err := verifyRecords(ctx, client, bytes.NewReader(corrupt), keyPath, false, collect); err != nil || len(got) != 3 {
+		t.Fatalf("verifyRecords continuing on failure: got %v after %d results, want nil after 3", err, len(got))
+	}
+	if !errors.Is(got[0].Err, ErrSignatureInvalid) || got[1].Err != nil || got[2].Err != nil {
+		t.Errorf("verifyRecords continuing on failure: errors %v, %v, %v; want only the first to fail", got[0].Err, got[1].Err, got[2].Err)
+	}
+
+	// Cutting the stream inside the last frame reports the records before it.
+	for _, cut := range []int{ends[1] + 2, ends[1] + 6, ends[2] - 1} {
+		got = nil
+		err := verifyRecords(ctx, client, bytes.NewReader(stream.Bytes()[:cut]), keyPath, true, collect)
+		if !errors.Is(err, io.ErrUnexpectedEOF) || len(got) != 2 {
+			t.Errorf("stream cut at %d: got %v after %d results, want io.ErrUnexpectedEOF after 2", cut, err, len(got))
+		}
+	}
+
+	if err := verifyRecords(ctx, client, bytes.NewReader(stream.Bytes()), keyPath, true, collect, WithMaxMessageBytes(3)); !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("verifyRecords with a record over the limit: got %v, want ErrMessageTooLarge", err)
+	}
+}
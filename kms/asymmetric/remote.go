@@ -0,0 +1,77 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+const (
+	// defaultMaxURLBytes bounds the body fetched by verifyURL unless
+	// WithMaxMessageBytes is given.
+	defaultMaxURLBytes = 32 << 20
+	// urlFetchTimeout bounds the whole fetch in verifyURL, including reading
+	// the body, however long ctx allows.
+	urlFetchTimeout = time.Minute
+)
+
+// verifyURL fetches url with httpClient, or with http.DefaultClient if it is
+// nil, and verifies signature over the response body with the key at
+// keyPath, as for a webhook that signs the body of the resource it names.
+// The body is streamed through the hash, never held in memory, and is
+// limited to WithMaxMessageBytes, or 32 MiB by default; a longer body fails
+// with ErrMessageTooLarge. The fetch must finish within a minute.
+//
+// A failed request, a response other than 200 OK or an error reading the
+// body is reported with an error wrapping ErrFetchFailed, so that callers
+// can tell an unreachable URL from a bad signature.
+func verifyURL(ctx context.Context, client *cloudkms.Service, signature, url, keyPath string, httpClient *http.Client, opts ...Option) error {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	maxBytes := int64(defaultMaxURLBytes)
+	if o := newOptions(opts); o.maxMessageBytes > 0 {
+		maxBytes = o.maxMessageBytes
+	}
+	fetchCtx, cancel := context.WithTimeout(ctx, urlFetchTimeout)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrFetchFailed, err)
+	}
+	resp, err := httpClient.Do(req.WithContext(fetchCtx))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrFetchFailed, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: GET %s: %s", ErrFetchFailed, url, resp.Status)
+	}
+	// Refuse a body that says up front it is too long.
+	if resp.ContentLength > maxBytes {
+		return fmt.Errorf("%w: body of %s is %d bytes; the maximum is %d", ErrMessageTooLarge, url, resp.ContentLength, maxBytes)
+	}
+	opts = append(opts[:len(opts):len(opts)], WithMaxMessageBytes(maxBytes))
+	return verifySignatureReader(ctx, client, signature, fetchErrorReader{resp.Body}, keyPath, opts...)
+}
+
+// fetchErrorReader marks errors reading a response body with ErrFetchFailed.
+type fetchErrorReader struct {
+	r io.Reader
+}
+
+func (f fetchErrorReader) Read(b []byte) (int, error) {
+	n, err := f.r.Read(b)
+	if err != nil && err != io.EOF {
+		err = fmt.Errorf("%w: %w", ErrFetchFailed, err)
+	}
+	return n, err
+}
@@ -0,0 +1,80 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestVerifyURL(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("ec-sign")
+	f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+	body := "webhook payload"
+	signature, err := signAsymmetric(ctx, client, body, keyPath)
+	if err != nil {
+		t.Fatalf("signAsymmetric: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/body", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	})
+	mux.HandleFunc("/tampered", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body + "!"))
+	})
+	mux.HandleFunc("/large", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 1024)))
+	})
+	mux.HandleFunc("/streamed", func(w http.ResponseWriter, r *http.Request) {
+		// Without a Content-Length, the limit is only found by reading.
+		for i := 0; i < 4; i++ {
+			w.Write([]byte(strings.Repeat("x", 256)))
+			w.(http.Flusher).Flush()
+		}
+	})
+	mux.HandleFunc("/cut", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		w.Write([]byte(body))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	if err := verifyURL(ctx, client, signature, server.URL+"/body", keyPath, server.Client()); err != nil {
+		t.Errorf("verifyURL: %v", err)
+	}
+	if err := verifyURL(ctx, client, signature, server.URL+"/body", keyPath, nil); err != nil {
+		t.Errorf("verifyURL with the default client: %v", err)
+	}
+	limit := WithMaxMessageBytes(512)
+	tests := []struct {
+		name string
+		url  string
+		want error
+	}{
+		{"tampered body", server.URL + "/tampered", ErrSignatureInvalid},
+		{"not found", server.URL + "/missing", ErrFetchFailed},
+		{"body cut short", server.URL + "/cut", ErrFetchFailed},
+		{"bad URL", "http://%zz", ErrFetchFailed},
+		{"too large", server.URL + "/large", ErrMessageTooLarge},
+		{"too large, streamed", server.URL + "/streamed", ErrMessageTooLarge},
+	}
+	for _, test := range tests {
+		err := verifyURL(ctx, client, signature, test.url, keyPath, server.Client(), limit)
+		if !errors.Is(err, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.want)
+		}
+		if test.want != ErrFetchFailed && errors.Is(err, ErrFetchFailed) {
+			t.Errorf("%s: got %v, which is not a fetch failure", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	initialRetryDelay = 100 * time.Millisecond
+	maxRetryDelay     = 5 * time.Second
+)
+
+// WithRetryBudget retries failed KMS calls that are likely to succeed on a
+// second attempt, such as 503 or 429 responses. Retrying stops after
+// maxAttempts calls in total or once maxDuration has passed since the first
+// call, whichever comes first, as well as when ctx is done. A maxDuration of 0
+// means no time limit. Without this option each call is attempted once.
+func WithRetryBudget(maxAttempts int, maxDuration time.Duration) Option {
+	return func(o *options) {
+		o.maxAttempts = maxAttempts
+		o.maxRetryDuration = maxDuration
+	}
+}
+
+// retry calls f, retrying within the budget set by WithRetryBudget. If more
+// than one attempt was made, the returned error records how many.
+func (o *options) retry(ctx context.Context, f func() error) error {
+	start := time.Now()
+	delay := initialRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil {
+			return nil
+		}
+		if attempt >= o.maxAttempts || !isRetryable(err) {
+			return annotateAttempts(err, attempt)
+		}
+		// Sleep for a random duration up to delay.
+		sleep := time.Duration(rand.Int63n(int64(delay)))
+		if o.maxRetryDuration > 0 && time.Since(start)+sleep > o.maxRetryDuration {
+			return annotateAttempts(err, attempt)
+		}
+		select {
+		case <-ctx.Done():
+			return annotateAttempts(err, attempt)
+		case <-time.After(sleep):
+		}
+		if delay *= 2; delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
+
+func annotateAttempts(err error, attempts int) error {
+	if attempts == 1 {
+		return err
+	}
+	return fmt.Errorf("gave up after %d attempts: %w", attempts, err)
+}
+
+// isRetryable reports whether a failed KMS call may succeed if repeated.
+// UNAVAILABLE (503) and RESOURCE_EXHAUSTED (429) are retried, as are other
+// server and network errors. Client errors such as INVALID_ARGUMENT and
+// FAILED_PRECONDITION (both 400), for example a ciphertext that was not
+// encrypted with the key, would fail again and are never retried.
+func isRetryable(err error) bool {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		switch {
+		case apiErr.Code == http.StatusTooManyRequests:
+			return true
+		case apiErr.Code >= 400 && apiErr.Code < 500:
+			return false
+		}
+	}
+	return isUnavailable(err)
+}
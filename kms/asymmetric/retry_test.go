@@ -0,0 +1,90 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+)
+
+func TestRetryBudget(t *testing.T) {
+	ctx := context.Background()
+	unavailable := &googleapi.Error{Code: 503}
+
+	calls := 0
+	flaky := func() error {
+		if calls++; calls < 3 {
+			return unavailable
+		}
+		return nil
+	}
+	if err := newOptions([]Option{WithRetryBudget(5, time.Minute)}).retry(ctx, flaky); err != nil {
+		t.Errorf("retry with budget of 5: %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("calls = %d; want: %d", calls, 3)
+	}
+
+	calls = 0
+	err := newOptions([]Option{WithRetryBudget(2, time.Minute)}).retry(ctx, flaky)
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || !strings.Contains(err.Error(), "2 attempts") {
+		t.Errorf("retry with budget of 2: got %v; want 503 after 2 attempts", err)
+	}
+
+	calls = 0
+	if err := newOptions(nil).retry(ctx, flaky); err != unavailable || calls != 1 {
+		t.Errorf("retry without budget: got %v after %d calls; want one call", err, calls)
+	}
+}
+
+func TestDecryptRetryClassification(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("decrypt")
+	f.addKey(t, keyPath, "RSA_DECRYPT_OAEP_2048_SHA256")
+	ciphertext, err := encryptRSA(ctx, client, "secret", keyPath)
+	if err != nil {
+		t.Fatalf("encryptRSA: %v", err)
+	}
+	budget := WithRetryBudget(3, time.Minute)
+
+	for _, tc := range []struct {
+		failures  []int
+		wantCalls int
+		wantErr   bool
+	}{
+		// One decryption, not retried, and the key lookup made by oaepHint.
+		{failures: []int{400}, wantCalls: 2, wantErr: true},
+		{failures: []int{503}, wantCalls: 2},
+		{failures: []int{429, 503}, wantCalls: 3},
+	} {
+		f.mu.Lock()
+		f.failures, f.requests = tc.failures, 0
+		f.mu.Unlock()
+		plaintext, err := decryptRSA(ctx, client, ciphertext, keyPath, budget)
+		f.mu.Lock()
+		calls := f.requests
+		f.mu.Unlock()
+		if calls != tc.wantCalls {
+			t.Errorf("failures %v: %d calls, want %d", tc.failures, calls, tc.wantCalls)
+		}
+		if tc.wantErr {
+			var apiErr *googleapi.Error
+			if !errors.As(err, &apiErr) || apiErr.Code != tc.failures[0] {
+				t.Errorf("failures %v: got %v, want a %d error", tc.failures, err, tc.failures[0])
+			}
+			continue
+		}
+		if err != nil || plaintext != "secret" {
+			t.Errorf("failures %v: got (%q, %v), want (%q, nil)", tc.failures, plaintext, err, "secret")
+		}
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// decryptRSAWithFallback decrypts a ciphertext that may have been encrypted
+// with any of several versions of a rotated key. It tries each key version in
+// keyPaths in order and returns the first successful decryption. If every
+// attempt fails, the returned error contains each version's error. Versions
+// that fail are not looked up for oaepHint, so each costs one request.
+func decryptRSAWithFallback(ctx context.Context, client *cloudkms.Service, ciphertext string, keyPaths []string, opts ...Option) (string, error) {
+	if len(keyPaths) == 0 {
+		return "", errors.New("no key versions to try")
+	}
+	opts = append(opts[:len(opts):len(opts)], withoutOAEPHint())
+	var errs []error
+	for _, keyPath := range keyPaths {
+		plaintext, err := decryptRSA(ctx, client, ciphertext, keyPath, opts...)
+		if err == nil {
+			return plaintext, nil
+		}
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", keyPath, err))
+	}
+	return "", fmt.Errorf("decryption failed with all %d key versions: %w", len(keyPaths), errors.Join(errs...))
+}
+
+// keyVersionPollInterval is how often waitForKeyVersion checks the state of
+// a key version.
+var keyVersionPollInterval = 2 * time.Second
+
+// rotateSigningKey creates a new version of the asymmetric signing key
+// keyName, such as "projects/PROJECT/locations/LOCATION/keyRings/RING/cryptoKeys/KEY",
+// waits until it is enabled, and returns a signer bound to it. A new version
+// cannot sign while it is being generated, so use the returned signer, rather
+// than the latest version listed, for tokens issued after the rotation.
+// Asymmetric keys have no primary version; callers choose the version to use
+// by its name, which is the signer's keyPath.
+func rotateSigningKey(ctx context.Context, client *cloudkms.Service, keyName string, opts ...Option) (*kmsSigner, error) {
+	versionName, err := createEnabledKeyVersion(ctx, client, keyName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return newKMSSigner(ctx, client, versionName, opts...)
+}
+
+// createEnabledKeyVersion creates a new version of the key keyName, waits
+// until it is enabled and returns its name.
+func createEnabledKeyVersion(ctx context.Context, client *cloudkms.Service, keyName string, opts ...Option) (string, error) {
+	o := newOptions(opts)
+	call := client.Projects.Locations.KeyRings.CryptoKeys.CryptoKeyVersions.
+		Create(keyName, &cloudkms.CryptoKeyVersion{})
+	var version *cloudkms.CryptoKeyVersion
+	err := o.kmsCall(ctx, "CreateCryptoKeyVersion", keyName, call.Header(), func() (http.Header, error) {
+		var err error
+		version, err = call.Context(ctx).Do()
+		if err != nil {
+			return nil, err
+		}
+		return version.Header, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create version of %s: %w", keyName, err)
+	}
+	if err := waitForKeyVersion(ctx, client, version.Name, opts...); err != nil {
+		return "", err
+	}
+	return version.Name, nil
+}
+
+// A RotationResult is the outcome of rotating one key with rotateAllKeys.
+type RotationResult struct {
+	// Key is the name of the key,
+	// "projects/PROJECT/locations/LOCATION/keyRings/RING/cryptoKeys/KEY".
+	Key string
+	// Version is the name of the new, enabled key version, or empty if Err
+	// is set.
+	Version string
+	Err     error
+}
+
+// rotateAllKeys creates a new version of every asymmetric signing key in the
+// key ring keyRingPath, such as
+// "projects/PROJECT/locations/LOCATION/keyRings/RING", rotating up to
+// concurrency keys at a time, and waits until each new version is enabled.
+// If concurrency is not positive, defaultMaxInFlight is used.
+//
+// It returns one result per key, in the order KMS lists them. A key that
+// fails to rotate has its error in its result and does not stop the others;
+// the returned error is set only if the keys could not be listed, or if ctx
+// was done before every key was started.
+func rotateAllKeys(ctx context.Context, client *cloudkms.Service, keyRingPath string, concurrency int, opts ...Option) ([]RotationResult, error) {
+	o := newOptions(opts)
+	if concurrency <= 0 {
+		concurrency = defaultMaxInFlight
+	}
+	var keyNames []string
+	call := client.Projects.Locations.KeyRings.CryptoKeys.List(keyRingPath)
+	for pageToken := ""; ; {
+		var keys *cloudkms.ListCryptoKeysResponse
+		err := o.kmsCall(ctx, "ListCryptoKeys", keyRingPath, call.Header(), func() (http.Header, error) {
+			var err error
+			keys, err = call.PageToken(pageToken).Context(ctx).Do()
+			if err != nil {
+				return nil, err
+			}
+			return keys.Header, nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list keys of %s: %w", keyRingPath, err)
+		}
+		for _, key := range keys.CryptoKeys {
+			if key.Purpose == "ASYMMETRIC_SIGN" {
+				keyNames = append(keyNames, key.Name)
+			}
+		}
+		if pageToken = keys.NextPageToken; pageToken == "" {
+			break
+		}
+	}
+
+	results := make([]RotationResult, len(keyNames))
+	var wg sync.WaitGroup
+	inFlight := make(chan struct{}, concurrency)
+	for i, keyName := range keyNames {
+		results[i].Key = keyName
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			for j := range results[i:] {
+				results[i+j] = RotationResult{Key: keyNames[i+j], Err: ctx.Err()}
+			}
+			return results, ctx.Err()
+		case inFlight <- struct{}{}:
+		}
+		wg.Add(1)
+		go func(result *RotationResult) {
+			defer wg.Done()
+			defer func() { <-inFlight }()
+			result.Version, result.Err = createEnabledKeyVersion(ctx, client, result.Key, opts...)
+		}(&results[i])
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// waitForKeyVersion polls the key version at keyPath until it is enabled. It
+// fails if the version reaches any other final state, or when ctx is done.
+func waitForKeyVersion(ctx context.Context, client *cloudkms.Service, keyPath string, opts ...Option) error {
+	ticker := time.NewTicker(keyVersionPollInterval)
+	defer ticker.Stop()
+	for {
+		version, err := getKeyVersion(ctx, client, keyPath, opts...)
+		if err != nil {
+			return err
+		}
+		switch version.State {
+		case "ENABLED":
+			return nil
+		case "PENDING_GENERATION", "PENDING_IMPORT":
+		default:
+			return fmt.Errorf("key version %s is %s, not ENABLED", keyPath, version.State)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("key version %s not enabled: %w", keyPath, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
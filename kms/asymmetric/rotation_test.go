@@ -0,0 +1,165 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+func TestRotateSigningKey(t *testing.T) {
+	defer func(d time.Duration) { keyVersionPollInterval = d }(keyVersionPollInterval)
+	keyVersionPollInterval = time.Millisecond
+
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("jwt")
+	f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+
+	signer, err := rotateSigningKey(ctx, client, parentKeyPath(keyPath))
+	if err != nil {
+		t.Fatalf("rotateSigningKey: %v", err)
+	}
+	if want := parentKeyPath(keyPath) + "/cryptoKeyVersions/2"; signer.keyPath != want {
+		t.Errorf("signer bound to %s, want %s", signer.keyPath, want)
+	}
+	digest := sha256.Sum256([]byte("token"))
+	signature, err := signer.Sign(nil, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if err := verifySignatureEC(ctx, client, encodeSignature(signature), "token", signer.keyPath); err != nil {
+		t.Errorf("verifySignatureEC: %v", err)
+	}
+}
+
+func TestRotateAllKeys(t *testing.T) {
+	defer func(d time.Duration) { keyVersionPollInterval = d }(keyVersionPollInterval)
+	keyVersionPollInterval = time.Millisecond
+
+	ctx := context.Background()
+	f, _ := newFakeKMS(t)
+	for _, id := range []string{"a", "b", "c", "d"} {
+		f.addKey(t, testKeyPath(id), "EC_SIGN_P256_SHA256")
+	}
+	f.addKey(t, testKeyPath("decrypt"), "RSA_DECRYPT_OAEP_2048_SHA256")
+	// Refuse to create versions of key b, to check that one failure does
+	// not stop the batch.
+	broken := parentKeyPath(testKeyPath("b"))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && strings.Contains(r.URL.Path, broken+"/") {
+			writeFakeError(w, http.StatusForbidden, errors.New("permission denied"))
+			return
+		}
+		f.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	client, err := cloudkms.New(server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.BasePath = server.URL + "/"
+
+	results, err := rotateAllKeys(ctx, client, "projects/test/locations/global/keyRings/ring", 2)
+	if err != nil {
+		t.Fatalf("rotateAllKeys: %v", err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("got %d results, want one for each of the 4 signing keys", len(results))
+	}
+	for _, result := range results {
+		if result.Key == broken {
+			if result.Err == nil || result.Version != "" {
+				t.Errorf("%s: got version %q, error %v; want an error", result.Key, result.Version, result.Err)
+			}
+			continue
+		}
+		if want := result.Key + "/cryptoKeyVersions/2"; result.Err != nil || result.Version != want {
+			t.Errorf("%s: got version %q, error %v; want %s", result.Key, result.Version, result.Err, want)
+			continue
+		}
+		if k, ok := f.key(result.Version); !ok || k.version.State != "ENABLED" {
+			t.Errorf("%s: new version is not enabled", result.Key)
+		}
+	}
+	if _, ok := f.key(parentKeyPath(testKeyPath("decrypt")) + "/cryptoKeyVersions/2"); ok {
+		t.Error("rotateAllKeys rotated a decryption key")
+	}
+}
+
+func TestDecryptRSAWithFallback(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	// The fake shares keys between keys of one algorithm, so each version
+	// gets its own.
+	oldVersion := testKeyPath("rotated") // version 1
+	newVersion := parentKeyPath(oldVersion) + "/cryptoKeyVersions/2"
+	other := testKeyPath("unrelated")
+	signKey := testKeyPath("rotated-sign")
+	f.addKey(t, oldVersion, "RSA_DECRYPT_OAEP_2048_SHA1")
+	f.addKey(t, newVersion, "RSA_DECRYPT_OAEP_2048_SHA256")
+	f.addKey(t, other, "RSA_DECRYPT_OAEP_3072_SHA256")
+	f.addKey(t, signKey, "EC_SIGN_P256_SHA256")
+	requests := func() int {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return f.requests
+	}
+
+	ciphertext, err := encryptRSA(ctx, client, "secret", newVersion)
+	if err != nil {
+		t.Fatalf("encryptRSA: %v", err)
+	}
+	tests := []struct {
+		name     string
+		keyPaths []string
+		requests int
+	}{
+		{"later version", []string{oldVersion, newVersion}, 2},
+		{"non-decrypt key first", []string{signKey, newVersion}, 2},
+	}
+	for _, test := range tests {
+		before := requests()
+		plaintext, err := decryptRSAWithFallback(ctx, client, ciphertext, test.keyPaths)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if plaintext != "secret" {
+			t.Errorf("%s: plaintext = %q, want %q", test.name, plaintext, "secret")
+		}
+		// Failed attempts are not followed by an oaepHint key lookup.
+		if n := requests() - before; n != test.requests {
+			t.Errorf("%s: %d requests, want %d", test.name, n, test.requests)
+		}
+	}
+
+	keyPaths := []string{signKey, oldVersion, other}
+	_, err = decryptRSAWithFallback(ctx, client, ciphertext, keyPaths)
+	var joined interface{ Unwrap() []error }
+	if !errors.As(err, &joined) {
+		t.Fatalf("decryptRSAWithFallback with no matching version: got %v, want joined errors", err)
+	}
+	if n := len(joined.Unwrap()); n != len(keyPaths) {
+		t.Errorf("error joins %d errors, want %d", n, len(keyPaths))
+	}
+	for _, keyPath := range keyPaths {
+		if !strings.Contains(err.Error(), keyPath) {
+			t.Errorf("error %q does not mention %s", err, keyPath)
+		}
+	}
+	if _, err := decryptRSAWithFallback(ctx, client, ciphertext, nil); err == nil {
+		t.Error("decryptRSAWithFallback with no versions: got nil error")
+	}
+}
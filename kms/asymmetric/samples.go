@@ -11,13 +11,10 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
-	"crypto/x509"
-	"encoding/asn1"
 	"encoding/base64"
-	"encoding/pem"
 	"errors"
 	"fmt"
-	"math/big"
+	"net/http"
 
 	"golang.org/x/net/context"
 	"google.golang.org/api/cloudkms/v1"
@@ -26,17 +23,12 @@ import (
 // [START kms_get_asymmetric_public]
 
 // getAsymmetricPublicKey retrieves the public key from a saved asymmetric key pair on KMS.
-func getAsymmetricPublicKey(ctx context.Context, client *cloudkms.Service, keyPath string) (interface{}, error) {
-	response, err := client.Projects.Locations.KeyRings.CryptoKeys.CryptoKeyVersions.
-		GetPublicKey(keyPath).Context(ctx).Do()
+// fetchPublicKey also makes sure that KMS answered for the key that was
+// asked for.
+func getAsymmetricPublicKey(ctx context.Context, client *cloudkms.Service, keyPath string, opts ...Option) (interface{}, error) {
+	_, publicKey, err := fetchPublicKey(ctx, client, keyPath, opts...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch public key: %+v", err)
-	}
-	keyBytes := []byte(response.Pem)
-	block, _ := pem.Decode(keyBytes)
-	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse public key: %+v", err)
+		return nil, err
 	}
 	return publicKey, nil
 }
@@ -46,21 +38,69 @@ func getAsymmetricPublicKey(ctx context.Context, client *cloudkms.Service, keyPa
 // [START kms_decrypt_rsa]
 
 // decryptRSA will attempt to decrypt a given ciphertext with saved a RSA key.
-func decryptRSA(ctx context.Context, client *cloudkms.Service, ciphertext, keyPath string) (string, error) {
+// Callers handling secrets should prefer decryptRSABytes, as a string cannot
+// be cleared from memory after use.
+func decryptRSA(ctx context.Context, client *cloudkms.Service, ciphertext, keyPath string, opts ...Option) (string, error) {
+	message, err := decryptRSABytes(ctx, client, ciphertext, keyPath, opts...)
+	if err != nil {
+		return "", err
+	}
+	defer zeroize(message)
+	return string(message), nil
+}
+
+// decryptRSABytes is like decryptRSA, but returns the plaintext in a buffer
+// owned by the caller, who should clear it with zeroize when done.
+func decryptRSABytes(ctx context.Context, client *cloudkms.Service, ciphertext, keyPath string, opts ...Option) ([]byte, error) {
+	_, message, err := decryptRSAFull(ctx, client, ciphertext, keyPath, opts...)
+	return message, err
+}
+
+// decryptRSAFull is like decryptRSA, but also returns the raw KMS response,
+// which carries details such as the key's protection level.
+func decryptRSAFull(ctx context.Context, client *cloudkms.Service, ciphertext, keyPath string, opts ...Option) (*cloudkms.AsymmetricDecryptResponse, []byte, error) {
+	if err := validateKeyPath(keyPath); err != nil {
+		return nil, nil, err
+	}
+	ciphertextBytes, err := decodeBase64(ciphertext)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode ciphertext string: %w", err)
+	}
+	// Optional but recommended: send a checksum so KMS can detect corruption
+	// of the request in transit.
 	decryptRequest := &cloudkms.AsymmetricDecryptRequest{
-		Ciphertext: ciphertext,
+		Ciphertext:       ciphertext,
+		CiphertextCrc32c: int64(crc32c(ciphertextBytes)),
 	}
-	response, err := client.Projects.Locations.KeyRings.CryptoKeys.CryptoKeyVersions.
-		AsymmetricDecrypt(keyPath, decryptRequest).Context(ctx).Do()
+	o := newOptions(opts)
+	var response *cloudkms.AsymmetricDecryptResponse
+	err = o.retry(ctx, func() error {
+		call := client.Projects.Locations.KeyRings.CryptoKeys.CryptoKeyVersions.
+			AsymmetricDecrypt(keyPath, decryptRequest)
+		return o.kmsCall(ctx, "AsymmetricDecrypt", keyPath, call.Header(), func() (http.Header, error) {
+			var err error
+			response, err = call.Context(ctx).Do()
+			if err != nil {
+				return nil, err
+			}
+			return response.Header, nil
+		})
+	})
 	if err != nil {
-		return "", fmt.Errorf("decryption request failed: %+v", err)
+		return nil, nil, oaepHint(ctx, client, keyPath, fmt.Errorf("decryption request failed: %w", err), opts...)
+	}
+	if !response.VerifiedCiphertextCrc32c {
+		return nil, nil, o.integrityFailure("AsymmetricDecrypt", keyPath, "decryption request", false)
 	}
-	message, err := base64.StdEncoding.DecodeString(response.Plaintext)
+	message, err := decodeBase64(response.Plaintext)
 	if err != nil {
-		return "", fmt.Errorf("failed to decode decryted string: %+v", err)
+		return nil, nil, fmt.Errorf("failed to decode decryted string: %w", err)
 
 	}
-	return string(message), nil
+	if int64(crc32c(message)) != response.PlaintextCrc32c {
+		return nil, nil, o.integrityFailure("AsymmetricDecrypt", keyPath, "decryption response", true)
+	}
+	return response, message, nil
 }
 
 // [END kms_decrypt_rsa]
@@ -68,20 +108,50 @@ func decryptRSA(ctx context.Context, client *cloudkms.Service, ciphertext, keyPa
 // [START kms_encrypt_rsa]
 
 // encryptRSA creates a ciphertext from a plain message using a RSA public key saved at the specified keyPath.
-func encryptRSA(ctx context.Context, client *cloudkms.Service, message, keyPath string) (string, error) {
-	abstractKey, err := getAsymmetricPublicKey(ctx, client, keyPath)
+func encryptRSA(ctx context.Context, client *cloudkms.Service, message, keyPath string, opts ...Option) (string, error) {
+	return encryptRSABytes(ctx, client, []byte(message), keyPath, opts...)
+}
+
+// encryptRSABytes is like encryptRSA, for a message held as bytes.
+func encryptRSABytes(ctx context.Context, client *cloudkms.Service, message []byte, keyPath string, opts ...Option) (string, error) {
+	ciphertext, _, err := encryptRSAWithHash(ctx, client, message, keyPath, opts...)
+	return ciphertext, err
+}
+
+// encryptRSAWithHash is like encryptRSABytes, but also returns the OAEP hash
+// used, which is the one the key's algorithm requires.
+func encryptRSAWithHash(ctx context.Context, client *cloudkms.Service, message []byte, keyPath string, opts ...Option) (string, crypto.Hash, error) {
+	o := newOptions(opts)
+	var response *cloudkms.PublicKey
+	var abstractKey interface{}
+	err := o.retry(ctx, func() error {
+		var err error
+		response, abstractKey, err = fetchPublicKey(ctx, client, keyPath, opts...)
+		return err
+	})
 	if err != nil {
-		return "", err
+		return "", 0, err
+	}
+	// KMS uses the key's OAEP hash for both the digest and MGF1.
+	hash, err := o.oaepHash(response.Algorithm)
+	if err != nil {
+		return "", 0, err
 	}
 
 	// Perform type assertion to get the RSA key.
-	rsaKey := abstractKey.(*rsa.PublicKey)
+	rsaKey, ok := abstractKey.(*rsa.PublicKey)
+	if !ok {
+		return "", 0, fmt.Errorf("%w: want *rsa.PublicKey, got %T", ErrKeyTypeMismatch, abstractKey)
+	}
+	if err := o.checkKeyStrength(rsaKey, keyPath); err != nil {
+		return "", 0, err
+	}
 
-	ciphertextBytes, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, rsaKey, []byte(message), nil)
+	ciphertextBytes, err := rsa.EncryptOAEP(hash.New(), rand.Reader, rsaKey, message, nil)
 	if err != nil {
-		return "", fmt.Errorf("encryption failed: %+v", err)
+		return "", 0, fmt.Errorf("encryption failed: %w", err)
 	}
-	return base64.StdEncoding.EncodeToString(ciphertextBytes), nil
+	return base64.StdEncoding.EncodeToString(ciphertextBytes), hash, nil
 }
 
 // [END kms_encrypt_rsa]
@@ -89,23 +159,151 @@ func encryptRSA(ctx context.Context, client *cloudkms.Service, message, keyPath
 // [START kms_sign_asymmetric]
 
 // signAsymmetric will sign a plaintext message using a saved asymmetric private key.
-func signAsymmetric(ctx context.Context, client *cloudkms.Service, message, keyPath string) (string, error) {
+// The message is hashed with the digest algorithm that the key requires.
+// The returned signature is base64-encoded, exactly as KMS sends it, unless
+// another encoding is chosen with WithSignatureEncoding; use
+// signAsymmetricBytes to get the raw signature bytes.
+func signAsymmetric(ctx context.Context, client *cloudkms.Service, message, keyPath string, opts ...Option) (string, error) {
+	return signMessageBytes(ctx, client, []byte(message), keyPath, opts...)
+}
+
+// signMessageBytes is like signAsymmetric, but takes the message as a byte
+// slice, which it does not copy, so that callers can clear a secret message
+// once it is signed.
+func signMessageBytes(ctx context.Context, client *cloudkms.Service, message []byte, keyPath string, opts ...Option) (string, error) {
+	// Look up which digest the key signs, for example SHA-384 for an
+	// EC_SIGN_P384_SHA384 key. This costs one extra KMS call per signature
+	// unless WithKeyAlgorithm gives the algorithm.
+	alg, err := getKeyAlgorithm(ctx, client, keyPath, opts...)
+	if err != nil {
+		return "", err
+	}
+	if alg.Purpose != "ASYMMETRIC_SIGN" {
+		return "", fmt.Errorf("%w: %s does not sign digests", ErrKeyTypeMismatch, alg.Name)
+	}
+	o := newOptions(opts)
+	if err := o.checkAllowedAlgorithm(alg.Name, keyPath); err != nil {
+		return "", err
+	}
+
 	// Find the hash of the plaintext message.
-	digest := sha256.New()
-	digest.Write([]byte(message))
-	digestStr := base64.StdEncoding.EncodeToString(digest.Sum(nil))
+	digest := alg.Hash.New()
+	digest.Write(o.normalizeMessage(message))
+	signature, err := signDigestForAlgorithm(ctx, client, digest.Sum(nil), alg, keyPath, opts...)
+	if err != nil {
+		return "", err
+	}
+	if o.signRecord != nil {
+		o.signRecord.Algorithm = alg.Name
+	}
+	return o.encodeSignature(signature, alg.Name)
+}
+
+// signDigest signs a precomputed digest of a message with the key at keyPath.
+// The digest must have been computed with the hash the key's algorithm
+// requires.
+func signDigest(ctx context.Context, client *cloudkms.Service, digest []byte, keyPath string, opts ...Option) (string, error) {
+	alg, err := getKeyAlgorithm(ctx, client, keyPath, opts...)
+	if err != nil {
+		return "", err
+	}
+	return signDigestForAlgorithm(ctx, client, digest, alg, keyPath, opts...)
+}
+
+// signDigestForAlgorithm is like signDigestWithHash for a key whose
+// algorithm, alg, has already been looked up.
+func signDigestForAlgorithm(ctx context.Context, client *cloudkms.Service, digest []byte, alg AlgorithmInfo, keyPath string, opts ...Option) (string, error) {
+	opts = append(opts[:len(opts):len(opts)], WithKeyAlgorithm(alg.Name))
+	return signDigestWithHash(ctx, client, digest, alg.Hash, keyPath, opts...)
+}
 
+// signDigestWithHash sends digest, computed with hash, to KMS for signing.
+// It enforces WithAllowedAlgorithms, looking up the key's algorithm unless
+// WithKeyAlgorithm gives it.
+func signDigestWithHash(ctx context.Context, client *cloudkms.Service, digest []byte, hash crypto.Hash, keyPath string, opts ...Option) (string, error) {
+	if err := validateKeyPath(keyPath); err != nil {
+		return "", err
+	}
+	o := newOptions(opts)
+	if o.restrictAlgorithms {
+		alg := o.keyAlgorithm
+		if alg == "" {
+			version, err := getKeyVersion(ctx, client, keyPath, opts...)
+			if err != nil {
+				return "", err
+			}
+			alg = version.Algorithm
+		}
+		if err := o.checkAllowedAlgorithm(alg, keyPath); err != nil {
+			return "", err
+		}
+	}
+	if hash == 0 {
+		return "", errors.New("key does not sign digests")
+	}
+	// KMS would reject the request; explain why instead. KMS never signs a
+	// truncated digest, so WithTruncatedDigest does not apply here.
+	if err := checkDigestLength(digest, hash, false); err != nil {
+		return "", err
+	}
+	digestStr := base64.StdEncoding.EncodeToString(digest)
+	kmsDigest := &cloudkms.Digest{}
+	switch hash {
+	case crypto.SHA256:
+		kmsDigest.Sha256 = digestStr
+	case crypto.SHA384:
+		kmsDigest.Sha384 = digestStr
+	case crypto.SHA512:
+		kmsDigest.Sha512 = digestStr
+	default:
+		return "", fmt.Errorf("unsupported digest algorithm %v", hash)
+	}
+
+	// Optional but recommended: send a checksum so KMS can detect corruption
+	// of the request in transit.
 	asymmetricSignRequest := &cloudkms.AsymmetricSignRequest{
-		Digest: &cloudkms.Digest{
-			Sha256: digestStr,
-		},
+		Digest:       kmsDigest,
+		DigestCrc32c: int64(crc32c(digest)),
 	}
 
-	response, err := client.Projects.Locations.KeyRings.CryptoKeys.CryptoKeyVersions.
-		AsymmetricSign(keyPath, asymmetricSignRequest).Context(ctx).Do()
+	call := client.Projects.Locations.KeyRings.CryptoKeys.CryptoKeyVersions.
+		AsymmetricSign(keyPath, asymmetricSignRequest)
+	var response *cloudkms.AsymmetricSignResponse
+	err := o.kmsCall(ctx, "AsymmetricSign", keyPath, call.Header(), func() (http.Header, error) {
+		var err error
+		response, err = call.Context(ctx).Do()
+		if err != nil {
+			return nil, err
+		}
+		return response.Header, nil
+	})
 	if err != nil {
-		return "", fmt.Errorf("asymmetric sign request failed: %+v", err)
-
+		return "", fmt.Errorf("asymmetric sign request failed: %w", err)
+	}
+	if !response.VerifiedDigestCrc32c {
+		return "", o.integrityFailure("AsymmetricSign", keyPath, "asymmetric sign request", false)
+	}
+	signatureBytes, err := decodeBase64(response.Signature)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode signature string: %w", err)
+	}
+	if int64(crc32c(signatureBytes)) != response.SignatureCrc32c {
+		return "", o.integrityFailure("AsymmetricSign", keyPath, "asymmetric sign response", true)
+	}
+	if o.signRecord != nil {
+		*o.signRecord = SignRecord{
+			Signature:            response.Signature,
+			Digest:               append([]byte(nil), digest...),
+			Hash:                 hash,
+			KeyVersion:           response.Name,
+			LogicalKeyID:         logicalKeyID(response.Name),
+			DigestCrc32c:         asymmetricSignRequest.DigestCrc32c,
+			VerifiedDigestCrc32c: response.VerifiedDigestCrc32c,
+			SignatureCrc32c:      response.SignatureCrc32c,
+		}
+	}
+	if o.requestToken != nil {
+		*o.requestToken = signRequestToken(keyPath, hash, digest)
 	}
 
 	return response.Signature, nil
@@ -116,27 +314,43 @@ func signAsymmetric(ctx context.Context, client *cloudkms.Service, message, keyP
 // [START kms_verify_signature_rsa]
 
 // verifySignatureRSA will verify that an 'RSA_SIGN_PSS_2048_SHA256' signature is valid for a given plaintext message.
-func verifySignatureRSA(ctx context.Context, client *cloudkms.Service, signature, message, keyPath string) error {
-	abstractKey, err := getAsymmetricPublicKey(ctx, client, keyPath)
+// message must be exactly the bytes that were signed.
+func verifySignatureRSA(ctx context.Context, client *cloudkms.Service, signature, message, keyPath string, opts ...Option) error {
+	return verifySignatureRSABytes(ctx, client, signature, []byte(message), keyPath, opts...)
+}
+
+// verifySignatureRSABytes is like verifySignatureRSA, for a message held as bytes.
+func verifySignatureRSABytes(ctx context.Context, client *cloudkms.Service, signature string, message []byte, keyPath string, opts ...Option) error {
+	o := newOptions(opts)
+	if err := o.checkMessageLength(message); err != nil {
+		return err
+	}
+	abstractKey, err := o.getPublicKey(ctx, client, keyPath)
 	if err != nil {
 		return err
 	}
 	// Perform type assertion to get the RSA key.
-	rsaKey := abstractKey.(*rsa.PublicKey)
-	decodedSignature, err := base64.StdEncoding.DecodeString(signature)
+	rsaKey, ok := abstractKey.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("%w: want *rsa.PublicKey, got %T", ErrKeyTypeMismatch, abstractKey)
+	}
+	decodedSignature, err := o.decodeSignature(signature)
 	if err != nil {
-		return fmt.Errorf("failed to decode signature string: %+v", err)
-
+		return err
 	}
 	digest := sha256.New()
-	digest.Write([]byte(message))
+	digest.Write(o.normalizeMessage(message))
 	hash := digest.Sum(nil)
 
-	pssOptions := rsa.PSSOptions{SaltLength: len(hash), Hash: crypto.SHA256}
+	start := o.startTimer()
+	pssOptions := rsa.PSSOptions{SaltLength: o.saltLength(len(hash)), Hash: crypto.SHA256}
 	err = rsa.VerifyPSS(rsaKey, crypto.SHA256, hash, decodedSignature, &pssOptions)
+	o.recordVerify(start)
 	if err != nil {
-		return fmt.Errorf("signature verification failed: %+v", err)
+		err = fmt.Errorf("%w: %w", ErrSignatureInvalid, err)
+		return o.diagnoseHash(err, rsaKey, rsaPSSAlgorithm(rsaKey), message, decodedSignature)
 	}
+	o.reportAlgorithm(func() string { return rsaPSSAlgorithm(rsaKey) })
 	return nil
 }
 
@@ -144,31 +358,54 @@ func verifySignatureRSA(ctx context.Context, client *cloudkms.Service, signature
 
 // [START kms_verify_signature_ec]
 
-// verifySignatureEC will verify that an 'EC_SIGN_P224_SHA256' signature is valid for a given plaintext message.
-func verifySignatureEC(ctx context.Context, client *cloudkms.Service, signature, message, keyPath string) error {
-	abstractKey, err := getAsymmetricPublicKey(ctx, client, keyPath)
+// verifySignatureEC will verify that an 'EC_SIGN_P256_SHA256' signature is valid for a given plaintext message.
+// message must be exactly the bytes that were signed.
+func verifySignatureEC(ctx context.Context, client *cloudkms.Service, signature, message, keyPath string, opts ...Option) error {
+	return verifySignatureECBytes(ctx, client, signature, []byte(message), keyPath, opts...)
+}
+
+// verifySignatureECBytes is like verifySignatureEC, for a message held as bytes.
+func verifySignatureECBytes(ctx context.Context, client *cloudkms.Service, signature string, message []byte, keyPath string, opts ...Option) error {
+	o := newOptions(opts)
+	if err := o.checkMessageLength(message); err != nil {
+		return err
+	}
+	abstractKey, err := o.getPublicKey(ctx, client, keyPath)
 	if err != nil {
 		return err
 	}
 	// Perform type assertion to get the elliptic curve key.
-	ecKey := abstractKey.(*ecdsa.PublicKey)
-	decodedSignature, err := base64.StdEncoding.DecodeString(signature)
+	ecKey, ok := abstractKey.(*ecdsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("%w: want *ecdsa.PublicKey, got %T", ErrKeyTypeMismatch, abstractKey)
+	}
+	// Hash as KMS does for the curve; the curve fixes the algorithm.
+	hash, err := ecHash(ecKey.Curve)
 	if err != nil {
-		return fmt.Errorf("failed to decode signature string: %+v", err)
+		return err
+	}
+	decodedSignature, err := o.decodeSignature(signature)
+	if err != nil {
+		return err
 	}
-	var parsedSig struct{ R, S *big.Int }
-	_, err = asn1.Unmarshal(decodedSignature, &parsedSig)
+	r, s, err := parseECDSASignature(decodedSignature, ecKey.Curve)
 	if err != nil {
-		return fmt.Errorf("failed to parse signature bytes: %+v", err)
+		return err
+	}
+	if err := o.checkLowS(s, ecKey.Curve); err != nil {
+		return err
 	}
 
-	digest := sha256.New()
-	digest.Write([]byte(message))
-	hash := digest.Sum(nil)
+	digest := hash.New()
+	digest.Write(o.normalizeMessage(message))
 
-	if !ecdsa.Verify(ecKey, hash, parsedSig.R, parsedSig.S) {
-		return errors.New("signature verification failed")
+	start := o.startTimer()
+	valid := ecdsa.Verify(ecKey, digest.Sum(nil), r, s)
+	o.recordVerify(start)
+	if !valid {
+		return o.diagnoseHash(ErrSignatureInvalid, ecKey, ecAlgorithm(ecKey), message, decodedSignature)
 	}
+	o.reportAlgorithm(func() string { return ecAlgorithm(ecKey) })
 	return nil
 }
 
@@ -8,6 +8,8 @@ package main
 import (
 	"crypto/ecdsa"
 	"crypto/rsa"
+	"crypto/x509"
+	"errors"
 	"os"
 	"testing"
 	"time"
@@ -84,7 +86,7 @@ func TestMain(m *testing.M) {
 		//Create cryptokeys in the test project if needed.
 		s1 := createKeyHelper(v, v.rsaDecryptId, v.rsaDecryptPath, "ASYMMETRIC_DECRYPT", "RSA_DECRYPT_OAEP_2048_SHA256", parent)
 		s2 := createKeyHelper(v, v.rsaSignId, v.rsaSignPath, "ASYMMETRIC_SIGN", "RSA_SIGN_PSS_2048_SHA256", parent)
-		s3 := createKeyHelper(v, v.ecSignId, v.ecSignPath, "ASYMMETRIC_SIGN", "EC_SIGN_P224_SHA256", parent)
+		s3 := createKeyHelper(v, v.ecSignId, v.ecSignPath, "ASYMMETRIC_SIGN", "EC_SIGN_P256_SHA256", parent)
 		if s1 || s2 || s3 {
 			//Leave time for keys to initialize.
 			time.Sleep(20 * time.Second)
@@ -175,8 +177,14 @@ func TestRSASignVerify(t *testing.T) {
 	if err = verifySignatureRSA(v.ctx, v.client, sig, v.message, v.rsaSignPath); err != nil {
 		t.Fatalf("verifySignatureRSA(%s, %s, %s): %v", sig, v.message, v.rsaSignPath, err)
 	}
-	if err = verifySignatureRSA(v.ctx, v.client, sig, v.message+".", v.rsaSignPath); err == nil {
-		t.Errorf("verification for modified message should fail")
+	if err = verifySignatureRSA(v.ctx, v.client, sig, v.message+".", v.rsaSignPath); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("verification for modified message: got %v; want ErrSignatureInvalid", err)
+	}
+	if err = verifySignatureEC(v.ctx, v.client, sig, v.message, v.rsaSignPath); !errors.Is(err, ErrKeyTypeMismatch) {
+		t.Errorf("verifySignatureEC with RSA key: got %v; want ErrKeyTypeMismatch", err)
+	}
+	if err = verifySignatureRSA(v.ctx, v.client, sig, v.message, v.rsaSignPath, WithExpectedLength(len(v.message)+1)); err == nil {
+		t.Errorf("verification with mismatched expected length should fail")
 	}
 }
 
@@ -202,3 +210,107 @@ func TestECSignVerify(t *testing.T) {
 		t.Errorf("verification for modified message should fail")
 	}
 }
+
+func TestKeyBitLength(t *testing.T) {
+	tc := testutil.SystemTest(t)
+	v, err := getTestVariables(tc.ProjectID)
+	if err != nil {
+		t.Fatalf("intial variable setup failed: %v", err)
+	}
+
+	bits, err := keyBitLength(v.ctx, v.client, v.rsaSignPath)
+	if err != nil {
+		t.Fatalf("keyBitLength(%s): %v", v.rsaSignPath, err)
+	}
+	if bits != 2048 {
+		t.Errorf("keyBitLength(%s) = %d; want: %d", v.rsaSignPath, bits, 2048)
+	}
+	bits, err = keyBitLength(v.ctx, v.client, v.ecSignPath)
+	if err != nil {
+		t.Fatalf("keyBitLength(%s): %v", v.ecSignPath, err)
+	}
+	if bits != 256 {
+		t.Errorf("keyBitLength(%s) = %d; want: %d", v.ecSignPath, bits, 256)
+	}
+}
+
+func TestGenerateAndWrapDEK(t *testing.T) {
+	tc := testutil.SystemTest(t)
+	v, err := getTestVariables(tc.ProjectID)
+	if err != nil {
+		t.Fatalf("intial variable setup failed: %v", err)
+	}
+
+	dek, wrapped, err := generateAndWrapDEK(v.ctx, v.client, v.rsaDecryptPath)
+	if err != nil {
+		t.Fatalf("generateAndWrapDEK(%s): %v", v.rsaDecryptPath, err)
+	}
+	if len(dek) != dekSize {
+		t.Errorf("dek length = %d; want: %d", len(dek), dekSize)
+	}
+	unwrapped, err := decryptRSA(v.ctx, v.client, wrapped, v.rsaDecryptPath)
+	if err != nil {
+		t.Fatalf("decryptRSA(%s, %s): %v", wrapped, v.rsaDecryptPath, err)
+	}
+	if unwrapped != string(dek) {
+		t.Errorf("unwrapped key does not match generated key")
+	}
+}
+
+func TestKMSAlgorithmToX509(t *testing.T) {
+	tests := []struct {
+		alg  string
+		want x509.SignatureAlgorithm
+	}{
+		{"RSA_SIGN_PSS_2048_SHA256", x509.SHA256WithRSAPSS},
+		{"RSA_SIGN_PSS_4096_SHA512", x509.SHA512WithRSAPSS},
+		{"RSA_SIGN_PKCS1_3072_SHA256", x509.SHA256WithRSA},
+		{"RSA_SIGN_PKCS1_4096_SHA512", x509.SHA512WithRSA},
+		{"EC_SIGN_P256_SHA256", x509.ECDSAWithSHA256},
+		{"EC_SIGN_P384_SHA384", x509.ECDSAWithSHA384},
+	}
+	for _, test := range tests {
+		got, err := kmsAlgorithmToX509(test.alg)
+		if err != nil {
+			t.Errorf("kmsAlgorithmToX509(%s): %v", test.alg, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("kmsAlgorithmToX509(%s) = %v; want: %v", test.alg, got, test.want)
+		}
+	}
+	if _, err := kmsAlgorithmToX509("RSA_DECRYPT_OAEP_2048_SHA256"); err == nil {
+		t.Errorf("kmsAlgorithmToX509 should fail for a decryption algorithm")
+	}
+}
+
+func TestValidateKeyAtStartup(t *testing.T) {
+	tc := testutil.SystemTest(t)
+	v, err := getTestVariables(tc.ProjectID)
+	if err != nil {
+		t.Fatalf("intial variable setup failed: %v", err)
+	}
+
+	if err := validateKeyAtStartup(v.ctx, v.client, v.rsaSignPath, "ASYMMETRIC_SIGN"); err != nil {
+		t.Errorf("validateKeyAtStartup(%s): %v", v.rsaSignPath, err)
+	}
+	if err := validateKeyAtStartup(v.ctx, v.client, v.rsaSignPath, "ASYMMETRIC_DECRYPT"); err == nil {
+		t.Errorf("validateKeyAtStartup(%s) with wrong purpose should fail", v.rsaSignPath)
+	}
+}
+
+func TestEnforceAlgorithm(t *testing.T) {
+	tc := testutil.SystemTest(t)
+	v, err := getTestVariables(tc.ProjectID)
+	if err != nil {
+		t.Fatalf("intial variable setup failed: %v", err)
+	}
+
+	if err := enforceAlgorithm(v.ctx, v.client, v.rsaSignPath, []string{"RSA_SIGN_PSS_2048_SHA256"}); err != nil {
+		t.Errorf("enforceAlgorithm(%s): %v", v.rsaSignPath, err)
+	}
+	_, err = signAsymmetric(v.ctx, v.client, v.message, v.rsaSignPath, WithAllowedAlgorithms("EC_SIGN_P384_SHA384"))
+	if !errors.Is(err, ErrAlgorithmNotAllowed) {
+		t.Errorf("signAsymmetric with disallowed algorithm: got %v; want ErrAlgorithmNotAllowed", err)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// selfTestNonceSize is the size in bytes of the random value signed or
+// encrypted by selfTestKey. It fits within every OAEP key's message limit.
+const selfTestNonceSize = 32
+
+// A SelfTestError reports the step at which selfTestKey failed.
+type SelfTestError struct {
+	KeyPath string
+	// Step is "look up key", "sign", "fetch public key", "verify",
+	// "encrypt", "decrypt" or "compare".
+	Step string
+	Err  error
+}
+
+func (e *SelfTestError) Error() string {
+	return fmt.Sprintf("self-test of %s failed at %s: %v", e.KeyPath, e.Step, e.Err)
+}
+
+func (e *SelfTestError) Unwrap() error { return e.Err }
+
+// selfTestKey exercises the key version at keyPath end to end, as a deploy
+// gate: a signing key signs a random nonce through KMS, and the signature is
+// verified locally; a decryption key encrypts a random nonce locally, and
+// KMS decrypts it. Nothing is stored, so there is nothing to clean up. It
+// returns nil if every step passed, and otherwise a *SelfTestError naming
+// the step that failed.
+func selfTestKey(ctx context.Context, client *cloudkms.Service, keyPath string, opts ...Option) error {
+	fail := func(step string, err error) error {
+		return &SelfTestError{KeyPath: keyPath, Step: step, Err: err}
+	}
+	alg, err := getKeyAlgorithm(ctx, client, keyPath, opts...)
+	if err != nil {
+		return fail("look up key", err)
+	}
+	nonce := make([]byte, selfTestNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return fail("look up key", fmt.Errorf("failed to generate nonce: %w", err))
+	}
+	switch alg.Purpose {
+	case "ASYMMETRIC_SIGN":
+		if alg.Hash == 0 {
+			return fail("sign", fmt.Errorf("self-test of %s keys is not supported", alg.Name))
+		}
+		digest := alg.Hash.New()
+		digest.Write(nonce)
+		sum := digest.Sum(nil)
+		signature, err := signDigestForAlgorithm(ctx, client, sum, alg, keyPath, opts...)
+		if err != nil {
+			return fail("sign", err)
+		}
+		decoded, err := decodeBase64(signature)
+		if err != nil {
+			return fail("sign", fmt.Errorf("failed to decode signature string: %w", err))
+		}
+		_, publicKey, err := fetchPublicKey(ctx, client, keyPath, opts...)
+		if err != nil {
+			return fail("fetch public key", err)
+		}
+		if err := verifyDigest(publicKey, alg, sum, decoded); err != nil {
+			return fail("verify", err)
+		}
+	case "ASYMMETRIC_DECRYPT":
+		ciphertext, err := encryptRSABytes(ctx, client, nonce, keyPath, opts...)
+		if err != nil {
+			return fail("encrypt", err)
+		}
+		plaintext, err := decryptRSABytes(ctx, client, ciphertext, keyPath, opts...)
+		if err != nil {
+			return fail("decrypt", err)
+		}
+		defer zeroize(plaintext)
+		if !bytes.Equal(plaintext, nonce) {
+			return fail("compare", errors.New("decrypted nonce does not match"))
+		}
+	default:
+		return fail("look up key", fmt.Errorf("unsupported key purpose %s", alg.Purpose))
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestSelfTestKey(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	for _, alg := range []string{
+		"RSA_SIGN_PSS_2048_SHA256",
+		"RSA_SIGN_PKCS1_2048_SHA256",
+		"EC_SIGN_P256_SHA256",
+		"EC_SIGN_P384_SHA384",
+		"RSA_DECRYPT_OAEP_2048_SHA256",
+		"RSA_DECRYPT_OAEP_4096_SHA512",
+	} {
+		keyPath := testKeyPath(alg)
+		f.addKey(t, keyPath, alg)
+		if err := selfTestKey(ctx, client, keyPath); err != nil {
+			t.Errorf("%s: %v", alg, err)
+		}
+	}
+
+	f.mu.Lock()
+	f.failures = []int{0, http.StatusForbidden}
+	f.mu.Unlock()
+	err := selfTestKey(ctx, client, testKeyPath("EC_SIGN_P256_SHA256"))
+	var selfTestErr *SelfTestError
+	if !errors.As(err, &selfTestErr) || selfTestErr.Step != "sign" {
+		t.Errorf("got %v, want a failure at the sign step", err)
+	}
+}
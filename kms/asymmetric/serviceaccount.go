@@ -0,0 +1,58 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// verifySASignature verifies a signature made by the IAM signBlob API with a
+// Google-managed service account key. signBlob signs the blob itself with
+// RSASSA-PKCS1-v1_5 and SHA-256, hashing it on Google's side; KMS, by
+// contrast, signs a digest the caller computed, with the padding and hash of
+// the key's algorithm. signature is the raw signature, base64-decoded from
+// the signedBlob field of the response.
+//
+// pubKeyPEM is the service account's public key, either as the PEM
+// certificate published at
+// https://www.googleapis.com/service_accounts/v1/metadata/x509/ACCOUNT_EMAIL
+// for the keyId that signBlob returned, or as a PEM PUBLIC KEY block.
+func verifySASignature(blob, signature []byte, pubKeyPEM string) error {
+	block, _ := pem.Decode([]byte(pubKeyPEM))
+	if block == nil {
+		return errors.New("failed to parse service account key: no PEM data found")
+	}
+	var publicKey crypto.PublicKey
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("failed to parse service account certificate: %w", err)
+		}
+		publicKey = cert.PublicKey
+	case "PUBLIC KEY":
+		var err error
+		if publicKey, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+			return fmt.Errorf("failed to parse service account key: %w", err)
+		}
+	default:
+		return fmt.Errorf("unexpected PEM block %q for a service account key", block.Type)
+	}
+	rsaKey, ok := publicKey.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("%w: want *rsa.PublicKey, got %T", ErrKeyTypeMismatch, publicKey)
+	}
+	digest := sha256.Sum256(blob)
+	if err := rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest[:], signature); err != nil {
+		return fmt.Errorf("%w: %w", ErrSignatureInvalid, err)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestVerifySASignature(t *testing.T) {
+	// A service account key signs like an RSA_SIGN_PKCS1_2048_SHA256 key,
+	// but over the blob itself.
+	key, sign := generateTestKey(t, "RSA_SIGN_PKCS1_2048_SHA256")
+	blob := []byte("blob")
+	signature, err := decodeSignature(sign(blob))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Google publishes service account keys as self-signed certificates.
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sa@project.iam.gserviceaccount.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range []*pem.Block{{Type: "CERTIFICATE", Bytes: certDER}, {Type: "PUBLIC KEY", Bytes: keyDER}} {
+		pubKeyPEM := string(pem.EncodeToMemory(block))
+		if err := verifySASignature(blob, signature, pubKeyPEM); err != nil {
+			t.Errorf("%s: verifySASignature: %v", block.Type, err)
+		}
+		if err := verifySASignature([]byte("other"), signature, pubKeyPEM); !errors.Is(err, ErrSignatureInvalid) {
+			t.Errorf("%s: verifySASignature of another blob: got %v, want ErrSignatureInvalid", block.Type, err)
+		}
+	}
+
+	ecKey, _ := generateTestKey(t, "EC_SIGN_P256_SHA256")
+	ecDER, err := x509.MarshalPKIXPublicKey(ecKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: ecDER}))
+	if err := verifySASignature(blob, signature, ecPEM); !errors.Is(err, ErrKeyTypeMismatch) {
+		t.Errorf("verifySASignature with an EC key: got %v, want ErrKeyTypeMismatch", err)
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// A ShardItem is a base64-encoded signature and the message it signs, to be
+// checked by verifyShards.
+type ShardItem struct {
+	Sig, Msg string
+}
+
+// A ShardResult is the outcome of verifying one ShardItem.
+type ShardResult struct {
+	// Valid is true if the signature is valid.
+	Valid bool
+	// Err is why the signature is not valid: it wraps ErrSignatureInvalid
+	// if the signature was checked and does not match, and is an
+	// operational error, such as ErrSignatureMalformed or the context's
+	// error, if it could not be checked.
+	Err error
+}
+
+// verifyShards verifies items with the key at keyPath, splitting them into
+// shards contiguous runs, or defaultMaxInFlight if shards is not positive,
+// that are verified concurrently. The public key is fetched once, as by
+// newVerifier, and shared by every shard. The results are in the order of
+// items. Each item is reported, but if any could not be checked, for
+// example because its signature is malformed or ctx ended, the error of the
+// first such item is also returned; items not reached before ctx ended have
+// ctx's error as their Err.
+func verifyShards(ctx context.Context, client *cloudkms.Service, items []ShardItem, keyPath string, shards int, opts ...Option) ([]ShardResult, error) {
+	verify, err := newVerifier(ctx, client, keyPath, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if shards <= 0 {
+		shards = defaultMaxInFlight
+	}
+	if shards > len(items) {
+		shards = len(items)
+	}
+	results := make([]ShardResult, len(items))
+	var wg sync.WaitGroup
+	for shard := 0; shard < shards; shard++ {
+		start, end := shard*len(items)/shards, (shard+1)*len(items)/shards
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				if err := ctx.Err(); err != nil {
+					results[i].Err = err
+					continue
+				}
+				results[i].Err = verify(items[i].Sig, items[i].Msg)
+				results[i].Valid = results[i].Err == nil
+			}
+		}()
+	}
+	wg.Wait()
+	for i, result := range results {
+		if _, err := validity(result.Err); err != nil {
+			return results, fmt.Errorf("item %d: %w", i, err)
+		}
+	}
+	return results, nil
+}
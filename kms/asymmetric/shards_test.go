@@ -0,0 +1,71 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestVerifyShards(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("shards")
+	f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+	var items []ShardItem
+	for i := 0; i < 10; i++ {
+		msg := fmt.Sprintf("message %d", i)
+		sig, err := signAsymmetric(ctx, client, msg, keyPath)
+		if err != nil {
+			t.Fatalf("signAsymmetric: %v", err)
+		}
+		items = append(items, ShardItem{Sig: sig, Msg: msg})
+	}
+	items[3].Msg = "tampered"
+
+	f.mu.Lock()
+	before := f.requests
+	f.mu.Unlock()
+	results, err := verifyShards(ctx, client, items, keyPath, 3)
+	if err != nil {
+		t.Fatalf("verifyShards: %v", err)
+	}
+	f.mu.Lock()
+	if n := f.requests - before; n != 1 {
+		t.Errorf("verifyShards sent %d requests, want 1 to fetch the public key", n)
+	}
+	f.mu.Unlock()
+	if len(results) != len(items) {
+		t.Fatalf("got %d results, want %d", len(results), len(items))
+	}
+	for i, result := range results {
+		if want := i != 3; result.Valid != want {
+			t.Errorf("item %d: Valid = %v (%v), want %v", i, result.Valid, result.Err, want)
+		}
+	}
+	if !errors.Is(results[3].Err, ErrSignatureInvalid) {
+		t.Errorf("tampered item: Err = %v, want ErrSignatureInvalid", results[3].Err)
+	}
+
+	// A malformed signature is an operational error, but the other items are
+	// still reported.
+	items[5].Sig = "not base64!"
+	results, err = verifyShards(ctx, client, items, keyPath, 0)
+	if !errors.Is(err, ErrSignatureMalformed) {
+		t.Errorf("verifyShards with a malformed signature: got %v, want ErrSignatureMalformed", err)
+	}
+	if len(results) != len(items) || !results[9].Valid {
+		t.Errorf("results alongside an operational error = %+v, want every item reported", results)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := verifyShards(canceled, client, items, keyPath, 2); !errors.Is(err, context.Canceled) {
+		t.Errorf("verifyShards with a canceled context: got %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// maxSidecarBytes bounds the size of a signature file read by
+// verifyFileDetached. The largest signature, RSA 4096 as PEM, is under 1 KiB.
+const maxSidecarBytes = 64 << 10
+
+// signFileDetached signs the file at dataPath with the key at keyPath and
+// writes the signature to sigPath, followed by a newline, like the detached
+// .sig files of GPG. The file is streamed through the hash, so files of any
+// size can be signed in constant memory. The signature is base64 unless
+// another encoding, such as SignaturePEM, is chosen with
+// WithSignatureEncoding. An existing file at sigPath is replaced.
+func signFileDetached(ctx context.Context, client *cloudkms.Service, dataPath, sigPath, keyPath string, opts ...Option) error {
+	data, err := os.Open(dataPath)
+	if err != nil {
+		return fileError("read data file", dataPath, err)
+	}
+	defer data.Close()
+	signature, err := signAsymmetricReader(ctx, client, data, keyPath, opts...)
+	if err != nil {
+		return err
+	}
+	if !strings.HasSuffix(signature, "\n") {
+		signature += "\n"
+	}
+	if err := os.WriteFile(sigPath, []byte(signature), 0644); err != nil {
+		return fileError("write signature file", sigPath, err)
+	}
+	return nil
+}
+
+// verifyFileDetached verifies the signature in the file at sigPath, as
+// written by signFileDetached, over the file at dataPath with the key at
+// keyPath. The data file is streamed like in signFileDetached. Base64, hex
+// and PEM signatures are all accepted unless WithSignatureEncoding says
+// otherwise; surrounding whitespace is ignored.
+func verifyFileDetached(ctx context.Context, client *cloudkms.Service, dataPath, sigPath, keyPath string, opts ...Option) error {
+	sigFile, err := os.Open(sigPath)
+	if err != nil {
+		return fileError("read signature file", sigPath, err)
+	}
+	defer sigFile.Close()
+	signature, err := io.ReadAll(io.LimitReader(sigFile, maxSidecarBytes+1))
+	if err != nil {
+		return fileError("read signature file", sigPath, err)
+	}
+	if len(signature) > maxSidecarBytes {
+		return fmt.Errorf("%w: signature file %s is larger than %d bytes", ErrSignatureMalformed, sigPath, maxSidecarBytes)
+	}
+	data, err := os.Open(dataPath)
+	if err != nil {
+		return fileError("read data file", dataPath, err)
+	}
+	defer data.Close()
+	opts = append([]Option{WithSignatureEncoding(SignatureAutoDetect)}, opts...)
+	return verifySignatureReader(ctx, client, strings.TrimSpace(string(signature)), data, keyPath, opts...)
+}
+
+// verifyReaderWithSig verifies signature over everything read from r, such
+// as os.Stdin in a pipeline like "cat file | verifytool -sig SIG -key KEY",
+// with the key at keyPath. r is streamed through the hash until EOF, so it
+// need not be seekable or fit in memory; give WithMaxMessageBytes to bound
+// how much is read. As with verifyFileDetached, signature may be base64, hex
+// or PEM unless WithSignatureEncoding says otherwise, and surrounding
+// whitespace, as left by command substitution, is ignored.
+func verifyReaderWithSig(ctx context.Context, client *cloudkms.Service, r io.Reader, signature, keyPath string, opts ...Option) error {
+	opts = append([]Option{WithSignatureEncoding(SignatureAutoDetect)}, opts...)
+	return verifySignatureReader(ctx, client, strings.TrimSpace(signature), r, keyPath, opts...)
+}
+
+// fileError describes err, from trying to do op on the file at path, with
+// the common causes spelled out. err stays in the chain, so callers can
+// still test for fs.ErrNotExist or fs.ErrPermission.
+func fileError(op, path string, err error) error {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return fmt.Errorf("cannot %s %s: no such file or directory: %w", op, path, err)
+	case errors.Is(err, fs.ErrPermission):
+		return fmt.Errorf("cannot %s %s: permission denied: %w", op, path, err)
+	case errors.Is(err, fs.ErrExist):
+		return fmt.Errorf("cannot %s %s: file already exists: %w", op, path, err)
+	default:
+		return fmt.Errorf("cannot %s %s: %w", op, path, err)
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestSignFileDetached(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("ec-sign")
+	f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+	dir := t.TempDir()
+	dataPath := filepath.Join(dir, "release.tar")
+	if err := os.WriteFile(dataPath, []byte(strings.Repeat("data", 1<<16)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range []SignatureEncoding{SignatureBase64, SignaturePEM} {
+		sigPath := dataPath + ".sig"
+		if err := signFileDetached(ctx, client, dataPath, sigPath, keyPath, WithSignatureEncoding(e)); err != nil {
+			t.Fatalf("encoding %d: signFileDetached: %v", e, err)
+		}
+		if err := verifyFileDetached(ctx, client, dataPath, sigPath, keyPath); err != nil {
+			t.Errorf("encoding %d: verifyFileDetached: %v", e, err)
+		}
+	}
+
+	sigPath := dataPath + ".sig"
+	otherPath := filepath.Join(dir, "other.tar")
+	if err := os.WriteFile(otherPath, []byte("other"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyFileDetached(ctx, client, otherPath, sigPath, keyPath); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("verifyFileDetached with other data: got %v, want ErrSignatureInvalid", err)
+	}
+
+	missing := filepath.Join(dir, "missing")
+	if err := signFileDetached(ctx, client, missing, sigPath, keyPath); !errors.Is(err, fs.ErrNotExist) || !strings.Contains(err.Error(), missing) {
+		t.Errorf("signFileDetached with a missing data file: got %v, want fs.ErrNotExist naming the file", err)
+	}
+	if err := verifyFileDetached(ctx, client, dataPath, missing, keyPath); !errors.Is(err, fs.ErrNotExist) || !strings.Contains(err.Error(), "signature file") {
+		t.Errorf("verifyFileDetached with a missing signature file: got %v, want fs.ErrNotExist naming the signature file", err)
+	}
+	noDir := filepath.Join(missing, "release.tar.sig")
+	if err := signFileDetached(ctx, client, dataPath, noDir, keyPath); !errors.Is(err, fs.ErrNotExist) || !strings.Contains(err.Error(), "write signature file") {
+		t.Errorf("signFileDetached into a missing directory: got %v, want fs.ErrNotExist", err)
+	}
+}
+
+func TestVerifyReaderWithSig(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("ec-sign")
+	f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+	data := strings.Repeat("piped data\n", 1<<14)
+	signature, err := signAsymmetricReader(ctx, client, strings.NewReader(data), keyPath, WithSignatureEncoding(SignatureHex))
+	if err != nil {
+		t.Fatalf("signAsymmetricReader: %v", err)
+	}
+
+	// A pipe, like stdin, cannot seek.
+	pipe := func(s string) io.Reader {
+		r, w := io.Pipe()
+		t.Cleanup(func() { r.Close() })
+		go func() {
+			for len(s) > 0 {
+				n := len(s)
+				if n > 4096 {
+					n = 4096
+				}
+				if _, err := w.Write([]byte(s[:n])); err != nil {
+					return
+				}
+				s = s[n:]
+			}
+			w.Close()
+		}()
+		return r
+	}
+	if err := verifyReaderWithSig(ctx, client, pipe(data), signature+"\n", keyPath); err != nil {
+		t.Errorf("verifyReaderWithSig: %v", err)
+	}
+	if err := verifyReaderWithSig(ctx, client, pipe(data+"x"), signature, keyPath); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("verifyReaderWithSig with changed data: got %v, want ErrSignatureInvalid", err)
+	}
+	limit := WithMaxMessageBytes(int64(len(data) - 1))
+	if err := verifyReaderWithSig(ctx, client, pipe(data), signature, keyPath, limit); !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("verifyReaderWithSig over the limit: got %v, want ErrMessageTooLarge", err)
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// A SignatureBundle carries a signature with what is needed to trust it
+// offline, in the manner of a Sigstore bundle. It is unrelated to the trust
+// bundles of loadTrustBundle, which hold public keys.
+type SignatureBundle struct {
+	// Signature is the signature over the message, in the encoding given by
+	// WithSignatureEncoding, base64 by default.
+	Signature string
+	// Algorithm is the KMS algorithm of the signing key, such as
+	// "EC_SIGN_P256_SHA256", which selects the hash and padding.
+	Algorithm string
+	// CertChain is the PEM-encoded signing certificate, followed by any
+	// intermediate certificates.
+	CertChain []byte
+	// Timestamp is an optional DER-encoded RFC 3161 TimeStampToken over the
+	// raw signature bytes, proving when the signature was made.
+	Timestamp []byte
+}
+
+// oidTSTInfo is the content type of an RFC 3161 TimeStampToken.
+var oidTSTInfo = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+
+// tstInfo is the signed content of an RFC 3161 TimeStampToken.
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint tstMessageImprint
+	SerialNumber   *big.Int
+	GenTime        time.Time     `asn1:"generalized"`
+	Accuracy       tstAccuracy   `asn1:"optional"`
+	Ordering       bool          `asn1:"optional"`
+	Nonce          *big.Int      `asn1:"optional"`
+	TSA            asn1.RawValue `asn1:"optional,explicit,tag:0"`
+	Extensions     asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type tstMessageImprint struct {
+	HashAlgorithm cmsAlgorithmIdentifier
+	HashedMessage []byte
+}
+
+type tstAccuracy struct {
+	Seconds int `asn1:"optional"`
+	Millis  int `asn1:"optional,tag:0"`
+	Micros  int `asn1:"optional,tag:1"`
+}
+
+// verifyBundle checks bundle as a signature over message, entirely offline,
+// and fails with an error naming the first component that does not check
+// out:
+//   - the timestamp, if any, must be a TimeStampToken over the signature,
+//     signed by a time-stamping certificate that chains to one of the
+//     PEM-encoded tsaRootsPEM at the time it states, or the error wraps
+//     ErrTimestampInvalid;
+//   - the signing certificate must be valid at the timestamp's time, or
+//     without one at the time given by WithClock or else now, or the error
+//     wraps ErrCertExpired or ErrCertNotYetValid;
+//   - the chain must lead from it to one of the PEM-encoded rootsPEM, valid
+//     at the same time, or the error wraps ErrChainInvalid;
+//   - the signature must match message and the certificate's key under
+//     bundle.Algorithm, or the error wraps ErrSignatureInvalid, or
+//     ErrKeyTypeMismatch if the key does not fit the algorithm.
+//
+// A timestamp makes a signature by a short-lived certificate verifiable
+// after the certificate expires.
+func verifyBundle(bundle *SignatureBundle, message, rootsPEM, tsaRootsPEM []byte, opts ...Option) error {
+	o := newOptions(opts)
+	alg, ok := lookupAlgorithm(bundle.Algorithm)
+	if !ok || alg.Purpose != "ASYMMETRIC_SIGN" || alg.Hash == 0 {
+		return fmt.Errorf("%w: %q is not a KMS algorithm that signs digests", ErrKeyTypeMismatch, bundle.Algorithm)
+	}
+	signature, err := o.decodeSignature(bundle.Signature)
+	if err != nil {
+		return fmt.Errorf("bundle signature: %w", err)
+	}
+
+	at := o.now()
+	if len(bundle.Timestamp) > 0 {
+		if at, err = verifyTimestampToken(bundle.Timestamp, signature, tsaRootsPEM); err != nil {
+			return fmt.Errorf("bundle timestamp: %w", err)
+		}
+	}
+	block, chainPEM := pem.Decode(bundle.CertChain)
+	if block == nil {
+		return fmt.Errorf("bundle certificate chain: %w: no signing certificate", ErrChainInvalid)
+	}
+	leafPEM := bundle.CertChain[:len(bundle.CertChain)-len(chainPEM)]
+	clock := WithClock(func() time.Time { return at })
+	leaf, err := parseCertificatePEM(leafPEM)
+	if err != nil {
+		return fmt.Errorf("bundle certificate chain: %w: %w", ErrChainInvalid, err)
+	}
+	if err := newOptions([]Option{WithCertValidity(), clock}).certValidity(leaf); err != nil {
+		return fmt.Errorf("bundle certificate chain: %w at %v", err, at)
+	}
+	if _, err := verifyCertChain(leafPEM, chainPEM, rootsPEM, clock); err != nil {
+		return fmt.Errorf("bundle certificate chain: %w", err)
+	}
+
+	if err := checkKeyAlgorithm(leaf.PublicKey, alg); err != nil {
+		return fmt.Errorf("bundle signature: %w", err)
+	}
+	if err := o.verifyWithAlgorithm(leaf.PublicKey, alg, bundle.Signature, message, "signing certificate"); err != nil {
+		return fmt.Errorf("bundle signature: %w", err)
+	}
+	return nil
+}
+
+// verifyTimestampToken checks that the DER-encoded RFC 3161 TimeStampToken
+// token covers signature and was issued by a time-stamping authority that
+// chains to one of the PEM-encoded tsaRootsPEM, and returns the time it
+// states. Errors wrap ErrTimestampInvalid only, not the sentinel errors of
+// the token's own signature or chain, which would be mistaken for those of
+// the signature it covers.
+func verifyTimestampToken(token, signature, tsaRootsPEM []byte) (time.Time, error) {
+	invalid := func(format string, args ...interface{}) (time.Time, error) {
+		return time.Time{}, fmt.Errorf("%w: %s", ErrTimestampInvalid, fmt.Sprintf(format, args...))
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(tsaRootsPEM) {
+		return time.Time{}, errors.New("no time-stamping root certificates found in tsaRootsPEM")
+	}
+	var contentInfo cmsContentInfo
+	if rest, err := asn1.Unmarshal(token, &contentInfo); err != nil || len(rest) > 0 || !contentInfo.ContentType.Equal(oidSignedData) {
+		return invalid("not a DER-encoded TimeStampToken")
+	}
+	var signedData cmsSignedDataIn
+	if _, err := asn1.Unmarshal(contentInfo.Content.Bytes, &signedData); err != nil {
+		return invalid("SignedData: %v", err)
+	}
+	content := signedData.EncapContentInfo.Content
+	if !signedData.EncapContentInfo.ContentType.Equal(oidTSTInfo) || content == nil {
+		return invalid("content is not a TSTInfo")
+	}
+	var info tstInfo
+	if rest, err := asn1.Unmarshal(content, &info); err != nil || len(rest) > 0 {
+		return invalid("malformed TSTInfo")
+	}
+	hash, ok := map[string]crypto.Hash{
+		oidSHA256.String(): crypto.SHA256,
+		oidSHA384.String(): crypto.SHA384,
+		oidSHA512.String(): crypto.SHA512,
+	}[info.MessageImprint.HashAlgorithm.Algorithm.String()]
+	if !ok {
+		return invalid("unsupported message imprint hash %v", info.MessageImprint.HashAlgorithm.Algorithm)
+	}
+	h := hash.New()
+	h.Write(signature)
+	if !bytes.Equal(h.Sum(nil), info.MessageImprint.HashedMessage) {
+		return invalid("timestamp is not over this signature")
+	}
+
+	// RFC 3161, section 2.4.2: exactly one signer, whose certificate is for
+	// time stamping only.
+	if len(signedData.SignerInfos) != 1 {
+		return invalid("token has %d signers, want 1", len(signedData.SignerInfos))
+	}
+	certs, err := x509.ParseCertificates(signedData.Certificates.Bytes)
+	if err != nil {
+		return invalid("certificates: %v", err)
+	}
+	tsaCert, err := findCMSSigner(signedData.SignerInfos[0].SID, certs)
+	if err != nil {
+		return invalid("%v", err)
+	}
+	if len(tsaCert.ExtKeyUsage) != 1 || tsaCert.ExtKeyUsage[0] != x509.ExtKeyUsageTimeStamping {
+		return invalid("signer %q is not a time-stamping certificate", tsaCert.Subject.String())
+	}
+	atGenTime := WithClock(func() time.Time { return info.GenTime })
+	if err := verifyPKCS7(token, content, roots, atGenTime); err != nil {
+		return invalid("%v", err)
+	}
+	return info.GenTime, nil
+}
@@ -0,0 +1,242 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"sort"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// issueBundleCert creates a certificate for pub from template, issued by
+// parent and signed with parentKey, or self-signed if parent is nil.
+func issueBundleCert(t *testing.T, template, parent *x509.Certificate, pub crypto.PublicKey, parentKey crypto.Signer) (*x509.Certificate, []byte) {
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	if parent == nil {
+		parent = template
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, pub, parentKey)
+	if err != nil {
+		t.Fatalf("CreateCertificate(%s): %v", template.Subject.CommonName, err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// testTimestampToken returns an RFC 3161 TimeStampToken over signature at
+// genTime, signed with tsaKey, the key of tsaCert.
+func testTimestampToken(t *testing.T, signature []byte, genTime time.Time, tsaCert *x509.Certificate, tsaKey *ecdsa.PrivateKey) []byte {
+	sha256Alg := cmsAlgorithmIdentifier{Algorithm: oidSHA256}
+	imprint := sha256.Sum256(signature)
+	content, err := asn1.Marshal(tstInfo{
+		Version:        1,
+		Policy:         asn1.ObjectIdentifier{1, 2, 3, 4},
+		MessageImprint: tstMessageImprint{HashAlgorithm: sha256Alg, HashedMessage: imprint[:]},
+		SerialNumber:   big.NewInt(7),
+		GenTime:        genTime.UTC(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contentDigest := sha256.Sum256(content)
+	var attrs [][]byte
+	for _, a := range []struct {
+		oid   asn1.ObjectIdentifier
+		value interface{}
+	}{
+		{oidContentType, oidTSTInfo},
+		{oidMessageDigest, contentDigest[:]},
+	} {
+		encoded, err := asn1.Marshal(a.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		attr, err := asn1.Marshal(cmsAttribute{
+			Type:   a.oid,
+			Values: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: encoded},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		attrs = append(attrs, attr)
+	}
+	sort.Slice(attrs, func(i, j int) bool { return bytes.Compare(attrs[i], attrs[j]) < 0 })
+	set, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: bytes.Join(attrs, nil)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	setDigest := sha256.Sum256(set)
+	tsaSignature, err := ecdsa.SignASN1(rand.Reader, tsaKey, setDigest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signedData, err := asn1.Marshal(struct {
+		Version          int
+		DigestAlgorithms []cmsAlgorithmIdentifier `asn1:"set"`
+		EncapContentInfo cmsEncapContentInfoIn
+		Certificates     asn1.RawValue
+		SignerInfos      []cmsSignerInfo `asn1:"set"`
+	}{
+		Version:          3,
+		DigestAlgorithms: []cmsAlgorithmIdentifier{sha256Alg},
+		EncapContentInfo: cmsEncapContentInfoIn{ContentType: oidTSTInfo, Content: content},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: tsaCert.Raw},
+		SignerInfos: []cmsSignerInfo{{
+			Version: 1,
+			SID: cmsIssuerAndSerialNumber{
+				Issuer:       asn1.RawValue{FullBytes: tsaCert.RawIssuer},
+				SerialNumber: tsaCert.SerialNumber,
+			},
+			DigestAlgorithm:    sha256Alg,
+			SignedAttrs:        asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: bytes.Join(attrs, nil)},
+			SignatureAlgorithm: cmsAlgorithmIdentifier{Algorithm: oidECDSAWithSHA256},
+			Signature:          tsaSignature,
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := asn1.Marshal(cmsContentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedData},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestVerifyBundle(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("bundle-signer")
+	const alg = "EC_SIGN_P256_SHA256"
+	f.addKey(t, keyPath, alg)
+	_, signerKey, err := fetchPublicKey(ctx, client, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey := func() *ecdsa.PrivateKey {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+	now := time.Now()
+	caTemplate := func(name string) *x509.Certificate {
+		return &x509.Certificate{
+			Subject:               pkix.Name{CommonName: name},
+			NotBefore:             now.Add(-24 * time.Hour),
+			NotAfter:              now.Add(24 * time.Hour),
+			KeyUsage:              x509.KeyUsageCertSign,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}
+	}
+
+	// The signing certificate is short-lived and expired an hour ago, but
+	// the timestamp shows the signature was made while it was valid.
+	signedAt := now.Add(-2 * time.Hour).Truncate(time.Second)
+	rootKey := newKey()
+	root, rootPEM := issueBundleCert(t, caTemplate("root"), nil, &rootKey.PublicKey, rootKey)
+	_, leafPEM := issueBundleCert(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "signer"},
+		NotBefore: signedAt.Add(-30 * time.Minute),
+		NotAfter:  signedAt.Add(time.Hour),
+		KeyUsage:  x509.KeyUsageDigitalSignature,
+	}, root, signerKey, rootKey)
+	otherKey := newKey()
+	_, otherRootPEM := issueBundleCert(t, caTemplate("root"), nil, &otherKey.PublicKey, otherKey)
+
+	tsaRootKey, tsaKey := newKey(), newKey()
+	tsaRoot, tsaRootPEM := issueBundleCert(t, caTemplate("tsa root"), nil, &tsaRootKey.PublicKey, tsaRootKey)
+	tsaTemplate := func() *x509.Certificate {
+		return &x509.Certificate{
+			Subject:     pkix.Name{CommonName: "tsa"},
+			NotBefore:   now.Add(-24 * time.Hour),
+			NotAfter:    now.Add(24 * time.Hour),
+			KeyUsage:    x509.KeyUsageDigitalSignature,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+		}
+	}
+	tsaCert, _ := issueBundleCert(t, tsaTemplate(), tsaRoot, &tsaKey.PublicKey, tsaRootKey)
+	notTSATemplate := tsaTemplate()
+	notTSATemplate.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning}
+	notTSACert, _ := issueBundleCert(t, notTSATemplate, tsaRoot, &tsaKey.PublicKey, tsaRootKey)
+
+	message := []byte("release artifact")
+	signature, err := signMessageBytes(ctx, client, message, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle := func(certChain []byte, timestamp []byte) *SignatureBundle {
+		return &SignatureBundle{Signature: signature, Algorithm: alg, CertChain: certChain, Timestamp: timestamp}
+	}
+	token := testTimestampToken(t, decoded, signedAt, tsaCert, tsaKey)
+	if err := verifyBundle(bundle(leafPEM, token), message, rootPEM, tsaRootPEM); err != nil {
+		t.Fatalf("verifyBundle: %v", err)
+	}
+
+	tampered := append([]byte(nil), token...)
+	tampered[len(tampered)-1] ^= 1
+	tests := []struct {
+		name     string
+		bundle   *SignatureBundle
+		message  []byte
+		rootsPEM []byte
+		want     error
+	}{
+		{"other message", bundle(leafPEM, token), []byte("other"), rootPEM, ErrSignatureInvalid},
+		{"untrusted root", bundle(leafPEM, token), message, otherRootPEM, ErrChainInvalid},
+		{"no timestamp, after expiry", bundle(leafPEM, nil), message, rootPEM, ErrCertExpired},
+		{"timestamp before validity", bundle(leafPEM, testTimestampToken(t, decoded, signedAt.Add(-time.Hour), tsaCert, tsaKey)), message, rootPEM, ErrCertNotYetValid},
+		{"timestamp over another signature", bundle(leafPEM, testTimestampToken(t, []byte("other"), signedAt, tsaCert, tsaKey)), message, rootPEM, ErrTimestampInvalid},
+		{"tampered timestamp", bundle(leafPEM, tampered), message, rootPEM, ErrTimestampInvalid},
+		{"timestamp not by a TSA", bundle(leafPEM, testTimestampToken(t, decoded, signedAt, notTSACert, tsaKey)), message, rootPEM, ErrTimestampInvalid},
+	}
+	sentinels := []error{ErrSignatureInvalid, ErrChainInvalid, ErrCertExpired, ErrCertNotYetValid, ErrTimestampInvalid}
+	for _, test := range tests {
+		err := verifyBundle(test.bundle, test.message, test.rootsPEM, tsaRootPEM)
+		for _, sentinel := range sentinels {
+			if got, want := errors.Is(err, sentinel), sentinel == test.want; got != want {
+				t.Errorf("%s: got %v, want only %v", test.name, err, test.want)
+				break
+			}
+		}
+	}
+
+	if err := verifyBundle(bundle(leafPEM, token), message, rootPEM, otherRootPEM); !errors.Is(err, ErrTimestampInvalid) {
+		t.Errorf("untrusted TSA: got %v, want ErrTimestampInvalid", err)
+	}
+	atSigning := WithClock(func() time.Time { return signedAt })
+	if err := verifyBundle(bundle(leafPEM, nil), message, rootPEM, nil, atSigning); err != nil {
+		t.Errorf("no timestamp, with WithClock at signing: %v", err)
+	}
+}
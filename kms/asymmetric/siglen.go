@@ -0,0 +1,56 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// maxSignatureLen returns the largest signature, in bytes before any text
+// encoding, that the key version at keyPath can produce. RSA signatures are
+// always exactly the modulus size. ECDSA signatures from KMS are DER-encoded
+// and vary in length; the maximum allows for both integers needing a
+// leading zero byte. Use it to size fixed records, and to reject received
+// signatures that are too long to be genuine before parsing them.
+func maxSignatureLen(ctx context.Context, client *cloudkms.Service, keyPath string, opts ...Option) (int, error) {
+	alg, err := getKeyAlgorithm(ctx, client, keyPath, opts...)
+	if err != nil {
+		return 0, err
+	}
+	return maxSignatureLenFor(alg)
+}
+
+// maxSignatureLenFor is like maxSignatureLen, for the algorithm alg.
+func maxSignatureLenFor(alg AlgorithmInfo) (int, error) {
+	if alg.Purpose != "ASYMMETRIC_SIGN" {
+		return 0, fmt.Errorf("%w: %s is not a signing algorithm", ErrKeyTypeMismatch, alg.Name)
+	}
+	size := (alg.KeySize + 7) / 8
+	switch alg.KeyType {
+	case "RSA":
+		return size, nil
+	case "Ed25519":
+		return 2 * size, nil
+	case "EC":
+		// SEQUENCE { INTEGER r, INTEGER s }, where each integer holds up to
+		// size bytes plus a zero byte to keep it positive.
+		integer := derLen(size + 1)
+		return derLen(2 * integer), nil
+	default:
+		return 0, fmt.Errorf("unsupported key type %s", alg.KeyType)
+	}
+}
+
+// derLen returns the length of a DER TLV with n bytes of content.
+func derLen(n int) int {
+	header := 2 // tag and short-form length
+	for l := n; l > 127; l >>= 8 {
+		header++
+	}
+	return header + n
+}
@@ -0,0 +1,53 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestMaxSignatureLen(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	tests := []struct {
+		alg  string
+		want int
+	}{
+		{"RSA_SIGN_PSS_2048_SHA256", 256},
+		{"RSA_SIGN_PKCS1_3072_SHA256", 384},
+		{"EC_SIGN_P256_SHA256", 72},
+		{"EC_SIGN_P384_SHA384", 104},
+	}
+	for _, test := range tests {
+		keyPath := testKeyPath(test.alg)
+		f.addKey(t, keyPath, test.alg)
+		got, err := maxSignatureLen(ctx, client, keyPath)
+		if err != nil {
+			t.Fatalf("%s: maxSignatureLen: %v", test.alg, err)
+		}
+		if got != test.want {
+			t.Errorf("%s: maxSignatureLen = %d, want %d", test.alg, got, test.want)
+		}
+		for i := 0; i < 20; i++ {
+			sig, err := signAsymmetricBytes(ctx, client, "message", keyPath)
+			if err != nil {
+				t.Fatalf("%s: signAsymmetricBytes: %v", test.alg, err)
+			}
+			if len(sig) > got {
+				t.Errorf("%s: %d-byte signature exceeds maximum %d", test.alg, len(sig), got)
+			}
+		}
+	}
+	decryptPath := testKeyPath("decrypt")
+	f.addKey(t, decryptPath, "RSA_DECRYPT_OAEP_2048_SHA256")
+	if _, err := maxSignatureLen(ctx, client, decryptPath); err == nil {
+		t.Error("maxSignatureLen succeeded for a decryption key")
+	}
+	if got, want := derLen(138), 141; got != want {
+		t.Errorf("derLen(138) = %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// encryptAndSign encrypts message with the RSA key at encKeyPath, then signs
+// the base64 ciphertext, exactly as returned, with the key at signKeyPath.
+// Signing the ciphertext rather than the plaintext lets verifyAndDecrypt
+// reject a tampered message without sending it to KMS for decryption, and
+// reveals nothing about the plaintext to anyone holding the public key.
+func encryptAndSign(ctx context.Context, client *cloudkms.Service, message string, encKeyPath, signKeyPath string, opts ...Option) (ciphertext, signature string, err error) {
+	ciphertext, err = encryptRSA(ctx, client, message, encKeyPath, opts...)
+	if err != nil {
+		return "", "", err
+	}
+	signature, err = signAsymmetric(ctx, client, ciphertext, signKeyPath, opts...)
+	if err != nil {
+		return "", "", err
+	}
+	return ciphertext, signature, nil
+}
+
+// verifyAndDecrypt reverses encryptAndSign. The signature over ciphertext is
+// checked against the key at signKeyPath first, and the ciphertext is
+// decrypted with the key at encKeyPath only if it matches.
+func verifyAndDecrypt(ctx context.Context, client *cloudkms.Service, ciphertext, signature string, encKeyPath, signKeyPath string, opts ...Option) (string, error) {
+	if err := verifySignature(ctx, client, signature, []byte(ciphertext), signKeyPath, opts...); err != nil {
+		return "", err
+	}
+	return decryptRSA(ctx, client, ciphertext, encKeyPath, opts...)
+}
+
+// decryptAndSign decrypts ciphertext with the RSA key at decKeyPath and signs
+// the plaintext with the key at signKeyPath, for handing a secret on to a
+// service that checks its origin with verifySignature instead of trusting
+// the channel. The plaintext is returned in a buffer owned by the caller, who
+// should clear it with zeroize when done. If signing fails, the plaintext is
+// cleared and not returned.
+func decryptAndSign(ctx context.Context, client *cloudkms.Service, ciphertext string, decKeyPath, signKeyPath string, opts ...Option) (plaintext []byte, signature string, err error) {
+	plaintext, err = decryptRSABytes(ctx, client, ciphertext, decKeyPath, opts...)
+	if err != nil {
+		return nil, "", err
+	}
+	signature, err = signMessageBytes(ctx, client, plaintext, signKeyPath, opts...)
+	if err != nil {
+		zeroize(plaintext)
+		return nil, "", err
+	}
+	return plaintext, signature, nil
+}
+
+// decryptAndSignOnly is like decryptAndSign for callers that need only the
+// signature: the plaintext is cleared before it returns.
+func decryptAndSignOnly(ctx context.Context, client *cloudkms.Service, ciphertext string, decKeyPath, signKeyPath string, opts ...Option) (string, error) {
+	plaintext, signature, err := decryptAndSign(ctx, client, ciphertext, decKeyPath, signKeyPath, opts...)
+	if err != nil {
+		return "", err
+	}
+	zeroize(plaintext)
+	return signature, nil
+}
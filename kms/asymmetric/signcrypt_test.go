@@ -0,0 +1,83 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestEncryptAndSign(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	encKeyPath := testKeyPath("rsa-decrypt")
+	signKeyPath := testKeyPath("ec-sign")
+	f.addKey(t, encKeyPath, "RSA_DECRYPT_OAEP_2048_SHA256")
+	f.addKey(t, signKeyPath, "EC_SIGN_P256_SHA256")
+
+	ciphertext, signature, err := encryptAndSign(ctx, client, "secret", encKeyPath, signKeyPath)
+	if err != nil {
+		t.Fatalf("encryptAndSign: %v", err)
+	}
+	plaintext, err := verifyAndDecrypt(ctx, client, ciphertext, signature, encKeyPath, signKeyPath)
+	if err != nil || plaintext != "secret" {
+		t.Errorf("verifyAndDecrypt = (%q, %v), want (%q, nil)", plaintext, err, "secret")
+	}
+
+	other, _, err := encryptAndSign(ctx, client, "other", encKeyPath, signKeyPath)
+	if err != nil {
+		t.Fatalf("encryptAndSign: %v", err)
+	}
+	f.mu.Lock()
+	f.requests = 0
+	f.mu.Unlock()
+	if _, err := verifyAndDecrypt(ctx, client, other, signature, encKeyPath, signKeyPath); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("verifyAndDecrypt with swapped ciphertext: got %v, want ErrSignatureInvalid", err)
+	}
+	f.mu.Lock()
+	requests := f.requests
+	f.mu.Unlock()
+	// Only the signing key's public key is fetched; nothing is decrypted.
+	if requests != 1 {
+		t.Errorf("verifyAndDecrypt with swapped ciphertext made %d requests, want 1", requests)
+	}
+}
+
+func TestDecryptAndSign(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	decKeyPath := testKeyPath("rsa-decrypt")
+	signKeyPath := testKeyPath("ec-sign")
+	f.addKey(t, decKeyPath, "RSA_DECRYPT_OAEP_2048_SHA256")
+	f.addKey(t, signKeyPath, "EC_SIGN_P256_SHA256")
+
+	ciphertext, err := encryptRSA(ctx, client, "secret", decKeyPath)
+	if err != nil {
+		t.Fatalf("encryptRSA: %v", err)
+	}
+	plaintext, signature, err := decryptAndSign(ctx, client, ciphertext, decKeyPath, signKeyPath)
+	if err != nil || string(plaintext) != "secret" {
+		t.Fatalf("decryptAndSign = (%q, %v), want (%q, nil)", plaintext, err, "secret")
+	}
+	if err := verifySignature(ctx, client, signature, plaintext, signKeyPath); err != nil {
+		t.Errorf("verifySignature: %v", err)
+	}
+
+	signature, err = decryptAndSignOnly(ctx, client, ciphertext, decKeyPath, signKeyPath)
+	if err != nil {
+		t.Fatalf("decryptAndSignOnly: %v", err)
+	}
+	if err := verifySignature(ctx, client, signature, []byte("secret"), signKeyPath); err != nil {
+		t.Errorf("verifySignature: %v", err)
+	}
+
+	// Signing with the decrypt key fails, and no plaintext is returned.
+	plaintext, _, err = decryptAndSign(ctx, client, ciphertext, decKeyPath, decKeyPath)
+	if err == nil || plaintext != nil {
+		t.Errorf("decryptAndSign with a decrypt key as signer = (%q, %v), want (nil, error)", plaintext, err)
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// A V4 signed URL for Cloud Storage, or another Google API that accepts
+// them, carries an RSA signature made with a service account key. A KMS key
+// can make it if its public key has been uploaded to the service account,
+// wrapped in a self-signed X.509 certificate, so that the service account
+// named in X-Goog-Credential vouches for it. The key must be an
+// RSA_SIGN_PKCS1_*_SHA256 key: the service checks an RSASSA-PKCS1-v1_5
+// signature over a SHA-256 digest and rejects PSS.
+//
+// What is signed is not the canonical request itself but a string built from
+// it, four lines joined by "\n" with no trailing newline:
+//
+//	GOOG4-RSA-SHA256
+//	20190301T190859Z
+//	20190301/us-central1/storage/goog4_request
+//	<lowercase hex SHA-256 of the canonical request>
+//
+// The second line is X-Goog-Date and the third the credential scope, the
+// part of X-Goog-Credential after the service account's email address. The
+// signature goes in the X-Goog-Signature query parameter, in lowercase hex.
+//
+// For the older V2 signed URLs, sign the V2 string to sign with
+// signAsymmetric and pass its base64 result, query-escaped, as Signature.
+
+// signedURLAlgorithm is the X-Goog-Algorithm of a V4 signed URL signed with
+// an RSA key.
+const signedURLAlgorithm = "GOOG4-RSA-SHA256"
+
+// signedURLTimeFormat is the format of X-Goog-Date.
+const signedURLTimeFormat = "20060102T150405Z"
+
+// signedURLStringToSign returns the string signed for a V4 signed URL with
+// canonicalRequest, credentialScope, such as
+// "20190301/us-central1/storage/goog4_request", and X-Goog-Date signedAt.
+func signedURLStringToSign(canonicalRequest, credentialScope string, signedAt time.Time) string {
+	digest := sha256.Sum256([]byte(canonicalRequest))
+	return strings.Join([]string{
+		signedURLAlgorithm,
+		signedAt.UTC().Format(signedURLTimeFormat),
+		credentialScope,
+		hex.EncodeToString(digest[:]),
+	}, "\n")
+}
+
+// signSignedURL signs the V4 signed URL canonical request canonicalRequest
+// with the key version at keyPath, and returns the value of the
+// X-Goog-Signature query parameter. credentialScope and signedAt must match
+// the X-Goog-Credential and X-Goog-Date parameters of the URL. It fails with
+// ErrKeyTypeMismatch, before anything is signed, unless the key is an
+// RSA_SIGN_PKCS1_*_SHA256 key.
+func signSignedURL(ctx context.Context, client *cloudkms.Service, canonicalRequest, credentialScope string, signedAt time.Time, keyPath string, opts ...Option) (string, error) {
+	alg, err := getKeyAlgorithm(ctx, client, keyPath, opts...)
+	if err != nil {
+		return "", err
+	}
+	if alg.KeyType != "RSA" || alg.Padding != "PKCS1" || alg.Hash != crypto.SHA256 {
+		return "", fmt.Errorf("%w: signed URLs need an RSA_SIGN_PKCS1_*_SHA256 key, not %s", ErrKeyTypeMismatch, alg.Name)
+	}
+	stringToSign := signedURLStringToSign(canonicalRequest, credentialScope, signedAt)
+	opts = append(opts[:len(opts):len(opts)], WithSignatureEncoding(SignatureHex))
+	return signAsymmetric(ctx, client, stringToSign, keyPath, opts...)
+}
@@ -0,0 +1,56 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestSignSignedURL(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("signed-url")
+	f.addKey(t, keyPath, "RSA_SIGN_PKCS1_2048_SHA256")
+	const (
+		canonicalRequest = "GET\n/example-bucket/cat.jpeg\nX-Goog-Algorithm=GOOG4-RSA-SHA256\nhost:storage.googleapis.com\n\nhost\nUNSIGNED-PAYLOAD"
+		scope            = "20190301/us-central1/storage/goog4_request"
+	)
+	signedAt := time.Date(2019, 3, 1, 19, 8, 59, 0, time.UTC)
+
+	stringToSign := signedURLStringToSign(canonicalRequest, scope, signedAt)
+	digest := sha256.Sum256([]byte(canonicalRequest))
+	want := "GOOG4-RSA-SHA256\n20190301T190859Z\n" + scope + "\n" + hex.EncodeToString(digest[:])
+	if stringToSign != want {
+		t.Errorf("signedURLStringToSign = %q, want %q", stringToSign, want)
+	}
+
+	signature, err := signSignedURL(ctx, client, canonicalRequest, scope, signedAt, keyPath)
+	if err != nil {
+		t.Fatalf("signSignedURL: %v", err)
+	}
+	raw, err := hex.DecodeString(signature)
+	if err != nil {
+		t.Fatalf("signature %q is not hex: %v", signature, err)
+	}
+	publicKey := testPrivateKey(t, "RSA_SIGN_PKCS1_2048_SHA256").Public().(*rsa.PublicKey)
+	hashed := sha256.Sum256([]byte(stringToSign))
+	if err := rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, hashed[:], raw); err != nil {
+		t.Errorf("signature does not verify as PKCS #1 v1.5 over the string to sign: %v", err)
+	}
+
+	pssKey := testKeyPath("signed-url-pss")
+	f.addKey(t, pssKey, "RSA_SIGN_PSS_2048_SHA256")
+	if _, err := signSignedURL(ctx, client, canonicalRequest, scope, signedAt, pssKey); !errors.Is(err, ErrKeyTypeMismatch) {
+		t.Errorf("signSignedURL with a PSS key: got %v, want ErrKeyTypeMismatch", err)
+	}
+}
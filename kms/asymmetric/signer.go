@@ -0,0 +1,59 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"io"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// kmsSigner is a crypto.Signer whose private key is an asymmetric signing key
+// held in KMS. It can be passed to packages such as crypto/x509 that sign
+// through the crypto.Signer interface.
+type kmsSigner struct {
+	ctx       context.Context
+	client    *cloudkms.Service
+	keyPath   string
+	algorithm string
+	publicKey crypto.PublicKey
+	opts      []Option
+}
+
+// newKMSSigner returns a signer for the key version at keyPath.
+// ctx and opts are used for every signing request the signer makes.
+func newKMSSigner(ctx context.Context, client *cloudkms.Service, keyPath string, opts ...Option) (*kmsSigner, error) {
+	response, publicKey, err := fetchPublicKey(ctx, client, keyPath, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &kmsSigner{
+		ctx:       ctx,
+		client:    client,
+		keyPath:   keyPath,
+		algorithm: response.Algorithm,
+		publicKey: publicKey,
+		// The algorithm is known, so WithAllowedAlgorithms need not look
+		// it up again for every signature.
+		opts: append(opts[:len(opts):len(opts)], WithKeyAlgorithm(response.Algorithm)),
+	}, nil
+}
+
+// Public returns the public half of the KMS key.
+func (s *kmsSigner) Public() crypto.PublicKey {
+	return s.publicKey
+}
+
+// Sign asks KMS to sign digest, which must have been computed with
+// opts.HashFunc(). rand is ignored; KMS supplies its own randomness.
+func (s *kmsSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	signature, err := signDigestWithHash(s.ctx, s.client, digest, opts.HashFunc(), s.keyPath, s.opts...)
+	if err != nil {
+		return nil, err
+	}
+	return decodeSignature(signature)
+}
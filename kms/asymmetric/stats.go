@@ -0,0 +1,140 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A StatsCollector receives the latency of KMS requests. op is the KMS
+// method, such as "AsymmetricSign" or "GetPublicKey".
+type StatsCollector interface {
+	RecordLatency(op string, d time.Duration)
+}
+
+// WithStats makes the sample functions report the latency of every KMS
+// request they make to c, including failed requests and each retry. List
+// requests report each page. Functions that make concurrent requests may
+// call c concurrently.
+func WithStats(c StatsCollector) Option {
+	return func(o *options) { o.stats = c }
+}
+
+// startCall returns the current time if WithStats was given.
+func (o *options) startCall() time.Time {
+	if o.stats == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// endCall reports the latency of the KMS request op, started at start, to
+// the collector given to WithStats. It does nothing if start is zero.
+func (o *options) endCall(op string, start time.Time) {
+	if o.stats != nil && !start.IsZero() {
+		o.stats.RecordLatency(op, time.Since(start))
+	}
+}
+
+// defaultLatencySamples is how many samples of each operation a LatencyStats
+// keeps unless newLatencyStats is given another limit.
+const defaultLatencySamples = 1000
+
+// LatencyStats is an in-memory StatsCollector that keeps the most recent
+// latencies of each operation, up to a limit, and reports percentiles over
+// them. It is safe for concurrent use.
+type LatencyStats struct {
+	maxSamples int
+
+	mu  sync.Mutex
+	ops map[string]*latencySamples
+}
+
+// latencySamples is a ring buffer of the latencies of one operation.
+type latencySamples struct {
+	count   int64
+	samples []time.Duration
+	next    int
+}
+
+// newLatencyStats returns a LatencyStats that keeps up to maxSamples
+// latencies of each operation, or defaultLatencySamples if maxSamples is 0
+// or less.
+func newLatencyStats(maxSamples int) *LatencyStats {
+	if maxSamples <= 0 {
+		maxSamples = defaultLatencySamples
+	}
+	return &LatencyStats{maxSamples: maxSamples, ops: make(map[string]*latencySamples)}
+}
+
+// RecordLatency records that the operation op took d.
+func (s *LatencyStats) RecordLatency(op string, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.ops[op]
+	if !ok {
+		l = &latencySamples{}
+		s.ops[op] = l
+	}
+	l.count++
+	if len(l.samples) < s.maxSamples {
+		l.samples = append(l.samples, d)
+		return
+	}
+	l.samples[l.next] = d
+	l.next = (l.next + 1) % s.maxSamples
+}
+
+// Percentile returns the p-th percentile, from 0 to 100, of the kept
+// latencies of op, by the nearest-rank method: Percentile(op, 50) is the
+// median and Percentile(op, 100) the maximum. It returns 0 if none have
+// been recorded.
+func (s *LatencyStats) Percentile(op string, p float64) time.Duration {
+	s.mu.Lock()
+	l, ok := s.ops[op]
+	var sorted []time.Duration
+	if ok {
+		sorted = append(sorted, l.samples...)
+	}
+	s.mu.Unlock()
+	if len(sorted) == 0 {
+		return 0
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1]
+}
+
+// Count returns how many latencies of op have been recorded, including any
+// no longer kept.
+func (s *LatencyStats) Count(op string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if l, ok := s.ops[op]; ok {
+		return l.count
+	}
+	return 0
+}
+
+// Ops returns the operations with recorded latencies, sorted.
+func (s *LatencyStats) Ops() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ops := make([]string, 0, len(s.ops))
+	for op := range s.ops {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	return ops
+}
@@ -0,0 +1,108 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestLatencyStats(t *testing.T) {
+	s := newLatencyStats(0)
+	for i := 1; i <= 100; i++ {
+		s.RecordLatency("op", time.Duration(i)*time.Millisecond)
+	}
+	tests := []struct {
+		p    float64
+		want time.Duration
+	}{
+		{0, time.Millisecond},
+		{50, 50 * time.Millisecond},
+		{90, 90 * time.Millisecond},
+		{99.5, 100 * time.Millisecond},
+		{100, 100 * time.Millisecond},
+	}
+	for _, test := range tests {
+		if got := s.Percentile("op", test.p); got != test.want {
+			t.Errorf("Percentile(op, %v) = %v, want %v", test.p, got, test.want)
+		}
+	}
+	if got := s.Percentile("other", 50); got != 0 {
+		t.Errorf("Percentile of an operation with no samples = %v, want 0", got)
+	}
+
+	// Only the latest samples are kept, but all are counted.
+	s = newLatencyStats(10)
+	for i := 1; i <= 25; i++ {
+		s.RecordLatency("op", time.Duration(i)*time.Millisecond)
+	}
+	if got, want := s.Percentile("op", 0), 16*time.Millisecond; got != want {
+		t.Errorf("minimum after overflowing the samples = %v, want %v", got, want)
+	}
+	if got := s.Count("op"); got != 25 {
+		t.Errorf("Count(op) = %d, want 25", got)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				s.RecordLatency("concurrent", time.Millisecond)
+				s.Percentile("concurrent", 50)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := s.Count("concurrent"); got != 800 {
+		t.Errorf("Count after concurrent recording = %d, want 800", got)
+	}
+}
+
+func TestWithStats(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("ec-sign")
+	f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+	stats := newLatencyStats(0)
+
+	signature, err := signAsymmetric(ctx, client, "message", keyPath, WithStats(stats))
+	if err != nil {
+		t.Fatalf("signAsymmetric: %v", err)
+	}
+	if err := verifySignatureEC(ctx, client, signature, "message", keyPath, WithStats(stats)); err != nil {
+		t.Fatalf("verifySignatureEC: %v", err)
+	}
+	if _, err := getKeyVersion(ctx, client, testKeyPath("missing"), WithStats(stats)); err == nil {
+		t.Fatal("getKeyVersion of a missing key succeeded")
+	}
+	ring := "projects/test/locations/global/keyRings/ring"
+	if _, err := exportKeyRingPublicKeys(ctx, client, ring, WithStats(stats)); err != nil {
+		t.Fatalf("exportKeyRingPublicKeys: %v", err)
+	}
+
+	want := []string{"AsymmetricSign", "GetCryptoKeyVersion", "GetPublicKey", "ListCryptoKeyVersions", "ListCryptoKeys"}
+	if got := stats.Ops(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Ops() = %v, want %v", got, want)
+	}
+	f.mu.Lock()
+	requests := f.requests
+	f.mu.Unlock()
+	var recorded int64
+	for _, op := range stats.Ops() {
+		recorded += stats.Count(op)
+		if stats.Percentile(op, 100) <= 0 {
+			t.Errorf("maximum latency of %s is not positive", op)
+		}
+	}
+	if recorded != int64(requests) {
+		t.Errorf("recorded %d latencies for %d requests", recorded, requests)
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// progressInterval is the number of bytes read between progress reports.
+const progressInterval = 1 << 20
+
+// signAsymmetricReader signs the contents of r with the key at keyPath. Only
+// the digest is sent to KMS, and r is hashed as it is read, so inputs of any
+// size can be signed without holding them in memory. The signature is
+// encoded as set with WithSignatureEncoding.
+func signAsymmetricReader(ctx context.Context, client *cloudkms.Service, r io.Reader, keyPath string, opts ...Option) (string, error) {
+	o := newOptions(opts)
+	alg, err := getKeyAlgorithm(ctx, client, keyPath, opts...)
+	if err != nil {
+		return "", err
+	}
+	if err := o.checkAllowedAlgorithm(alg.Name, keyPath); err != nil {
+		return "", err
+	}
+	digest, err := o.hashReader(r, alg)
+	if err != nil {
+		return "", err
+	}
+	signature, err := signDigestForAlgorithm(ctx, client, digest, alg, keyPath, opts...)
+	if err != nil {
+		return "", err
+	}
+	return o.encodeSignature(signature, alg.Name)
+}
+
+// verifySignatureReader verifies signature over the contents of r with the
+// key at keyPath, hashing r as it is read like signAsymmetricReader.
+func verifySignatureReader(ctx context.Context, client *cloudkms.Service, signature string, r io.Reader, keyPath string, opts ...Option) error {
+	o := newOptions(opts)
+	alg, err := getKeyAlgorithm(ctx, client, keyPath, opts...)
+	if err != nil {
+		return err
+	}
+	if err := o.checkAllowedAlgorithm(alg.Name, keyPath); err != nil {
+		return err
+	}
+	publicKey, err := o.getPublicKey(ctx, client, keyPath)
+	if err != nil {
+		return err
+	}
+	decoded, err := o.decodeSignature(signature)
+	if err != nil {
+		return err
+	}
+	digest, err := o.hashReader(r, alg)
+	if err != nil {
+		return err
+	}
+	return verifyDigest(publicKey, alg, digest, decoded)
+}
+
+// WithMaxMessageBytes makes signAsymmetricReader and verifySignatureReader
+// fail with ErrMessageTooLarge as soon as more than n bytes have been read,
+// so an untrusted reader cannot keep them hashing forever. The default is
+// no limit.
+func WithMaxMessageBytes(n int64) Option {
+	return func(o *options) { o.maxMessageBytes = n }
+}
+
+// hashReader hashes r with alg's hash, honoring WithProgress and
+// WithMaxMessageBytes.
+func (o *options) hashReader(r io.Reader, alg AlgorithmInfo) ([]byte, error) {
+	if alg.Hash == 0 {
+		return nil, fmt.Errorf("%s does not sign digests", alg.Name)
+	}
+	if o.maxMessageBytes > 0 {
+		// Read one byte past the limit to tell an exact fit from an overflow.
+		r = io.LimitReader(r, o.maxMessageBytes+1)
+	}
+	var pr *progressReader
+	if o.progress != nil {
+		pr = &progressReader{r: r, report: o.progress}
+		r = pr
+	}
+	digest := alg.Hash.New()
+	n, err := io.Copy(digest, r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read message: %w", err)
+	}
+	if o.maxMessageBytes > 0 && n > o.maxMessageBytes {
+		return nil, fmt.Errorf("%w: message is longer than %d bytes", ErrMessageTooLarge, o.maxMessageBytes)
+	}
+	if pr != nil {
+		pr.report(pr.n)
+	}
+	return digest.Sum(nil), nil
+}
+
+// WithProgress makes signAsymmetricReader and verifySignatureReader call report with the total number
+// of bytes hashed so far, about once per MiB and once more when the whole
+// input has been read. report is called synchronously, so it should return
+// quickly.
+func WithProgress(report func(n int64)) Option {
+	return func(o *options) { o.progress = report }
+}
+
+// progressReader passes reads through to r and reports the running total
+// each time another progressInterval bytes have been read.
+type progressReader struct {
+	r      io.Reader
+	report func(n int64)
+	n      int64
+	next   int64
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.n += int64(n)
+	if p.n >= p.next+progressInterval {
+		p.next = p.n
+		p.report(p.n)
+	}
+	return n, err
+}
@@ -0,0 +1,103 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sync"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// defaultMaxInFlight is the number of concurrent signing requests made by
+// signAsymmetricStream unless WithMaxInFlight is given.
+const defaultMaxInFlight = 4
+
+// A SignResult is the outcome of signing one message from the input of
+// signAsymmetricStream.
+type SignResult struct {
+	// Index is the position of Message in the input, counting from 0.
+	Index     int
+	Message   string
+	Signature string
+	Err       error
+}
+
+// WithMaxInFlight limits signAsymmetricStream and exportJWKS to n concurrent
+// requests. WithQuotaTracker replaces it for signAsymmetricStream.
+func WithMaxInFlight(n int) Option {
+	return func(o *options) { o.maxInFlight = n }
+}
+
+// signAsymmetricStream signs each message received from in with the key at
+// keyPath, as signAsymmetric would, and sends one SignResult per message on
+// the returned channel. Results arrive in completion order; use Index to
+// match them to the input. The output channel is closed once in is closed
+// and every result has been sent. If ctx is done, no more messages are read,
+// requests in flight are abandoned without sending their results, and the
+// output channel is closed, so a consumer can simply range over it.
+func signAsymmetricStream(ctx context.Context, client *cloudkms.Service, in <-chan string, keyPath string, opts ...Option) <-chan SignResult {
+	o := newOptions(opts)
+	out := make(chan SignResult)
+	go func() {
+		defer close(out)
+		// The key's algorithm applies to every message, so look it up once.
+		alg, setupErr := getKeyAlgorithm(ctx, client, keyPath, opts...)
+		if setupErr == nil {
+			setupErr = o.checkAllowedAlgorithm(alg.Name, keyPath)
+		}
+		maxInFlight := o.maxInFlight
+		if maxInFlight <= 0 {
+			maxInFlight = defaultMaxInFlight
+		}
+		inFlight := make(chan struct{}, maxInFlight)
+		var wg sync.WaitGroup
+		defer wg.Wait()
+		for index := 0; ; index++ {
+			var message string
+			select {
+			case <-ctx.Done():
+				return
+			case m, ok := <-in:
+				if !ok {
+					return
+				}
+				message = m
+			}
+			release := func() { <-inFlight }
+			if q := o.quotaTracker; q != nil {
+				if q.acquire(ctx) != nil {
+					return
+				}
+				release = q.release
+			} else {
+				select {
+				case <-ctx.Done():
+					return
+				case inFlight <- struct{}{}:
+				}
+			}
+			wg.Add(1)
+			go func(index int, message string) {
+				defer wg.Done()
+				defer release()
+				result := SignResult{Index: index, Message: message, Err: setupErr}
+				if setupErr == nil {
+					digest := alg.Hash.New()
+					digest.Write(o.normalizeMessage([]byte(message)))
+					result.Signature, result.Err = signDigestForAlgorithm(ctx, client, digest.Sum(nil), alg, keyPath, opts...)
+					if result.Err == nil {
+						result.Signature, result.Err = o.encodeSignature(result.Signature, alg.Name)
+					}
+				}
+				select {
+				case out <- result:
+				case <-ctx.Done():
+				}
+			}(index, message)
+		}
+	}()
+	return out
+}
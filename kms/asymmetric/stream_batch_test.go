@@ -0,0 +1,60 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestSignAsymmetricStream(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("ec-sign")
+	f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+
+	const n = 10
+	in := make(chan string)
+	go func() {
+		defer close(in)
+		for i := 0; i < n; i++ {
+			in <- fmt.Sprintf("message %d", i)
+		}
+	}()
+	seen := make(map[int]bool)
+	for result := range signAsymmetricStream(ctx, client, in, keyPath, WithMaxInFlight(3)) {
+		if result.Err != nil {
+			t.Errorf("result %d: %v", result.Index, result.Err)
+			continue
+		}
+		if want := fmt.Sprintf("message %d", result.Index); result.Message != want {
+			t.Errorf("result %d has message %q, want %q", result.Index, result.Message, want)
+		}
+		if err := verifySignatureEC(ctx, client, result.Signature, result.Message, keyPath); err != nil {
+			t.Errorf("result %d: verifySignatureEC: %v", result.Index, err)
+		}
+		seen[result.Index] = true
+	}
+	if len(seen) != n {
+		t.Errorf("got %d results, want %d", len(seen), n)
+	}
+}
+
+func TestSignAsymmetricStreamCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("ec-sign")
+	f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+
+	// in is never closed; cancellation alone must close the output.
+	in := make(chan string)
+	out := signAsymmetricStream(ctx, client, in, keyPath)
+	in <- "message"
+	cancel()
+	for range out {
+	}
+}
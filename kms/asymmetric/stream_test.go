@@ -0,0 +1,67 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestSignAsymmetricReaderProgress(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("ec-sign")
+	f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+
+	message := bytes.Repeat([]byte("x"), 3*progressInterval+10)
+	var reports []int64
+	signature, err := signAsymmetricReader(ctx, client, bytes.NewReader(message), keyPath,
+		WithProgress(func(n int64) { reports = append(reports, n) }))
+	if err != nil {
+		t.Fatalf("signAsymmetricReader: %v", err)
+	}
+	if err := verifySignatureEC(ctx, client, signature, string(message), keyPath); err != nil {
+		t.Errorf("verifySignatureEC: %v", err)
+	}
+	if len(reports) < 2 || len(reports) > 5 {
+		t.Fatalf("got %d progress reports, want between 2 and 5: %v", len(reports), reports)
+	}
+	for i := 1; i < len(reports); i++ {
+		if reports[i] < reports[i-1] {
+			t.Errorf("progress went backwards: %v", reports)
+		}
+	}
+	if last := reports[len(reports)-1]; last != int64(len(message)) {
+		t.Errorf("final progress = %d, want %d", last, len(message))
+	}
+}
+
+func TestWithMaxMessageBytes(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("rsa-sign")
+	f.addKey(t, keyPath, "RSA_SIGN_PSS_2048_SHA256")
+	message := bytes.Repeat([]byte("x"), 1000)
+
+	signature, err := signAsymmetricReader(ctx, client, bytes.NewReader(message), keyPath, WithMaxMessageBytes(1000))
+	if err != nil {
+		t.Fatalf("signAsymmetricReader at the limit: %v", err)
+	}
+	if err := verifySignatureReader(ctx, client, signature, bytes.NewReader(message), keyPath, WithMaxMessageBytes(1000)); err != nil {
+		t.Errorf("verifySignatureReader at the limit: %v", err)
+	}
+	if err := verifySignatureReader(ctx, client, signature, bytes.NewReader(message[1:]), keyPath); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("verifySignatureReader with another message: got %v, want ErrSignatureInvalid", err)
+	}
+	if _, err := signAsymmetricReader(ctx, client, bytes.NewReader(message), keyPath, WithMaxMessageBytes(999)); !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("signAsymmetricReader over the limit: got %v, want ErrMessageTooLarge", err)
+	}
+	if err := verifySignatureReader(ctx, client, signature, bytes.NewReader(message), keyPath, WithMaxMessageBytes(999)); !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("verifySignatureReader over the limit: got %v, want ErrMessageTooLarge", err)
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// taggedPrefix starts every envelope made by encryptRSATagged.
+const taggedPrefix = "kms-oaep-v1"
+
+// oaepHashNames names the OAEP hashes used by KMS in tagged envelopes.
+var oaepHashNames = map[crypto.Hash]string{
+	crypto.SHA1:   "SHA1",
+	crypto.SHA256: "SHA256",
+	crypto.SHA512: "SHA512",
+}
+
+// encryptRSATagged is like encryptRSA, but returns a self-describing
+// envelope that records the OAEP hash and the key version used:
+//
+//	kms-oaep-v1:HASH:KEY_VERSION_PATH:BASE64_CIPHERTEXT
+//
+// where HASH is SHA1, SHA256 or SHA512. decryptRSATagged reads both back, so
+// ciphertexts stored for years remain decryptable after new key versions
+// with a different hash are introduced, without the caller tracking which
+// version and hash each one used.
+func encryptRSATagged(ctx context.Context, client *cloudkms.Service, message, keyPath string, opts ...Option) (string, error) {
+	ciphertext, hash, err := encryptRSAWithHash(ctx, client, []byte(message), keyPath, opts...)
+	if err != nil {
+		return "", err
+	}
+	name, ok := oaepHashNames[hash]
+	if !ok {
+		return "", fmt.Errorf("%w: no tag for OAEP hash %v", ErrUnsupportedOAEP, hash)
+	}
+	return strings.Join([]string{taggedPrefix, name, keyPath, ciphertext}, ":"), nil
+}
+
+// decryptRSATagged decrypts an envelope made by encryptRSATagged with the key
+// version recorded in it. KMS always decrypts with the hash of the key
+// version's algorithm, so the recorded hash is checked against it first: an
+// envelope whose hash the key cannot use fails with ErrUnsupportedOAEP rather
+// than with an opaque decryption error.
+func decryptRSATagged(ctx context.Context, client *cloudkms.Service, envelope string, opts ...Option) (string, error) {
+	parts := strings.Split(envelope, ":")
+	if len(parts) != 4 || parts[0] != taggedPrefix {
+		return "", fmt.Errorf("not a %s envelope", taggedPrefix)
+	}
+	hashName, keyPath, ciphertext := parts[1], parts[2], parts[3]
+	version, err := getKeyVersion(ctx, client, keyPath, opts...)
+	if err != nil {
+		return "", err
+	}
+	info, ok := lookupAlgorithm(version.Algorithm)
+	if !ok || info.Padding != "OAEP" {
+		return "", fmt.Errorf("%w: %s is not an OAEP decryption algorithm", ErrKeyTypeMismatch, version.Algorithm)
+	}
+	if oaepHashNames[info.Hash] != hashName {
+		return "", fmt.Errorf("%w: envelope was encrypted with %s, but %s uses %s", ErrUnsupportedOAEP, hashName, keyPath, oaepHashNames[info.Hash])
+	}
+	return decryptRSA(ctx, client, ciphertext, keyPath, opts...)
+}
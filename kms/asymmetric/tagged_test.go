@@ -0,0 +1,51 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestEncryptRSATagged(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	oldPath := testKeyPath("archive") // version 1, SHA-256
+	newPath := parentKeyPath(oldPath) + "/cryptoKeyVersions/2"
+	f.addKey(t, oldPath, "RSA_DECRYPT_OAEP_2048_SHA256")
+	f.addKey(t, newPath, "RSA_DECRYPT_OAEP_4096_SHA512")
+
+	for _, keyPath := range []string{oldPath, newPath} {
+		envelope, err := encryptRSATagged(ctx, client, "record", keyPath)
+		if err != nil {
+			t.Fatalf("encryptRSATagged: %v", err)
+		}
+		plaintext, err := decryptRSATagged(ctx, client, envelope)
+		if err != nil {
+			t.Fatalf("decryptRSATagged(%s): %v", envelope[:40], err)
+		}
+		if plaintext != "record" {
+			t.Errorf("decrypted %q, want %q", plaintext, "record")
+		}
+	}
+
+	envelope, err := encryptRSATagged(ctx, client, "record", oldPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(envelope, "kms-oaep-v1:SHA256:"+oldPath+":") {
+		t.Errorf("envelope %q does not record the hash and key version", envelope)
+	}
+	tampered := strings.Replace(envelope, ":SHA256:", ":SHA1:", 1)
+	if _, err := decryptRSATagged(ctx, client, tampered); !errors.Is(err, ErrUnsupportedOAEP) {
+		t.Errorf("mismatched hash tag: got %v, want ErrUnsupportedOAEP", err)
+	}
+	if _, err := decryptRSATagged(ctx, client, "garbage"); err == nil {
+		t.Error("decryptRSATagged accepted a malformed envelope")
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// generateTestKey returns a new private key with the parameters KMS uses for
+// the signing algorithm alg, and a function that signs a message with it as
+// KMS would, returning the base64 signature that signAsymmetric returns:
+// the message is hashed with the algorithm's digest, RSA-PSS uses a salt as
+// long as the digest, and ECDSA signatures are ASN.1 DER. RSA_SIGN_RAW_PKCS1
+// and Ed25519 sign the message itself. Unlike testPrivateKey, every call
+// generates a fresh key, for tests that need keys of their own.
+func generateTestKey(t testing.TB, alg string) (crypto.Signer, func(message []byte) string) {
+	t.Helper()
+	info, ok := lookupAlgorithm(alg)
+	if !ok || info.Purpose != "ASYMMETRIC_SIGN" {
+		t.Fatalf("%s is not a KMS signing algorithm", alg)
+	}
+	var key crypto.Signer
+	var err error
+	switch {
+	case info.KeyType == "RSA":
+		key, err = rsa.GenerateKey(rand.Reader, info.KeySize)
+	case alg == "EC_SIGN_P256_SHA256":
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case alg == "EC_SIGN_P384_SHA384":
+		key, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case info.KeyType == "Ed25519":
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		t.Fatalf("cannot generate %s keys locally", alg)
+	}
+	if err != nil {
+		t.Fatalf("failed to generate %s key: %v", alg, err)
+	}
+
+	sign := func(message []byte) string {
+		t.Helper()
+		digest := message
+		var signerOpts crypto.SignerOpts = info.Hash
+		if info.Hash != 0 {
+			h := info.Hash.New()
+			h.Write(message)
+			digest = h.Sum(nil)
+		}
+		if info.Padding == "PSS" {
+			signerOpts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: info.Hash}
+		}
+		signature, err := key.Sign(rand.Reader, digest, signerOpts)
+		if err != nil {
+			t.Fatalf("failed to sign with %s key: %v", alg, err)
+		}
+		return base64.StdEncoding.EncodeToString(signature)
+	}
+	return key, sign
+}
+
+func TestGenerateTestKey(t *testing.T) {
+	message := []byte("message")
+	for _, alg := range []string{"RSA_SIGN_PSS_2048_SHA256", "RSA_SIGN_PKCS1_3072_SHA256", "EC_SIGN_P256_SHA256", "EC_SIGN_P384_SHA384"} {
+		key, sign := generateTestKey(t, alg)
+		info, _ := lookupAlgorithm(alg)
+		signature, err := decodeSignature(sign(message))
+		if err != nil {
+			t.Fatalf("%s: %v", alg, err)
+		}
+		h := info.Hash.New()
+		h.Write(message)
+		// verifyDigest also checks that the key fits the algorithm.
+		if err := verifyDigest(key.Public(), info, h.Sum(nil), signature); err != nil {
+			t.Errorf("%s: verifyDigest: %v", alg, err)
+		}
+	}
+
+	key, sign := generateTestKey(t, "RSA_SIGN_PSS_2048_SHA256")
+	other, _ := generateTestKey(t, "RSA_SIGN_PSS_2048_SHA256")
+	if key.Public().(*rsa.PublicKey).Equal(other.Public()) {
+		t.Error("generateTestKey returned the same key twice")
+	}
+	// With the key passed in, the verify functions need no KMS.
+	if err := verifySignature(context.Background(), nil, sign(message), message, "", WithPublicKey(key.Public())); err != nil {
+		t.Errorf("verifySignature: %v", err)
+	}
+
+	key, sign = generateTestKey(t, "EC_SIGN_ED25519")
+	signature, err := decodeSignature(sign(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ed25519.Verify(key.Public().(ed25519.PublicKey), message, signature) {
+		t.Error("EC_SIGN_ED25519: signature does not verify")
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// timestampedMessage returns the message signed for payload at ts: payload
+// followed by ts as a big-endian uint64 count of nanoseconds since the Unix
+// epoch. The timestamp has a fixed size, so it cannot be confused with the
+// end of the payload.
+func timestampedMessage(payload []byte, ts time.Time) []byte {
+	message := make([]byte, 0, len(payload)+8)
+	message = append(message, payload...)
+	return binary.BigEndian.AppendUint64(message, uint64(ts.UnixNano()))
+}
+
+// signTimestamped signs payload bound to the current time, from WithClock or
+// else now, with the key at keyPath, and returns the signature and the time
+// signed. Send both with the payload; verifyTimestamped needs all three.
+func signTimestamped(ctx context.Context, client *cloudkms.Service, payload []byte, keyPath string, opts ...Option) (string, time.Time, error) {
+	ts := newOptions(opts).now()
+	signature, err := signMessageBytes(ctx, client, timestampedMessage(payload, ts), keyPath, opts...)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signature, ts, nil
+}
+
+// verifyTimestamped verifies signature over payload bound to the time ts, as
+// made by signTimestamped, with the key at keyPath. If maxAge is positive, it
+// then checks that ts is no more than maxAge before the time given by
+// WithClock, or else now, and not after it, allowing for WithClockSkew
+// either way.
+//
+// A signature that does not match returns an error wrapping
+// ErrSignatureInvalid. A signature that matches, over a timestamp that is too
+// old or in the future, returns ErrTimestampStale or ErrTimestampInFuture
+// instead, so that an authentic but replayed message can be told apart from
+// a forged one.
+func verifyTimestamped(ctx context.Context, client *cloudkms.Service, signature string, payload []byte, ts time.Time, maxAge time.Duration, keyPath string, opts ...Option) error {
+	if err := verifySignature(ctx, client, signature, timestampedMessage(payload, ts), keyPath, opts...); err != nil {
+		return err
+	}
+	if maxAge <= 0 {
+		return nil
+	}
+	o := newOptions(opts)
+	now := o.now()
+	skew := o.clockSkew
+	if skew < 0 {
+		skew = 0
+	}
+	if age := now.Sub(ts); age > maxAge+skew {
+		return fmt.Errorf("%w: signed %v ago, at %v; the maximum age is %v", ErrTimestampStale, age, ts, maxAge)
+	}
+	if ts.After(now.Add(skew)) {
+		return fmt.Errorf("%w: signed at %v, %v from now", ErrTimestampInFuture, ts, ts.Sub(now))
+	}
+	return nil
+}
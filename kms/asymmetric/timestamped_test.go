@@ -0,0 +1,57 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestVerifyTimestamped(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("ec-sign")
+	f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+	at := func(t time.Time) Option { return WithClock(func() time.Time { return t }) }
+
+	signedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	payload := []byte("order #42")
+	signature, ts, err := signTimestamped(ctx, client, payload, keyPath, at(signedAt))
+	if err != nil {
+		t.Fatalf("signTimestamped: %v", err)
+	}
+	if !ts.Equal(signedAt) {
+		t.Errorf("signTimestamped returned time %v, want %v", ts, signedAt)
+	}
+
+	tests := []struct {
+		name    string
+		payload []byte
+		ts      time.Time
+		maxAge  time.Duration
+		opts    []Option
+		want    error
+	}{
+		{"fresh", payload, ts, time.Minute, []Option{at(signedAt.Add(30 * time.Second))}, nil},
+		{"no freshness check", payload, ts, 0, []Option{at(signedAt.Add(24 * time.Hour))}, nil},
+		{"stale", payload, ts, time.Minute, []Option{at(signedAt.Add(2 * time.Minute))}, ErrTimestampStale},
+		{"stale within skew", payload, ts, time.Minute, []Option{at(signedAt.Add(90 * time.Second)), WithClockSkew(time.Minute)}, nil},
+		{"future", payload, ts, time.Minute, []Option{at(signedAt.Add(-time.Minute))}, ErrTimestampInFuture},
+		{"future within skew", payload, ts, time.Minute, []Option{at(signedAt.Add(-30 * time.Second)), WithClockSkew(time.Minute)}, nil},
+		{"changed payload", []byte("order #43"), ts, time.Minute, []Option{at(signedAt)}, ErrSignatureInvalid},
+		// Moving the timestamp forward to pass the freshness check breaks
+		// the signature.
+		{"changed timestamp", payload, ts.Add(time.Hour), time.Minute, []Option{at(signedAt.Add(time.Hour))}, ErrSignatureInvalid},
+	}
+	for _, test := range tests {
+		err := verifyTimestamped(ctx, client, signature, test.payload, test.ts, test.maxAge, keyPath, test.opts...)
+		if !errors.Is(err, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.want)
+		}
+	}
+}
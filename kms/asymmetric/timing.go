@@ -0,0 +1,41 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import "time"
+
+// VerifyTiming reports where a verification spent its time.
+type VerifyTiming struct {
+	// KeyFetch is the time spent fetching and parsing the public key.
+	KeyFetch time.Duration
+	// Verify is the time spent on the local cryptographic check.
+	Verify time.Duration
+}
+
+// WithVerifyTiming makes the verify functions fill in t. Without it no
+// timestamps are taken.
+func WithVerifyTiming(t *VerifyTiming) Option {
+	return func(o *options) { o.timing = t }
+}
+
+// startTimer returns the current time if timing is enabled.
+func (o *options) startTimer() time.Time {
+	if o.timing == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+func (o *options) recordKeyFetch(start time.Time) {
+	if o.timing != nil {
+		o.timing.KeyFetch = time.Since(start)
+	}
+}
+
+func (o *options) recordVerify(start time.Time) {
+	if o.timing != nil {
+		o.timing.Verify = time.Since(start)
+	}
+}
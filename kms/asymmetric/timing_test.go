@@ -0,0 +1,44 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestWithVerifyTiming(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("ec-sign")
+	f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+	signature, err := signAsymmetric(ctx, client, "message", keyPath)
+	if err != nil {
+		t.Fatalf("signAsymmetric: %v", err)
+	}
+
+	var timing VerifyTiming
+	if err := verifySignatureEC(ctx, client, signature, "message", keyPath, WithVerifyTiming(&timing)); err != nil {
+		t.Fatalf("verifySignatureEC: %v", err)
+	}
+	if timing.KeyFetch <= 0 || timing.Verify <= 0 {
+		t.Errorf("timing with a KMS key fetch = %+v, want both durations recorded", timing)
+	}
+
+	publicKey := testPrivateKey(t, "EC_SIGN_P256_SHA256").Public()
+	timing = VerifyTiming{}
+	if err := verifySignatureEC(ctx, nil, signature, "message", "", WithPublicKey(publicKey), WithVerifyTiming(&timing)); err != nil {
+		t.Fatalf("verifySignatureEC with WithPublicKey: %v", err)
+	}
+	if timing.KeyFetch != 0 || timing.Verify <= 0 {
+		t.Errorf("timing with WithPublicKey = %+v, want only Verify recorded", timing)
+	}
+
+	// Without the option, no timestamps are taken at all.
+	if start := newOptions(nil).startTimer(); !start.IsZero() {
+		t.Errorf("startTimer without WithVerifyTiming = %v, want the zero time", start)
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// tlsSignatureSchemes maps KMS signing algorithms to the only TLS signature
+// scheme each can produce.
+var tlsSignatureSchemes = map[string]tls.SignatureScheme{
+	"RSA_SIGN_PSS_2048_SHA256":   tls.PSSWithSHA256,
+	"RSA_SIGN_PSS_3072_SHA256":   tls.PSSWithSHA256,
+	"RSA_SIGN_PSS_4096_SHA256":   tls.PSSWithSHA256,
+	"RSA_SIGN_PSS_4096_SHA512":   tls.PSSWithSHA512,
+	"RSA_SIGN_PKCS1_2048_SHA256": tls.PKCS1WithSHA256,
+	"RSA_SIGN_PKCS1_3072_SHA256": tls.PKCS1WithSHA256,
+	"RSA_SIGN_PKCS1_4096_SHA256": tls.PKCS1WithSHA256,
+	"RSA_SIGN_PKCS1_4096_SHA512": tls.PKCS1WithSHA512,
+	"EC_SIGN_P256_SHA256":        tls.ECDSAWithP256AndSHA256,
+	"EC_SIGN_P384_SHA384":        tls.ECDSAWithP384AndSHA384,
+}
+
+// buildTLSCertificate returns a TLS certificate whose private key is the KMS
+// key at keyPath, for use in a tls.Config of a server or a client doing
+// mutual TLS. certPEM holds the PEM-encoded leaf certificate for the key,
+// optionally followed by intermediate certificates. Each handshake makes one
+// signing request to KMS, using the context ctx.
+// A KMS key signs with a single padding and hash, so the certificate is
+// restricted to the matching TLS signature scheme. PKCS #1 v1.5 keys cannot
+// be used with TLS 1.3, which requires PSS for RSA.
+func buildTLSCertificate(ctx context.Context, client *cloudkms.Service, keyPath string, certPEM []byte, opts ...Option) (*tls.Certificate, error) {
+	cert := &tls.Certificate{}
+	for rest := certPEM; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			cert.Certificate = append(cert.Certificate, block.Bytes)
+		}
+	}
+	if len(cert.Certificate) == 0 {
+		return nil, errors.New("no certificate found in certPEM")
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+	signer, err := newKMSSigner(ctx, client, keyPath, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCertificateKey(leaf, signer); err != nil {
+		return nil, err
+	}
+	scheme, ok := tlsSignatureSchemes[signer.algorithm]
+	if !ok {
+		return nil, fmt.Errorf("%s keys cannot be used for TLS", signer.algorithm)
+	}
+	cert.PrivateKey = signer
+	cert.Leaf = leaf
+	cert.SupportedSignatureAlgorithms = []tls.SignatureScheme{scheme}
+	return cert, nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestBuildTLSCertificateHandshake(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	for _, alg := range []string{"EC_SIGN_P256_SHA256", "RSA_SIGN_PSS_2048_SHA256"} {
+		keyPath := testKeyPath(alg)
+		f.addKey(t, keyPath, alg)
+		signer, err := newKMSSigner(ctx, client, keyPath)
+		if err != nil {
+			t.Fatalf("newKMSSigner: %v", err)
+		}
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: "server.test"},
+			DNSNames:              []string{"server.test"},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+			ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}
+		der, err := createCertificate(template, template, signer.Public(), signer)
+		if err != nil {
+			t.Fatalf("createCertificate: %v", err)
+		}
+		certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+		tlsCert, err := buildTLSCertificate(ctx, client, keyPath, certPEM)
+		if err != nil {
+			t.Fatalf("%s: buildTLSCertificate: %v", alg, err)
+		}
+
+		roots := x509.NewCertPool()
+		roots.AppendCertsFromPEM(certPEM)
+		clientConn, serverConn := net.Pipe()
+		server := tls.Server(serverConn, &tls.Config{Certificates: []tls.Certificate{*tlsCert}})
+		done := make(chan error, 1)
+		go func() {
+			done <- server.Handshake()
+			server.Close()
+		}()
+		tlsClient := tls.Client(clientConn, &tls.Config{RootCAs: roots, ServerName: "server.test"})
+		if err := tlsClient.Handshake(); err != nil {
+			t.Errorf("%s: client handshake: %v", alg, err)
+		}
+		tlsClient.Close()
+		if err := <-done; err != nil {
+			t.Errorf("%s: server handshake: %v", alg, err)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// A frame sequence carries a chunked file, as written by encryptChunked, in
+// messages no larger than a transport's size limit. Each frame is
+//
+//	"KMSF" || version (1 byte) || stream ID (16 bytes) ||
+//	uint32 sequence number || uint32 frame count || payload
+//
+// Integers are big-endian. The stream ID is random and the same in every
+// frame of a sequence. Frames 0 to count-2 hold consecutive pieces of the
+// chunked file; the last frame holds
+//
+//	HMAC-SHA256(MAC key, every earlier frame, each prefixed by its uint32
+//	length || the last frame's header)
+//
+// where the MAC key is HMAC-SHA256(DEK, "KMSF frame MAC key"). The MAC binds
+// the sequence numbers, the count and the stream ID, which the chunks' own
+// authentication does not cover.
+
+const (
+	framesMagic   = "KMSF"
+	framesVersion = 1
+	streamIDSize  = 16
+	// frameHeaderSize is the size of a frame's header, before its payload.
+	frameHeaderSize = len(framesMagic) + 1 + streamIDSize + 4 + 4
+	// minFrameSize is the smallest frame size encryptToFrames accepts: one
+	// that fits the trailing MAC.
+	minFrameSize = frameHeaderSize + sha256.Size
+)
+
+// frameHeader is the parsed header of a frame.
+type frameHeader struct {
+	streamID [streamIDSize]byte
+	sequence uint32
+	count    uint32
+}
+
+// encryptToFrames reads plaintext from r until EOF, envelope encrypts it in
+// the chunked format under a new DEK wrapped with the RSA key at keyPath,
+// and splits the result into frames of at most frameSize bytes each, which
+// must be at least minFrameSize. Send the frames in order; decryptFromFrames
+// reassembles and decrypts them. The whole ciphertext is held in memory.
+func encryptToFrames(ctx context.Context, client *cloudkms.Service, r io.Reader, frameSize int, keyPath string, opts ...Option) ([][]byte, error) {
+	if frameSize < minFrameSize {
+		return nil, fmt.Errorf("frame size %d is too small; the minimum is %d", frameSize, minFrameSize)
+	}
+	dek, wrapped, err := generateAndWrapDEK(ctx, client, keyPath, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer zeroize(dek)
+	var file bytes.Buffer
+	if err := writeChunked(&file, r, dek, wrapped); err != nil {
+		return nil, err
+	}
+
+	payloadSize := frameSize - frameHeaderSize
+	count := uint64((file.Len()+payloadSize-1)/payloadSize) + 1
+	if count > math.MaxUint32 {
+		return nil, errors.New("ciphertext needs too many frames; use a larger frame size")
+	}
+	h := frameHeader{count: uint32(count)}
+	if _, err := rand.Read(h.streamID[:]); err != nil {
+		return nil, fmt.Errorf("failed to generate stream ID: %w", err)
+	}
+	frames := make([][]byte, 0, count)
+	for rest := file.Bytes(); len(rest) > 0; h.sequence++ {
+		n := payloadSize
+		if n > len(rest) {
+			n = len(rest)
+		}
+		frames = append(frames, append(h.append(nil), rest[:n]...))
+		rest = rest[n:]
+	}
+	last := h.append(nil)
+	return append(frames, framesMAC(dek, frames, last)), nil
+}
+
+// decryptFromFrames reassembles frames written by encryptToFrames, checks
+// their trailing MAC and writes the plaintext to w. The frames must be the
+// complete sequence, in order: a frame that is missing, including the last,
+// fails with an error wrapping ErrFrameMissing, and one that is duplicated or
+// out of place fails with an error wrapping ErrFrameOutOfOrder, naming the
+// frame, before the DEK is unwrapped. Frames that were altered, or that come
+// from another sequence, fail with an error wrapping ErrFrameAuthentication.
+// Nothing is written to w unless the MAC matches.
+func decryptFromFrames(ctx context.Context, client *cloudkms.Service, w io.Writer, frames [][]byte, keyPath string, opts ...Option) error {
+	if len(frames) == 0 {
+		return fmt.Errorf("%w: no frames", ErrFrameMissing)
+	}
+	headers := make([]frameHeader, len(frames))
+	for i, frame := range frames {
+		h, err := parseFrameHeader(frame)
+		if err != nil {
+			return fmt.Errorf("frame at position %d: %w", i, err)
+		}
+		headers[i] = h
+	}
+	if err := checkFrameSequence(headers); err != nil {
+		return err
+	}
+
+	var file bytes.Buffer
+	for _, frame := range frames[:len(frames)-1] {
+		file.Write(frame[frameHeaderSize:])
+	}
+	fileReader := bytes.NewReader(file.Bytes())
+	ch, err := readChunkedHeader(fileReader)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrFrameAuthentication, err)
+	}
+	dek, err := decryptRSABytes(ctx, client, base64.StdEncoding.EncodeToString(ch.wrappedDEK), keyPath, opts...)
+	if err != nil {
+		return err
+	}
+	defer zeroize(dek)
+	last := frames[len(frames)-1]
+	want := framesMAC(dek, frames[:len(frames)-1], last[:frameHeaderSize])
+	if !hmac.Equal(last, want) {
+		return fmt.Errorf("%w: the trailing MAC does not match the frames", ErrFrameAuthentication)
+	}
+	aead, err := newChunkAEAD(dek)
+	if err != nil {
+		return err
+	}
+	_, err = openChunks(w, fileReader, ch, aead, 0)
+	return err
+}
+
+// checkFrameSequence returns an error unless headers are those of one
+// complete frame sequence, in order.
+func checkFrameSequence(headers []frameHeader) error {
+	first := headers[0]
+	positions := make(map[uint32]int, len(headers))
+	for i, h := range headers {
+		if h.streamID != first.streamID || h.count != first.count {
+			return fmt.Errorf("%w: frame at position %d is from another sequence", ErrFrameAuthentication, i)
+		}
+		if h.sequence >= h.count {
+			return fmt.Errorf("%w: frame %d is beyond the last frame, %d", ErrFrameOutOfOrder, h.sequence, h.count-1)
+		}
+		if _, ok := positions[h.sequence]; ok {
+			return fmt.Errorf("%w: frame %d appears more than once", ErrFrameOutOfOrder, h.sequence)
+		}
+		positions[h.sequence] = i
+	}
+	for seq := uint32(0); seq < first.count; seq++ {
+		if _, ok := positions[seq]; !ok {
+			return fmt.Errorf("%w: frame %d of %d", ErrFrameMissing, seq, first.count)
+		}
+	}
+	for i, h := range headers {
+		if int(h.sequence) != i {
+			return fmt.Errorf("%w: frame %d is at position %d", ErrFrameOutOfOrder, h.sequence, i)
+		}
+	}
+	return nil
+}
+
+// framesMAC returns the last frame of a sequence: lastHeader followed by the
+// MAC over frames and lastHeader.
+func framesMAC(dek []byte, frames [][]byte, lastHeader []byte) []byte {
+	keyMAC := hmac.New(sha256.New, dek)
+	keyMAC.Write([]byte("KMSF frame MAC key"))
+	key := keyMAC.Sum(nil)
+	defer zeroize(key)
+
+	mac := hmac.New(sha256.New, key)
+	for _, frame := range frames {
+		mac.Write(binary.BigEndian.AppendUint32(nil, uint32(len(frame))))
+		mac.Write(frame)
+	}
+	mac.Write(lastHeader)
+	return mac.Sum(lastHeader[:len(lastHeader):len(lastHeader)])
+}
+
+// append appends the encoded header h to b.
+func (h frameHeader) append(b []byte) []byte {
+	b = append(b, framesMagic...)
+	b = append(b, framesVersion)
+	b = append(b, h.streamID[:]...)
+	b = binary.BigEndian.AppendUint32(b, h.sequence)
+	return binary.BigEndian.AppendUint32(b, h.count)
+}
+
+// parseFrameHeader parses the header of frame.
+func parseFrameHeader(frame []byte) (frameHeader, error) {
+	var h frameHeader
+	if len(frame) < frameHeaderSize || !bytes.HasPrefix(frame, []byte(framesMagic)) {
+		return h, errors.New("not a frame")
+	}
+	rest := frame[len(framesMagic):]
+	if rest[0] != framesVersion {
+		return h, fmt.Errorf("unsupported frame version %d", rest[0])
+	}
+	copy(h.streamID[:], rest[1:])
+	rest = rest[1+streamIDSize:]
+	h.sequence = binary.BigEndian.Uint32(rest)
+	h.count = binary.BigEndian.Uint32(rest[4:])
+	if h.count == 0 {
+		return h, errors.New("frame count is 0")
+	}
+	return h, nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestFrames(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("rsa-decrypt")
+	f.addKey(t, keyPath, "RSA_DECRYPT_OAEP_2048_SHA256")
+	plaintext := make([]byte, chunkedChunkSize+1000)
+	if _, err := rand.Read(plaintext); err != nil {
+		t.Fatal(err)
+	}
+	const frameSize = 4096
+	frames, err := encryptToFrames(ctx, client, bytes.NewReader(plaintext), frameSize, keyPath)
+	if err != nil {
+		t.Fatalf("encryptToFrames: %v", err)
+	}
+	if len(frames) < 3 {
+		t.Fatalf("encryptToFrames returned %d frames, want several", len(frames))
+	}
+	for i, frame := range frames {
+		if len(frame) > frameSize {
+			t.Errorf("frame %d is %d bytes, want at most %d", i, len(frame), frameSize)
+		}
+	}
+	var out bytes.Buffer
+	if err := decryptFromFrames(ctx, client, &out, frames, keyPath); err != nil {
+		t.Fatalf("decryptFromFrames: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), plaintext) {
+		t.Errorf("decryptFromFrames returned %d bytes that do not match the plaintext", out.Len())
+	}
+
+	other, err := encryptToFrames(ctx, client, bytes.NewReader(plaintext), frameSize, keyPath)
+	if err != nil {
+		t.Fatalf("encryptToFrames: %v", err)
+	}
+	n := len(frames)
+	without := func(i int) [][]byte {
+		return append(append([][]byte(nil), frames[:i]...), frames[i+1:]...)
+	}
+	swapped := append([][]byte(nil), frames...)
+	swapped[1], swapped[2] = swapped[2], swapped[1]
+	tampered := append([][]byte(nil), frames...)
+	tampered[1] = append([]byte(nil), frames[1]...)
+	tampered[1][len(tampered[1])-1] ^= 1
+	renumbered := append([][]byte(nil), swapped...)
+	for i := 1; i <= 2; i++ {
+		renumbered[i] = append([]byte(nil), swapped[i]...)
+		copy(renumbered[i][frameHeaderSize-8:], frames[i][frameHeaderSize-8:frameHeaderSize-4])
+	}
+	mixed := append([][]byte(nil), frames...)
+	mixed[1] = other[1]
+	tests := []struct {
+		name   string
+		frames [][]byte
+		want   error
+	}{
+		{"no frames", nil, ErrFrameMissing},
+		{"middle frame missing", without(1), ErrFrameMissing},
+		{"MAC frame missing", frames[:n-1], ErrFrameMissing},
+		{"frames swapped", swapped, ErrFrameOutOfOrder},
+		{"frame duplicated", append(append([][]byte(nil), frames...), frames[0]), ErrFrameOutOfOrder},
+		{"payload altered", tampered, ErrFrameAuthentication},
+		{"frames swapped and renumbered", renumbered, ErrFrameAuthentication},
+		{"frame from another sequence", mixed, ErrFrameAuthentication},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		err := decryptFromFrames(ctx, client, &out, test.frames, keyPath)
+		if !errors.Is(err, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.want)
+		}
+		if out.Len() > 0 {
+			t.Errorf("%s: wrote %d bytes of plaintext, want none", test.name, out.Len())
+		}
+	}
+
+	if _, err := encryptToFrames(ctx, client, bytes.NewReader(plaintext), minFrameSize-1, keyPath); err == nil {
+		t.Errorf("encryptToFrames with frame size %d succeeded, want an error", minFrameSize-1)
+	}
+}
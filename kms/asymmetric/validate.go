@@ -0,0 +1,248 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// validateKeyAtStartup checks that the key version at keyPath exists, is
+// enabled and belongs to a key with the expected purpose, such as
+// "ASYMMETRIC_SIGN" or "ASYMMETRIC_DECRYPT". For asymmetric keys it also
+// fetches the public key to confirm the caller may read it.
+// Call it once when a service starts, so that a misconfigured key is reported
+// immediately rather than on the first request that needs it.
+func validateKeyAtStartup(ctx context.Context, client *cloudkms.Service, keyPath, expectedPurpose string, opts ...Option) error {
+	version, err := getKeyVersion(ctx, client, keyPath, opts...)
+	if err != nil {
+		return err
+	}
+	if version.State != "ENABLED" {
+		return fmt.Errorf("key version %s is %s, not ENABLED", keyPath, version.State)
+	}
+	key, err := getCryptoKey(ctx, client, keyPath, opts...)
+	if err != nil {
+		return err
+	}
+	if key.Purpose != expectedPurpose {
+		return fmt.Errorf("key %s has purpose %s; want %s", key.Name, key.Purpose, expectedPurpose)
+	}
+	if strings.HasPrefix(key.Purpose, "ASYMMETRIC_") {
+		if _, err := getAsymmetricPublicKey(ctx, client, keyPath, opts...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getCryptoKey returns the CryptoKey that owns the key version at keyPath.
+func getCryptoKey(ctx context.Context, client *cloudkms.Service, keyPath string, opts ...Option) (*cloudkms.CryptoKey, error) {
+	o := newOptions(opts)
+	call := client.Projects.Locations.KeyRings.CryptoKeys.Get(parentKeyPath(keyPath))
+	var key *cloudkms.CryptoKey
+	err := o.kmsCall(ctx, "GetCryptoKey", parentKeyPath(keyPath), call.Header(), func() (http.Header, error) {
+		var err error
+		key, err = call.Context(ctx).Do()
+		if err != nil {
+			return nil, err
+		}
+		return key.Header, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get key %s: %w", parentKeyPath(keyPath), err)
+	}
+	return key, nil
+}
+
+// enforceAlgorithm returns ErrAlgorithmNotAllowed unless the algorithm of the
+// key version at keyPath is one of allowed.
+func enforceAlgorithm(ctx context.Context, client *cloudkms.Service, keyPath string, allowed []string, opts ...Option) error {
+	version, err := getKeyVersion(ctx, client, keyPath, opts...)
+	if err != nil {
+		return err
+	}
+	if !containsString(allowed, version.Algorithm) {
+		return fmt.Errorf("%w: %s uses %s", ErrAlgorithmNotAllowed, keyPath, version.Algorithm)
+	}
+	return nil
+}
+
+// validateKeyConfig checks a configuration that maps logical key names to
+// key version paths in keys against expectedAlgs, which maps the same names
+// to the KMS algorithm each key must use, such as "EC_SIGN_P256_SHA256". The
+// algorithm names the key size too, so an RSA_SIGN_PSS_2048_SHA256 key
+// configured where RSA_SIGN_PSS_4096_SHA256 is expected is a mismatch. Every
+// key is checked, and the error joins one error per problem, sorted by
+// name: a key whose algorithm differs, wrapping ErrUnexpectedAlgorithm, a
+// key that could not be fetched, and a name in only one of the maps. Call it
+// when a service starts, like validateKeyAtStartup.
+func validateKeyConfig(ctx context.Context, client *cloudkms.Service, keys map[string]string, expectedAlgs map[string]string, opts ...Option) error {
+	names := make([]string, 0, len(keys))
+	for name := range keys {
+		names = append(names, name)
+	}
+	for name := range expectedAlgs {
+		if _, ok := keys[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var errs []error
+	for _, name := range names {
+		keyPath, ok := keys[name]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: no key configured", name))
+			continue
+		}
+		want, ok := expectedAlgs[name]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: no expected algorithm for %s", name, keyPath))
+			continue
+		}
+		version, err := getKeyVersion(ctx, client, keyPath, opts...)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		if version.Algorithm != want {
+			errs = append(errs, fmt.Errorf("%w: %s: %s uses %s; want %s", ErrUnexpectedAlgorithm, name, keyPath, version.Algorithm, want))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// verifyStrict verifies signature over message with the key at keyPath only
+// if the key version's algorithm is exactly requiredAlg, such as
+// "EC_SIGN_P256_SHA256". Otherwise it fails with ErrUnexpectedAlgorithm
+// without checking the signature, so a caller expecting one algorithm is
+// never satisfied by a signature of another.
+func verifyStrict(ctx context.Context, client *cloudkms.Service, signature, message, keyPath, requiredAlg string, opts ...Option) error {
+	o := newOptions(opts)
+	if err := o.checkMessageLength([]byte(message)); err != nil {
+		return err
+	}
+	response, publicKey, err := fetchPublicKey(ctx, client, keyPath, opts...)
+	if err != nil {
+		return err
+	}
+	if response.Algorithm != requiredAlg {
+		return fmt.Errorf("%w: %s uses %s; want %s", ErrUnexpectedAlgorithm, keyPath, response.Algorithm, requiredAlg)
+	}
+	alg, ok := lookupAlgorithm(requiredAlg)
+	if !ok || alg.Purpose != "ASYMMETRIC_SIGN" || alg.Hash == 0 {
+		return fmt.Errorf("%w: %s is not a KMS algorithm that signs digests", ErrKeyTypeMismatch, requiredAlg)
+	}
+	return o.verifyWithAlgorithm(publicKey, alg, signature, []byte(message), keyPath)
+}
+
+// WithAllowedAlgorithms makes signing fail with ErrAlgorithmNotAllowed, before
+// anything is signed, unless the key's algorithm is one of algs. With no
+// algs, every key is rejected. Looking up the algorithm costs one extra KMS
+// call per signature, unless WithKeyAlgorithm gives it.
+func WithAllowedAlgorithms(algs ...string) Option {
+	return func(o *options) {
+		o.allowedAlgorithms = algs
+		o.restrictAlgorithms = true
+	}
+}
+
+// checkAllowedAlgorithm enforces WithAllowedAlgorithms for a key version
+// whose algorithm is alg.
+func (o *options) checkAllowedAlgorithm(alg, keyPath string) error {
+	if o.restrictAlgorithms && !containsString(o.allowedAlgorithms, alg) {
+		return fmt.Errorf("%w: %s uses %s", ErrAlgorithmNotAllowed, keyPath, alg)
+	}
+	return nil
+}
+
+// WithMinRSABits makes encryption and verification fail with ErrWeakKey if
+// the key is an RSA key with a modulus shorter than bits. EC keys are not
+// checked.
+func WithMinRSABits(bits int) Option {
+	return func(o *options) { o.minRSABits = bits }
+}
+
+// checkKeyStrength enforces WithMinRSABits for publicKey, the key at keyPath.
+func (o *options) checkKeyStrength(publicKey crypto.PublicKey, keyPath string) error {
+	rsaKey, ok := publicKey.(*rsa.PublicKey)
+	if !ok || o.minRSABits == 0 {
+		return nil
+	}
+	if bits := rsaKey.N.BitLen(); bits < o.minRSABits {
+		return fmt.Errorf("%w: %s is a %d-bit RSA key; need at least %d bits", ErrWeakKey, keyPath, bits, o.minRSABits)
+	}
+	return nil
+}
+
+// WithMaxKeyAge makes signing fail with ErrKeyTooOld, before anything is
+// signed, if the key version was generated more than maxAge ago. It enforces
+// a rotation policy at the point of use, so a key that should have been
+// rotated is reported rather than silently used.
+func WithMaxKeyAge(maxAge time.Duration) Option {
+	return func(o *options) { o.maxKeyAge = maxAge }
+}
+
+// checkKeyAge enforces WithMaxKeyAge for version.
+func (o *options) checkKeyAge(version *cloudkms.CryptoKeyVersion) error {
+	if o.maxKeyAge <= 0 {
+		return nil
+	}
+	generated, err := parseTimestamp(version.GenerateTime)
+	if err != nil {
+		return err
+	}
+	if generated.IsZero() {
+		return fmt.Errorf("%w: %s has no generation time", ErrKeyTooOld, version.Name)
+	}
+	if age := o.now().Sub(generated); age > o.maxKeyAge {
+		return fmt.Errorf("%w: %s was generated %v ago; the maximum is %v",
+			ErrKeyTooOld, version.Name, age.Round(time.Second), o.maxKeyAge)
+	}
+	return nil
+}
+
+// WithRequireHSM makes every function that fetches a public key from KMS,
+// including the verify functions, fail with ErrInsufficientProtection unless
+// the key version's protection level is HSM. A SOFTWARE or EXTERNAL key is
+// rejected even if the signature is valid, so a caller can insist that a
+// signature came from hardware. A key passed in rather than fetched, such as
+// one given by WithPublicKey or a certificate's, cannot be checked, so
+// verifying with one fails with ErrInsufficientProtection.
+func WithRequireHSM() Option {
+	return func(o *options) { o.requireHSM = true }
+}
+
+// checkProtectionLevel enforces WithRequireHSM for the key at keyPath, whose
+// protection level KMS reported as level.
+func (o *options) checkProtectionLevel(level, keyPath string) error {
+	if o.requireHSM && level != "HSM" {
+		return fmt.Errorf("%w: %s has protection level %s", ErrInsufficientProtection, keyPath, level)
+	}
+	return nil
+}
+
+// checkKeyFreshness returns an error wrapping ErrKeyTooOld if the key version
+// at keyPath was generated more than maxAge ago. Call it at startup to find
+// stale keys before they are needed.
+func checkKeyFreshness(ctx context.Context, client *cloudkms.Service, keyPath string, maxAge time.Duration, opts ...Option) error {
+	version, err := getKeyVersion(ctx, client, keyPath, opts...)
+	if err != nil {
+		return err
+	}
+	return newOptions(append(opts[:len(opts):len(opts)], WithMaxKeyAge(maxAge))).checkKeyAge(version)
+}
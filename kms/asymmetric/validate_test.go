@@ -0,0 +1,230 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestWithMinRSABits(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	decryptPath := testKeyPath("decrypt")
+	signPath := testKeyPath("rsa-sign")
+	ecPath := testKeyPath("ec-sign")
+	f.addKey(t, decryptPath, "RSA_DECRYPT_OAEP_2048_SHA256")
+	f.addKey(t, signPath, "RSA_SIGN_PSS_2048_SHA256")
+	f.addKey(t, ecPath, "EC_SIGN_P256_SHA256")
+
+	if _, err := encryptRSA(ctx, client, "message", decryptPath, WithMinRSABits(3072)); !errors.Is(err, ErrWeakKey) {
+		t.Errorf("encryptRSA with 2048-bit key: got %v, want ErrWeakKey", err)
+	}
+	if _, err := encryptRSA(ctx, client, "message", decryptPath, WithMinRSABits(2048)); err != nil {
+		t.Errorf("encryptRSA with 2048-bit minimum: %v", err)
+	}
+
+	signature, err := signAsymmetric(ctx, client, "message", signPath)
+	if err != nil {
+		t.Fatalf("signAsymmetric: %v", err)
+	}
+	if err := verifySignatureRSA(ctx, client, signature, "message", signPath, WithMinRSABits(3072)); !errors.Is(err, ErrWeakKey) {
+		t.Errorf("verifySignatureRSA with 2048-bit key: got %v, want ErrWeakKey", err)
+	}
+
+	signature, err = signAsymmetric(ctx, client, "message", ecPath)
+	if err != nil {
+		t.Fatalf("signAsymmetric: %v", err)
+	}
+	if err := verifySignatureEC(ctx, client, signature, "message", ecPath, WithMinRSABits(3072)); err != nil {
+		t.Errorf("verifySignatureEC should ignore WithMinRSABits: %v", err)
+	}
+}
+
+func TestWithAllowedAlgorithms(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("ec-sign")
+	f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+	digest, _, err := computeSignDigest("message", "EC_SIGN_P256_SHA256")
+	if err != nil {
+		t.Fatalf("computeSignDigest: %v", err)
+	}
+	raw, err := decodeBase64(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := newKMSSigner(ctx, client, keyPath, WithAllowedAlgorithms("EC_SIGN_P384_SHA384"))
+	if err != nil {
+		t.Fatalf("newKMSSigner: %v", err)
+	}
+
+	var none []string
+	for name, allowed := range map[string]Option{
+		"other algorithm": WithAllowedAlgorithms("EC_SIGN_P384_SHA384"),
+		"empty list":      WithAllowedAlgorithms(),
+		"nil list":        WithAllowedAlgorithms(none...),
+	} {
+		if _, err := signAsymmetric(ctx, client, "message", keyPath, allowed); !errors.Is(err, ErrAlgorithmNotAllowed) {
+			t.Errorf("%s: signAsymmetric: got %v, want ErrAlgorithmNotAllowed", name, err)
+		}
+		if _, err := signDigest(ctx, client, raw, keyPath, allowed); !errors.Is(err, ErrAlgorithmNotAllowed) {
+			t.Errorf("%s: signDigest: got %v, want ErrAlgorithmNotAllowed", name, err)
+		}
+		if _, err := signComputedDigest(ctx, client, digest, "SHA-256", keyPath, allowed); !errors.Is(err, ErrAlgorithmNotAllowed) {
+			t.Errorf("%s: signComputedDigest: got %v, want ErrAlgorithmNotAllowed", name, err)
+		}
+		if _, err := signDigestWithHash(ctx, client, raw, crypto.SHA256, keyPath, allowed); !errors.Is(err, ErrAlgorithmNotAllowed) {
+			t.Errorf("%s: signDigestWithHash: got %v, want ErrAlgorithmNotAllowed", name, err)
+		}
+	}
+	if _, err := signer.Sign(nil, raw, crypto.SHA256); !errors.Is(err, ErrAlgorithmNotAllowed) {
+		t.Errorf("kmsSigner.Sign: got %v, want ErrAlgorithmNotAllowed", err)
+	}
+	if _, err := signDigest(ctx, client, raw, keyPath, WithAllowedAlgorithms("EC_SIGN_P256_SHA256")); err != nil {
+		t.Errorf("signDigest with an allowed algorithm: %v", err)
+	}
+}
+
+func TestWithMaxKeyAge(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("ec-sign")
+	f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+	f.mu.Lock()
+	f.keys[keyPath].version.GenerateTime = time.Now().Add(-48 * time.Hour).UTC().Format(time.RFC3339Nano)
+	f.mu.Unlock()
+
+	if _, err := signAsymmetric(ctx, client, "message", keyPath, WithMaxKeyAge(24*time.Hour)); !errors.Is(err, ErrKeyTooOld) {
+		t.Errorf("signAsymmetric with 2-day-old key: got %v, want ErrKeyTooOld", err)
+	}
+	if _, err := signAsymmetric(ctx, client, "message", keyPath, WithMaxKeyAge(72*time.Hour)); err != nil {
+		t.Errorf("signAsymmetric within max age: %v", err)
+	}
+	if err := checkKeyFreshness(ctx, client, keyPath, 24*time.Hour); !errors.Is(err, ErrKeyTooOld) {
+		t.Errorf("checkKeyFreshness: got %v, want ErrKeyTooOld", err)
+	}
+
+	generated := time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC)
+	f.mu.Lock()
+	f.keys[keyPath].version.GenerateTime = generated.Format(time.RFC3339Nano)
+	f.mu.Unlock()
+	clock := WithClock(func() time.Time { return generated.Add(12 * time.Hour) })
+	if _, err := signAsymmetric(ctx, client, "message", keyPath, WithMaxKeyAge(24*time.Hour), clock); err != nil {
+		t.Errorf("signAsymmetric with a clock half a day after generation: %v", err)
+	}
+	if err := checkKeyFreshness(ctx, client, keyPath, 24*time.Hour, clock); err != nil {
+		t.Errorf("checkKeyFreshness with a clock half a day after generation: %v", err)
+	}
+	clock = WithClock(func() time.Time { return generated.Add(36 * time.Hour) })
+	if err := checkKeyFreshness(ctx, client, keyPath, 24*time.Hour, clock); !errors.Is(err, ErrKeyTooOld) {
+		t.Errorf("checkKeyFreshness with a clock 36 hours after generation: got %v, want ErrKeyTooOld", err)
+	}
+}
+
+func TestWithRequireHSM(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	softwarePath := testKeyPath("software")
+	hsmPath := testKeyPath("hsm")
+	f.addKey(t, softwarePath, "EC_SIGN_P256_SHA256")
+	f.addKey(t, hsmPath, "EC_SIGN_P256_SHA256")
+	f.mu.Lock()
+	f.keys[hsmPath].version.ProtectionLevel = "HSM"
+	f.mu.Unlock()
+
+	for _, keyPath := range []string{softwarePath, hsmPath} {
+		signature, err := signAsymmetric(ctx, client, "message", keyPath)
+		if err != nil {
+			t.Fatalf("signAsymmetric: %v", err)
+		}
+		var want error
+		if keyPath == softwarePath {
+			want = ErrInsufficientProtection
+		}
+		if err := verifySignature(ctx, client, signature, []byte("message"), keyPath, WithRequireHSM()); !errors.Is(err, want) {
+			t.Errorf("verifySignature with %s: got %v, want %v", keyPath, err, want)
+		}
+		if err := verifySignatureEC(ctx, client, signature, "message", keyPath, WithRequireHSM()); !errors.Is(err, want) {
+			t.Errorf("verifySignatureEC with %s: got %v, want %v", keyPath, err, want)
+		}
+		if _, err := newVerifier(ctx, client, keyPath, WithRequireHSM()); !errors.Is(err, want) {
+			t.Errorf("newVerifier with %s: got %v, want %v", keyPath, err, want)
+		}
+		if result := verifyDetailedEC(ctx, client, signature, "message", keyPath, WithRequireHSM()); !errors.Is(result.Err, want) {
+			t.Errorf("verifyDetailedEC with %s: got %v, want %v", keyPath, result.Err, want)
+		}
+	}
+
+	// A key passed in has no protection level to check.
+	signature, err := signAsymmetric(ctx, client, "message", hsmPath)
+	if err != nil {
+		t.Fatalf("signAsymmetric: %v", err)
+	}
+	publicKey := testPrivateKey(t, "EC_SIGN_P256_SHA256").Public()
+	if err := verifySignatureEC(ctx, nil, signature, "message", "", WithPublicKey(publicKey), WithRequireHSM()); !errors.Is(err, ErrInsufficientProtection) {
+		t.Errorf("verifySignatureEC with WithPublicKey and WithRequireHSM: got %v, want ErrInsufficientProtection", err)
+	}
+	if err := verifySignatureEC(ctx, nil, signature, "message", "", WithPublicKey(publicKey)); err != nil {
+		t.Errorf("verifySignatureEC with WithPublicKey: %v", err)
+	}
+}
+
+func TestVerifyStrict(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("rsa-sign")
+	f.addKey(t, keyPath, "RSA_SIGN_PKCS1_2048_SHA256")
+	signature, err := signAsymmetric(ctx, client, "message", keyPath)
+	if err != nil {
+		t.Fatalf("signAsymmetric: %v", err)
+	}
+	if err := verifyStrict(ctx, client, signature, "message", keyPath, "RSA_SIGN_PKCS1_2048_SHA256"); err != nil {
+		t.Errorf("verifyStrict with the key's algorithm: %v", err)
+	}
+	if err := verifyStrict(ctx, client, signature, "other", keyPath, "RSA_SIGN_PKCS1_2048_SHA256"); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("verifyStrict with another message: got %v, want ErrSignatureInvalid", err)
+	}
+	if err := verifyStrict(ctx, client, signature, "message", keyPath, "EC_SIGN_P256_SHA256"); !errors.Is(err, ErrUnexpectedAlgorithm) {
+		t.Errorf("verifyStrict with another algorithm: got %v, want ErrUnexpectedAlgorithm", err)
+	}
+}
+
+func TestValidateKeyConfig(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	signer := testKeyPath("config-signer")
+	legacy := testKeyPath("config-legacy")
+	f.addKey(t, signer, "EC_SIGN_P256_SHA256")
+	f.addKey(t, legacy, "RSA_SIGN_PSS_2048_SHA256")
+	keys := map[string]string{"signer": signer, "legacy": legacy}
+	expected := map[string]string{"signer": "EC_SIGN_P256_SHA256", "legacy": "RSA_SIGN_PSS_2048_SHA256"}
+	if err := validateKeyConfig(ctx, client, keys, expected); err != nil {
+		t.Fatalf("validateKeyConfig of a matching configuration: %v", err)
+	}
+
+	// A smaller key of the same type, a missing key version and names in
+	// only one map are all reported together.
+	expected["legacy"] = "RSA_SIGN_PSS_4096_SHA256"
+	expected["audit"] = "EC_SIGN_P384_SHA384"
+	keys["missing"] = testKeyPath("config-missing")
+	expected["missing"] = "EC_SIGN_P256_SHA256"
+	keys["unexpected"] = signer
+	err := validateKeyConfig(ctx, client, keys, expected)
+	if !errors.Is(err, ErrUnexpectedAlgorithm) {
+		t.Fatalf("validateKeyConfig with a key size mismatch: got %v, want ErrUnexpectedAlgorithm", err)
+	}
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		t.Fatalf("validateKeyConfig error %T does not join its errors", err)
+	}
+	if n := len(joined.Unwrap()); n != 4 {
+		t.Errorf("validateKeyConfig reported %d problems, want 4:\n%v", n, err)
+	}
+}
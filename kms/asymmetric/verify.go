@@ -0,0 +1,286 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// WithPublicKey makes the verify functions use key instead of fetching the
+// public key from KMS. key may come from anywhere: KMS, a PEM file parsed
+// with parsePublicKeyPEM, or a hardware token such as a YubiKey, through
+// PKCS#11. With it, no KMS request is made, so client may be nil and keyPath
+// empty. It also lets a caller that has already fetched the key pass it on
+// without a second request. The signature must still be in the form the
+// verify function expects; verifySignatureRSA, for example, checks RSA-PSS
+// with SHA-256, and so does verifySignature with an RSA key unless
+// WithKeyAlgorithm names another algorithm, such as RSA_SIGN_PKCS1_2048_SHA256
+// for the PKCS#1 v1.5 signatures a YubiKey's PIV applet makes.
+// WithMinRSABits and WithExpectedKeyFingerprint still apply to key.
+// WithRequireHSM cannot be checked for key, so verifying fails with
+// ErrInsufficientProtection if it is also given.
+func WithPublicKey(key crypto.PublicKey) Option {
+	return func(o *options) {
+		o.publicKey = key
+		o.publicKeyFetched = false
+	}
+}
+
+// withFetchedPublicKey is like WithPublicKey, for passing on a key that was
+// fetched from KMS with the caller's options, so that WithRequireHSM has
+// already been enforced for it.
+func withFetchedPublicKey(key crypto.PublicKey) Option {
+	return func(o *options) {
+		o.publicKey = key
+		o.publicKeyFetched = true
+	}
+}
+
+// getPublicKey returns the public key to verify with: the one given by
+// WithPublicKey, or else the key at keyPath, fetched from KMS.
+// The key is checked against WithMinRSABits and WithExpectedKeyFingerprint.
+func (o *options) getPublicKey(ctx context.Context, client *cloudkms.Service, keyPath string) (crypto.PublicKey, error) {
+	publicKey, _, err := o.getPublicKeyAlgorithm(ctx, client, keyPath)
+	return publicKey, err
+}
+
+// getPublicKeyAlgorithm is like getPublicKey, but also returns the name of
+// the key's algorithm: the one KMS reports for a fetched key, or else the one
+// given by WithKeyAlgorithm, or "" if neither is known.
+func (o *options) getPublicKeyAlgorithm(ctx context.Context, client *cloudkms.Service, keyPath string) (crypto.PublicKey, string, error) {
+	publicKey, alg := o.publicKey, o.keyAlgorithm
+	if publicKey != nil && o.requireHSM && !o.publicKeyFetched {
+		return nil, "", fmt.Errorf("%w: the protection level of a key given by WithPublicKey is unknown", ErrInsufficientProtection)
+	}
+	if publicKey == nil {
+		start := o.startTimer()
+		response, fetched, err := fetchPublicKey(ctx, client, keyPath, o.option())
+		if err != nil {
+			return nil, "", err
+		}
+		o.recordKeyFetch(start)
+		publicKey, alg = fetched, response.Algorithm
+	}
+	if err := o.checkKeyStrength(publicKey, keyPath); err != nil {
+		return nil, "", err
+	}
+	if err := o.checkKeyPin(publicKey, keyPath); err != nil {
+		return nil, "", err
+	}
+	return publicKey, alg, nil
+}
+
+// verifySignature verifies signature over message with the key at keyPath,
+// using the padding and hash of the key's algorithm for an RSA key, or
+// verifySignatureECBytes for an elliptic curve key, whose curve fixes the
+// hash. The public key is fetched only once. For a key given by
+// WithPublicKey, whose algorithm KMS does not report, RSA signatures are
+// checked as RSA-PSS with SHA-256 unless WithKeyAlgorithm says otherwise.
+func verifySignature(ctx context.Context, client *cloudkms.Service, signature string, message []byte, keyPath string, opts ...Option) error {
+	o := newOptions(opts)
+	if o.graceVersions > 0 && o.publicKey == nil {
+		return verifyWithGrace(ctx, client, signature, message, keyPath, o)
+	}
+	if err := o.checkMessageLength(message); err != nil {
+		return err
+	}
+	publicKey, algName, err := o.getPublicKeyAlgorithm(ctx, client, keyPath)
+	if err != nil {
+		return err
+	}
+	opts = append(opts[:len(opts):len(opts)], withFetchedPublicKey(publicKey))
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		if algName == "" {
+			return verifySignatureRSABytes(ctx, client, signature, message, keyPath, opts...)
+		}
+		alg, ok := lookupAlgorithm(algName)
+		if !ok {
+			return fmt.Errorf("unsupported algorithm %s", algName)
+		}
+		return o.verifyRSA(key, alg, signature, message)
+	case *ecdsa.PublicKey:
+		return verifySignatureECBytes(ctx, client, signature, message, keyPath, opts...)
+	default:
+		return fmt.Errorf("%w: unsupported public key type %T", ErrKeyTypeMismatch, publicKey)
+	}
+}
+
+// verifyRSA verifies signature over message with rsaKey, a key of the KMS
+// algorithm alg, with its padding and hash. Like verifySignatureRSABytes, it
+// applies WithPSSSaltLength to PSS signatures.
+func (o *options) verifyRSA(rsaKey *rsa.PublicKey, alg AlgorithmInfo, signature string, message []byte) error {
+	if alg.Purpose != "ASYMMETRIC_SIGN" || alg.Hash == 0 || !alg.Hash.Available() {
+		return fmt.Errorf("%w: %s does not sign digests", ErrKeyTypeMismatch, alg.Name)
+	}
+	if err := checkKeyAlgorithm(rsaKey, alg); err != nil {
+		return err
+	}
+	decodedSignature, err := o.decodeSignature(signature)
+	if err != nil {
+		return err
+	}
+	digest := alg.Hash.New()
+	digest.Write(o.normalizeMessage(message))
+	hash := digest.Sum(nil)
+
+	start := o.startTimer()
+	switch alg.Padding {
+	case "PSS":
+		pssOptions := rsa.PSSOptions{SaltLength: o.saltLength(len(hash)), Hash: alg.Hash}
+		err = rsa.VerifyPSS(rsaKey, alg.Hash, hash, decodedSignature, &pssOptions)
+	case "PKCS1":
+		err = rsa.VerifyPKCS1v15(rsaKey, alg.Hash, hash, decodedSignature)
+	default:
+		return fmt.Errorf("%w: %s is not an RSA signing algorithm", ErrKeyTypeMismatch, alg.Name)
+	}
+	o.recordVerify(start)
+	if err != nil {
+		err = fmt.Errorf("%w: %w", ErrSignatureInvalid, err)
+		return o.diagnoseHash(err, rsaKey, alg.Name, message, decodedSignature)
+	}
+	o.reportAlgorithm(func() string { return alg.Name })
+	return nil
+}
+
+// checkSignatureRSA is like verifySignatureRSA, but reports a signature that
+// was checked and found not to match as valid == false with a nil error. err
+// is non-nil only if the signature could not be checked, for example because
+// KMS could not be reached or the signature is not valid base64.
+func checkSignatureRSA(ctx context.Context, client *cloudkms.Service, signature, message, keyPath string, opts ...Option) (valid bool, err error) {
+	return validity(verifySignatureRSA(ctx, client, signature, message, keyPath, opts...))
+}
+
+// checkSignatureEC is like checkSignatureRSA, for an elliptic curve key.
+func checkSignatureEC(ctx context.Context, client *cloudkms.Service, signature, message, keyPath string, opts ...Option) (valid bool, err error) {
+	return validity(verifySignatureEC(ctx, client, signature, message, keyPath, opts...))
+}
+
+// checkSignature is like verifySignature, returning validity as checkSignatureRSA does.
+func checkSignature(ctx context.Context, client *cloudkms.Service, signature string, message []byte, keyPath string, opts ...Option) (valid bool, err error) {
+	return validity(verifySignature(ctx, client, signature, message, keyPath, opts...))
+}
+
+// validity splits the result of a verify function into a verdict and an
+// operational error.
+func validity(err error) (bool, error) {
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, ErrSignatureInvalid):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// newVerifier fetches the public key at keyPath once and returns a function
+// that verifies signatures made with it, with no further requests, for hot
+// paths where a KMS round trip per signature is too slow. The returned
+// function hashes message with the digest the key's algorithm requires and
+// applies opts, such as WithSignatureEncoding, on every call. It is safe for
+// concurrent use. Because the key is never fetched again, create a new
+// verifier after the key version is disabled or destroyed.
+func newVerifier(ctx context.Context, client *cloudkms.Service, keyPath string, opts ...Option) (func(signature, message string) error, error) {
+	response, publicKey, err := fetchPublicKey(ctx, client, keyPath, opts...)
+	if err != nil {
+		return nil, err
+	}
+	alg, ok := lookupAlgorithm(response.Algorithm)
+	if !ok {
+		return nil, fmt.Errorf("unsupported algorithm %s", response.Algorithm)
+	}
+	if alg.Purpose != "ASYMMETRIC_SIGN" || alg.Hash == 0 {
+		return nil, fmt.Errorf("%w: %s does not sign digests", ErrKeyTypeMismatch, alg.Name)
+	}
+	o := newOptions(append(opts[:len(opts):len(opts)], withFetchedPublicKey(publicKey)))
+	if err := o.checkAllowedAlgorithm(alg.Name, keyPath); err != nil {
+		return nil, err
+	}
+	// Check the key against WithMinRSABits and WithExpectedKeyFingerprint
+	// once, rather than on every call.
+	if _, err := o.getPublicKey(ctx, client, keyPath); err != nil {
+		return nil, err
+	}
+	if err := checkKeyAlgorithm(publicKey, alg); err != nil {
+		return nil, err
+	}
+	return func(signature, message string) error {
+		if err := o.checkMessageLength([]byte(message)); err != nil {
+			return err
+		}
+		decoded, err := o.decodeSignature(signature)
+		if err != nil {
+			return err
+		}
+		h := alg.Hash.New()
+		h.Write(o.normalizeMessage([]byte(message)))
+		if err := verifyDigest(publicKey, alg, h.Sum(nil), decoded); err != nil {
+			return o.diagnoseHash(err, publicKey, alg.Name, []byte(message), decoded)
+		}
+		o.reportAlgorithm(func() string { return alg.Name })
+		return nil
+	}, nil
+}
+
+// A VerifyResult is the full outcome of a verification by verifyDetailedRSA
+// or verifyDetailedEC.
+type VerifyResult struct {
+	// Valid is true if the signature is valid, in which case Err is nil.
+	Valid bool
+	// KeyVersion is the resource name of the key version verified with, and
+	// LogicalKeyID its logicalKeyID.
+	KeyVersion   string
+	LogicalKeyID string
+	// Algorithm is the KMS algorithm of the key version, such as
+	// "EC_SIGN_P256_SHA256", and Fingerprint the keyFingerprint of its public
+	// key. Both are empty if the public key could not be fetched.
+	Algorithm   string
+	Fingerprint string
+	// Err is why the signature is not valid. failureReason(Err) categorizes
+	// it.
+	Err error
+}
+
+// verifyDetailedRSA is like verifySignatureRSA, but returns a VerifyResult
+// that also describes the key the signature was checked against.
+func verifyDetailedRSA(ctx context.Context, client *cloudkms.Service, signature, message, keyPath string, opts ...Option) VerifyResult {
+	return verifyDetailed(ctx, client, keyPath, opts, func(opts ...Option) error {
+		return verifySignatureRSA(ctx, client, signature, message, keyPath, opts...)
+	})
+}
+
+// verifyDetailedEC is like verifyDetailedRSA, for an elliptic curve key.
+func verifyDetailedEC(ctx context.Context, client *cloudkms.Service, signature, message, keyPath string, opts ...Option) VerifyResult {
+	return verifyDetailed(ctx, client, keyPath, opts, func(opts ...Option) error {
+		return verifySignatureEC(ctx, client, signature, message, keyPath, opts...)
+	})
+}
+
+// verifyDetailed fetches the public key at keyPath once, describes it and
+// passes it to verify.
+func verifyDetailed(ctx context.Context, client *cloudkms.Service, keyPath string, opts []Option, verify func(opts ...Option) error) VerifyResult {
+	result := VerifyResult{KeyVersion: keyPath, LogicalKeyID: logicalKeyID(keyPath)}
+	response, publicKey, err := fetchPublicKey(ctx, client, keyPath, opts...)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	result.Algorithm = response.Algorithm
+	if result.Fingerprint, err = keyFingerprint(publicKey); err != nil {
+		result.Err = err
+		return result
+	}
+	result.Err = verify(append(opts[:len(opts):len(opts)], withFetchedPublicKey(publicKey))...)
+	result.Valid = result.Err == nil
+	return result
+}
@@ -0,0 +1,290 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+func TestCheckSignature(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	rsaPath := testKeyPath("rsa-sign")
+	ecPath := testKeyPath("ec-sign")
+	f.addKey(t, rsaPath, "RSA_SIGN_PSS_2048_SHA256")
+	f.addKey(t, ecPath, "EC_SIGN_P256_SHA256")
+
+	for _, tc := range []struct {
+		keyPath string
+		check   func(ctx context.Context, signature, message, keyPath string) (bool, error)
+	}{
+		{rsaPath, func(ctx context.Context, signature, message, keyPath string) (bool, error) {
+			return checkSignatureRSA(ctx, client, signature, message, keyPath)
+		}},
+		{ecPath, func(ctx context.Context, signature, message, keyPath string) (bool, error) {
+			return checkSignatureEC(ctx, client, signature, message, keyPath)
+		}},
+		{ecPath, func(ctx context.Context, signature, message, keyPath string) (bool, error) {
+			return checkSignature(ctx, client, signature, []byte(message), keyPath)
+		}},
+	} {
+		signature, err := signAsymmetric(ctx, client, "message", tc.keyPath)
+		if err != nil {
+			t.Fatalf("signAsymmetric(%s): %v", tc.keyPath, err)
+		}
+		if valid, err := tc.check(ctx, signature, "message", tc.keyPath); !valid || err != nil {
+			t.Errorf("%s: good signature: got (%v, %v), want (true, nil)", tc.keyPath, valid, err)
+		}
+		if valid, err := tc.check(ctx, signature, "tampered", tc.keyPath); valid || err != nil {
+			t.Errorf("%s: bad signature: got (%v, %v), want (false, nil)", tc.keyPath, valid, err)
+		}
+		if valid, err := tc.check(ctx, signature, "message", testKeyPath("missing")); valid || err == nil {
+			t.Errorf("%s: missing key: got (%v, %v), want (false, error)", tc.keyPath, valid, err)
+		}
+	}
+}
+
+func TestRejectEmptyMessage(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	keyPath := testKeyPath("ec-sign")
+	f.addKey(t, keyPath, "EC_SIGN_P256_SHA256")
+	signature, err := signAsymmetric(ctx, client, "", keyPath)
+	if err != nil {
+		t.Fatalf("signAsymmetric: %v", err)
+	}
+	if err := verifySignatureEC(ctx, client, signature, "", keyPath); err != nil {
+		t.Errorf("verifySignatureEC without option: %v", err)
+	}
+	requests := f.requests
+	if err := verifySignatureEC(ctx, client, signature, "", keyPath, WithRejectEmptyMessage()); !errors.Is(err, ErrEmptyMessage) {
+		t.Errorf("verifySignatureEC: got %v, want ErrEmptyMessage", err)
+	}
+	if err := verifySignature(ctx, client, signature, nil, keyPath, WithRejectEmptyMessage()); !errors.Is(err, ErrEmptyMessage) {
+		t.Errorf("verifySignature: got %v, want ErrEmptyMessage", err)
+	}
+	if f.requests != requests {
+		t.Errorf("made %d requests for an empty message; want none", f.requests-requests)
+	}
+}
+
+// TestVerifyFailsClosed feeds the verify functions algorithms and keys they
+// cannot handle, and checks that each reports an error rather than success.
+func TestVerifyFailsClosed(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	rawPath := testKeyPath("raw")
+	f.addKey(t, rawPath, "RSA_SIGN_RAW_PKCS1_2048")
+	ecKey := testPrivateKey(t, "EC_SIGN_P256_SHA256").Public()
+	rsaKey := testPrivateKey(t, "RSA_SIGN_PSS_2048_SHA256").Public()
+	digest := make([]byte, 32)
+
+	for _, name := range []string{"EC_SIGN_ED25519", "RSA_SIGN_RAW_PKCS1_2048", "RSA_DECRYPT_OAEP_2048_SHA256", "RSA_SIGN_PSS_2048_SHA256", "EC_SIGN_P384_SHA384"} {
+		alg, _ := lookupAlgorithm(name)
+		if err := verifyDigest(ecKey, alg, digest, make([]byte, 64)); err == nil {
+			t.Errorf("verifyDigest with a P-256 key and %s succeeded", name)
+		}
+	}
+	if err := verifyDigest(ecKey, AlgorithmInfo{}, digest, nil); err == nil {
+		t.Error("verifyDigest with an empty algorithm succeeded")
+	}
+	if err := verifyDigestSignature(ctx, client, "AAAA", digest, rawPath); err == nil {
+		t.Error("verifyDigestSignature with a raw PKCS#1 key succeeded")
+	}
+	if err := verifyJOSESignature(ecKey, "ES384", []byte("input"), make([]byte, 64)); !errors.Is(err, ErrKeyTypeMismatch) {
+		t.Errorf("verifyJOSESignature with ES384 and a P-256 key: got %v, want ErrKeyTypeMismatch", err)
+	}
+	for _, alg := range []string{"", "none", "HS256", "EdDSA"} {
+		if err := verifyJOSESignature(rsaKey, alg, []byte("input"), nil); err == nil {
+			t.Errorf("verifyJOSESignature with alg %q succeeded", alg)
+		}
+	}
+	if err := verifyWithHint("not PEM", "AAAA", "message", "EC_SIGN_P256_SHA256"); err == nil {
+		t.Error("verifyWithHint with an unparsable key succeeded")
+	}
+	if valid, err := checkSignature(ctx, client, "AAAA", []byte("message"), testKeyPath("missing")); valid || err == nil {
+		t.Errorf("checkSignature with a missing key = (%v, %v), want (false, error)", valid, err)
+	}
+}
+
+func TestNewVerifier(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	for _, algName := range []string{"RSA_SIGN_PKCS1_2048_SHA256", "RSA_SIGN_PSS_4096_SHA512", "EC_SIGN_P384_SHA384"} {
+		keyPath := testKeyPath(algName)
+		f.addKey(t, keyPath, algName)
+		signature, err := signAsymmetric(ctx, client, "message", keyPath)
+		if err != nil {
+			t.Fatalf("%s: signAsymmetric: %v", algName, err)
+		}
+		verify, err := newVerifier(ctx, client, keyPath)
+		if err != nil {
+			t.Fatalf("%s: newVerifier: %v", algName, err)
+		}
+		f.mu.Lock()
+		f.requests = 0
+		f.mu.Unlock()
+		if err := verify(signature, "message"); err != nil {
+			t.Errorf("%s: verify: %v", algName, err)
+		}
+		if err := verify(signature, "other"); !errors.Is(err, ErrSignatureInvalid) {
+			t.Errorf("%s: verify with the wrong message: got %v, want ErrSignatureInvalid", algName, err)
+		}
+		f.mu.Lock()
+		requests := f.requests
+		f.mu.Unlock()
+		if requests != 0 {
+			t.Errorf("%s: verify made %d requests, want 0", algName, requests)
+		}
+	}
+
+	decKeyPath := testKeyPath("rsa-decrypt")
+	f.addKey(t, decKeyPath, "RSA_DECRYPT_OAEP_2048_SHA256")
+	if _, err := newVerifier(ctx, client, decKeyPath); !errors.Is(err, ErrKeyTypeMismatch) {
+		t.Errorf("newVerifier with a decrypt key: got %v, want ErrKeyTypeMismatch", err)
+	}
+}
+
+func TestVerifyDetailed(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	rsaPath := testKeyPath("rsa-sign")
+	ecPath := testKeyPath("ec-sign")
+	f.addKey(t, rsaPath, "RSA_SIGN_PSS_2048_SHA256")
+	f.addKey(t, ecPath, "EC_SIGN_P256_SHA256")
+
+	type verifyFunc func(ctx context.Context, client *cloudkms.Service, signature, message, keyPath string, opts ...Option) VerifyResult
+	for name, test := range map[string]struct {
+		keyPath, alg string
+		verify       verifyFunc
+	}{
+		"verifyDetailedRSA": {rsaPath, "RSA_SIGN_PSS_2048_SHA256", verifyDetailedRSA},
+		"verifyDetailedEC":  {ecPath, "EC_SIGN_P256_SHA256", verifyDetailedEC},
+	} {
+		signature, err := signAsymmetric(ctx, client, "message", test.keyPath)
+		if err != nil {
+			t.Fatalf("signAsymmetric: %v", err)
+		}
+		fingerprint, err := keyFingerprint(testPrivateKey(t, test.alg).Public())
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := VerifyResult{Valid: true, KeyVersion: test.keyPath, LogicalKeyID: parentKeyPath(test.keyPath), Algorithm: test.alg, Fingerprint: fingerprint}
+		if got := test.verify(ctx, client, signature, "message", test.keyPath); got != want {
+			t.Errorf("%s = %+v, want %+v", name, got, want)
+		}
+
+		got := test.verify(ctx, client, signature, "other", test.keyPath)
+		if got.Valid || !errors.Is(got.Err, ErrSignatureInvalid) || got.Algorithm != test.alg || got.Fingerprint != fingerprint {
+			t.Errorf("%s with the wrong message = %+v, want an invalid result describing the key", name, got)
+		}
+
+		missing := testKeyPath("missing")
+		got = test.verify(ctx, client, signature, "message", missing)
+		if got.Valid || got.Err == nil || got.KeyVersion != missing || got.Algorithm != "" {
+			t.Errorf("%s with a missing key = %+v, want an error and no key details", name, got)
+		}
+	}
+
+	// Each is still specific to its key type.
+	signature, err := signAsymmetric(ctx, client, "message", ecPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := verifyDetailedRSA(ctx, client, signature, "message", ecPath); !errors.Is(got.Err, ErrKeyTypeMismatch) {
+		t.Errorf("verifyDetailedRSA with an EC key: got %v, want ErrKeyTypeMismatch", got.Err)
+	}
+}
+
+// TestWithPublicKeyFromAnySource verifies signatures made by local software
+// keys, standing in for a hardware token, with no KMS client at all.
+func TestWithPublicKeyFromAnySource(t *testing.T) {
+	ctx := context.Background()
+	message := []byte("message")
+	digest := sha256.Sum256(message)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecSig, err := ecdsa.SignASN1(rand.Reader, ecKey, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey := testPrivateKey(t, "RSA_SIGN_PSS_2048_SHA256").(*rsa.PrivateKey)
+	rsaSig, err := rsa.SignPSS(rand.Reader, rsaKey, crypto.SHA256, digest[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A key read from a PEM file is just as good.
+	der, err := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromPEM, err := parsePublicKeyPEM(string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecSignature := base64.StdEncoding.EncodeToString(ecSig)
+	rsaSignature := base64.StdEncoding.EncodeToString(rsaSig)
+	for _, tc := range []struct {
+		name      string
+		signature string
+		key       crypto.PublicKey
+	}{
+		{"EC key", ecSignature, &ecKey.PublicKey},
+		{"EC key from PEM", ecSignature, fromPEM},
+		{"RSA key", rsaSignature, &rsaKey.PublicKey},
+	} {
+		if err := verifySignature(ctx, nil, tc.signature, message, "", WithPublicKey(tc.key)); err != nil {
+			t.Errorf("%s: verifySignature: %v", tc.name, err)
+		}
+		if err := verifySignature(ctx, nil, tc.signature, []byte("other"), "", WithPublicKey(tc.key)); !errors.Is(err, ErrSignatureInvalid) {
+			t.Errorf("%s: verifySignature of another message: got %v, want ErrSignatureInvalid", tc.name, err)
+		}
+	}
+	if err := verifySignatureEC(ctx, nil, ecSignature, string(message), "", WithPublicKey(&ecKey.PublicKey)); err != nil {
+		t.Errorf("verifySignatureEC: %v", err)
+	}
+	if err := verifySignatureRSA(ctx, nil, rsaSignature, string(message), "", WithPublicKey(&rsaKey.PublicKey)); err != nil {
+		t.Errorf("verifySignatureRSA: %v", err)
+	}
+	if err := verifySignature(ctx, nil, ecSignature, message, "", WithPublicKey(&rsaKey.PublicKey)); err == nil {
+		t.Error("verifySignature of an EC signature with an RSA key succeeded")
+	}
+
+	// A PKCS#1 v1.5 signature, as a YubiKey's PIV applet makes, needs the
+	// algorithm named.
+	pkcs1Sig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs1Signature := base64.StdEncoding.EncodeToString(pkcs1Sig)
+	err = verifySignature(ctx, nil, pkcs1Signature, message, "", WithPublicKey(&rsaKey.PublicKey), WithKeyAlgorithm("RSA_SIGN_PKCS1_2048_SHA256"))
+	if err != nil {
+		t.Errorf("verifySignature of a PKCS#1 signature with WithKeyAlgorithm: %v", err)
+	}
+	if err := verifySignature(ctx, nil, pkcs1Signature, message, "", WithPublicKey(&rsaKey.PublicKey)); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("verifySignature of a PKCS#1 signature as RSA-PSS: got %v, want ErrSignatureInvalid", err)
+	}
+	err = verifySignature(ctx, nil, pkcs1Signature, message, "", WithPublicKey(&rsaKey.PublicKey), WithKeyAlgorithm("RSA_SIGN_PKCS1_4096_SHA256"))
+	if !errors.Is(err, ErrKeyTypeMismatch) {
+		t.Errorf("verifySignature with an algorithm for another key size: got %v, want ErrKeyTypeMismatch", err)
+	}
+}
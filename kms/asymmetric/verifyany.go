@@ -0,0 +1,41 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// verifyAny checks a base64-encoded signature over message against each of
+// the key versions at keyPaths in order, which may use different algorithms,
+// such as an old RSA key and the EC key replacing it during a migration. Each
+// key is checked with the hash and padding of its own KMS algorithm, as
+// newVerifier does. It returns the resource name of the first key version
+// the signature verifies with. If none does, the error wraps
+// ErrSignatureInvalid and each key version's error.
+func verifyAny(ctx context.Context, client *cloudkms.Service, signature, message string, keyPaths []string, opts ...Option) (string, error) {
+	if len(keyPaths) == 0 {
+		return "", errors.New("no key versions to try")
+	}
+	var errs []error
+	for _, keyPath := range keyPaths {
+		verify, err := newVerifier(ctx, client, keyPath, opts...)
+		if err == nil {
+			err = verify(signature, message)
+		}
+		if err == nil {
+			return keyPath, nil
+		}
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", keyPath, err))
+	}
+	return "", fmt.Errorf("%w: no match among %d key versions: %w", ErrSignatureInvalid, len(keyPaths), errors.Join(errs...))
+}
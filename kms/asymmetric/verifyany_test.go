@@ -0,0 +1,43 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestVerifyAny(t *testing.T) {
+	ctx := context.Background()
+	f, client := newFakeKMS(t)
+	oldKey := testKeyPath("migrate-rsa")
+	newKey := testKeyPath("migrate-ec")
+	f.addKey(t, oldKey, "RSA_SIGN_PKCS1_2048_SHA256")
+	f.addKey(t, newKey, "EC_SIGN_P256_SHA256")
+	keyPaths := []string{oldKey, newKey}
+	const message = "cutover"
+
+	for _, signer := range keyPaths {
+		signature, err := signAsymmetric(ctx, client, message, signer)
+		if err != nil {
+			t.Fatalf("signAsymmetric: %v", err)
+		}
+		matched, err := verifyAny(ctx, client, signature, message, keyPaths)
+		if err != nil {
+			t.Errorf("verifyAny with a signature by %s: %v", signer, err)
+		} else if matched != signer {
+			t.Errorf("verifyAny matched %s, want %s", matched, signer)
+		}
+		if _, err := verifyAny(ctx, client, signature, "tampered", keyPaths); !errors.Is(err, ErrSignatureInvalid) {
+			t.Errorf("verifyAny of a tampered message: got %v, want ErrSignatureInvalid", err)
+		}
+	}
+
+	if _, err := verifyAny(ctx, client, "c2lnbmF0dXJl", message, nil); err == nil {
+		t.Error("verifyAny with no keys: got nil error")
+	}
+}